@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/go-chi/render"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 )
 
 // Type holds a type string and integer code for the error
@@ -16,10 +17,15 @@ const (
 	Authorization        Type = "AUTHORIZATION"        // Authentication Failures -
 	BadRequest           Type = "BADREQUEST"           // Validation errors / BadInput
 	Conflict             Type = "CONFLICT"             // Already exists (eg, create account with existent email) - 409
+	Forbidden            Type = "FORBIDDEN"            // Authenticated but not allowed (eg, unverified email) - 403
+	InsufficientStorage  Type = "INSUFFICIENTSTORAGE"  // Over a storage quota (eg, the attachments of a user) - 507
 	Internal             Type = "INTERNAL"             // Server (500) and fallback errors
 	NotFound             Type = "NOTFOUND"             // For not finding resource
 	PayloadTooLarge      Type = "PAYLOADTOOLARGE"      // for uploading tons of JSON, or an image over the limit - 413
+	PreconditionFailed   Type = "PRECONDITIONFAILED"   // If-Match does not match the current version - 412
+	PreconditionRequired Type = "PRECONDITIONREQUIRED" // If-Match is missing on a conditional update - 428
 	ServiceUnavailable   Type = "SERVICE_UNAVAILABLE"  // For long running handlers
+	TooManyRequests      Type = "TOOMANYREQUESTS"      // Throttled requests - 429
 	UnsupportedMediaType Type = "UNSUPPORTEDMEDIATYPE" // for http 415
 )
 
@@ -49,14 +55,24 @@ func (e *Error) Status() int {
 		return http.StatusBadRequest
 	case Conflict:
 		return http.StatusConflict
+	case Forbidden:
+		return http.StatusForbidden
+	case InsufficientStorage:
+		return http.StatusInsufficientStorage
 	case Internal:
 		return http.StatusInternalServerError
 	case NotFound:
 		return http.StatusNotFound
 	case PayloadTooLarge:
 		return http.StatusRequestEntityTooLarge
+	case PreconditionFailed:
+		return http.StatusPreconditionFailed
+	case PreconditionRequired:
+		return http.StatusPreconditionRequired
 	case ServiceUnavailable:
 		return http.StatusServiceUnavailable
+	case TooManyRequests:
+		return http.StatusTooManyRequests
 	case UnsupportedMediaType:
 		return http.StatusUnsupportedMediaType
 	default:
@@ -103,6 +119,14 @@ func NewConflict(name string, value string) *Error {
 	}
 }
 
+// NewForbidden to create an error for 403
+func NewForbidden(reason string) *Error {
+	return &Error{
+		Type:    Forbidden,
+		Message: reason,
+	}
+}
+
 // NewInternal for 500 errors and unknown errors
 func NewInternal() *Error {
 	return &Error{
@@ -111,6 +135,14 @@ func NewInternal() *Error {
 	}
 }
 
+// NewInsufficientStorage to create an error for 507
+func NewInsufficientStorage(reason string) *Error {
+	return &Error{
+		Type:    InsufficientStorage,
+		Message: reason,
+	}
+}
+
 // NewNotFound to create an error for 404 with a generic error message
 func NewNotFound(name string, value string) *Error {
 	return &Error{
@@ -127,6 +159,22 @@ func NewPayloadTooLarge(maxBodySize int64, contentLength int64) *Error {
 	}
 }
 
+// NewPreconditionFailed to create an error for 412
+func NewPreconditionFailed(reason string) *Error {
+	return &Error{
+		Type:    PreconditionFailed,
+		Message: reason,
+	}
+}
+
+// NewPreconditionRequired to create an error for 428
+func NewPreconditionRequired(reason string) *Error {
+	return &Error{
+		Type:    PreconditionRequired,
+		Message: reason,
+	}
+}
+
 // NewServiceUnavailable to create an error for 503
 func NewServiceUnavailable() *Error {
 	return &Error{
@@ -135,6 +183,14 @@ func NewServiceUnavailable() *Error {
 	}
 }
 
+// NewTooManyRequests to create an error for 429
+func NewTooManyRequests(reason string) *Error {
+	return &Error{
+		Type:    TooManyRequests,
+		Message: reason,
+	}
+}
+
 // NewUnsupportedMediaType to create an error for 415
 func NewUnsupportedMediaType(reason string) *Error {
 	return &Error{
@@ -189,4 +245,25 @@ func ErrInternalError(err error) render.Renderer {
 	}
 }
 
+// ErrFromError renders err with the status of an *Error. Validation errors
+// are treated as bad requests and anything else as an internal error.
+func ErrFromError(err error) render.Renderer {
+	var e *Error
+	if errors.As(err, &e) {
+		return &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: e.Status(),
+			StatusText:     http.StatusText(e.Status()),
+			ErrorText:      e.Message,
+		}
+	}
+
+	var verr validation.Errors
+	if errors.As(err, &verr) {
+		return ErrInvalidRequest(err)
+	}
+
+	return ErrInternalError(err)
+}
+
 var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
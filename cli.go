@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+)
+
+const usage = `Usage: myserver [command]
+
+Without a command the HTTP server is started.
+
+Commands:
+  unlock <email>        lift the lockout of an account
+  grant-admin <email>   make an account admin
+  revoke-admin <email>  remove the admin rights of an account
+  erase <email>         anonymise an account and delete its data
+  schedules             list the schedules with their next tick and last run
+  run-schedule <name>   run a schedule now and wait for it to finish
+`
+
+// runCommand runs the CLI command found in args
+func runCommand(svc *services, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "schedules":
+		return listSchedules(svc)
+	case len(args) == 2 && args[0] == "run-schedule":
+		return runSchedule(svc, args[1])
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	command, email := args[0], args[1]
+
+	u, err := svc.UserRepo.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("no account with email %s", email)
+		}
+		return err
+	}
+
+	// changes made from the CLI have no actor
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{UserAgent: "cli"})
+
+	switch command {
+	case "unlock":
+		err = svc.Auth.Unlock(u.ID, nil, auth.Client{UserAgent: "cli"})
+	case "grant-admin":
+		err = svc.User.SetAdmin(ctx, u.ID, true)
+	case "revoke-admin":
+		err = svc.User.SetAdmin(ctx, u.ID, false)
+	case "erase":
+		err = svc.Privacy.Erase(u.ID, nil, auth.Client{UserAgent: "cli"})
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%s: done for %s\n", command, email)
+
+	return nil
+}
+
+// listSchedules prints the schedules with their next tick and last run
+func listSchedules(svc *services) error {
+	schedules, err := svc.Scheduler.Schedules()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSPEC\tNEXT RUN\tLAST RUN\tSTATUS")
+
+	for _, s := range schedules {
+		last, status := "-", "-"
+		if s.LastRun != nil {
+			last, status = s.LastRun.StartedAt.Time.Format(time.RFC3339), s.LastRun.Status
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.Spec, s.NextRunAt.Format(time.RFC3339), last, status)
+	}
+
+	return w.Flush()
+}
+
+// runSchedule runs the schedule name now
+func runSchedule(svc *services, name string) error {
+	ctx := audit.WithMetadata(context.Background(), audit.Metadata{UserAgent: "cli"})
+
+	run, err := svc.Scheduler.Trigger(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if run.Status == entity.RunFailed {
+		return fmt.Errorf("run-schedule: %s failed after %dms: %s", name, run.DurationMs, run.Error)
+	}
+
+	fmt.Fprintf(os.Stdout, "run-schedule: %s succeeded in %dms\n", name, run.DurationMs)
+
+	return nil
+}
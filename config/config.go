@@ -6,20 +6,125 @@ import (
 )
 
 type Config struct {
-	DatabaseUrl    string `env:"DATABASE_URL,required"`
+	DatabaseUrl string `env:"DATABASE_URL,required"`
 	// RedisUrl       string `env:"REDIS_URL,required"`
-	Port           string `env:"PORT,default=4000"`
-	// SessionSecret  string `env:"SECRET,required"`
+	Port          string `env:"PORT,default=4000"`
+	SessionSecret string `env:"SECRET,required"`
+	AppURL        string `env:"APP_URL,default=http://localhost:3000"`
 	// Domain         string `env:"DOMAIN"`
 	// CorsOrigin     string `env:"CORS_ORIGIN,required"`
-	// AccessKey      string `env:"AWS_ACCESS_KEY"`
-	// SecretKey      string `env:"SECRET_KEY"`
-	// BucketName     string `env:"BUCKET_NAME"`
-	// Region         string `env:"REGION"`
-	// GmailUser      string `env:"GMAIL_USER"`
-	// GmailPassword  string `env:"GMAIL_PASSWORD"`
 	// HandlerTimeOut int64  `env:"HANDLER_TIMEOUT,default=5"`
 	// MaxBodyBytes   int64  `env:"MAX_BODY_BYTES,default=4194304"`
+
+	// EmailVerificationPolicy is one of "off", "login" or "routes"
+	EmailVerificationPolicy    string `env:"EMAIL_VERIFICATION_POLICY,default=routes"`
+	VerificationTokenTTL       int64  `env:"VERIFICATION_TOKEN_TTL,default=86400"`
+	VerificationResendInterval int64  `env:"VERIFICATION_RESEND_INTERVAL,default=60"`
+	AccessTokenTTL             int64  `env:"ACCESS_TOKEN_TTL,default=900"`
+	RefreshTokenTTL            int64  `env:"REFRESH_TOKEN_TTL,default=2592000"`
+	TwoFactorChallengeTTL      int64  `env:"TWO_FACTOR_CHALLENGE_TTL,default=300"`
+	TwoFactorIssuer            string `env:"TWO_FACTOR_ISSUER,default=My Server"`
+	// EncryptionKey encrypts secrets stored in the database (eg, TOTP secrets)
+	EncryptionKey string `env:"ENCRYPTION_KEY,required"`
+
+	// Failed logins within LockoutWindow before an account is locked
+	LockoutThreshold int   `env:"LOCKOUT_THRESHOLD,default=5"`
+	LockoutWindow    int64 `env:"LOCKOUT_WINDOW,default=900"`
+	// The first lock lasts LockoutDuration, it doubles up to LockoutMaxDuration
+	LockoutDuration    int64 `env:"LOCKOUT_DURATION,default=60"`
+	LockoutMaxDuration int64 `env:"LOCKOUT_MAX_DURATION,default=86400"`
+	// Failed logins within LockoutWindow before an IP address is refused
+	LockoutIPThreshold int `env:"LOCKOUT_IP_THRESHOLD,default=50"`
+	// Login attempts are kept for LoginAttemptRetention, at least LockoutWindow
+	LoginAttemptRetention int64 `env:"LOGIN_ATTEMPT_RETENTION,default=2592000"`
+
+	// Soft deleted users are purged, with their notes, after UserRetentionDays
+	UserRetentionDays int `env:"USER_RETENTION_DAYS,default=30"`
+	// Notes in the trash are purged after TrashRetentionDays
+	TrashRetentionDays int `env:"TRASH_RETENTION_DAYS,default=30"`
+
+	// The runs of the schedules are kept for ScheduleRunRetention
+	ScheduleRunRetention int64 `env:"SCHEDULE_RUN_RETENTION,default=2592000"`
+
+	// Data exports are written to ExportDir and can be downloaded for ExportTTL
+	ExportDir string `env:"EXPORT_DIR,default=data/exports"`
+	ExportTTL int64  `env:"EXPORT_TTL,default=604800"`
+
+	// Responses to POST requests with an Idempotency-Key are replayed for
+	// IdempotencyTTL, a request holds its key for IdempotencyLease and its
+	// JSON body is at most IdempotencyMaxBodySize bytes
+	IdempotencyTTL         int64 `env:"IDEMPOTENCY_TTL,default=86400"`
+	IdempotencyLease       int64 `env:"IDEMPOTENCY_LEASE,default=300"`
+	IdempotencyMaxBodySize int64 `env:"IDEMPOTENCY_MAX_BODY_SIZE,default=1048576"`
+
+	// JobConcurrency workers run the background jobs, jobs running for longer
+	// than JobStaleAfter are queued again and finished jobs kept for JobRetention
+	JobConcurrency  int   `env:"JOB_CONCURRENCY,default=4"`
+	JobPollInterval int64 `env:"JOB_POLL_INTERVAL,default=1"`
+	JobStaleAfter   int64 `env:"JOB_STALE_AFTER,default=3600"`
+	JobRetention    int64 `env:"JOB_RETENTION,default=604800"`
+
+	// The outbox is polled every OutboxPollInterval, failed deliveries are
+	// retried up to OutboxMaxAttempts and processed events kept for OutboxRetention
+	OutboxPollInterval int64 `env:"OUTBOX_POLL_INTERVAL,default=1"`
+	OutboxMaxAttempts  int   `env:"OUTBOX_MAX_ATTEMPTS,default=10"`
+	OutboxRetention    int64 `env:"OUTBOX_RETENTION,default=604800"`
+
+	// The streams of events are resumed from the last EventsBufferSize
+	// events, a comment is sent on the idle streams every EventsHeartbeat
+	EventsBufferSize int   `env:"EVENTS_BUFFER_SIZE,default=1000"`
+	EventsHeartbeat  int64 `env:"EVENTS_HEARTBEAT,default=25"`
+
+	// Webhook deliveries time out after WebhookTimeout and are given up after
+	// WebhookMaxAttempts, webhooks are disabled after WebhookDisableAfter
+	// failed attempts in a row
+	WebhookTimeout      int64 `env:"WEBHOOK_TIMEOUT,default=10"`
+	WebhookMaxAttempts  int   `env:"WEBHOOK_MAX_ATTEMPTS,default=8"`
+	WebhookDisableAfter int   `env:"WEBHOOK_DISABLE_AFTER,default=20"`
+
+	// SearchLanguage is the text search configuration of the notes (eg,
+	// "english", "simple"), notes keep the one they were created with
+	SearchLanguage string `env:"SEARCH_LANGUAGE,default=english"`
+	// NoteRevisionLimit is the number of revisions kept per note, users can
+	// set their own
+	NoteRevisionLimit int `env:"NOTE_REVISION_LIMIT,default=50"`
+	// NoteRenderCacheSize is the number of rendered notes kept in memory
+	NoteRenderCacheSize int `env:"NOTE_RENDER_CACHE_SIZE,default=1000"`
+	// NoteImportMaxSize is the largest ZIP archive of notes imported, in bytes
+	NoteImportMaxSize int64 `env:"NOTE_IMPORT_MAX_SIZE,default=52428800"`
+
+	// StorageDriver is one of "local", blobs are files of StorageDir, or "s3"
+	StorageDriver string `env:"STORAGE_DRIVER,default=local"`
+	StorageDir    string `env:"STORAGE_DIR,default=data/blobs"`
+	// StorageEndpoint is the URL of an S3-compatible store, AWS when it is
+	// empty. Most stores other than AWS require StoragePathStyle
+	StorageEndpoint  string `env:"STORAGE_ENDPOINT"`
+	StoragePathStyle bool   `env:"STORAGE_PATH_STYLE,default=false"`
+	AccessKey        string `env:"AWS_ACCESS_KEY"`
+	SecretKey        string `env:"SECRET_KEY"`
+	BucketName       string `env:"BUCKET_NAME"`
+	Region           string `env:"REGION"`
+	// AttachmentMaxSize is the largest file attached to a note and
+	// AttachmentQuota the size of the attachments of a user, in bytes
+	AttachmentMaxSize int64 `env:"ATTACHMENT_MAX_SIZE,default=26214400"`
+	AttachmentQuota   int64 `env:"ATTACHMENT_QUOTA,default=1073741824"`
+	// AvatarMaxSize is the largest image uploaded as an avatar, in bytes
+	AvatarMaxSize int64 `env:"AVATAR_MAX_SIZE,default=5242880"`
+
+	PasswordMinLength     int    `env:"PASSWORD_MIN_LENGTH,default=8"`
+	BreachedPasswordsFile string `env:"BREACHED_PASSWORDS_FILE,default=config/breached_passwords.txt"`
+
+	// MailDriver is one of "smtp", "file" or "log". It is required so a
+	// deploy cannot silently log the emails instead of sending them
+	MailDriver   string `env:"MAIL_DRIVER,required"`
+	MailFrom     string `env:"MAIL_FROM,default=My Server <no-reply@localhost>"`
+	MailDir      string `env:"MAIL_DIR"`
+	SMTPHost     string `env:"SMTP_HOST"`
+	SMTPPort     int    `env:"SMTP_PORT,default=587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+	// SMTPTLS is "starttls", or "none" for the local SMTP sinks
+	SMTPTLS string `env:"SMTP_TLS,default=starttls"`
 }
 
 func LoadConfig(ctx context.Context) (config Config, err error) {
@@ -0,0 +1,28 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS login_attempts(
+  id serial PRIMARY KEY,
+  user_id INTEGER NULL,
+  email VARCHAR(255) NOT NULL,
+  ip VARCHAR(64) NOT NULL,
+  success BOOLEAN NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_users
+    FOREIGN KEY(user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_user_id_idx ON login_attempts(user_id, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts(ip, created_at) WHERE NOT success;
+
+CREATE TABLE IF NOT EXISTS account_lockouts(
+  user_id INTEGER PRIMARY KEY,
+  lockout_count INTEGER NOT NULL DEFAULT 0,
+  locked_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  locked_until TIMESTAMP WITH TIME ZONE NOT NULL,
+  CONSTRAINT fk_users
+    FOREIGN KEY(user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
@@ -0,0 +1,10 @@
+ALTER TABLE notes DROP CONSTRAINT IF EXISTS fk_users;
+ALTER TABLE notes ADD CONSTRAINT fk_users
+  FOREIGN KEY(user_id)
+  REFERENCES users(id);
+
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS users_email_live_idx;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
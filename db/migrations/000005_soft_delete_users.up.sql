@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE NULL;
+
+-- emails only need to be unique among live accounts
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_live_idx ON users(email) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- purging a user removes their notes
+ALTER TABLE notes DROP CONSTRAINT IF EXISTS fk_users;
+ALTER TABLE notes ADD CONSTRAINT fk_users
+  FOREIGN KEY(user_id)
+  REFERENCES users(id)
+  ON DELETE CASCADE;
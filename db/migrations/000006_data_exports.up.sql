@@ -0,0 +1,19 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP WITH TIME ZONE NULL;
+
+CREATE TABLE IF NOT EXISTS data_exports(
+  id serial PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  file_path VARCHAR NOT NULL DEFAULT '',
+  error VARCHAR NOT NULL DEFAULT '',
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  completed_at TIMESTAMP WITH TIME ZONE NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  CONSTRAINT fk_users
+    FOREIGN KEY(user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS data_exports_expires_at_idx ON data_exports(expires_at);
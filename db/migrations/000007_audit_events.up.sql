@@ -0,0 +1,30 @@
+-- actor_id and target_id have no foreign keys, the history outlives the rows
+CREATE TABLE IF NOT EXISTS audit_events(
+  id bigserial PRIMARY KEY,
+  actor_id INTEGER NULL,
+  action VARCHAR(64) NOT NULL,
+  target_type VARCHAR(64) NOT NULL,
+  target_id VARCHAR(64) NOT NULL DEFAULT '',
+  changes JSONB NOT NULL DEFAULT '{}',
+  details JSONB NOT NULL DEFAULT '{}',
+  ip VARCHAR(64) NOT NULL DEFAULT '',
+  user_agent VARCHAR NOT NULL DEFAULT '',
+  request_id VARCHAR(128) NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events(actor_id, id);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events(target_type, target_id, id);
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events(action, id);
+
+-- the log is append-only
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+  BEFORE UPDATE OR DELETE ON audit_events
+  FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,18 @@
+-- events are written in the transaction of the change they describe and
+-- delivered by the dispatcher once committed
+CREATE TABLE IF NOT EXISTS outbox(
+  id bigserial PRIMARY KEY,
+  event_type VARCHAR(64) NOT NULL,
+  aggregate_type VARCHAR(64) NOT NULL,
+  aggregate_id VARCHAR(64) NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  available_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  processed_at TIMESTAMP WITH TIME ZONE NULL,
+  failed_at TIMESTAMP WITH TIME ZONE NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox(available_at, id)
+  WHERE processed_at IS NULL AND failed_at IS NULL;
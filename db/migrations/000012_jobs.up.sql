@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS jobs(
+  id bigserial PRIMARY KEY,
+  kind VARCHAR(64) NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}',
+  unique_key VARCHAR(255) NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'queued',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  max_attempts INTEGER NOT NULL DEFAULT 5,
+  last_error TEXT NOT NULL DEFAULT '',
+  run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  locked_at TIMESTAMP WITH TIME ZONE NULL,
+  finished_at TIMESTAMP WITH TIME ZONE NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS jobs_queued_idx ON jobs(run_at, id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs(locked_at) WHERE status = 'running';
+
+-- a unique job is enqueued once until it finishes
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs(kind, unique_key)
+  WHERE unique_key IS NOT NULL AND status IN ('queued', 'running');
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS schedule_runs(
+  id bigserial PRIMARY KEY,
+  schedule VARCHAR(64) NOT NULL,
+  -- "schedule" for the runs started by the scheduler, "manual" otherwise
+  triggered_by VARCHAR(16) NOT NULL,
+  -- the tick of the cron expression, NULL for manual runs
+  scheduled_for TIMESTAMP WITH TIME ZONE NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'running',
+  error TEXT NOT NULL DEFAULT '',
+  started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMP WITH TIME ZONE NULL,
+  duration_ms INTEGER NOT NULL DEFAULT 0
+);
+
+-- a tick runs once, whichever replica is the leader
+CREATE UNIQUE INDEX IF NOT EXISTS schedule_runs_tick_idx ON schedule_runs(schedule, scheduled_for);
+CREATE INDEX IF NOT EXISTS schedule_runs_schedule_idx ON schedule_runs(schedule, id DESC);
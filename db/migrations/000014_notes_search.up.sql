@@ -0,0 +1,9 @@
+-- the text search configuration the note was indexed with
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS language REGCONFIG NOT NULL DEFAULT 'english';
+
+-- the title weighs more than the content in the ranking
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (
+  setweight(to_tsvector(language, title), 'A') || setweight(to_tsvector(language, content), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS notes_search_idx ON notes USING GIN(search);
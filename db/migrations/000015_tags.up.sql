@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS tags(
+  id serial PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  name VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_users
+    FOREIGN KEY(user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
+
+-- the names of the tags of a user are unique, ignoring case
+CREATE UNIQUE INDEX IF NOT EXISTS tags_user_id_name_idx ON tags(user_id, LOWER(name));
+
+CREATE TABLE IF NOT EXISTS note_tags(
+  note_id INTEGER NOT NULL,
+  tag_id INTEGER NOT NULL,
+  PRIMARY KEY(note_id, tag_id),
+  CONSTRAINT fk_notes
+    FOREIGN KEY(note_id)
+    REFERENCES notes(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_tags
+    FOREIGN KEY(tag_id)
+    REFERENCES tags(id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS note_tags_tag_id_idx ON note_tags(tag_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS note_settings;
+DROP TABLE IF EXISTS note_revisions;
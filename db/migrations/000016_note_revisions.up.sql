@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS note_revisions(
+  id bigserial PRIMARY KEY,
+  note_id INTEGER NOT NULL,
+  -- version is the version of the note the revision was saved as
+  version INTEGER NOT NULL,
+  title VARCHAR(255) NOT NULL,
+  content VARCHAR NOT NULL,
+  attrs JSONB NOT NULL,
+  author_id INTEGER NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_notes
+    FOREIGN KEY(note_id)
+    REFERENCES notes(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_users
+    FOREIGN KEY(author_id)
+    REFERENCES users(id)
+    ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS note_revisions_note_id_version_idx ON note_revisions(note_id, version);
+
+-- the history of the existing notes starts with their current content
+INSERT INTO note_revisions (note_id, version, title, content, attrs, author_id, created_at)
+  SELECT id, version, title, content, attrs, user_id, COALESCE(updated_at, created_at, NOW()) FROM notes;
+
+CREATE TABLE IF NOT EXISTS note_settings(
+  user_id INTEGER PRIMARY KEY,
+  -- revision_limit is the number of revisions kept per note, NULL keeps the
+  -- server default
+  revision_limit INTEGER NULL CHECK (revision_limit > 0),
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_users
+    FOREIGN KEY(user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS note_links;
+DROP TABLE IF EXISTS note_shares;
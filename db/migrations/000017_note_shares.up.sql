@@ -0,0 +1,46 @@
+CREATE TABLE IF NOT EXISTS note_shares(
+  id serial PRIMARY KEY,
+  note_id INTEGER NOT NULL,
+  -- user_id is the user the note is shared with
+  user_id INTEGER NOT NULL,
+  permission VARCHAR(8) NOT NULL,
+  created_by INTEGER NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE NULL,
+  CONSTRAINT fk_notes
+    FOREIGN KEY(note_id)
+    REFERENCES notes(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_users
+    FOREIGN KEY(user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_created_by
+    FOREIGN KEY(created_by)
+    REFERENCES users(id)
+    ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS note_shares_note_id_user_id_idx ON note_shares(note_id, user_id);
+CREATE INDEX IF NOT EXISTS note_shares_user_id_idx ON note_shares(user_id);
+
+CREATE TABLE IF NOT EXISTS note_links(
+  id serial PRIMARY KEY,
+  note_id INTEGER NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  -- password is the hash of the password of the link, empty when it has none
+  password VARCHAR(255) NOT NULL DEFAULT '',
+  expires_at TIMESTAMP WITH TIME ZONE NULL,
+  created_by INTEGER NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_notes
+    FOREIGN KEY(note_id)
+    REFERENCES notes(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_created_by
+    FOREIGN KEY(created_by)
+    REFERENCES users(id)
+    ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS note_links_note_id_idx ON note_links(note_id);
@@ -0,0 +1,4 @@
+-- deleted notes stay in the trash until they are purged
+ALTER TABLE notes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE NULL;
+
+CREATE INDEX IF NOT EXISTS notes_deleted_at_idx ON notes(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS note_attachments(
+  id serial PRIMARY KEY,
+  note_id INTEGER NOT NULL,
+  -- user_id is the owner of the note, the attachment counts against their quota
+  user_id INTEGER NOT NULL,
+  filename VARCHAR(255) NOT NULL,
+  -- content_type is sniffed from the content, not the one declared by the client
+  content_type VARCHAR(255) NOT NULL,
+  size BIGINT NOT NULL,
+  checksum VARCHAR(64) NOT NULL,
+  storage_key VARCHAR(255) NOT NULL UNIQUE,
+  created_by INTEGER NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_notes
+    FOREIGN KEY(note_id)
+    REFERENCES notes(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_users
+    FOREIGN KEY(user_id)
+    REFERENCES users(id)
+    ON DELETE CASCADE,
+  CONSTRAINT fk_created_by
+    FOREIGN KEY(created_by)
+    REFERENCES users(id)
+    ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS note_attachments_note_id_idx ON note_attachments(note_id);
+CREATE INDEX IF NOT EXISTS note_attachments_user_id_idx ON note_attachments(user_id);
@@ -0,0 +1,3 @@
+-- avatar is the token of the current avatar of the user, its images are
+-- stored under avatars/{id}/{avatar}/, empty when the user has none
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar VARCHAR(64) NOT NULL DEFAULT '';
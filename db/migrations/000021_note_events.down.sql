@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS outbox_note_events ON outbox;
+DROP FUNCTION IF EXISTS notify_note_event();
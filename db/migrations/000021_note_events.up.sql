@@ -0,0 +1,23 @@
+-- the note events of the outbox are notified to the servers streaming them
+-- to the clients, once committed. The payload only identifies the change, a
+-- notification is limited to 8000 bytes.
+CREATE OR REPLACE FUNCTION notify_note_event() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('note_events', json_build_object(
+    'id', NEW.id,
+    'type', NEW.event_type,
+    'note_id', NEW.aggregate_id::bigint,
+    'user_id', (NEW.payload->>'user_id')::bigint,
+    'version', (NEW.payload->>'version')::bigint,
+    'created_at', NEW.created_at
+  )::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS outbox_note_events ON outbox;
+
+CREATE TRIGGER outbox_note_events AFTER INSERT ON outbox
+  FOR EACH ROW
+  WHEN (NEW.event_type IN ('note.created', 'note.updated', 'note.deleted', 'note.restored', 'note.purged'))
+  EXECUTE FUNCTION notify_note_event();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idempotency_keys_user_id_idx;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,9 @@
+-- keys are scoped to the user making the request and held by the request in
+-- flight until locked_until. The keys stored before cannot be attributed to
+-- a user, they are dropped.
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_user_id_idx ON idempotency_keys(user_id);
@@ -0,0 +1,3 @@
+-- the responses of the webhook endpoints are not kept, they may disclose
+-- what answers at the URL
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS response_body;
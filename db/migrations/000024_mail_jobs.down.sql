@@ -0,0 +1,2 @@
+-- the dropped jobs cannot be restored
+SELECT 1;
@@ -0,0 +1,3 @@
+-- the mail.send jobs held the whole message, verification links included.
+-- The emails are now built when their job runs, the old jobs are dropped.
+DELETE FROM jobs WHERE kind = 'mail.send';
@@ -0,0 +1,2 @@
+DELETE FROM idempotency_keys WHERE user_id IS NULL;
+ALTER TABLE idempotency_keys ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,2 @@
+-- anonymous requests, the signups, store their keys without a user
+ALTER TABLE idempotency_keys ALTER COLUMN user_id DROP NOT NULL;
@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+)
+
+// RegisterAdminHandlers adds the admin endpoints of the audit service to r,
+// which must already require an admin.
+func RegisterAdminHandlers(r chi.Router, service Service) {
+	res := resource{service}
+
+	r.Get("/audit-events", res.query) // GET /admin/audit-events - read the audit log, newest first
+}
+
+type resource struct {
+	service Service
+}
+
+func (c resource) query(w http.ResponseWriter, r *http.Request) {
+	filter, err := filterFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	page, err := pagination.NewCursorFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.Query(filter, page); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, page)
+}
+
+// filterFromRequest reads the actor_id, action, target_type, target_id,
+// since and until (RFC 3339) query parameters.
+func filterFromRequest(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+
+	f := Filter{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+	}
+
+	if v := q.Get("actor_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return f, err
+		}
+		f.ActorID = &id
+	}
+
+	var err error
+
+	if v := q.Get("since"); v != "" {
+		if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+
+	if v := q.Get("until"); v != "" {
+		if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
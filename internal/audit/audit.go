@@ -0,0 +1,178 @@
+// Package audit keeps an append-only log of security and data-changing
+// events. Events are inserted with the queries bound to the transaction of
+// the change they describe, so both commit or roll back together.
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/opaulochaves/myserver/internal/entity"
+)
+
+// Types of audited records
+const (
+	TargetUser = "user"
+)
+
+// Metadata describes who is behind a change and the request it comes from.
+type Metadata struct {
+	ActorID   *int64
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// Event returns a new event of action on the target with the metadata.
+func (m Metadata) Event(action string, targetType string, targetID interface{}) *entity.AuditEvent {
+	return &entity.AuditEvent{
+		ActorID:    m.ActorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   fmt.Sprint(targetID),
+		Changes:    entity.JSONMap{},
+		Details:    entity.JSONMap{},
+		IP:         m.IP,
+		UserAgent:  m.UserAgent,
+		RequestID:  m.RequestID,
+	}
+}
+
+type contextKey struct{}
+
+// FromContext returns the metadata stored in ctx, if any.
+func FromContext(ctx context.Context) Metadata {
+	m, _ := ctx.Value(contextKey{}).(Metadata)
+	return m
+}
+
+// WithMetadata returns a copy of ctx carrying m.
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// WithActor returns a copy of ctx whose metadata names actorID as the actor.
+func WithActor(ctx context.Context, actorID int64) context.Context {
+	m := FromContext(ctx)
+	m.ActorID = &actorID
+	return WithMetadata(ctx, m)
+}
+
+// Middleware stores the client and request ID of the request in its context.
+// It must run after middleware.RequestID.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ctx := WithMetadata(r.Context(), Metadata{
+			IP:        ip,
+			UserAgent: r.UserAgent(),
+			RequestID: middleware.GetReqID(r.Context()),
+		})
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Diff returns the fields that differ between before and after, two
+// structs of the same type or nil for a creation or a deletion. Fields are
+// named after their db tag, fields tagged `audit:"-"` are left out.
+//
+// The log is append-only, personal data written to it could never be
+// erased. The values of the fields tagged `audit:"redact"` are left out,
+// only the change is recorded.
+func Diff(before, after interface{}) entity.JSONMap {
+	changes := entity.JSONMap{}
+
+	from, to := fields(before), fields(after)
+
+	for name, value := range to {
+		if old, ok := from[name]; !ok || !reflect.DeepEqual(old, value) {
+			changes[name] = change(old, value)
+		}
+	}
+
+	for name, value := range from {
+		if _, ok := to[name]; !ok {
+			changes[name] = change(value, nil)
+		}
+	}
+
+	return changes
+}
+
+// redacted holds the value of a field tagged `audit:"redact"`, compared
+// but not recorded.
+type redacted struct {
+	value interface{}
+}
+
+func change(from, to interface{}) entity.Change {
+	_, fromRedacted := from.(redacted)
+	_, toRedacted := to.(redacted)
+
+	if fromRedacted || toRedacted {
+		return entity.Change{Redacted: true}
+	}
+
+	return entity.Change{From: from, To: to}
+}
+
+// fields flattens the audited fields of a struct, embedded ones included.
+func fields(v interface{}) map[string]interface{} {
+	values := map[string]interface{}{}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return values
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return values
+	}
+
+	collect(rv, values)
+
+	return values
+}
+
+func collect(rv reflect.Value, values map[string]interface{}) {
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+
+		tag := f.Tag.Get("audit")
+
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			collect(rv.Field(i), values)
+			continue
+		}
+
+		name := f.Tag.Get("db")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if tag == "redact" {
+			values[name] = redacted{rv.Field(i).Interface()}
+			continue
+		}
+
+		values[name] = rv.Field(i).Interface()
+	}
+}
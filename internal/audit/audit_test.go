@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	before := &entity.User{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Password: "old"}
+	after := *before
+	after.FirstName = "Augusta"
+	after.IsAdmin = true
+	after.Password = "new"
+
+	assert.Equal(t, entity.JSONMap{
+		"first_name": entity.Change{Redacted: true},
+		"is_admin":   entity.Change{From: false, To: true},
+	}, Diff(before, &after))
+
+	assert.Empty(t, Diff(before, before))
+}
+
+func TestDiffCreation(t *testing.T) {
+	u := &entity.User{BaseEntity: entity.BaseEntity{ID: 7}, Email: "ada@example.com", Password: "secret"}
+
+	changes := Diff(nil, u)
+
+	assert.Equal(t, entity.Change{To: int64(7)}, changes["id"])
+	assert.Equal(t, entity.Change{Redacted: true}, changes["email"])
+	assert.NotContains(t, changes, "password")
+	assert.NotContains(t, changes, "updated_at")
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+// Filter narrows down a query of the audit log. Zero values match anything.
+type Filter struct {
+	ActorID    *int64
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	// Before only keeps events older than the event with this ID
+	Before int64
+}
+
+type AuditQueries interface {
+	Insert(event *entity.AuditEvent) error
+	Query(filter Filter, limit int) ([]entity.AuditEvent, error)
+	GetUserEvents(userID int64) ([]entity.AuditEvent, error)
+}
+
+// auditQueries struct for queries from the audit_events table. Events are
+// only ever inserted, the table refuses updates and deletes.
+type auditQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewAuditQueries(db *sqlx.DB, tx *sqlx.Tx) AuditQueries {
+	return &auditQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *auditQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// Insert implements AuditQueries
+func (q *auditQueries) Insert(e *entity.AuditEvent) error {
+	query := `INSERT INTO audit_events (actor_id, action, target_type, target_id, changes, details, ip, user_agent, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`
+
+	err := q.conn().QueryRowx(query, e.ActorID, e.Action, e.TargetType, e.TargetID, e.Changes, e.Details, e.IP, e.UserAgent, e.RequestID).
+		Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "insert audit event error")
+	}
+
+	return nil
+}
+
+// Query implements AuditQueries
+//
+// Events are returned newest first.
+func (q *auditQueries) Query(f Filter, limit int) ([]entity.AuditEvent, error) {
+	var where []string
+	var args []interface{}
+
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	if f.ActorID != nil {
+		add("actor_id = $%d", *f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	if f.Before > 0 {
+		add("id < $%d", f.Before)
+	}
+
+	query := `SELECT * FROM audit_events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+
+	args = append(args, limit)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	events := []entity.AuditEvent{}
+
+	err := sqlx.Select(q.conn(), &events, query, args...)
+
+	return events, err
+}
+
+// GetUserEvents implements AuditQueries
+//
+// It returns the events done by or to the user, oldest first.
+func (q *auditQueries) GetUserEvents(userID int64) ([]entity.AuditEvent, error) {
+	events := []entity.AuditEvent{}
+
+	query := `SELECT * FROM audit_events WHERE actor_id = $1 OR (target_type = $2 AND target_id = $3) ORDER BY id`
+
+	err := sqlx.Select(q.conn(), &events, query, userID, TargetUser, fmt.Sprint(userID))
+
+	return events, err
+}
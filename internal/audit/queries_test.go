@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type queriesSuiteTest struct {
+	test.TSuite
+}
+
+func TestQueriesSuiteTest(t *testing.T) {
+	suite.Run(t, new(queriesSuiteTest))
+}
+
+func (t *queriesSuiteTest) TestQuery() {
+	queries := NewAuditQueries(t.DB, t.TX)
+
+	actor := int64(1)
+	m := Metadata{ActorID: &actor, IP: "127.0.0.1"}
+
+	for i := int64(1); i <= 5; i++ {
+		require.NoError(t.T(), queries.Insert(m.Event("user.updated", TargetUser, i)))
+	}
+	require.NoError(t.T(), queries.Insert(Metadata{}.Event("user.purged", TargetUser, 1)))
+
+	events, err := queries.Query(Filter{ActorID: &actor}, 10)
+	require.NoError(t.T(), err)
+	assert.Len(t.T(), events, 5)
+	assert.Equal(t.T(), "5", events[0].TargetID, "newest first")
+
+	events, err = queries.Query(Filter{TargetType: TargetUser, TargetID: "1"}, 10)
+	require.NoError(t.T(), err)
+	assert.Len(t.T(), events, 2)
+
+	// cursor pagination walks the log without gaps
+	service := NewService(queries)
+
+	page := &pagination.Cursor{Limit: 4}
+	require.NoError(t.T(), service.Query(Filter{}, page))
+	assert.Len(t.T(), page.Items, 4)
+	assert.NotEmpty(t.T(), page.NextCursor)
+
+	last := page.Items.([]entity.AuditEvent)[3]
+
+	page = &pagination.Cursor{Limit: 4, After: last.ID}
+	require.NoError(t.T(), service.Query(Filter{}, page))
+	assert.Len(t.T(), page.Items, 2)
+	assert.Empty(t.T(), page.NextCursor)
+}
+
+func (t *queriesSuiteTest) TestAppendOnly() {
+	queries := NewAuditQueries(t.DB, t.TX)
+
+	require.NoError(t.T(), queries.Insert(Metadata{}.Event("user.created", TargetUser, 1)))
+
+	_, err := t.TX.Exec(`SAVEPOINT append_only`)
+	require.NoError(t.T(), err)
+
+	_, err = t.TX.Exec(`DELETE FROM audit_events`)
+	assert.ErrorContains(t.T(), err, "append-only")
+
+	_, err = t.TX.Exec(`ROLLBACK TO SAVEPOINT append_only`)
+	require.NoError(t.T(), err)
+}
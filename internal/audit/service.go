@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"github.com/opaulochaves/myserver/pkg/pagination"
+)
+
+type Service interface {
+	// Query reads a page of the events matching filter, newest first.
+	Query(filter Filter, page *pagination.Cursor) error
+}
+
+type service struct {
+	repo AuditQueries
+}
+
+func NewService(repo AuditQueries) Service {
+	return service{repo}
+}
+
+// Query implements Service
+func (s service) Query(filter Filter, page *pagination.Cursor) error {
+	filter.Before = page.After
+
+	events, err := s.repo.Query(filter, page.Fetch())
+	if err != nil {
+		return err
+	}
+
+	n := page.Page(len(events), func(i int) int64 { return events[i].ID })
+
+	page.Items = events[:n]
+
+	return nil
+}
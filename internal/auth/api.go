@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/user"
+)
+
+func RegisterHandlers(service Service, users user.Service) *chi.Mux {
+	res := resource{service, users}
+	r := chi.NewRouter()
+
+	r.Post("/login", res.login)                // POST /auth/login - exchange credentials for tokens
+	r.Post("/login/2fa", res.loginTwoFactor)   // POST /auth/login/2fa - complete a login with a two-factor code
+	r.Post("/refresh", res.refresh)            // POST /auth/refresh - rotate a refresh token
+	r.Post("/logout", res.logout)              // POST /auth/logout - revoke a refresh token
+	r.Post("/verify", res.verify)              // POST /auth/verify - confirm an email address
+	r.Post("/verify/resend", res.resendVerify) // POST /auth/verify/resend - send a new verification email
+
+	r.Group(func(r chi.Router) {
+		r.Use(Authenticate(service))
+		r.Use(RequireVerified(service))
+		r.Get("/me", res.me)                         // GET /auth/me - read the authenticated user
+		r.Put("/password", res.changePassword)       // PUT /auth/password - change the password, requires the current one, revokes the sessions
+		r.Post("/2fa/enroll", res.enrollTwoFactor)   // POST /auth/2fa/enroll - start two-factor enrolment
+		r.Post("/2fa/confirm", res.confirmTwoFactor) // POST /auth/2fa/confirm - confirm enrolment with a first code
+		r.Post("/2fa/disable", res.disableTwoFactor) // POST /auth/2fa/disable - turn two-factor authentication off
+	})
+
+	return r
+}
+
+// RegisterAdminHandlers adds the admin endpoints of the auth service to r,
+// which must already require an admin.
+func RegisterAdminHandlers(r chi.Router, service Service) {
+	res := resource{service: service}
+
+	r.Post("/users/{id}/unlock", res.unlock) // POST /admin/users/{id}/unlock - lift the lockout of an account
+}
+
+type resource struct {
+	service Service
+	users   user.Service
+}
+
+func (c resource) login(w http.ResponseWriter, r *http.Request) {
+	input := LoginRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	tokens, err := c.service.Login(input, ClientFromRequest(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &tokens)
+}
+
+func (c resource) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	input := TwoFactorLoginRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	tokens, err := c.service.CompleteLogin(input, ClientFromRequest(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &tokens)
+}
+
+func (c resource) refresh(w http.ResponseWriter, r *http.Request) {
+	input := RefreshRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	tokens, err := c.service.Refresh(input, ClientFromRequest(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &tokens)
+}
+
+func (c resource) logout(w http.ResponseWriter, r *http.Request) {
+	input := RefreshRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.Logout(input); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
+func (c resource) verify(w http.ResponseWriter, r *http.Request) {
+	input := VerifyRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.Verify(input.Token, ClientFromRequest(r)); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
+func (c resource) resendVerify(w http.ResponseWriter, r *http.Request) {
+	input := ResendRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.ResendVerification(input.Email); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// changePassword counts the wrong current passwords towards the lockout of
+// the client, like failed logins.
+func (c resource) changePassword(w http.ResponseWriter, r *http.Request) {
+	input := user.ChangePasswordRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	u := CurrentUser(r.Context())
+	client := ClientFromRequest(r)
+
+	if err := c.service.CheckClient(client); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	if err := c.users.ChangePassword(r.Context(), u.ID, input); err != nil {
+		if apperrors.Status(err) == http.StatusForbidden {
+			c.service.RecordFailure(client, "password:"+strconv.FormatInt(u.ID, 10))
+		}
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
+func (c resource) me(w http.ResponseWriter, r *http.Request) {
+	u := CurrentUser(r.Context())
+
+	if err := render.Render(w, r, &user.UserResponse{User: user.User{User: u}}); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	enrollment, err := c.service.EnrollTwoFactor(CurrentUser(r.Context()))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &enrollment)
+}
+
+func (c resource) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	input := CodeRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	codes, err := c.service.ConfirmTwoFactor(CurrentUser(r.Context()), input, ClientFromRequest(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &codes)
+}
+
+func (c resource) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	input := CodeRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.DisableTwoFactor(CurrentUser(r.Context()), input, ClientFromRequest(r)); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.NoContent(w, r)
+}
+
+func (c resource) unlock(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.Unlock(userID, CurrentUser(r.Context()), ClientFromRequest(r)); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.NoContent(w, r)
+}
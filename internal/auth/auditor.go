@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+)
+
+// Security events recorded by the auth service
+const (
+	EventAccountLocked     = "account.locked"
+	EventAccountUnlocked   = "account.unlocked"
+	EventEmailVerified     = "user.email_verified"
+	EventTwoFactorEnabled  = "two_factor.enabled"
+	EventTwoFactorDisabled = "two_factor.disabled"
+)
+
+// Metadata returns the audit metadata of a change made by actor, nil for
+// anonymous clients and system tasks, from the client.
+func (c Client) Metadata(actor *entity.User) audit.Metadata {
+	m := audit.Metadata{IP: c.IP, UserAgent: c.UserAgent, RequestID: c.RequestID}
+
+	if actor != nil {
+		m.ActorID = &actor.ID
+	}
+
+	return m
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/jobs"
+	"github.com/opaulochaves/myserver/internal/util"
+	"github.com/pkg/errors"
+)
+
+// VerificationJob sends a verification email. The token is created when the
+// job runs so it is never stored in the clear.
+type VerificationJob struct {
+	UserID int64 `json:"user_id"`
+}
+
+// Kind implements jobs.Job
+func (VerificationJob) Kind() string {
+	return "auth.verification"
+}
+
+// AccountLockedJob tells a user their account was locked.
+type AccountLockedJob struct {
+	UserID int64     `json:"user_id"`
+	Until  time.Time `json:"until"`
+	IP     string    `json:"ip"`
+}
+
+// Kind implements jobs.Job
+func (AccountLockedJob) Kind() string {
+	return "auth.account_locked"
+}
+
+// StartVerification implements user.Verifier
+//
+// The email is sent by SendVerification, a verification waiting to be sent
+// is not queued twice.
+func (s service) StartVerification(u *entity.User) error {
+	_, err := jobs.Enqueue(s.db, nil, VerificationJob{UserID: u.ID}, jobs.Unique(strconv.FormatInt(u.ID, 10)))
+	return err
+}
+
+// SendVerification implements Service
+func (s service) SendVerification(ctx context.Context, job VerificationJob) error {
+	u, err := s.users.GetUser(job.UserID)
+	if err != nil {
+		// deleted in the meantime
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if u.IsVerified() {
+		return nil
+	}
+
+	token, err := util.RandomToken(32)
+	if err != nil {
+		return errors.Wrap(err, "generating verification token error")
+	}
+
+	expiresAt := time.Now().Add(s.opts.VerificationTokenTTL)
+	if err := s.repo.CreateVerificationToken(u.ID, util.HashToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	link := s.opts.AppURL + "/verify?token=" + url.QueryEscape(token)
+
+	return s.mailer.SendVerification(u, link)
+}
+
+// SendAccountLocked implements Service
+func (s service) SendAccountLocked(ctx context.Context, job AccountLockedJob) error {
+	u, err := s.users.GetUser(job.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	return s.mailer.SendAccountLocked(u, job.Until, job.IP)
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/jobs"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+// LockoutOptions configures the brute-force protection of logins.
+type LockoutOptions struct {
+	// Threshold is the number of failed logins within Window locking an account
+	Threshold int
+	Window    time.Duration
+	// BaseDuration is how long the first lock lasts, it doubles with every
+	// lock until MaxDuration
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+	// IPThreshold is the number of failed logins within Window after which
+	// an IP address is refused, whatever the account
+	IPThreshold int
+}
+
+// tooManyFailures is the message of the refused clients and locked accounts.
+const tooManyFailures = "too many failed login attempts, try again later"
+
+// Duration returns how long the lockoutCount-th consecutive lock lasts.
+func (o LockoutOptions) Duration(lockoutCount int) time.Duration {
+	d := o.BaseDuration
+
+	for i := 1; i < lockoutCount && d < o.MaxDuration; i++ {
+		d *= 2
+	}
+
+	if o.MaxDuration > 0 && d > o.MaxDuration {
+		d = o.MaxDuration
+	}
+
+	return d
+}
+
+// Unlock implements Service
+func (s service) Unlock(userID int64, actor *entity.User, client Client) error {
+	if _, err := s.users.GetUser(userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewNotFound("user", fmt.Sprint(userID))
+		}
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewAuthQueries(s.db, tx).Unlock(userID); err != nil {
+			return err
+		}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(client.Metadata(actor).Event(EventAccountUnlocked, audit.TargetUser, userID))
+	})
+}
+
+// PurgeLoginAttempts implements Service
+//
+// The attempts within the lockout window are kept whatever the retention,
+// they are still counted.
+func (s service) PurgeLoginAttempts(retention time.Duration) (int64, error) {
+	if retention < s.opts.Lockout.Window {
+		retention = s.opts.Lockout.Window
+	}
+
+	return s.repo.DeleteLoginAttempts(time.Now().Add(-retention))
+}
+
+// CheckClient implements Service
+func (s service) CheckClient(client Client) error {
+	return s.checkIP(client)
+}
+
+// RecordFailure implements Service
+//
+// The attempt is logged as a failed login of subject, it counts towards the
+// lockout of the IP address of client.
+func (s service) RecordFailure(client Client, subject string) {
+	if err := s.repo.RecordLoginAttempt(nil, subject, client.IP, false); err != nil {
+		log.Printf("record failed attempt error: %v", err)
+	}
+}
+
+// checkIP refuses clients with too many recent failed logins.
+func (s service) checkIP(client Client) error {
+	if s.opts.Lockout.IPThreshold <= 0 {
+		return nil
+	}
+
+	count, err := s.repo.CountFailedLoginsFromIP(client.IP, time.Now().Add(-s.opts.Lockout.Window))
+	if err != nil {
+		return err
+	}
+
+	if count >= s.opts.Lockout.IPThreshold {
+		return apperrors.NewTooManyRequests(tooManyFailures)
+	}
+
+	return nil
+}
+
+// checkLocked refuses accounts that are currently locked. It must run before
+// the password is compared so a locked account reveals nothing about it. The
+// error is the one of a refused client, it does not confirm the account
+// exists, the user learns about the lock by email.
+func (s service) checkLocked(u *entity.User) error {
+	lockout, err := s.repo.GetLockout(u.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if time.Now().Before(lockout.LockedUntil.Time) {
+		return apperrors.NewTooManyRequests(tooManyFailures)
+	}
+
+	return nil
+}
+
+// recordSuccess logs a successful login and resets the backoff of the account.
+func (s service) recordSuccess(u *entity.User, client Client) error {
+	if err := s.repo.RecordLoginAttempt(&u.ID, u.Email, client.IP, true); err != nil {
+		return err
+	}
+
+	return s.repo.Unlock(u.ID)
+}
+
+// recordFailure logs a failed login and locks the account once it reached
+// the threshold. u is nil when the email does not belong to any account.
+// Errors are logged so the caller can still answer with the failure.
+func (s service) recordFailure(u *entity.User, email string, client Client) {
+	var userID *int64
+	if u != nil {
+		userID = &u.ID
+	}
+
+	if err := s.repo.RecordLoginAttempt(userID, email, client.IP, false); err != nil {
+		log.Printf("record login attempt error: %v", err)
+		return
+	}
+
+	if u == nil || s.opts.Lockout.Threshold <= 0 {
+		return
+	}
+
+	var lockout *entity.Lockout
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewAuthQueries(s.db, tx)
+
+		// failures before the end of a previous lock were already punished
+		since := time.Now().Add(-s.opts.Lockout.Window)
+		if previous, err := repo.GetLockout(u.ID); err == nil && previous.LockedUntil.Time.After(since) {
+			since = previous.LockedUntil.Time
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		count, err := repo.CountFailedLogins(u.ID, since)
+		if err != nil || count < s.opts.Lockout.Threshold {
+			return err
+		}
+
+		lockout, err = repo.Lock(u.ID, func(lockoutCount int) time.Time {
+			return time.Now().Add(s.opts.Lockout.Duration(lockoutCount))
+		})
+		// already locked by a concurrent failure
+		if err != nil || lockout == nil {
+			return err
+		}
+
+		event := client.Metadata(nil).Event(EventAccountLocked, audit.TargetUser, u.ID)
+		event.Details["locked_until"] = lockout.LockedUntil.Time
+		event.Details["lockout_count"] = lockout.LockoutCount
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		log.Printf("lock account error: %v", err)
+		return
+	}
+
+	if lockout == nil {
+		return
+	}
+
+	job := AccountLockedJob{UserID: u.ID, Until: lockout.LockedUntil.Time, IP: client.IP}
+	if _, err := jobs.Enqueue(s.db, nil, job); err != nil {
+		log.Printf("send account locked email error: %v", err)
+	}
+}
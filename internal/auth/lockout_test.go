@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	opts := LockoutOptions{BaseDuration: time.Minute, MaxDuration: 10 * time.Minute}
+
+	assert.Equal(t, time.Minute, opts.Duration(1))
+	assert.Equal(t, 2*time.Minute, opts.Duration(2))
+	assert.Equal(t, 8*time.Minute, opts.Duration(4))
+	assert.Equal(t, 10*time.Minute, opts.Duration(5))
+	assert.Equal(t, 10*time.Minute, opts.Duration(100))
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/mailer"
+)
+
+// Mailer delivers the emails sent by the auth flows.
+type Mailer interface {
+	SendVerification(user *entity.User, link string) error
+	SendAccountLocked(user *entity.User, until time.Time, ip string) error
+}
+
+type templateMailer struct {
+	mailer    mailer.Mailer
+	templates *mailer.Templates
+}
+
+// NewMailer returns a Mailer rendering the auth emails with templates and
+// sending them through m.
+func NewMailer(m mailer.Mailer, templates *mailer.Templates) Mailer {
+	return templateMailer{m, templates}
+}
+
+// SendVerification implements Mailer
+func (m templateMailer) SendVerification(user *entity.User, link string) error {
+	return m.send("verification", user, map[string]interface{}{
+		"Name": user.FirstName,
+		"Link": link,
+	})
+}
+
+// SendAccountLocked implements Mailer
+func (m templateMailer) SendAccountLocked(user *entity.User, until time.Time, ip string) error {
+	return m.send("account_locked", user, map[string]interface{}{
+		"Name":  user.FirstName,
+		"Until": until.UTC().Format(time.RFC1123),
+		"IP":    ip,
+	})
+}
+
+func (m templateMailer) send(name string, user *entity.User, data interface{}) error {
+	msg, err := m.templates.Render(name, []string{user.Email}, data)
+	if err != nil {
+		return err
+	}
+
+	return m.mailer.Send(msg)
+}
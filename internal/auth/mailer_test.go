@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/mailer"
+	"github.com/opaulochaves/myserver/internal/mailer/mailertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailerSendVerification(t *testing.T) {
+	templates, err := mailer.NewTemplates()
+	require.NoError(t, err)
+
+	recorder := mailertest.NewRecorder()
+	m := NewMailer(recorder, templates)
+
+	u := &entity.User{FirstName: "User", Email: "user01@example.com"}
+	require.NoError(t, m.SendVerification(u, "http://localhost:3000/verify?token=abc"))
+
+	sent := recorder.SentTo("user01@example.com")
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0].Text, "http://localhost:3000/verify?token=abc")
+}
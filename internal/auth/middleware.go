@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+)
+
+type contextKey struct{}
+
+type expiryKey struct{}
+
+// CurrentUser returns the user authenticated for the request, or nil.
+func CurrentUser(ctx context.Context) *entity.User {
+	u, _ := ctx.Value(contextKey{}).(*entity.User)
+	return u
+}
+
+// WithUser returns a copy of ctx carrying u as the authenticated user.
+func WithUser(ctx context.Context, u *entity.User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// ExpiresAt returns when the access token authenticating the request
+// expires, or the zero time.
+func ExpiresAt(ctx context.Context) time.Time {
+	t, _ := ctx.Value(expiryKey{}).(time.Time)
+	return t
+}
+
+// WithExpiry returns a copy of ctx whose access token expires at t.
+func WithExpiry(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, expiryKey{}, t)
+}
+
+// Authenticate requires a valid bearer access token and loads its user
+// into the request context, with the expiry of the token.
+func Authenticate(service Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(header, "Bearer ")
+
+			if header == "" || token == header {
+				render.Render(w, r, apperrors.ErrFromError(apperrors.NewAuthorization(apperrors.Unauthorized)))
+				return
+			}
+
+			u, expiresAt, err := service.AuthenticateToken(token)
+			if err != nil {
+				render.Render(w, r, apperrors.ErrFromError(err))
+				return
+			}
+
+			ctx := audit.WithActor(WithExpiry(WithUser(r.Context(), u), expiresAt), u.ID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireVerified refuses users who did not verify their email address yet,
+// unless the verification policy is off. It must run after Authenticate.
+func RequireVerified(service Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := CurrentUser(r.Context())
+
+			if u == nil {
+				render.Render(w, r, apperrors.ErrFromError(apperrors.NewAuthorization(apperrors.Unauthorized)))
+				return
+			}
+
+			if service.Policy() != PolicyOff && !u.IsVerified() {
+				render.Render(w, r, apperrors.ErrFromError(apperrors.NewForbidden(emailNotVerified)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAdmin refuses users who are not admins. It must run after Authenticate.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := CurrentUser(r.Context())
+
+		if u == nil {
+			render.Render(w, r, apperrors.ErrFromError(apperrors.NewAuthorization(apperrors.Unauthorized)))
+			return
+		}
+
+		if !u.IsAdmin {
+			render.Render(w, r, apperrors.ErrFromError(apperrors.NewForbidden(apperrors.Unauthorized)))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSelfOrAdmin refuses requests on another user, the {id} URL
+// parameter, unless the authenticated user is an admin. It must run after
+// Authenticate.
+func RequireSelfOrAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrInvalidRequest(err))
+			return
+		}
+
+		u := CurrentUser(r.Context())
+
+		if u == nil {
+			render.Render(w, r, apperrors.ErrFromError(apperrors.NewAuthorization(apperrors.Unauthorized)))
+			return
+		}
+
+		if u.ID != id && !u.IsAdmin {
+			render.Render(w, r, apperrors.ErrFromError(apperrors.NewForbidden(apperrors.Unauthorized)))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
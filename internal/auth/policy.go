@@ -0,0 +1,26 @@
+package auth
+
+import "fmt"
+
+// Policy decides what an account with an unverified email address may do.
+type Policy string
+
+const (
+	// PolicyOff does not restrict unverified accounts
+	PolicyOff Policy = "off"
+	// PolicyLogin refuses to log unverified accounts in
+	PolicyLogin Policy = "login"
+	// PolicyRoutes lets unverified accounts log in but refuses routes
+	// guarded by RequireVerified
+	PolicyRoutes Policy = "routes"
+)
+
+// ParsePolicy converts the configured value into a Policy.
+func ParsePolicy(value string) (Policy, error) {
+	switch p := Policy(value); p {
+	case PolicyOff, PolicyLogin, PolicyRoutes:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown email verification policy %q", value)
+	}
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+type AuthQueries interface {
+	CreateVerificationToken(userID int64, tokenHash string, expiresAt time.Time) error
+	GetVerificationToken(tokenHash string) (*entity.VerificationToken, error)
+	LastVerificationToken(userID int64) (*entity.VerificationToken, error)
+	UseVerificationToken(id int64) error
+	CreateSession(session *entity.Session, expiresAt time.Time) (*entity.Session, error)
+	GetSession(tokenHash string) (*entity.Session, error)
+	RevokeSession(id int64) error
+	DeleteExpiredTokens(now time.Time) (int64, error)
+	GetTOTP(userID int64) (*entity.TOTP, error)
+	SaveTOTP(userID int64, secret []byte) error
+	ConfirmTOTP(userID int64) error
+	UseTOTPStep(userID int64, step int64) error
+	DeleteTOTP(userID int64) error
+	ReplaceRecoveryCodes(userID int64, codeHashes []string) error
+	UseRecoveryCode(userID int64, codeHash string) error
+	RecordLoginAttempt(userID *int64, email string, ip string, success bool) error
+	CountFailedLogins(userID int64, since time.Time) (int, error)
+	CountFailedLoginsFromIP(ip string, since time.Time) (int, error)
+	DeleteLoginAttempts(before time.Time) (int64, error)
+	GetLockout(userID int64) (*entity.Lockout, error)
+	Lock(userID int64, lockedUntil func(lockoutCount int) time.Time) (*entity.Lockout, error)
+	Unlock(userID int64) error
+}
+
+// authQueries struct for queries from the verification_tokens, sessions,
+// two-factor and lockout tables.
+type authQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewAuthQueries(db *sqlx.DB, tx *sqlx.Tx) AuthQueries {
+	return &authQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *authQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// CreateVerificationToken implements AuthQueries
+func (q *authQueries) CreateVerificationToken(userID int64, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO verification_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+
+	_, err := q.conn().Exec(query, userID, tokenHash, expiresAt)
+	if err != nil {
+		return errors.Wrap(err, "insert verification token error")
+	}
+
+	return nil
+}
+
+// GetVerificationToken implements AuthQueries
+func (q *authQueries) GetVerificationToken(tokenHash string) (*entity.VerificationToken, error) {
+	var token entity.VerificationToken
+
+	query := `SELECT * FROM verification_tokens WHERE token_hash = $1`
+
+	err := sqlx.Get(q.conn(), &token, query, tokenHash)
+
+	return &token, err
+}
+
+// LastVerificationToken implements AuthQueries
+func (q *authQueries) LastVerificationToken(userID int64) (*entity.VerificationToken, error) {
+	var token entity.VerificationToken
+
+	query := `SELECT * FROM verification_tokens WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
+
+	err := sqlx.Get(q.conn(), &token, query, userID)
+
+	return &token, err
+}
+
+// UseVerificationToken implements AuthQueries
+func (q *authQueries) UseVerificationToken(id int64) error {
+	query := `UPDATE verification_tokens SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`
+
+	res, err := q.conn().Exec(query, id)
+	if err != nil {
+		return errors.Wrap(err, "use verification token error")
+	}
+
+	// a concurrent request consumed the token first
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// CreateSession implements AuthQueries
+func (q *authQueries) CreateSession(s *entity.Session, expiresAt time.Time) (*entity.Session, error) {
+	query := `INSERT INTO sessions (user_id, token_hash, user_agent, ip, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *`
+
+	var session entity.Session
+
+	err := q.conn().QueryRowx(query, s.UserID, s.TokenHash, s.UserAgent, s.IP, expiresAt).StructScan(&session)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert session error")
+	}
+
+	return &session, nil
+}
+
+// GetSession implements AuthQueries
+func (q *authQueries) GetSession(tokenHash string) (*entity.Session, error) {
+	var session entity.Session
+
+	query := `SELECT * FROM sessions WHERE token_hash = $1`
+
+	err := sqlx.Get(q.conn(), &session, query, tokenHash)
+
+	return &session, err
+}
+
+// RevokeSession implements AuthQueries
+func (q *authQueries) RevokeSession(id int64) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	res, err := q.conn().Exec(query, id)
+	if err != nil {
+		return errors.Wrap(err, "revoke session error")
+	}
+
+	// a concurrent request revoked the session first
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// DeleteExpiredTokens implements AuthQueries
+//
+// It deletes the verification tokens and the sessions which expired before
+// now, used or revoked ones included. It returns how many were deleted.
+func (q *authQueries) DeleteExpiredTokens(now time.Time) (int64, error) {
+	var n int64
+
+	for _, table := range []string{"verification_tokens", "sessions"} {
+		res, err := q.conn().Exec(`DELETE FROM `+table+` WHERE expires_at < $1`, now)
+		if err != nil {
+			return n, errors.Wrapf(err, "delete expired %s error", table)
+		}
+
+		count, err := res.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+		n += count
+	}
+
+	return n, nil
+}
+
+// GetTOTP implements AuthQueries
+func (q *authQueries) GetTOTP(userID int64) (*entity.TOTP, error) {
+	var totp entity.TOTP
+
+	query := `SELECT * FROM user_totp WHERE user_id = $1`
+
+	err := sqlx.Get(q.conn(), &totp, query, userID)
+
+	return &totp, err
+}
+
+// SaveTOTP implements AuthQueries
+//
+// A pending enrolment is replaced, a confirmed one is left untouched.
+func (q *authQueries) SaveTOTP(userID int64, secret []byte) error {
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL`
+
+	res, err := q.conn().Exec(query, userID, secret)
+	if err != nil {
+		return errors.Wrap(err, "save totp error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// ConfirmTOTP implements AuthQueries
+func (q *authQueries) ConfirmTOTP(userID int64) error {
+	query := `UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1`
+
+	_, err := q.conn().Exec(query, userID)
+	if err != nil {
+		return errors.Wrap(err, "confirm totp error")
+	}
+
+	return nil
+}
+
+// UseTOTPStep implements AuthQueries
+func (q *authQueries) UseTOTPStep(userID int64, step int64) error {
+	query := `UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+
+	res, err := q.conn().Exec(query, userID, step)
+	if err != nil {
+		return errors.Wrap(err, "use totp step error")
+	}
+
+	// the code was already used
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// DeleteTOTP implements AuthQueries
+func (q *authQueries) DeleteTOTP(userID int64) error {
+	if _, err := q.conn().Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return errors.Wrap(err, "delete recovery codes error")
+	}
+
+	if _, err := q.conn().Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return errors.Wrap(err, "delete totp error")
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes implements AuthQueries
+func (q *authQueries) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	if _, err := q.conn().Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return errors.Wrap(err, "delete recovery codes error")
+	}
+
+	query := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+
+	for _, hash := range codeHashes {
+		if _, err := q.conn().Exec(query, userID, hash); err != nil {
+			return errors.Wrap(err, "insert recovery code error")
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode implements AuthQueries
+func (q *authQueries) UseRecoveryCode(userID int64, codeHash string) error {
+	query := `UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	res, err := q.conn().Exec(query, userID, codeHash)
+	if err != nil {
+		return errors.Wrap(err, "use recovery code error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// RecordLoginAttempt implements AuthQueries
+func (q *authQueries) RecordLoginAttempt(userID *int64, email string, ip string, success bool) error {
+	query := `INSERT INTO login_attempts (user_id, email, ip, success) VALUES ($1, $2, $3, $4)`
+
+	_, err := q.conn().Exec(query, userID, email, ip, success)
+	if err != nil {
+		return errors.Wrap(err, "insert login attempt error")
+	}
+
+	return nil
+}
+
+// CountFailedLogins implements AuthQueries
+//
+// Only failures after the last successful login are counted.
+func (q *authQueries) CountFailedLogins(userID int64, since time.Time) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(id) FROM login_attempts
+		WHERE user_id = $1 AND NOT success AND created_at > $2
+		AND created_at > COALESCE((SELECT MAX(created_at) FROM login_attempts WHERE user_id = $1 AND success), '-infinity')`
+
+	err := q.conn().QueryRowx(query, userID, since).Scan(&count)
+
+	return count, err
+}
+
+// CountFailedLoginsFromIP implements AuthQueries
+func (q *authQueries) CountFailedLoginsFromIP(ip string, since time.Time) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(id) FROM login_attempts WHERE ip = $1 AND NOT success AND created_at > $2`
+
+	err := q.conn().QueryRowx(query, ip, since).Scan(&count)
+
+	return count, err
+}
+
+// DeleteLoginAttempts implements AuthQueries
+//
+// It deletes the login attempts made before before and returns how many
+// were deleted.
+func (q *authQueries) DeleteLoginAttempts(before time.Time) (int64, error) {
+	res, err := q.conn().Exec(`DELETE FROM login_attempts WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete login attempts error")
+	}
+
+	return res.RowsAffected()
+}
+
+// GetLockout implements AuthQueries
+func (q *authQueries) GetLockout(userID int64) (*entity.Lockout, error) {
+	var lockout entity.Lockout
+
+	query := `SELECT * FROM account_lockouts WHERE user_id = $1`
+
+	err := sqlx.Get(q.conn(), &lockout, query, userID)
+
+	return &lockout, err
+}
+
+// Lock implements AuthQueries
+//
+// The row is locked while the new lockout count is computed so concurrent
+// failures do not skip a backoff step. An account still locked is left as
+// it is, nil is returned: concurrent failures must not extend the lock.
+func (q *authQueries) Lock(userID int64, lockedUntil func(lockoutCount int) time.Time) (*entity.Lockout, error) {
+	var count int
+
+	err := q.conn().QueryRowx(`SELECT lockout_count FROM account_lockouts WHERE user_id = $1 FOR UPDATE`, userID).Scan(&count)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, "select lockout error")
+	}
+
+	count++
+
+	query := `INSERT INTO account_lockouts (user_id, lockout_count, locked_at, locked_until) VALUES ($1, $2, NOW(), $3)
+		ON CONFLICT (user_id) DO UPDATE SET lockout_count = EXCLUDED.lockout_count, locked_at = EXCLUDED.locked_at, locked_until = EXCLUDED.locked_until
+		WHERE account_lockouts.locked_until < NOW()
+		RETURNING *`
+
+	var lockout entity.Lockout
+
+	err = q.conn().QueryRowx(query, userID, count, lockedUntil(count)).StructScan(&lockout)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "lock account error")
+	}
+
+	return &lockout, nil
+}
+
+// Unlock implements AuthQueries
+func (q *authQueries) Unlock(userID int64) error {
+	_, err := q.conn().Exec(`DELETE FROM account_lockouts WHERE user_id = $1`, userID)
+	if err != nil {
+		return errors.Wrap(err, "unlock account error")
+	}
+
+	return nil
+}
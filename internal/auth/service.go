@@ -0,0 +1,533 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+const (
+	invalidCredentials = "invalid email or password"
+	emailNotVerified   = "email address is not verified"
+)
+
+type Service interface {
+	user.Verifier
+	Verify(token string, client Client) error
+	ResendVerification(email string) error
+	Login(input LoginRequest, client Client) (LoginResponse, error)
+	CompleteLogin(input TwoFactorLoginRequest, client Client) (TokenResponse, error)
+	Refresh(input RefreshRequest, client Client) (TokenResponse, error)
+	Logout(input RefreshRequest) error
+	Authenticate(accessToken string) (*entity.User, error)
+	// AuthenticateToken is Authenticate also returning when the access
+	// token expires.
+	AuthenticateToken(accessToken string) (*entity.User, time.Time, error)
+	EnrollTwoFactor(u *entity.User) (TwoFactorEnrollment, error)
+	ConfirmTwoFactor(u *entity.User, input CodeRequest, client Client) (RecoveryCodes, error)
+	DisableTwoFactor(u *entity.User, input CodeRequest, client Client) error
+	Unlock(userID int64, actor *entity.User, client Client) error
+	// CheckClient refuses the clients with too many recent failed attempts,
+	// RecordFailure counts one. Other services share the lockout of logins
+	// with them.
+	CheckClient(client Client) error
+	RecordFailure(client Client, subject string)
+	Policy() Policy
+	// PurgeExpiredTokens deletes the expired verification tokens and sessions.
+	PurgeExpiredTokens() (int64, error)
+	// PurgeLoginAttempts deletes the login attempts older than retention.
+	PurgeLoginAttempts(retention time.Duration) (int64, error)
+	// SendVerification is the handler of VerificationJob.
+	SendVerification(ctx context.Context, job VerificationJob) error
+	// SendAccountLocked is the handler of AccountLockedJob.
+	SendAccountLocked(ctx context.Context, job AccountLockedJob) error
+}
+
+// Client describes where a request comes from.
+type Client struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// ClientFromRequest extracts the Client of an HTTP request.
+func ClientFromRequest(r *http.Request) Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return Client{IP: ip, UserAgent: r.UserAgent(), RequestID: middleware.GetReqID(r.Context())}
+}
+
+// LoginRequest represents a login request.
+type LoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Bind implements render.Binder
+func (*LoginRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the LoginRequest fields.
+func (l LoginRequest) Validate() error {
+	return validation.ValidateStruct(&l,
+		validation.Field(&l.Email, validation.Required, is.Email),
+		validation.Field(&l.Password, validation.Required),
+	)
+}
+
+// RefreshRequest represents a request carrying a refresh token.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Bind implements render.Binder
+func (*RefreshRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the RefreshRequest fields.
+func (l RefreshRequest) Validate() error {
+	return validation.ValidateStruct(&l,
+		validation.Field(&l.RefreshToken, validation.Required),
+	)
+}
+
+// VerifyRequest represents an email verification request.
+type VerifyRequest struct {
+	Token string `json:"token"`
+}
+
+// Bind implements render.Binder
+func (*VerifyRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// ResendRequest represents a request for a new verification email.
+type ResendRequest struct {
+	Email string `json:"email"`
+}
+
+// Bind implements render.Binder
+func (*ResendRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the ResendRequest fields.
+func (l ResendRequest) Validate() error {
+	return validation.ValidateStruct(&l,
+		validation.Field(&l.Email, validation.Required, is.Email),
+	)
+}
+
+// TokenResponse is returned once a user is authenticated.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Render implements render.Renderer
+//
+// The tokens must not be cached nor stored for idempotent replays.
+func (*TokenResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "no-store")
+	return nil
+}
+
+// LoginResponse is returned by Login. Accounts with two-factor
+// authentication get a challenge token to complete with CompleteLogin
+// instead of the tokens.
+type LoginResponse struct {
+	*TokenResponse
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+}
+
+// Render implements render.Renderer
+func (*LoginResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "no-store")
+	return nil
+}
+
+// Options holds the settings of the auth service.
+type Options struct {
+	Policy                     Policy
+	AppURL                     string
+	VerificationTokenTTL       time.Duration
+	VerificationResendInterval time.Duration
+	AccessTokenTTL             time.Duration
+	RefreshTokenTTL            time.Duration
+	// Issuer names the application in authenticator apps
+	Issuer string
+	// ChallengeTTL is how long a two-factor challenge can be completed
+	ChallengeTTL time.Duration
+	Lockout      LockoutOptions
+}
+
+// dummyHash returns a hash of a random password compared when the email of
+// a login has no account, it costs as much as comparing a real one.
+var dummyHash = func() func() string {
+	var (
+		once sync.Once
+		hash string
+	)
+
+	return func() string {
+		once.Do(func() {
+			password, err := util.RandomToken(16)
+			if err == nil {
+				hash, err = util.HashPassword(password)
+			}
+			if err != nil {
+				log.Printf("dummy password hash error: %v", err)
+			}
+		})
+		return hash
+	}
+}()
+
+type service struct {
+	db     *sqlx.DB
+	repo   AuthQueries
+	users  user.UserQueries
+	signer TokenSigner
+	cipher *util.Cipher
+	mailer Mailer
+	opts   Options
+}
+
+func NewService(db *sqlx.DB, repo AuthQueries, users user.UserQueries, signer TokenSigner, cipher *util.Cipher, mailer Mailer, opts Options) Service {
+	return service{db, repo, users, signer, cipher, mailer, opts}
+}
+
+// Policy implements Service
+func (s service) Policy() Policy {
+	return s.opts.Policy
+}
+
+// PurgeExpiredTokens implements Service
+func (s service) PurgeExpiredTokens() (int64, error) {
+	return s.repo.DeleteExpiredTokens(time.Now())
+}
+
+// Verify implements Service
+func (s service) Verify(token string, client Client) error {
+	if token == "" {
+		return apperrors.NewBadRequest("missing verification token")
+	}
+
+	vt, err := s.repo.GetVerificationToken(util.HashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewBadRequest("invalid verification token")
+		}
+		return err
+	}
+
+	if vt.UsedAt.Valid || time.Now().After(vt.ExpiresAt.Time) {
+		return apperrors.NewBadRequest("verification token is expired or was already used")
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewAuthQueries(s.db, tx).UseVerificationToken(vt.ID); err != nil {
+			if errors.Is(err, ErrInvalidToken) {
+				return apperrors.NewBadRequest("verification token is expired or was already used")
+			}
+			return err
+		}
+
+		if err := user.NewUserQueries(s.db, tx).VerifyUser(vt.UserID); err != nil {
+			// deleted since the token was sent
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("user", fmt.Sprint(vt.UserID))
+			}
+			return err
+		}
+
+		// following the link proves the user is behind the request
+		m := client.Metadata(nil)
+		m.ActorID = &vt.UserID
+
+		return audit.NewAuditQueries(s.db, tx).Insert(m.Event(EventEmailVerified, audit.TargetUser, vt.UserID))
+	})
+}
+
+// ResendVerification implements Service
+//
+// Unknown and already verified addresses are ignored, and the resends within
+// the resend interval are dropped silently, so the endpoint answers the same
+// whether or not the email has an account.
+func (s service) ResendVerification(email string) error {
+	if err := (ResendRequest{Email: email}).Validate(); err != nil {
+		return err
+	}
+
+	u, err := s.users.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if u.IsVerified() {
+		return nil
+	}
+
+	last, err := s.repo.LastVerificationToken(u.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err == nil && time.Since(last.CreatedAt.Time) < s.opts.VerificationResendInterval {
+		return nil
+	}
+
+	return s.StartVerification(u)
+}
+
+// Login implements Service
+func (s service) Login(input LoginRequest, client Client) (LoginResponse, error) {
+	if err := input.Validate(); err != nil {
+		return LoginResponse{}, err
+	}
+
+	if err := s.checkIP(client); err != nil {
+		return LoginResponse{}, err
+	}
+
+	u, err := s.users.GetUserByEmail(input.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// hash anyway so the response time does not reveal the account
+			// does not exist
+			util.ComparePasswords(dummyHash(), input.Password)
+			s.recordFailure(nil, input.Email, client)
+			return LoginResponse{}, apperrors.NewAuthorization(invalidCredentials)
+		}
+		return LoginResponse{}, err
+	}
+
+	if err := s.checkLocked(u); err != nil {
+		return LoginResponse{}, err
+	}
+
+	ok, err := util.ComparePasswords(u.Password, input.Password)
+	if err != nil || !ok {
+		s.recordFailure(u, input.Email, client)
+		return LoginResponse{}, apperrors.NewAuthorization(invalidCredentials)
+	}
+
+	s.rehashPassword(u, input.Password)
+
+	if s.opts.Policy == PolicyLogin && !u.IsVerified() {
+		return LoginResponse{}, apperrors.NewForbidden(emailNotVerified)
+	}
+
+	enabled, err := s.twoFactorEnabled(u.ID)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	// the login only succeeds once the challenge is completed, recording it
+	// now would reset the failures counted against two-factor codes
+	if enabled {
+		challenge, err := s.signer.Sign(u.ID, PurposeTwoFactor, s.opts.ChallengeTTL)
+		if err != nil {
+			return LoginResponse{}, errors.Wrap(err, "signing challenge token error")
+		}
+
+		return LoginResponse{TwoFactorRequired: true, ChallengeToken: challenge}, nil
+	}
+
+	if err := s.recordSuccess(u, client); err != nil {
+		return LoginResponse{}, err
+	}
+
+	tokens, err := s.issueTokens(s.repo, u.ID, client)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	return LoginResponse{TokenResponse: &tokens}, nil
+}
+
+// Refresh implements Service
+//
+// The refresh token is rotated: the presented session is revoked and a new
+// one is issued. The sessions of deleted users are refused.
+func (s service) Refresh(input RefreshRequest, client Client) (TokenResponse, error) {
+	if err := input.Validate(); err != nil {
+		return TokenResponse{}, err
+	}
+
+	var res TokenResponse
+
+	// the session is revoked in the transaction issuing the new one, a
+	// concurrent refresh with the same token fails to revoke it
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewAuthQueries(s.db, tx)
+
+		session, err := s.activeSession(repo, input.RefreshToken)
+		if err != nil {
+			return err
+		}
+
+		if err := revokeSession(repo, session.ID); err != nil {
+			return err
+		}
+
+		if _, err := s.users.GetUser(session.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewAuthorization(apperrors.InvalidSession)
+			}
+			return err
+		}
+
+		res, err = s.issueTokens(repo, session.UserID, client)
+		return err
+	})
+
+	return res, err
+}
+
+// Logout implements Service
+func (s service) Logout(input RefreshRequest) error {
+	if err := input.Validate(); err != nil {
+		return err
+	}
+
+	session, err := s.activeSession(s.repo, input.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return revokeSession(s.repo, session.ID)
+}
+
+// Authenticate implements Service
+func (s service) Authenticate(accessToken string) (*entity.User, error) {
+	u, _, err := s.AuthenticateToken(accessToken)
+	return u, err
+}
+
+// AuthenticateToken implements Service
+func (s service) AuthenticateToken(accessToken string) (*entity.User, time.Time, error) {
+	claims, err := s.signer.Parse(accessToken, PurposeAccess)
+	if err != nil {
+		return nil, time.Time{}, apperrors.NewAuthorization(err.Error())
+	}
+
+	u, err := s.users.GetUser(claims.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, time.Time{}, apperrors.NewAuthorization(apperrors.InvalidSession)
+		}
+		return nil, time.Time{}, err
+	}
+
+	return u, time.Unix(claims.ExpiresAt, 0), nil
+}
+
+// rehashPassword upgrades the stored hash of u when it was created with an
+// outdated algorithm or parameters. The password is only known at login so
+// this is the one place it can happen. Failures are logged, they must not
+// prevent the login.
+func (s service) rehashPassword(u *entity.User, password string) {
+	if !util.NeedsRehash(u.Password) {
+		return
+	}
+
+	hashedPassword, err := util.HashPassword(password)
+	if err != nil {
+		log.Printf("rehash password error: %v", err)
+		return
+	}
+
+	if err := s.users.UpdatePassword(u.ID, hashedPassword); err != nil {
+		log.Printf("rehash password error: %v", err)
+		return
+	}
+
+	u.Password = hashedPassword
+}
+
+func (s service) activeSession(repo AuthQueries, refreshToken string) (*entity.Session, error) {
+	session, err := repo.GetSession(util.HashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.NewAuthorization(apperrors.InvalidSession)
+		}
+		return nil, err
+	}
+
+	if session.RevokedAt.Valid || time.Now().After(session.ExpiresAt.Time) {
+		return nil, apperrors.NewAuthorization(apperrors.InvalidSession)
+	}
+
+	return session, nil
+}
+
+// revokeSession revokes the session id, which was revoked concurrently when
+// no row is left to revoke.
+func revokeSession(repo AuthQueries, id int64) error {
+	if err := repo.RevokeSession(id); err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return apperrors.NewAuthorization(apperrors.InvalidSession)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s service) issueTokens(repo AuthQueries, userID int64, client Client) (TokenResponse, error) {
+	accessToken, err := s.signer.Sign(userID, PurposeAccess, s.opts.AccessTokenTTL)
+	if err != nil {
+		return TokenResponse{}, errors.Wrap(err, "signing access token error")
+	}
+
+	refreshToken, err := util.RandomToken(32)
+	if err != nil {
+		return TokenResponse{}, errors.Wrap(err, "generating refresh token error")
+	}
+
+	_, err = repo.CreateSession(&entity.Session{
+		UserID:    userID,
+		TokenHash: util.HashToken(refreshToken),
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+	}, time.Now().Add(s.opts.RefreshTokenTTL))
+
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.opts.AccessTokenTTL.Seconds()),
+	}, nil
+}
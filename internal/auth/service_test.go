@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/jobs"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/opaulochaves/myserver/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type serviceSuiteTest struct {
+	test.TSuite
+}
+
+func TestServiceSuiteTest(t *testing.T) {
+	suite.Run(t, new(serviceSuiteTest))
+}
+
+// recordingMailer keeps the verification links and the ends of the locks
+// instead of sending them.
+type recordingMailer struct {
+	mu     sync.Mutex
+	links  []string
+	locked []time.Time
+}
+
+func (m *recordingMailer) SendVerification(u *entity.User, link string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.links = append(m.links, link)
+
+	return nil
+}
+
+func (m *recordingMailer) SendAccountLocked(u *entity.User, until time.Time, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.locked = append(m.locked, until)
+
+	return nil
+}
+
+func (m *recordingMailer) sent() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.links...)
+}
+
+func (t *serviceSuiteTest) newService(policy Policy, m Mailer) Service {
+	return t.newServiceWith(m, func(opts *Options) { opts.Policy = policy })
+}
+
+// newServiceWith returns a service whose options are changed by configure.
+func (t *serviceSuiteTest) newServiceWith(m Mailer, configure func(opts *Options)) Service {
+	cipher, err := util.NewCipher("test-key")
+	require.NoError(t.T(), err)
+
+	opts := Options{
+		AppURL:                     "http://localhost:3000",
+		VerificationTokenTTL:       time.Hour,
+		VerificationResendInterval: time.Minute,
+		AccessTokenTTL:             time.Minute,
+		RefreshTokenTTL:            time.Hour,
+	}
+	configure(&opts)
+
+	// the service runs its own transactions, it cannot share the one of the test
+	return NewService(t.DB, NewAuthQueries(t.DB, nil), user.NewUserQueries(t.DB, nil), NewTokenSigner("secret"), cipher, m, opts)
+}
+
+// runJobs runs the queued jobs of service, sending its emails.
+func (t *serviceSuiteTest) runJobs(service Service) {
+	pool := jobs.NewPool(t.DB, jobs.Options{})
+	jobs.Register(pool, service.SendVerification)
+	jobs.Register(pool, service.SendAccountLocked)
+
+	for {
+		ran, err := pool.RunNext(context.Background())
+		require.NoError(t.T(), err)
+		if !ran {
+			return
+		}
+	}
+}
+
+func (t *serviceSuiteTest) createUser() *entity.User {
+	u := test.GenerateUsers(1)[0]
+
+	created, err := user.NewUserQueries(t.DB, nil).CreateUser(&u)
+	require.NoError(t.T(), err)
+
+	return created
+}
+
+func (t *serviceSuiteTest) TestPolicyLogin() {
+	m := &recordingMailer{}
+	service := t.newService(PolicyLogin, m)
+	u := t.createUser()
+
+	_, err := service.Login(LoginRequest{Email: u.Email, Password: "12345678"}, Client{})
+	assert.Equal(t.T(), http.StatusForbidden, apperrors.Status(err))
+
+	require.NoError(t.T(), service.StartVerification(u))
+	// the token is created when the email is sent, it is not queued
+	var payload string
+	require.NoError(t.T(), t.DB.Get(&payload, `SELECT payload FROM jobs WHERE kind = $1`, VerificationJob{}.Kind()))
+	assert.JSONEq(t.T(), fmt.Sprintf(`{"user_id":%d}`, u.ID), payload)
+
+	t.runJobs(service)
+	require.Len(t.T(), m.sent(), 1)
+
+	link, err := url.Parse(m.sent()[0])
+	require.NoError(t.T(), err)
+	require.NoError(t.T(), service.Verify(link.Query().Get("token"), Client{}))
+
+	// a token is used once
+	err = service.Verify(link.Query().Get("token"), Client{})
+	assert.Equal(t.T(), http.StatusBadRequest, apperrors.Status(err))
+
+	res, err := service.Login(LoginRequest{Email: u.Email, Password: "12345678"}, Client{})
+	require.NoError(t.T(), err)
+	assert.NotEmpty(t.T(), res.AccessToken)
+}
+
+func (t *serviceSuiteTest) TestPolicyRoutes() {
+	service := t.newService(PolicyRoutes, &recordingMailer{})
+	u := t.createUser()
+
+	// unverified users log in but are refused the protected routes
+	res, err := service.Login(LoginRequest{Email: u.Email, Password: "12345678"}, Client{})
+	require.NoError(t.T(), err)
+
+	authenticated, err := service.Authenticate(res.AccessToken)
+	require.NoError(t.T(), err)
+
+	handler := RequireVerified(service)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(u *entity.User) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		handler.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+		return w.Code
+	}
+
+	assert.Equal(t.T(), http.StatusForbidden, serve(authenticated))
+
+	require.NoError(t.T(), user.NewUserQueries(t.DB, nil).VerifyUser(u.ID))
+
+	authenticated, err = service.Authenticate(res.AccessToken)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), http.StatusOK, serve(authenticated))
+
+	// with the policy off, unverified users are let through
+	u.VerifiedAt.Valid = false
+	off := RequireVerified(t.newService(PolicyOff, &recordingMailer{}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	off.ServeHTTP(w, r.WithContext(WithUser(r.Context(), u)))
+	assert.Equal(t.T(), http.StatusOK, w.Code)
+}
+
+func (t *serviceSuiteTest) TestResendVerification() {
+	m := &recordingMailer{}
+	service := t.newService(PolicyLogin, m)
+	u := t.createUser()
+
+	require.NoError(t.T(), service.ResendVerification(u.Email))
+	t.runJobs(service)
+	require.Len(t.T(), m.sent(), 1)
+
+	// dropped silently until the resend interval passed, like the unknown
+	// addresses
+	require.NoError(t.T(), service.ResendVerification(u.Email))
+	t.runJobs(service)
+	assert.Len(t.T(), m.sent(), 1)
+
+	// unknown and verified addresses are ignored silently
+	require.NoError(t.T(), service.ResendVerification("unknown@example.com"))
+
+	require.NoError(t.T(), user.NewUserQueries(t.DB, nil).VerifyUser(u.ID))
+	_, err := t.DB.Exec(`UPDATE verification_tokens SET created_at = created_at - INTERVAL '1 hour'`)
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), service.ResendVerification(u.Email))
+	t.runJobs(service)
+	assert.Len(t.T(), m.sent(), 1)
+}
+
+func (t *serviceSuiteTest) TestLoginUnknownEmail() {
+	service := t.newService(PolicyOff, &recordingMailer{})
+
+	_, err := service.Login(LoginRequest{Email: "unknown@example.com", Password: "12345678"}, Client{})
+	assert.Equal(t.T(), http.StatusUnauthorized, apperrors.Status(err))
+}
+
+func (t *serviceSuiteTest) TestRefreshRotates() {
+	service := t.newService(PolicyOff, &recordingMailer{})
+	u := t.createUser()
+
+	res, err := service.Login(LoginRequest{Email: u.Email, Password: "12345678"}, Client{})
+	require.NoError(t.T(), err)
+
+	rotated, err := service.Refresh(RefreshRequest{RefreshToken: res.RefreshToken}, Client{})
+	require.NoError(t.T(), err)
+
+	_, err = service.Refresh(RefreshRequest{RefreshToken: res.RefreshToken}, Client{})
+	assert.Equal(t.T(), http.StatusUnauthorized, apperrors.Status(err))
+
+	// only one of concurrent refreshes with the same token succeeds
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := service.Refresh(RefreshRequest{RefreshToken: rotated.RefreshToken}, Client{}); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t.T(), 1, succeeded)
+}
+
+func (t *serviceSuiteTest) TestRefreshDeletedUser() {
+	service := t.newService(PolicyOff, &recordingMailer{})
+	u := t.createUser()
+
+	res, err := service.Login(LoginRequest{Email: u.Email, Password: "12345678"}, Client{})
+	require.NoError(t.T(), err)
+
+	// the session is left as it is, the deleted user is refused anyway
+	_, err = t.DB.Exec(`UPDATE users SET deleted_at = NOW() WHERE id = $1`, u.ID)
+	require.NoError(t.T(), err)
+
+	_, err = service.Refresh(RefreshRequest{RefreshToken: res.RefreshToken}, Client{})
+	assert.Equal(t.T(), http.StatusUnauthorized, apperrors.Status(err))
+}
+
+func (t *serviceSuiteTest) TestLoginLockout() {
+	m := &recordingMailer{}
+	service := t.newServiceWith(m, func(opts *Options) {
+		opts.Lockout = LockoutOptions{Threshold: 3, Window: time.Hour, BaseDuration: time.Hour, MaxDuration: time.Hour}
+	})
+	u := t.createUser()
+	client := Client{IP: "203.0.113.7"}
+
+	for i := 0; i < 3; i++ {
+		_, err := service.Login(LoginRequest{Email: u.Email, Password: "wrong password"}, client)
+		assert.Equal(t.T(), http.StatusUnauthorized, apperrors.Status(err))
+	}
+
+	// the right password is refused too, like an unknown client would be
+	_, err := service.Login(LoginRequest{Email: u.Email, Password: "12345678"}, client)
+	assert.Equal(t.T(), http.StatusTooManyRequests, apperrors.Status(err))
+	assert.Equal(t.T(), tooManyFailures, err.Error())
+
+	t.runJobs(service)
+	m.mu.Lock()
+	assert.Len(t.T(), m.locked, 1)
+	m.mu.Unlock()
+
+	var actions []string
+	require.NoError(t.T(), t.DB.Select(&actions, `SELECT action FROM audit_events WHERE target_id = $1 ORDER BY id`, fmt.Sprint(u.ID)))
+	assert.Equal(t.T(), []string{EventAccountLocked}, actions)
+
+	// an admin lifts the lock
+	require.NoError(t.T(), service.Unlock(u.ID, nil, Client{}))
+
+	_, err = service.Login(LoginRequest{Email: u.Email, Password: "12345678"}, client)
+	assert.NoError(t.T(), err)
+
+	require.NoError(t.T(), t.DB.Select(&actions, `SELECT action FROM audit_events WHERE target_id = $1 ORDER BY id`, fmt.Sprint(u.ID)))
+	assert.Contains(t.T(), actions, EventAccountUnlocked)
+}
+
+func (t *serviceSuiteTest) TestPurgeLoginAttempts() {
+	service := t.newServiceWith(&recordingMailer{}, func(opts *Options) {
+		opts.Lockout = LockoutOptions{Window: time.Hour}
+	})
+
+	_, err := t.DB.Exec(`INSERT INTO login_attempts (email, ip, success, created_at) VALUES
+		('old@example.com', '', FALSE, NOW() - INTERVAL '2 days'),
+		('recent@example.com', '', FALSE, NOW() - INTERVAL '30 minutes')`)
+	require.NoError(t.T(), err)
+
+	// the attempts within the lockout window are kept
+	n, err := service.PurgeLoginAttempts(time.Minute)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(1), n)
+
+	var emails []string
+	require.NoError(t.T(), t.DB.Select(&emails, `SELECT email FROM login_attempts`))
+	assert.Equal(t.T(), []string{"recent@example.com"}, emails)
+}
+
+func (t *serviceSuiteTest) TestLockKeepsActiveLock() {
+	u := t.createUser()
+	repo := NewAuthQueries(t.DB, nil)
+
+	lockedUntil := func(lockoutCount int) time.Time {
+		return time.Now().Add(time.Duration(lockoutCount) * time.Hour)
+	}
+
+	lockout, err := repo.Lock(u.ID, lockedUntil)
+	require.NoError(t.T(), err)
+	require.Equal(t.T(), 1, lockout.LockoutCount)
+
+	// a failure while locked neither extends nor doubles the lock
+	again, err := repo.Lock(u.ID, lockedUntil)
+	require.NoError(t.T(), err)
+	assert.Nil(t.T(), again)
+
+	stored, err := repo.GetLockout(u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 1, stored.LockoutCount)
+
+	// once it expired, the next lock lasts longer
+	_, err = t.DB.Exec(`UPDATE account_lockouts SET locked_until = NOW() - INTERVAL '1 second'`)
+	require.NoError(t.T(), err)
+
+	lockout, err = repo.Lock(u.ID, lockedUntil)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 2, lockout.LockoutCount)
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenSigner(t *testing.T) {
+	signer := NewTokenSigner("secret")
+
+	token, err := signer.Sign(42, PurposeAccess, time.Minute)
+	require.NoError(t, err)
+
+	claims, err := signer.Parse(token, PurposeAccess)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), claims.UserID)
+
+	_, err = signer.Parse(token, "other")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = NewTokenSigner("another secret").Parse(token, PurposeAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = signer.Parse(token+"x", PurposeAccess)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestTokenSignerExpiry(t *testing.T) {
+	signer := NewTokenSigner("secret")
+
+	token, err := signer.Sign(42, PurposeAccess, time.Minute)
+	require.NoError(t, err)
+
+	signer.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	_, err = signer.Parse(token, PurposeAccess)
+	assert.ErrorIs(t, err, ErrExpiredToken)
+}
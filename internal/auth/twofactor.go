@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/opaulochaves/myserver/pkg/totp"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PurposeTwoFactor is the purpose of the challenge tokens handed out by
+	// Login when the account has two-factor authentication enabled
+	PurposeTwoFactor = "2fa"
+
+	recoveryCodeCount = 10
+	invalidCode       = "invalid two-factor code"
+)
+
+var (
+	// ErrTwoFactorEnabled is returned when enrolling an account that already has two-factor authentication
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrInvalidCode is returned when a TOTP or recovery code does not match or was already used
+	ErrInvalidCode = errors.New(invalidCode)
+)
+
+// TwoFactorEnrollment is returned when a user starts enrolling. Secret is the
+// base32 secret to type in an authenticator app and URI the otpauth:// URI to
+// render as a QR code.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// Render implements render.Renderer
+//
+// The secret must not be cached nor stored for idempotent replays.
+func (*TwoFactorEnrollment) Render(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "no-store")
+	return nil
+}
+
+// RecoveryCodes are shown once when two-factor authentication is confirmed.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// Render implements render.Renderer
+func (*RecoveryCodes) Render(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "no-store")
+	return nil
+}
+
+// CodeRequest represents a request carrying a TOTP code.
+type CodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Bind implements render.Binder
+func (*CodeRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the CodeRequest fields.
+func (c CodeRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Code, validation.Required),
+	)
+}
+
+// TwoFactorLoginRequest completes a login with the challenge returned by
+// Login and a TOTP or recovery code.
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// Bind implements render.Binder
+func (*TwoFactorLoginRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the TwoFactorLoginRequest fields.
+func (c TwoFactorLoginRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.ChallengeToken, validation.Required),
+		validation.Field(&c.Code, validation.Required),
+	)
+}
+
+// EnrollTwoFactor implements Service
+func (s service) EnrollTwoFactor(u *entity.User) (TwoFactorEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TwoFactorEnrollment{}, errors.Wrap(err, "generating totp secret error")
+	}
+
+	encrypted, err := s.cipher.Encrypt(secret)
+	if err != nil {
+		return TwoFactorEnrollment{}, errors.Wrap(err, "encrypting totp secret error")
+	}
+
+	if err := s.repo.SaveTOTP(u.ID, encrypted); err != nil {
+		if errors.Is(err, ErrTwoFactorEnabled) {
+			return TwoFactorEnrollment{}, apperrors.NewConflict("two-factor authentication", u.Email)
+		}
+		return TwoFactorEnrollment{}, err
+	}
+
+	return TwoFactorEnrollment{
+		Secret: totp.EncodeSecret(secret),
+		URI:    totp.URI(secret, s.opts.Issuer, u.Email),
+	}, nil
+}
+
+// ConfirmTwoFactor implements Service
+func (s service) ConfirmTwoFactor(u *entity.User, input CodeRequest, client Client) (RecoveryCodes, error) {
+	if err := input.Validate(); err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	t, err := s.repo.GetTOTP(u.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RecoveryCodes{}, apperrors.NewBadRequest("two-factor enrolment was not started")
+		}
+		return RecoveryCodes{}, err
+	}
+
+	if t.IsConfirmed() {
+		return RecoveryCodes{}, apperrors.NewConflict("two-factor authentication", u.Email)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewAuthQueries(s.db, tx)
+
+		if err := s.checkTOTP(repo, t, input.Code); err != nil {
+			return err
+		}
+
+		if err := repo.ConfirmTOTP(u.ID); err != nil {
+			return err
+		}
+
+		if err := repo.ReplaceRecoveryCodes(u.ID, hashes); err != nil {
+			return err
+		}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(client.Metadata(u).Event(EventTwoFactorEnabled, audit.TargetUser, u.ID))
+	})
+
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	return RecoveryCodes{Codes: codes}, nil
+}
+
+// DisableTwoFactor implements Service
+func (s service) DisableTwoFactor(u *entity.User, input CodeRequest, client Client) error {
+	if err := input.Validate(); err != nil {
+		return err
+	}
+
+	t, err := s.repo.GetTOTP(u.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewBadRequest("two-factor authentication is not enabled")
+		}
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewAuthQueries(s.db, tx)
+
+		if err := s.checkCode(repo, t, input.Code); err != nil {
+			return err
+		}
+
+		if err := repo.DeleteTOTP(u.ID); err != nil {
+			return err
+		}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(client.Metadata(u).Event(EventTwoFactorDisabled, audit.TargetUser, u.ID))
+	})
+}
+
+// CompleteLogin implements Service
+func (s service) CompleteLogin(input TwoFactorLoginRequest, client Client) (TokenResponse, error) {
+	if err := input.Validate(); err != nil {
+		return TokenResponse{}, err
+	}
+
+	if err := s.checkIP(client); err != nil {
+		return TokenResponse{}, err
+	}
+
+	claims, err := s.signer.Parse(input.ChallengeToken, PurposeTwoFactor)
+	if err != nil {
+		return TokenResponse{}, apperrors.NewAuthorization(err.Error())
+	}
+
+	u, err := s.users.GetUser(claims.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TokenResponse{}, apperrors.NewAuthorization(apperrors.InvalidSession)
+		}
+		return TokenResponse{}, err
+	}
+
+	if err := s.checkLocked(u); err != nil {
+		return TokenResponse{}, err
+	}
+
+	t, err := s.repo.GetTOTP(u.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TokenResponse{}, apperrors.NewAuthorization(apperrors.InvalidSession)
+		}
+		return TokenResponse{}, err
+	}
+
+	var res TokenResponse
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewAuthQueries(s.db, tx)
+
+		if err := s.checkCode(repo, t, input.Code); err != nil {
+			return err
+		}
+
+		res, err = s.issueTokens(repo, u.ID, client)
+		return err
+	})
+
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) && appErr.Type == apperrors.Authorization {
+		s.recordFailure(u, u.Email, client)
+		return res, err
+	}
+
+	if err != nil {
+		return res, err
+	}
+
+	if err := s.recordSuccess(u, client); err != nil {
+		log.Printf("record login attempt error: %v", err)
+	}
+
+	return res, nil
+}
+
+// twoFactorEnabled reports whether userID must complete a TOTP challenge to log in.
+func (s service) twoFactorEnabled(userID int64) (bool, error) {
+	t, err := s.repo.GetTOTP(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return t.IsConfirmed(), nil
+}
+
+// checkCode accepts a TOTP code or, when it does not look like one, a recovery code.
+func (s service) checkCode(repo AuthQueries, t *entity.TOTP, code string) error {
+	code = strings.TrimSpace(code)
+
+	if len(code) == totp.Digits {
+		return s.checkTOTP(repo, t, code)
+	}
+
+	if !t.IsConfirmed() {
+		return apperrors.NewAuthorization(invalidCode)
+	}
+
+	err := repo.UseRecoveryCode(t.UserID, util.HashToken(normalizeRecoveryCode(code)))
+	if errors.Is(err, ErrInvalidCode) {
+		return apperrors.NewAuthorization(invalidCode)
+	}
+
+	return err
+}
+
+func (s service) checkTOTP(repo AuthQueries, t *entity.TOTP, code string) error {
+	secret, err := s.cipher.Decrypt(t.Secret)
+	if err != nil {
+		return errors.Wrap(err, "decrypting totp secret error")
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return apperrors.NewAuthorization(invalidCode)
+	}
+
+	// refuse replaying a code that was already accepted
+	err = repo.UseTOTPStep(t.UserID, step)
+	if errors.Is(err, ErrInvalidCode) {
+		return apperrors.NewAuthorization(invalidCode)
+	}
+
+	return err
+}
+
+// generateRecoveryCodes returns codes formatted as "xxxxx-xxxxx" and their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		token, err := util.RandomToken(5)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "generating recovery code error")
+		}
+
+		codes[i] = token[:5] + "-" + token[5:]
+		hashes[i] = util.HashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,133 @@
+package avatar
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/user"
+)
+
+// RegisterHandlers adds the avatar endpoints to r, the router of the users
+// API. The images are public, changing an avatar is open to the user
+// themselves and admins.
+func RegisterHandlers(r chi.Router, service Service, authService auth.Service) {
+	res := resource{service}
+
+	r.Get("/{id}/avatar/{size}", res.image) // GET /users/{id}/avatar/{size} - read the image of a size of the avatar of a user
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Authenticate(authService))
+		r.Use(auth.RequireVerified(authService))
+		r.Use(auth.RequireSelfOrAdmin)
+
+		r.Put("/{id}/avatar", res.set)       // PUT /users/{id}/avatar - replace the avatar, a JPEG, PNG or WebP image as the body or the multipart/form-data "file" field
+		r.Delete("/{id}/avatar", res.delete) // DELETE /users/{id}/avatar - delete the avatar
+	})
+}
+
+type resource struct {
+	service Service
+}
+
+func (c resource) set(w http.ResponseWriter, r *http.Request) {
+	body, err := upload(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+	defer body.Close()
+
+	u, err := c.service.Set(r.Context(), userID(r), body)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &user.UserResponse{User: u})
+}
+
+// upload returns the image of a request, its body or the "file" part of a
+// multipart/form-data body.
+func upload(r *http.Request) (io.ReadCloser, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, apperrors.NewBadRequest(err.Error())
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, apperrors.NewBadRequest(`The "file" field is missing`)
+		}
+		if err != nil {
+			return nil, apperrors.NewBadRequest(err.Error())
+		}
+
+		if part.FormName() == "file" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func (c resource) delete(w http.ResponseWriter, r *http.Request) {
+	if err := c.service.Delete(r.Context(), userID(r)); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// image serves an image of an avatar. Its URL changes with the avatar, so
+// it can be cached for good when it carries the token of the avatar.
+func (c resource) image(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	size, err := strconv.Atoi(chi.URLParam(r, "size"))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	object, token, err := c.service.Open(r.Context(), id, size)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, token, size))
+
+	if v := r.URL.Query().Get("v"); v == token {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+
+	http.ServeContent(w, r, "", object.Info().ModTime, object)
+}
+
+// userID returns the {id} URL parameter, already validated by auth.RequireSelfOrAdmin.
+func userID(r *http.Request) int64 {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id
+}
@@ -0,0 +1,256 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maxPixels bounds the size of the decoded images, a small file can
+	// declare huge dimensions
+	maxPixels = 4096 * 4096
+	// quality is the JPEG quality of the resized images
+	quality = 85
+)
+
+// formats are the image formats accepted for avatars, as named by the image
+// package.
+var formats = map[string]bool{
+	"jpeg": true,
+	"png":  true,
+	"webp": true,
+}
+
+// decode decodes a JPEG, PNG or WebP image, turned upright from the EXIF
+// orientation of the JPEG images and flattened onto a white background.
+// Nothing but the pixels is kept, the metadata of the file included. The
+// image is downscaled first so its shorter side is at most size, only the
+// decoded image is held at full size.
+func decode(data []byte, size int) (*image.RGBA, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, apperrors.NewUnsupportedMediaType("Avatars are JPEG, PNG or WebP images")
+		}
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("Invalid image: %v", err))
+	}
+
+	if !formats[format] {
+		return nil, apperrors.NewUnsupportedMediaType("Avatars are JPEG, PNG or WebP images")
+	}
+
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("Images are up to %d pixels, this one is %dx%d", maxPixels, cfg.Width, cfg.Height))
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, apperrors.NewBadRequest(fmt.Sprintf("Invalid image: %v", err))
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	side := w
+	if h < side {
+		side = h
+	}
+
+	if side > size {
+		w, h = scaled(w, side, size), scaled(h, side, size)
+	}
+
+	flat := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	if w == b.Dx() && h == b.Dy() {
+		draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+	} else {
+		xdraw.CatmullRom.Scale(flat, flat.Bounds(), img, b, xdraw.Over, nil)
+	}
+
+	if format == "jpeg" {
+		return orient(flat, orientation(data)), nil
+	}
+
+	return flat, nil
+}
+
+// scaled returns the length n scaled by size/side, at least a pixel.
+func scaled(n int, side int, size int) int {
+	if n = n * size / side; n < 1 {
+		return 1
+	}
+	return n
+}
+
+// orientation returns the EXIF orientation of a JPEG image, from 1 to 8,
+// or 1, upright, when it has none.
+func orientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+
+		marker := data[i+1]
+
+		switch {
+		// padding and the markers without a segment
+		case marker == 0xFF:
+			i++
+			continue
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD8:
+			i += 2
+			continue
+		// the metadata segments come before the start of the scan
+		case marker == 0xDA || marker == 0xD9:
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of a TIFF
+// structure, the content of an EXIF segment.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int64(order.Uint32(tiff[4:]))
+	if offset+2 > int64(len(tiff)) {
+		return 1
+	}
+
+	count := int64(order.Uint16(tiff[offset:]))
+
+	for i := int64(0); i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > int64(len(tiff)) {
+			return 1
+		}
+
+		// a SHORT, its value is held by the entry
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+
+	return 1
+}
+
+// orient returns img turned upright from its EXIF orientation.
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o <= 1 || o > 8 {
+		return img
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+
+			switch o {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to be upright
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counterclockwise to be upright
+				dx, dy = y, w-1-x
+			}
+
+			s, d := img.PixOffset(x+img.Rect.Min.X, y+img.Rect.Min.Y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[d:d+4], img.Pix[s:s+4])
+		}
+	}
+
+	return dst
+}
+
+// thumbnail returns the square image of size pixels cut from the centre of
+// img.
+func thumbnail(img *image.RGBA, size int) *image.RGBA {
+	b := img.Bounds()
+
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	min := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.Rectangle{Min: min, Max: min.Add(image.Pt(side, side))}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, square, xdraw.Src, nil)
+
+	return dst
+}
+
+// encode encodes img as a JPEG image.
+func encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
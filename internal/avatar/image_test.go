@@ -0,0 +1,161 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withOrientation inserts an EXIF segment with the orientation o after the
+// start of image marker of a JPEG image.
+func withOrientation(t *testing.T, data []byte, o uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	// orientation, a SHORT of count 1
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, o)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+
+	require.Equal(t, []byte{0xFF, 0xD8}, data[:2])
+	return append(out, data[2:]...)
+}
+
+// testImage returns a w×h image, red on its left half and blue on its right
+// half.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(8, 4), nil))
+
+	assert.Equal(t, 1, orientation(buf.Bytes()))
+	assert.Equal(t, 6, orientation(withOrientation(t, buf.Bytes(), 6)))
+	assert.Equal(t, 1, orientation(withOrientation(t, buf.Bytes(), 42)))
+	assert.Equal(t, 1, orientation([]byte("not a jpeg")))
+	assert.Equal(t, 1, orientation(withOrientation(t, buf.Bytes(), 6)[:12]))
+}
+
+func TestOrient(t *testing.T) {
+	img := testImage(4, 2)
+
+	rotated := orient(img, 6)
+	assert.Equal(t, image.Rect(0, 0, 2, 4), rotated.Bounds())
+	// the left half, red, is on top once rotated clockwise
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, rotated.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{0, 0, 255, 255}, rotated.RGBAAt(0, 3))
+
+	rotated = orient(img, 8)
+	assert.Equal(t, color.RGBA{0, 0, 255, 255}, rotated.RGBAAt(0, 0))
+
+	mirrored := orient(img, 2)
+	assert.Equal(t, color.RGBA{0, 0, 255, 255}, mirrored.RGBAAt(0, 0))
+
+	assert.Same(t, img, orient(img, 1))
+}
+
+func TestProcess(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(300, 200), nil))
+
+	images, err := process(bytes.NewReader(withOrientation(t, buf.Bytes(), 6)), DefaultMaxSize)
+	require.NoError(t, err)
+	require.Len(t, images, len(user.AvatarSizes))
+
+	for _, size := range user.AvatarSizes {
+		assert.False(t, bytes.Contains(images[size], []byte("Exif")), "the metadata is dropped")
+
+		img, format, err := image.Decode(bytes.NewReader(images[size]))
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, image.Rect(0, 0, size, size), img.Bounds())
+	}
+
+	_, err = process(bytes.NewReader(buf.Bytes()), 100)
+	assert.IsType(t, &apperrors.Error{}, err)
+	assert.Equal(t, apperrors.PayloadTooLarge, err.(*apperrors.Error).Type)
+
+	_, err = process(strings.NewReader("GIF89a..."), DefaultMaxSize)
+	assert.Equal(t, apperrors.UnsupportedMediaType, err.(*apperrors.Error).Type)
+}
+
+func TestDecodeTransparent(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 2, 2))))
+
+	img, err := decode(buf.Bytes(), 512)
+	require.NoError(t, err)
+	// transparent pixels are flattened onto white
+	assert.Equal(t, color.RGBA{255, 255, 255, 255}, img.RGBAAt(1, 1))
+}
+
+func TestDecodeDownscales(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(1200, 800), nil))
+
+	img, err := decode(withOrientation(t, buf.Bytes(), 6), 512)
+	require.NoError(t, err)
+	// the shorter side is scaled down to 512, then the image is turned upright
+	assert.Equal(t, image.Rect(0, 0, 512, 768), img.Bounds())
+
+	img, err = decode(buf.Bytes(), 1024)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 1200, 800), img.Bounds())
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 1, 1))))
+
+	// the header declares 4097×4097 pixels, the IHDR chunk follows the
+	// signature, its CRC covers its type and data
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 4097)
+	binary.BigEndian.PutUint32(data[20:], 4097)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err := decode(data, 512)
+	assert.Equal(t, apperrors.BadRequest, err.(*apperrors.Error).Type)
+}
+
+func TestParseKey(t *testing.T) {
+	id, token, ok := parseKey(key(12, "abc", 64))
+	assert.True(t, ok)
+	assert.Equal(t, int64(12), id)
+	assert.Equal(t, "abc", token)
+
+	_, _, ok = parseKey("avatars/x/abc/64.jpg")
+	assert.False(t, ok)
+	_, _, ok = parseKey("avatars/12/abc")
+	assert.False(t, ok)
+}
@@ -0,0 +1,86 @@
+package avatar
+
+import (
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+type AvatarQueries interface {
+	SetAvatar(userID int64, token string) (before *entity.User, after *entity.User, err error)
+	GetAvatars(userIDs []int64) (map[int64]string, error)
+}
+
+// avatarQueries struct for queries of the avatars, kept by the users table.
+type avatarQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewAvatarQueries(db *sqlx.DB, tx *sqlx.Tx) AvatarQueries {
+	return &avatarQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *avatarQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// SetAvatar implements AvatarQueries
+//
+// The user is locked until the end of the transaction, so the avatar it
+// replaces is known for sure. It returns sql.ErrNoRows for a missing or
+// deleted user. It must run in a transaction.
+func (q *avatarQueries) SetAvatar(userID int64, token string) (*entity.User, *entity.User, error) {
+	var before, after entity.User
+
+	query := `SELECT * FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+
+	if err := sqlx.Get(q.conn(), &before, query, userID); err != nil {
+		return nil, nil, err
+	}
+
+	query = `UPDATE users SET avatar = $2, updated_at = NOW(), version = version + 1 WHERE id = $1 RETURNING *`
+
+	if err := q.conn().QueryRowx(query, userID, token).StructScan(&after); err != nil {
+		return nil, nil, errors.Wrap(err, "set avatar error")
+	}
+
+	return &before, &after, nil
+}
+
+// GetAvatars implements AvatarQueries
+//
+// It returns the tokens of the avatars of the users by id, the missing users
+// are left out.
+func (q *avatarQueries) GetAvatars(userIDs []int64) (map[int64]string, error) {
+	b, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []struct {
+		ID     int64  `db:"id"`
+		Avatar string `db:"avatar"`
+	}{}
+
+	query := `SELECT id, avatar FROM users
+		WHERE id IN (SELECT jsonb_array_elements_text($1::jsonb)::int)`
+
+	if err := sqlx.Select(q.conn(), &rows, query, string(b)); err != nil {
+		return nil, err
+	}
+
+	avatars := map[int64]string{}
+	for _, row := range rows {
+		avatars[row.ID] = row.Avatar
+	}
+
+	return avatars, nil
+}
@@ -0,0 +1,52 @@
+package avatar
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type queriesSuiteTest struct {
+	test.TSuite
+}
+
+func TestQueriesSuiteTest(t *testing.T) {
+	suite.Run(t, new(queriesSuiteTest))
+}
+
+func (t *queriesSuiteTest) createUser() *entity.User {
+	u, err := user.NewUserQueries(t.DB, t.TX).CreateUser(&test.GenerateUsers(1)[0])
+	require.NoError(t.T(), err)
+
+	return u
+}
+
+func (t *queriesSuiteTest) TestSetAvatar() {
+	u := t.createUser()
+
+	queries := NewAvatarQueries(t.DB, t.TX)
+
+	before, after, err := queries.SetAvatar(u.ID, "abc")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "", before.Avatar)
+	assert.Equal(t.T(), "abc", after.Avatar)
+	assert.Equal(t.T(), before.Version+1, after.Version)
+
+	before, after, err = queries.SetAvatar(u.ID, "def")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "abc", before.Avatar)
+	assert.Equal(t.T(), "def", after.Avatar)
+
+	avatars, err := queries.GetAvatars([]int64{u.ID, u.ID + 1000})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), map[int64]string{u.ID: "def"}, avatars)
+
+	_, _, err = queries.SetAvatar(u.ID+1000, "abc")
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+}
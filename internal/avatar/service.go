@@ -0,0 +1,343 @@
+// Package avatar manages the profile images of the users. Uploads are
+// decoded and re-encoded, which drops their metadata, then stored through
+// the blob storage as square JPEG images of each of user.AvatarSizes.
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	"github.com/opaulochaves/myserver/internal/storage"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+// Audited events of the avatars, they are also published to the outbox.
+const (
+	EventAvatarUpdated = "user.avatar_updated"
+	EventAvatarDeleted = "user.avatar_deleted"
+)
+
+const (
+	// DefaultMaxSize is the largest upload when the server does not set
+	// one, 5 MiB
+	DefaultMaxSize = 5 << 20
+	// prefix starts the storage keys of the avatars, they are
+	// avatars/{userID}/{token}/{size}.jpg
+	prefix = "avatars/"
+)
+
+type Service interface {
+	// Set replaces the avatar of the user with the image read from r
+	Set(ctx context.Context, userID int64, r io.Reader) (user.User, error)
+	Delete(ctx context.Context, userID int64) error
+	// Open opens the image of the given size of the avatar of the user, it
+	// returns the token of the avatar with it
+	Open(ctx context.Context, userID int64, size int) (storage.Object, string, error)
+	PurgeOrphanedBlobs(ctx context.Context, grace time.Duration) (int64, error)
+}
+
+// Options configures the avatar service.
+type Options struct {
+	Storage storage.Storage
+	// MaxSize is the largest upload, in bytes
+	MaxSize int64
+}
+
+type service struct {
+	db    *sqlx.DB
+	repo  AvatarQueries
+	users user.UserQueries
+	opts  Options
+}
+
+func NewService(db *sqlx.DB, repo AvatarQueries, users user.UserQueries, opts Options) Service {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+
+	return &service{db: db, repo: repo, users: users, opts: opts}
+}
+
+// key returns the storage key of the image of size of an avatar.
+func key(userID int64, token string, size int) string {
+	return fmt.Sprintf("%s%d/%s/%d.jpg", prefix, userID, token, size)
+}
+
+// process reads an image from r, up to max bytes, and returns the JPEG
+// images of each of user.AvatarSizes.
+func process(r io.Reader, max int64) (map[int][]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, apperrors.NewPayloadTooLarge(max, tooLarge.Limit)
+		}
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, apperrors.NewPayloadTooLarge(max, int64(len(data)))
+	}
+
+	largest := 0
+	for _, size := range user.AvatarSizes {
+		if size > largest {
+			largest = size
+		}
+	}
+
+	img, err := decode(data, largest)
+	if err != nil {
+		return nil, err
+	}
+
+	images := map[int][]byte{}
+
+	for _, size := range user.AvatarSizes {
+		if images[size], err = encode(thumbnail(img, size)); err != nil {
+			return nil, err
+		}
+	}
+
+	return images, nil
+}
+
+// Set implements Service
+//
+// The images are stored before the avatar is saved, those of the replaced
+// avatar are deleted once it is. The images left behind by a failure are
+// left to PurgeOrphanedBlobs.
+func (s *service) Set(ctx context.Context, userID int64, r io.Reader) (user.User, error) {
+	if _, err := s.getUser(userID); err != nil {
+		return user.User{}, err
+	}
+
+	images, err := process(r, s.opts.MaxSize)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	token, err := util.RandomToken(16)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	for _, size := range user.AvatarSizes {
+		image := images[size]
+		if err := s.opts.Storage.Put(ctx, key(userID, token, size), bytes.NewReader(image), int64(len(image)), "image/jpeg"); err != nil {
+			s.deleteBlobs(userID, token)
+			return user.User{}, err
+		}
+	}
+
+	before, after, err := s.setAvatar(ctx, EventAvatarUpdated, userID, token)
+	if err != nil {
+		s.deleteBlobs(userID, token)
+		return user.User{}, err
+	}
+
+	if before.Avatar != "" {
+		s.deleteBlobs(userID, before.Avatar)
+	}
+
+	return user.User{User: after}, nil
+}
+
+// Delete implements Service
+func (s *service) Delete(ctx context.Context, userID int64) error {
+	u, err := s.getUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if u.Avatar == "" {
+		return apperrors.NewNotFound("avatar", fmt.Sprint(userID))
+	}
+
+	before, _, err := s.setAvatar(ctx, EventAvatarDeleted, userID, "")
+	if err != nil {
+		return err
+	}
+
+	if before.Avatar != "" {
+		s.deleteBlobs(userID, before.Avatar)
+	}
+
+	return nil
+}
+
+// setAvatar saves token as the avatar of the user, with its outbox event and
+// its audit event.
+func (s *service) setAvatar(ctx context.Context, action string, userID int64, token string) (before *entity.User, after *entity.User, err error) {
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if before, after, err = NewAvatarQueries(s.db, tx).SetAvatar(userID, token); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("user", fmt.Sprint(userID))
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(action, audit.TargetUser, userID, after); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(action, audit.TargetUser, userID)
+		event.Changes = audit.Diff(before, after)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	return before, after, err
+}
+
+// Open implements Service
+func (s *service) Open(ctx context.Context, userID int64, size int) (storage.Object, string, error) {
+	u, err := s.getUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if u.Avatar == "" || !validSize(size) {
+		return nil, "", apperrors.NewNotFound("avatar", fmt.Sprint(userID))
+	}
+
+	object, err := s.opts.Storage.Open(ctx, key(userID, u.Avatar, size))
+	if err != nil {
+		// replaced since the user was read
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil, "", apperrors.NewNotFound("avatar", fmt.Sprint(userID))
+		}
+		return nil, "", err
+	}
+
+	return object, u.Avatar, nil
+}
+
+func (s *service) getUser(userID int64) (*entity.User, error) {
+	u, err := s.users.GetUser(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.NewNotFound("user", fmt.Sprint(userID))
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// validSize reports whether size is one of user.AvatarSizes.
+func validSize(size int) bool {
+	for _, s := range user.AvatarSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteBlobs deletes the images of an avatar, out of any request. The
+// images it fails to delete are left to PurgeOrphanedBlobs.
+func (s *service) deleteBlobs(userID int64, token string) {
+	for _, size := range user.AvatarSizes {
+		k := key(userID, token, size)
+		if err := s.opts.Storage.Delete(context.Background(), k); err != nil {
+			log.Printf("avatar: unable to delete the blob %s: %v", k, err)
+		}
+	}
+}
+
+// PurgeOrphanedBlobs implements Service
+//
+// The orphaned images are those of the replaced avatars which could not be
+// deleted, of the failed uploads and of the erased or purged users. The
+// images younger than grace are kept, their upload may not be saved yet.
+func (s *service) PurgeOrphanedBlobs(ctx context.Context, grace time.Duration) (int64, error) {
+	const batchSize = 500
+
+	var purged int64
+	batch := []string{}
+
+	flush := func() error {
+		ids := []int64{}
+		for _, k := range batch {
+			id, _, _ := parseKey(k)
+			ids = append(ids, id)
+		}
+
+		avatars, err := s.repo.GetAvatars(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range batch {
+			id, token, _ := parseKey(k)
+			if avatar, ok := avatars[id]; ok && avatar == token {
+				continue
+			}
+			if err := s.opts.Storage.Delete(ctx, k); err != nil {
+				return err
+			}
+			purged++
+		}
+
+		batch = batch[:0]
+
+		return nil
+	}
+
+	cutoff := time.Now().Add(-grace)
+
+	err := s.opts.Storage.Walk(ctx, prefix, func(info storage.ObjectInfo) error {
+		if info.ModTime.After(cutoff) {
+			return nil
+		}
+
+		// not written by this service, it is left alone
+		if _, _, ok := parseKey(info.Key); !ok {
+			return nil
+		}
+
+		batch = append(batch, info.Key)
+		if len(batch) < batchSize {
+			return nil
+		}
+
+		return flush()
+	})
+
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+
+	return purged, err
+}
+
+// parseKey returns the user and the token of the avatar of a storage key.
+func parseKey(k string) (int64, string, bool) {
+	segments := strings.Split(strings.TrimPrefix(k, prefix), "/")
+	if len(segments) != 3 {
+		return 0, "", false
+	}
+
+	id, err := strconv.ParseInt(segments[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return id, segments[1], true
+}
@@ -0,0 +1,69 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// AuditEvent records who did what to which record. ActorID is nil for
+// anonymous requests and system tasks.
+type AuditEvent struct {
+	ID         int64            `db:"id" json:"id"`
+	ActorID    *int64           `db:"actor_id" json:"actor_id"`
+	Action     string           `db:"action" json:"action"`
+	TargetType string           `db:"target_type" json:"target_type"`
+	TargetID   string           `db:"target_id" json:"target_id"`
+	Changes    JSONMap          `db:"changes" json:"changes"`
+	Details    JSONMap          `db:"details" json:"details"`
+	IP         string           `db:"ip" json:"ip"`
+	UserAgent  string           `db:"user_agent" json:"user_agent"`
+	RequestID  string           `db:"request_id" json:"request_id"`
+	CreatedAt  pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+// Change is the value of a field before and after an update. The values of
+// personal data are not kept, the change is Redacted.
+type Change struct {
+	From     interface{} `json:"from"`
+	To       interface{} `json:"to"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
+
+// JSONMap is stored in a JSONB column.
+type JSONMap map[string]interface{}
+
+// Value implements driver.Valuer
+func (m JSONMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (m *JSONMap) Scan(src interface{}) error {
+	var b []byte
+
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONMap", src)
+	}
+
+	return json.Unmarshal(b, m)
+}
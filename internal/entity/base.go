@@ -7,7 +7,9 @@ import (
 type BaseEntity struct {
 	ID        int64            `db:"id" json:"id"`
 	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
-	UpdatedAt pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	UpdatedAt pgtype.Timestamp `db:"updated_at" json:"updated_at" audit:"-"`
+	// Version is incremented on every change, it is the ETag of the entity
+	Version int64 `db:"version" json:"version" audit:"-"`
 }
 
 // GetID returns the user ID.
@@ -0,0 +1,25 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Data export statuses
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport is an archive of everything stored about a user, generated in
+// the background on request. FilePath is removed once ExpiresAt is past.
+type DataExport struct {
+	ID          int64            `db:"id" json:"id"`
+	UserID      int64            `db:"user_id" json:"user_id"`
+	Status      string           `db:"status" json:"status"`
+	FilePath    string           `db:"file_path" json:"-"`
+	Error       string           `db:"error" json:"error,omitempty"`
+	ExpiresAt   pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	CompletedAt pgtype.Timestamp `db:"completed_at" json:"completed_at"`
+	CreatedAt   pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
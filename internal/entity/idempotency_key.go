@@ -0,0 +1,22 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// IdempotencyKey is a request made with an Idempotency-Key header and,
+// once Completed, the response to replay on retries. Key and Fingerprint
+// are SHA-256 hashes. A request in flight holds the key until LockedUntil.
+// UserID is nil for the anonymous requests.
+type IdempotencyKey struct {
+	Key             string           `db:"key"`
+	UserID          *int64           `db:"user_id"`
+	Fingerprint     string           `db:"fingerprint"`
+	Completed       bool             `db:"completed"`
+	ResponseStatus  int              `db:"response_status"`
+	ResponseHeaders JSONMap          `db:"response_headers"`
+	ResponseBody    []byte           `db:"response_body"`
+	LockedUntil     pgtype.Timestamp `db:"locked_until"`
+	ExpiresAt       pgtype.Timestamp `db:"expires_at"`
+	CreatedAt       pgtype.Timestamp `db:"created_at"`
+}
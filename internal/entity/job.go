@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Statuses of a job. Failed jobs ran out of attempts.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is a task run in the background by a worker of the job queue.
+type Job struct {
+	ID          int64            `db:"id" json:"id"`
+	Kind        string           `db:"kind" json:"kind"`
+	Payload     json.RawMessage  `db:"payload" json:"payload"`
+	UniqueKey   *string          `db:"unique_key" json:"unique_key"`
+	Status      string           `db:"status" json:"status"`
+	Attempts    int              `db:"attempts" json:"attempts"`
+	MaxAttempts int              `db:"max_attempts" json:"max_attempts"`
+	LastError   string           `db:"last_error" json:"last_error"`
+	RunAt       pgtype.Timestamp `db:"run_at" json:"run_at"`
+	LockedAt    pgtype.Timestamp `db:"locked_at" json:"locked_at"`
+	FinishedAt  pgtype.Timestamp `db:"finished_at" json:"finished_at"`
+	CreatedAt   pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
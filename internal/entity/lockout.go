@@ -0,0 +1,15 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Lockout is the temporary lock of an account after repeated failed logins.
+// LockoutCount drives the exponential backoff and is reset on a successful
+// login or when an admin unlocks the account.
+type Lockout struct {
+	UserID       int64            `db:"user_id" json:"user_id"`
+	LockoutCount int              `db:"lockout_count" json:"lockout_count"`
+	LockedAt     pgtype.Timestamp `db:"locked_at" json:"locked_at"`
+	LockedUntil  pgtype.Timestamp `db:"locked_until" json:"locked_until"`
+}
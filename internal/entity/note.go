@@ -1,14 +1,71 @@
 package entity
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
 type Note struct {
 	BaseEntity
-	Title     string    `db:"title" json:"title"`
-	Content   string    `db:"content" json:"content"`
-	UserID    int64     `db:"user_id" json:"user_id"`
-	NoteAttrs NoteAttrs `db:"note_attrs" json:"note_attrs"`
+	Title   string    `db:"title" json:"title" audit:"redact"`
+	Content string    `db:"content" json:"content" audit:"redact"`
+	UserID  int64     `db:"user_id" json:"user_id"`
+	Attrs   NoteAttrs `db:"attrs" json:"attrs"`
+	// Language is the text search configuration the note is indexed with
+	Language string `db:"language" json:"language"`
+	// Tags are read with the note, they are changed through the tags queries
+	Tags NoteTags `db:"tags" json:"tags" audit:"redact"`
+	// DeletedAt is set while the note is in the trash
+	DeletedAt pgtype.Timestamp `db:"deleted_at" json:"deleted_at" audit:"-"`
+	// Permission is the permission of the user who read the note, it is only
+	// set by the reads checking the access of a user
+	Permission string `db:"permission" json:"permission,omitempty" audit:"-"`
 }
 
+// NoteMatch is a note found by a full-text search. The highlights are HTML,
+// the text is escaped and the matched words are surrounded with <mark> tags.
+type NoteMatch struct {
+	Note
+	Rank           float64 `db:"rank" json:"rank"`
+	TitleHighlight string  `db:"title_highlight" json:"title_highlight"`
+	Snippet        string  `db:"snippet" json:"snippet"`
+}
+
+// Formats of the content of a note.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// NoteAttrs are display attributes of a note, stored in a JSONB column.
 type NoteAttrs struct {
 	Color string `json:"color"`
 	Icon  string `json:"icon"`
+	// Format is the format of the content, plain text when it is empty
+	Format string `json:"format,omitempty"`
+}
+
+// Value implements driver.Valuer
+func (a NoteAttrs) Value() (driver.Value, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (a *NoteAttrs) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	default:
+		return fmt.Errorf("cannot scan %T into NoteAttrs", src)
+	}
 }
@@ -0,0 +1,23 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// NoteAttachment is a file attached to a note. The file is a blob of the
+// storage, at StorageKey.
+type NoteAttachment struct {
+	ID     int64 `db:"id" json:"id"`
+	NoteID int64 `db:"note_id" json:"note_id"`
+	// UserID is the owner of the note, the attachment counts against their
+	// quota
+	UserID      int64  `db:"user_id" json:"user_id"`
+	Filename    string `db:"filename" json:"filename" audit:"redact"`
+	ContentType string `db:"content_type" json:"content_type"`
+	Size        int64  `db:"size" json:"size"`
+	// Checksum is the hex SHA-256 of the file
+	Checksum   string           `db:"checksum" json:"checksum"`
+	StorageKey string           `db:"storage_key" json:"-" audit:"-"`
+	CreatedBy  *int64           `db:"created_by" json:"created_by"`
+	CreatedAt  pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// NoteRevision is the content of a note as it was saved. Every change of the
+// title, content or attrs of a note adds one, Version is the version of the
+// note it was saved as.
+type NoteRevision struct {
+	ID        int64            `db:"id" json:"id"`
+	NoteID    int64            `db:"note_id" json:"note_id"`
+	Version   int64            `db:"version" json:"version"`
+	Title     string           `db:"title" json:"title"`
+	Content   string           `db:"content" json:"content"`
+	Attrs     NoteAttrs        `db:"attrs" json:"attrs"`
+	AuthorID  *int64           `db:"author_id" json:"author_id"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+// NoteSettings are the preferences of a user about their notes.
+type NoteSettings struct {
+	UserID int64 `db:"user_id" json:"user_id"`
+	// RevisionLimit is the number of revisions kept per note, nil keeps the
+	// server default
+	RevisionLimit *int             `db:"revision_limit" json:"revision_limit"`
+	UpdatedAt     pgtype.Timestamp `db:"updated_at" json:"updated_at" audit:"-"`
+}
@@ -0,0 +1,45 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Permissions of a user on a note. The owner has every permission, an
+// editor can read and change the note but not delete or share it.
+const (
+	PermissionRead  = "read"
+	PermissionEdit  = "edit"
+	PermissionOwner = "owner"
+)
+
+// NoteShare grants a user, other than its owner, access to a note.
+type NoteShare struct {
+	ID         int64            `db:"id" json:"id"`
+	NoteID     int64            `db:"note_id" json:"note_id"`
+	UserID     int64            `db:"user_id" json:"user_id"`
+	Permission string           `db:"permission" json:"permission"`
+	CreatedBy  *int64           `db:"created_by" json:"created_by"`
+	CreatedAt  pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt  pgtype.Timestamp `db:"updated_at" json:"updated_at" audit:"-"`
+	// Email is the email of the user, it is read with the share
+	Email string `db:"email" json:"email" audit:"redact"`
+}
+
+// NoteLink is a public read-only link to a note. Only the hash of its token
+// is stored, the token is handed out once.
+type NoteLink struct {
+	ID        int64            `db:"id" json:"id"`
+	NoteID    int64            `db:"note_id" json:"note_id"`
+	TokenHash string           `db:"token_hash" json:"-" audit:"-"`
+	Password  string           `db:"password" json:"-" audit:"-"`
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	CreatedBy *int64           `db:"created_by" json:"created_by"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+	// OwnerID is the owner of the note, it is read with the link by token
+	OwnerID int64 `db:"owner_id" json:"-" audit:"-"`
+}
+
+// HasPassword reports whether the link requires a password.
+func (l NoteLink) HasPassword() bool {
+	return l.Password != ""
+}
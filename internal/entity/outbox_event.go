@@ -0,0 +1,23 @@
+package entity
+
+import (
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// OutboxEvent is a domain event waiting in the outbox to be dispatched.
+// Events that failed MaxAttempts times get a FailedAt and are not retried.
+type OutboxEvent struct {
+	ID            int64            `db:"id" json:"id"`
+	EventType     string           `db:"event_type" json:"event_type"`
+	AggregateType string           `db:"aggregate_type" json:"aggregate_type"`
+	AggregateID   string           `db:"aggregate_id" json:"aggregate_id"`
+	Payload       json.RawMessage  `db:"payload" json:"payload"`
+	Attempts      int              `db:"attempts" json:"attempts"`
+	LastError     string           `db:"last_error" json:"last_error"`
+	AvailableAt   pgtype.Timestamp `db:"available_at" json:"available_at"`
+	ProcessedAt   pgtype.Timestamp `db:"processed_at" json:"processed_at"`
+	FailedAt      pgtype.Timestamp `db:"failed_at" json:"failed_at"`
+	CreatedAt     pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,32 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Statuses of a schedule run.
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// What started a schedule run.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// ScheduleRun is a run of a recurring task. ScheduledFor is the tick of the
+// schedule it was started for, it is not set for manual runs.
+type ScheduleRun struct {
+	ID           int64            `db:"id" json:"id"`
+	Schedule     string           `db:"schedule" json:"schedule"`
+	TriggeredBy  string           `db:"triggered_by" json:"triggered_by"`
+	ScheduledFor pgtype.Timestamp `db:"scheduled_for" json:"scheduled_for"`
+	Status       string           `db:"status" json:"status"`
+	Error        string           `db:"error" json:"error"`
+	StartedAt    pgtype.Timestamp `db:"started_at" json:"started_at"`
+	FinishedAt   pgtype.Timestamp `db:"finished_at" json:"finished_at"`
+	DurationMs   int              `db:"duration_ms" json:"duration_ms"`
+}
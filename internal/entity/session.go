@@ -0,0 +1,17 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Session is a refresh token issued to a user on login.
+type Session struct {
+	ID        int64            `db:"id" json:"id"`
+	UserID    int64            `db:"user_id" json:"user_id"`
+	TokenHash string           `db:"token_hash" json:"-"`
+	UserAgent string           `db:"user_agent" json:"user_agent"`
+	IP        string           `db:"ip" json:"ip"`
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	RevokedAt pgtype.Timestamp `db:"revoked_at" json:"revoked_at"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Tag labels notes of a user. Names are unique per user, ignoring case.
+type Tag struct {
+	ID        int64            `db:"id" json:"id"`
+	UserID    int64            `db:"user_id" json:"user_id"`
+	Name      string           `db:"name" json:"name" audit:"redact"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+// TagCount is a tag and the number of notes it labels.
+type TagCount struct {
+	Tag
+	Notes int `db:"notes" json:"notes"`
+}
+
+// NoteTag is a tag as it is embedded in a note.
+type NoteTag struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// NoteTags are the tags of a note, read as a JSON array.
+type NoteTags []NoteTag
+
+// Scan implements sql.Scanner
+func (t *NoteTags) Scan(src interface{}) error {
+	var b []byte
+
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into NoteTags", src)
+	}
+
+	return json.Unmarshal(b, (*[]NoteTag)(t))
+}
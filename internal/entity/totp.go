@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TOTP is the two-factor enrolment of a user. Secret is encrypted.
+type TOTP struct {
+	UserID       int64            `db:"user_id"`
+	Secret       []byte           `db:"secret"`
+	LastUsedStep int64            `db:"last_used_step"`
+	ConfirmedAt  pgtype.Timestamp `db:"confirmed_at"`
+	CreatedAt    pgtype.Timestamp `db:"created_at"`
+}
+
+// IsConfirmed reports whether the enrolment was completed with a first code.
+func (t TOTP) IsConfirmed() bool {
+	return t.ConfirmedAt.Valid
+}
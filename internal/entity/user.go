@@ -1,13 +1,28 @@
 package entity
 
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
 type User struct {
 	BaseEntity
-	FirstName string `db:"first_name" json:"first_name"`
-	LastName  string `db:"last_name" json:"last_name"`
-	Email     string `db:"email" json:"email"`
-	Password  string `db:"password" json:"-"`
+	FirstName  string           `db:"first_name" json:"first_name" audit:"redact"`
+	LastName   string           `db:"last_name" json:"last_name" audit:"redact"`
+	Email      string           `db:"email" json:"email" audit:"redact"`
+	Password   string           `db:"password" json:"-" audit:"-"`
+	VerifiedAt pgtype.Timestamp `db:"verified_at" json:"verified_at"`
+	IsAdmin    bool             `db:"is_admin" json:"is_admin"`
+	DeletedAt  pgtype.Timestamp `db:"deleted_at" json:"-"`
+	ErasedAt   pgtype.Timestamp `db:"erased_at" json:"-"`
+	// Avatar is the token of the current avatar, empty without one
+	Avatar string `db:"avatar" json:"-"`
 }
 
 func (u User) FullName() string {
 	return u.FirstName + " " + u.LastName
 }
+
+// IsVerified reports whether the user confirmed their email address.
+func (u User) IsVerified() bool {
+	return u.VerifiedAt.Valid
+}
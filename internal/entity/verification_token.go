@@ -0,0 +1,16 @@
+package entity
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// VerificationToken is a single use token emailed to confirm an address.
+// Only the SHA-256 hash of the token is stored.
+type VerificationToken struct {
+	ID        int64            `db:"id"`
+	UserID    int64            `db:"user_id"`
+	TokenHash string           `db:"token_hash"`
+	ExpiresAt pgtype.Timestamp `db:"expires_at"`
+	UsedAt    pgtype.Timestamp `db:"used_at"`
+	CreatedAt pgtype.Timestamp `db:"created_at"`
+}
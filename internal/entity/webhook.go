@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Statuses of a webhook delivery. Pending deliveries are retried until they
+// succeed or become dead after too many failures.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is an endpoint receiving the events it subscribes to. UserID is
+// nil for global webhooks. Secret is encrypted.
+type Webhook struct {
+	BaseEntity
+	UserID              *int64           `db:"user_id" json:"user_id"`
+	URL                 string           `db:"url" json:"url"`
+	Secret              []byte           `db:"secret" json:"-" audit:"-"`
+	EventTypes          StringList       `db:"event_types" json:"event_types"`
+	Description         string           `db:"description" json:"description"`
+	Active              bool             `db:"active" json:"active"`
+	ConsecutiveFailures int              `db:"consecutive_failures" json:"consecutive_failures" audit:"-"`
+	DisabledAt          pgtype.Timestamp `db:"disabled_at" json:"disabled_at"`
+}
+
+// WebhookDelivery is a delivery of an event to a webhook and the response
+// status of its last attempt. EventID is nil for manual redeliveries.
+type WebhookDelivery struct {
+	ID             int64            `db:"id" json:"id"`
+	WebhookID      int64            `db:"webhook_id" json:"webhook_id"`
+	EventID        *int64           `db:"event_id" json:"event_id"`
+	EventType      string           `db:"event_type" json:"event_type"`
+	Payload        string           `db:"payload" json:"-"`
+	Status         string           `db:"status" json:"status"`
+	Attempts       int              `db:"attempts" json:"attempts"`
+	NextAttemptAt  pgtype.Timestamp `db:"next_attempt_at" json:"next_attempt_at"`
+	ResponseStatus *int             `db:"response_status" json:"response_status"`
+	Error          string           `db:"error" json:"error"`
+	DurationMs     int              `db:"duration_ms" json:"duration_ms"`
+	DeliveredAt    pgtype.Timestamp `db:"delivered_at" json:"delivered_at"`
+	CreatedAt      pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+// StringList is stored in a JSONB column.
+type StringList []string
+
+// Value implements driver.Valuer
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(src interface{}) error {
+	var b []byte
+
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+
+	return json.Unmarshal(b, (*[]string)(l))
+}
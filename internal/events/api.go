@@ -0,0 +1,129 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/auth"
+)
+
+// retry is the delay, in milliseconds, before the clients reconnect a
+// stream which ended
+const retry = 3000
+
+// EventExpired ends a stream once its access token expired, the client
+// reconnects with a new one. It has no id.
+const EventExpired = "expired"
+
+// RegisterHandlers returns the router of the events API.
+func RegisterHandlers(broker *Broker, authService auth.Service) *chi.Mux {
+	res := resource{broker}
+	r := chi.NewRouter()
+
+	r.Use(auth.Authenticate(authService))
+	r.Use(auth.RequireVerified(authService))
+
+	r.Get("/", res.stream) // GET /events - stream the changes of my notes as Server-Sent Events, resumed after the Last-Event-ID header
+
+	return r
+}
+
+type resource struct {
+	broker *Broker
+}
+
+func (c resource) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		render.Render(w, r, apperrors.ErrInternalError(errors.New("streaming is not supported")))
+		return
+	}
+
+	sub, missed, err := c.broker.Subscribe(auth.CurrentUser(r.Context()).ID, r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(apperrors.NewServiceUnavailable()))
+		return
+	}
+	defer c.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// proxies like nginx would buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", retry)
+
+	for _, e := range missed {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(c.broker.opts.Heartbeat)
+	defer heartbeat.Stop()
+
+	// the token is only checked when the stream opens, it ends with it
+	var expired <-chan time.Time
+	if expiresAt := auth.ExpiresAt(r.Context()); !expiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			io.WriteString(w, "event: "+EventExpired+"\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case e, ok := <-sub.Events():
+			// too far behind, or the server is shutting down
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes e in the format of Server-Sent Events, its type is the
+// name of the event.
+func writeEvent(w io.Writer, e Event) error {
+	if e.Type == EventReset {
+		_, err := io.WriteString(w, "event: "+EventReset+"\ndata: {}\n\n")
+		return err
+	}
+
+	data, err := json.Marshal(struct {
+		NoteID    int64     `json:"note_id"`
+		Version   int64     `json:"version"`
+		CreatedAt time.Time `json:"created_at"`
+	}{e.NoteID, e.Version, e.CreatedAt})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+
+	return err
+}
@@ -0,0 +1,294 @@
+// Package events streams the changes of the notes to the clients of their
+// owners as Server-Sent Events.
+//
+// The note events of the outbox are notified by Postgres once committed
+// (LISTEN/NOTIFY), so every replica receives the changes made through any
+// of them. Each replica keeps the last events in a bounded buffer to resume
+// the streams of the clients reconnecting with a Last-Event-ID.
+package events
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// Channel is the notification channel of the note events, see the
+// notify_note_event trigger.
+const Channel = "note_events"
+
+// EventReset tells a client events may have been missed, it must read the
+// notes again. It has no id.
+const EventReset = "reset"
+
+const (
+	// DefaultBufferSize is the number of events kept for the resumed streams
+	// when the server does not set one
+	DefaultBufferSize = 1000
+	// DefaultHeartbeat is the interval of the comments keeping the idle
+	// streams open through the proxies when the server does not set one
+	DefaultHeartbeat = 25 * time.Second
+	// subscriberBuffer is the number of events queued for a client, a client
+	// further behind is disconnected and resumes its stream
+	subscriberBuffer = 64
+	// retryDelay is the delay before listening again after an error
+	retryDelay = 5 * time.Second
+)
+
+// ErrClosed is returned when subscribing to a closed broker.
+var ErrClosed = errors.New("events: broker closed")
+
+// Event is a change of a note. ID is the id of the event in the outbox,
+// the events of a user are streamed to them only.
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	NoteID    int64     `json:"note_id"`
+	UserID    int64     `json:"user_id"`
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Options configures the broker.
+type Options struct {
+	// BufferSize is the number of events kept to resume the streams
+	BufferSize int
+	// Heartbeat is the interval of the comments sent on the idle streams
+	Heartbeat time.Duration
+}
+
+// Subscription receives the events of a user until it is unsubscribed or
+// the broker closed, Events is then closed.
+type Subscription struct {
+	userID int64
+	events chan Event
+}
+
+// Events returns the channel of the events of the subscription.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Broker receives the note events notified by Postgres and fans them out to
+// the subscriptions of their users.
+type Broker struct {
+	db   *sqlx.DB
+	opts Options
+
+	mu            sync.Mutex
+	buffer        []Event
+	subscriptions map[*Subscription]struct{}
+	closed        bool
+}
+
+func NewBroker(db *sqlx.DB, opts Options) *Broker {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = DefaultHeartbeat
+	}
+
+	return &Broker{db: db, opts: opts, subscriptions: map[*Subscription]struct{}{}}
+}
+
+// Subscribe subscribes to the events of a user. With the id of the last
+// event a client received, the events it missed are returned to be sent
+// first. When they are no longer buffered a reset event is returned instead.
+func (b *Broker) Subscribe(userID int64, lastEventID string) (*Subscription, []Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+
+	var missed []Event
+
+	if lastEventID != "" {
+		missed = b.replay(userID, lastEventID)
+	}
+
+	sub := &Subscription{userID: userID, events: make(chan Event, subscriberBuffer)}
+	b.subscriptions[sub] = struct{}{}
+
+	return sub, missed, nil
+}
+
+// replay returns the events of the user buffered after the event lastEventID.
+// They are replayed in the order they were received rather than by id, ids
+// are not committed in order.
+func (b *Broker) replay(userID int64, lastEventID string) []Event {
+	id, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil {
+		return []Event{{Type: EventReset}}
+	}
+
+	for i := len(b.buffer) - 1; i >= 0; i-- {
+		if b.buffer[i].ID != id {
+			continue
+		}
+
+		missed := []Event{}
+		for _, e := range b.buffer[i+1:] {
+			if e.UserID == userID {
+				missed = append(missed, e)
+			}
+		}
+		return missed
+	}
+
+	return []Event{{Type: EventReset}}
+}
+
+// Unsubscribe ends a subscription.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+// remove closes a subscription, b.mu must be held.
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subscriptions[sub]; ok {
+		delete(b.subscriptions, sub)
+		close(sub.events)
+	}
+}
+
+// Publish buffers an event and sends it to the subscriptions of its user.
+// The subscriptions too far behind are ended, their clients resume them.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.buffer = append(b.buffer, e)
+	if len(b.buffer) > b.opts.BufferSize {
+		b.buffer = append(b.buffer[:0], b.buffer[len(b.buffer)-b.opts.BufferSize:]...)
+	}
+
+	for sub := range b.subscriptions {
+		if sub.userID == e.UserID {
+			b.send(sub, e)
+		}
+	}
+}
+
+// send queues e for sub, or ends sub when its queue is full. b.mu must be
+// held.
+func (b *Broker) send(sub *Subscription, e Event) {
+	select {
+	case sub.events <- e:
+	default:
+		b.remove(sub)
+	}
+}
+
+// reset empties the buffer and sends a reset event to every subscription,
+// events may have been missed.
+func (b *Broker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buffer = nil
+
+	for sub := range b.subscriptions {
+		b.send(sub, Event{Type: EventReset})
+	}
+}
+
+// Close ends every subscription and refuses the new ones. It is registered
+// with http.Server.RegisterOnShutdown, the streams would keep the server
+// from shutting down otherwise.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+
+	for sub := range b.subscriptions {
+		b.remove(sub)
+	}
+}
+
+// Run listens to the notifications of Channel until ctx is done, listening
+// again after an error.
+func (b *Broker) Run(ctx context.Context) {
+	for {
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("events: listen error: %v", err)
+
+		// the events notified meanwhile are lost
+		b.reset()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// listen holds a connection of the pool listening to Channel until ctx is
+// done or an error occurs.
+func (b *Broker) listen(ctx context.Context) error {
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var listenErr error
+
+	conn.Raw(func(driverConn interface{}) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			listenErr = fmt.Errorf("unexpected driver connection %T", driverConn)
+			return nil
+		}
+
+		pg := c.Conn()
+
+		if _, err := pg.Exec(ctx, "LISTEN "+Channel); err != nil {
+			listenErr = err
+			return driver.ErrBadConn
+		}
+
+		for {
+			n, err := pg.WaitForNotification(ctx)
+			if err != nil {
+				listenErr = err
+				// still listening, it is not returned to the pool
+				return driver.ErrBadConn
+			}
+
+			var e Event
+			if err := json.Unmarshal([]byte(n.Payload), &e); err != nil {
+				log.Printf("events: invalid notification %q: %v", n.Payload, err)
+				continue
+			}
+
+			b.Publish(e)
+		}
+	})
+
+	return listenErr
+}
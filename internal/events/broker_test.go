@@ -0,0 +1,165 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerReplay(t *testing.T) {
+	b := NewBroker(nil, Options{BufferSize: 3})
+
+	b.Publish(Event{ID: 1, UserID: 1})
+	b.Publish(Event{ID: 3, UserID: 1})
+	b.Publish(Event{ID: 2, UserID: 2})
+	b.Publish(Event{ID: 4, UserID: 1})
+
+	// received after 3, 2 is not for the user
+	_, missed, err := b.Subscribe(1, "3")
+	require.NoError(t, err)
+	assert.Equal(t, []Event{{ID: 4, UserID: 1}}, missed)
+
+	_, missed, err = b.Subscribe(1, "4")
+	require.NoError(t, err)
+	assert.Empty(t, missed)
+
+	// 1 is out of the buffer
+	_, missed, err = b.Subscribe(1, "1")
+	require.NoError(t, err)
+	assert.Equal(t, []Event{{Type: EventReset}}, missed)
+
+	_, missed, err = b.Subscribe(1, "")
+	require.NoError(t, err)
+	assert.Nil(t, missed)
+}
+
+func TestBrokerPublish(t *testing.T) {
+	b := NewBroker(nil, Options{})
+
+	sub, _, err := b.Subscribe(1, "")
+	require.NoError(t, err)
+	other, _, err := b.Subscribe(2, "")
+	require.NoError(t, err)
+
+	b.Publish(Event{ID: 1, UserID: 1})
+	assert.Equal(t, Event{ID: 1, UserID: 1}, <-sub.Events())
+	assert.Len(t, other.Events(), 0)
+
+	b.reset()
+	assert.Equal(t, Event{Type: EventReset}, <-sub.Events())
+	assert.Equal(t, Event{Type: EventReset}, <-other.Events())
+
+	// a subscription too far behind is ended
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(Event{ID: int64(i + 2), UserID: 1})
+	}
+	n := 0
+	for range sub.Events() {
+		n++
+	}
+	assert.Equal(t, subscriberBuffer, n)
+
+	b.Unsubscribe(other)
+	_, ok := <-other.Events()
+	assert.False(t, ok)
+
+	b.Close()
+	_, _, err = b.Subscribe(1, "")
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestWriteEvent(t *testing.T) {
+	var buf bytes.Buffer
+
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, writeEvent(&buf, Event{ID: 7, Type: "note.updated", NoteID: 3, UserID: 1, Version: 2, CreatedAt: at}))
+	require.NoError(t, writeEvent(&buf, Event{Type: EventReset}))
+
+	assert.Equal(t, "id: 7\nevent: note.updated\ndata: {\"note_id\":3,\"version\":2,\"created_at\":\"2023-01-02T03:04:05Z\"}\n\n"+
+		"event: reset\ndata: {}\n\n", buf.String())
+}
+
+// TestStreamShutdown checks a stream delivers the events of its user and
+// does not keep the server from shutting down.
+func TestStreamShutdown(t *testing.T) {
+	b := NewBroker(nil, Options{Heartbeat: time.Hour})
+	b.Publish(Event{ID: 1, Type: "note.created", NoteID: 1, UserID: 1})
+
+	res := resource{b}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res.stream(w, r.WithContext(auth.WithUser(r.Context(), &entity.User{BaseEntity: entity.BaseEntity{ID: 1}})))
+	})}
+	server.RegisterOnShutdown(b.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+listener.Addr().String(), nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "0")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var event strings.Builder
+		for {
+			line, err := lines.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return event.String()
+			}
+			event.WriteString(line)
+		}
+	}
+
+	assert.Equal(t, "retry: 3000\n", readEvent())
+	// 0 was not buffered
+	assert.Equal(t, "event: reset\ndata: {}\n", readEvent())
+
+	b.Publish(Event{ID: 2, Type: "note.updated", NoteID: 1, UserID: 1, Version: 2})
+	assert.Contains(t, readEvent(), "id: 2\nevent: note.updated\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, server.Shutdown(ctx))
+}
+
+// TestStreamExpires checks a stream ends once its access token expired.
+func TestStreamExpires(t *testing.T) {
+	b := NewBroker(nil, Options{Heartbeat: time.Hour})
+	defer b.Close()
+
+	res := resource{b}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.WithUser(r.Context(), &entity.User{BaseEntity: entity.BaseEntity{ID: 1}})
+		res.stream(w, r.WithContext(auth.WithExpiry(ctx, time.Now().Add(100*time.Millisecond))))
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "retry: 3000\n\nevent: expired\ndata: {}\n\n", string(body))
+}
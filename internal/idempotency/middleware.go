@@ -0,0 +1,287 @@
+// Package idempotency makes POST requests safe to retry. A request carrying
+// an Idempotency-Key header is executed once, its response is stored and
+// replayed to retries made with the same key until the key expires.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Header is the request header holding the key chosen by the client
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses replayed from a previous request
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+
+	// DefaultLease is how long a request holds its key when the server does
+	// not set it
+	DefaultLease = 5 * time.Minute
+	// DefaultMaxBodySize is the largest body of a request with a key when
+	// the server does not set it
+	DefaultMaxBodySize int64 = 1 << 20
+)
+
+// Authenticator resolves the user of a bearer access token, it is
+// implemented by auth.Service.
+type Authenticator interface {
+	Authenticate(accessToken string) (*entity.User, error)
+}
+
+// Options configures the middleware.
+type Options struct {
+	// TTL is how long the responses are replayed
+	TTL time.Duration
+	// Lease is how long a request holds its key, a key left in flight by a
+	// request which crashed can be used again after it
+	Lease time.Duration
+	// MaxBodySize is the largest JSON body of a request with a key, the body
+	// is read in memory to fingerprint the request
+	MaxBodySize int64
+}
+
+// fingerprintedTypes are the media types of the bodies read to fingerprint
+// the requests. The uploads, multipart or streamed, are left to the routes.
+var fingerprintedTypes = map[string]bool{"": true, "application/json": true}
+
+// storedHeaders are the response headers replayed with a stored response.
+var storedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+var (
+	errKeyTooLong  = apperrors.NewBadRequest("Idempotency-Key must be at most 255 characters")
+	errKeyInFlight = &apperrors.Error{
+		Type:    apperrors.Conflict,
+		Message: "a request with this Idempotency-Key is still being processed",
+	}
+	errKeyReused = &apperrors.Error{
+		Type:    apperrors.Conflict,
+		Message: "Idempotency-Key was already used with a different request",
+	}
+)
+
+// Middleware honours the Idempotency-Key header of POST requests with a JSON
+// body or none, other requests pass through. Keys are scoped to the method,
+// path and user of the request so clients cannot replay each other's
+// responses, a retry with a refreshed access token is still recognised.
+// Anonymous requests, the signups, have no user to scope their key to: it
+// is scoped to their body as well, only the client which sent it can replay
+// the response. They are not erased with a user, they expire. Requests with
+// an invalid access token are refused by the routes.
+//
+// Only responses with a status below 500 and without Cache-Control: no-store
+// are stored. The key is released after a server error so the request can be
+// retried, and after a response carrying secrets, tokens or recovery codes,
+// which must not be kept.
+func Middleware(repo IdempotencyQueries, authenticator Authenticator, opts Options) func(http.Handler) http.Handler {
+	if opts.Lease <= 0 {
+		opts.Lease = DefaultLease
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxKeyLength {
+				render.Render(w, r, apperrors.ErrFromError(errKeyTooLong))
+				return
+			}
+
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); !fingerprintedTypes[mediaType] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, ok := requestUser(r, authenticator)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, opts.MaxBodySize))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					render.Render(w, r, apperrors.ErrFromError(apperrors.NewPayloadTooLarge(opts.MaxBodySize, r.ContentLength)))
+					return
+				}
+				render.Render(w, r, apperrors.ErrInvalidRequest(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := hash(r.Method, r.URL.Path, r.Header.Get("Content-Type"), string(body))
+			if userID != nil {
+				key = hash(r.Method, r.URL.Path, strconv.FormatInt(*userID, 10), key)
+			} else {
+				key = hash(r.Method, r.URL.Path, "anonymous", key, fingerprint)
+			}
+
+			now := time.Now()
+
+			reserved, err := repo.Reserve(key, userID, fingerprint, now.Add(opts.Lease), now.Add(opts.TTL))
+			if err != nil {
+				render.Render(w, r, apperrors.ErrInternalError(err))
+				return
+			}
+
+			if !reserved {
+				replay(w, r, repo, key, fingerprint)
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			completed := false
+
+			// release the key if the handler panics or fails
+			defer func() {
+				if completed {
+					return
+				}
+				if err := repo.Release(key); err != nil {
+					log.Printf("release idempotency key error: %v", err)
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= http.StatusInternalServerError || noStore(rec.Header()) {
+				return
+			}
+
+			headers := entity.JSONMap{}
+			for _, name := range storedHeaders {
+				if v := rec.Header().Get(name); v != "" {
+					headers[name] = v
+				}
+			}
+
+			if err := repo.Complete(key, rec.status, headers, rec.body.Bytes()); err != nil {
+				log.Printf("store idempotent response error: %v", err)
+				return
+			}
+
+			completed = true
+		})
+	}
+}
+
+// requestUser returns the ID of the user authenticated by the bearer token
+// of r, nil for anonymous requests. It reports false for invalid tokens.
+func requestUser(r *http.Request, authenticator Authenticator) (*int64, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, true
+	}
+
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header {
+		return nil, false
+	}
+
+	u, err := authenticator.Authenticate(token)
+	if err != nil {
+		return nil, false
+	}
+
+	return &u.ID, true
+}
+
+// noStore reports whether the response must not be stored.
+func noStore(header http.Header) bool {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+			return true
+		}
+	}
+	return false
+}
+
+// replay writes the response stored for key, or the error explaining why it
+// cannot be replayed.
+func replay(w http.ResponseWriter, r *http.Request, repo IdempotencyQueries, key string, fingerprint string) {
+	stored, err := repo.Get(key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		render.Render(w, r, apperrors.ErrInternalError(errors.Wrap(err, "get idempotency key error")))
+		return
+	}
+
+	if err == nil && stored.Fingerprint != fingerprint {
+		render.Render(w, r, apperrors.ErrFromError(errKeyReused))
+		return
+	}
+
+	// a key released in the meantime can be retried as well
+	if err != nil || !stored.Completed {
+		w.Header().Set("Retry-After", "1")
+		render.Render(w, r, apperrors.ErrFromError(errKeyInFlight))
+		return
+	}
+
+	for name, v := range stored.ResponseHeaders {
+		if s, ok := v.(string); ok {
+			w.Header().Set(name, s)
+		}
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(stored.ResponseStatus)
+	w.Write(stored.ResponseBody)
+}
+
+// recorder passes the response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// hash returns the hex SHA-256 of the parts, each prefixed with its length
+// so that different splits cannot collide.
+func hash(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(p)))
+		h.Write(n[:])
+		h.Write([]byte(p))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,298 @@
+package idempotency
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryQueries keeps the keys in memory, without expiration.
+type memoryQueries struct {
+	mu          sync.Mutex
+	keys        map[string]*entity.IdempotencyKey
+	lockedUntil map[string]time.Time
+}
+
+func newMemoryQueries() *memoryQueries {
+	return &memoryQueries{keys: map[string]*entity.IdempotencyKey{}, lockedUntil: map[string]time.Time{}}
+}
+
+func (q *memoryQueries) Reserve(key string, userID *int64, fingerprint string, lockedUntil time.Time, expiresAt time.Time) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if k, ok := q.keys[key]; ok && (k.Completed || time.Now().Before(q.lockedUntil[key])) {
+		return false, nil
+	}
+	q.keys[key] = &entity.IdempotencyKey{Key: key, UserID: userID, Fingerprint: fingerprint}
+	q.lockedUntil[key] = lockedUntil
+	return true, nil
+}
+
+func (q *memoryQueries) Get(key string) (*entity.IdempotencyKey, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	k, ok := q.keys[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	c := *k
+	return &c, nil
+}
+
+func (q *memoryQueries) Complete(key string, status int, headers entity.JSONMap, body []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	k := q.keys[key]
+	k.Completed, k.ResponseStatus, k.ResponseHeaders, k.ResponseBody = true, status, headers, body
+	return nil
+}
+
+func (q *memoryQueries) Release(key string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if k, ok := q.keys[key]; ok && !k.Completed {
+		delete(q.keys, key)
+	}
+	return nil
+}
+
+func (q *memoryQueries) DeleteExpired(now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// tokens authenticates the access tokens it maps to a user ID.
+type tokens map[string]int64
+
+func (a tokens) Authenticate(accessToken string) (*entity.User, error) {
+	id, ok := a[accessToken]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return &entity.User{BaseEntity: entity.BaseEntity{ID: id}}, nil
+}
+
+func middleware(repo IdempotencyQueries, opts Options) func(http.Handler) http.Handler {
+	if opts.TTL == 0 {
+		opts.TTL = time.Hour
+	}
+	return Middleware(repo, tokens{"token-1": 1, "token-1-refreshed": 1, "token-2": 2}, opts)
+}
+
+func post(h http.Handler, key string, body string) *httptest.ResponseRecorder {
+	return postAs(h, "token-1", key, body)
+}
+
+func postAs(h http.Handler, token string, key string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if key != "" {
+		r.Header.Set(Header, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func counter(calls *int, status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", fmt.Sprintf("/api/users/%d", *calls))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"id":%d}`, *calls)
+	})
+}
+
+func TestMiddlewareReplaysResponse(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{})(counter(&calls, http.StatusCreated))
+
+	first := post(h, "abc", `{"email":"a@b.c"}`)
+	retry := post(h, "abc", `{"email":"a@b.c"}`)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "/api/users/1", retry.Header().Get("Location"))
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+
+	// a new key is a new request
+	post(h, "def", `{"email":"a@b.c"}`)
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddlewareRejectsDifferentPayload(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{})(counter(&calls, http.StatusCreated))
+
+	post(h, "abc", `{"email":"a@b.c"}`)
+	w := post(h, "abc", `{"email":"x@y.z"}`)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestMiddlewareInFlight(t *testing.T) {
+	repo := newMemoryQueries()
+	calls := 0
+	var h http.Handler
+
+	// the retry arrives while the first request is being handled
+	var retry *httptest.ResponseRecorder
+	h = middleware(repo, Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			retry = post(h, "abc", `{}`)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	post(h, "abc", `{}`)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusConflict, retry.Code)
+	assert.Equal(t, "1", retry.Header().Get("Retry-After"))
+}
+
+func TestMiddlewareReleasesOnServerError(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{})(counter(&calls, http.StatusInternalServerError))
+
+	post(h, "abc", `{}`)
+	post(h, "abc", `{}`)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddlewareWithoutKey(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{})(counter(&calls, http.StatusCreated))
+
+	post(h, "", `{}`)
+	post(h, "", `{}`)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddlewareScopedByUser(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{})(counter(&calls, http.StatusCreated))
+
+	postAs(h, "token-1", "abc", `{}`)
+
+	// a retry after the access token was refreshed is the same request
+	retry := postAs(h, "token-1-refreshed", "abc", `{}`)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+
+	// another user does not share the key
+	postAs(h, "token-2", "abc", `{}`)
+	assert.Equal(t, 2, calls)
+
+	// invalid tokens are left to the routes
+	postAs(h, "expired", "abc", `{}`)
+	postAs(h, "expired", "abc", `{}`)
+	assert.Equal(t, 4, calls)
+}
+
+func TestMiddlewareAnonymousSignup(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{})(counter(&calls, http.StatusCreated))
+
+	signup := `{"email":"a@b.c","password":"correct horse battery"}`
+
+	first := postAs(h, "", "abc", signup)
+	retry := postAs(h, "", "abc", signup)
+
+	// one user is created, the retry gets its 201
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+
+	// the key is scoped to the body, another client using the same key does
+	// not get the response
+	other := postAs(h, "", "abc", `{"email":"x@y.z","password":"correct horse battery"}`)
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, other.Header().Get(ReplayedHeader))
+
+	// nor does an authenticated user
+	postAs(h, "token-1", "abc", signup)
+	assert.Equal(t, 3, calls)
+}
+
+func TestMiddlewareNoStore(t *testing.T) {
+	repo := newMemoryQueries()
+	calls := 0
+	h := middleware(repo, Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, `{"recovery_codes":["secret"]}`)
+	}))
+
+	post(h, "abc", `{}`)
+	retry := post(h, "abc", `{}`)
+
+	// the secrets are not kept, the retry runs again
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, retry.Header().Get(ReplayedHeader))
+	assert.Empty(t, repo.keys)
+}
+
+func TestMiddlewareSkipsUploads(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{MaxBodySize: 8})(counter(&calls, http.StatusCreated))
+
+	for _, contentType := range []string{"multipart/form-data; boundary=x", "application/zip"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/notes/import", strings.NewReader("larger than the limit"))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Authorization", "Bearer token-1")
+		r.Header.Set(Header, "abc")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusCreated, w.Code, contentType)
+	}
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddlewareLimitsBody(t *testing.T) {
+	calls := 0
+	h := middleware(newMemoryQueries(), Options{MaxBodySize: 8})(counter(&calls, http.StatusCreated))
+
+	w := post(h, "abc", `{"email":"a@b.c"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, calls)
+
+	// requests without a key are not read by the middleware
+	post(h, "", `{"email":"a@b.c"}`)
+	assert.Equal(t, 1, calls)
+}
+
+func TestMiddlewareLeaseExpires(t *testing.T) {
+	repo := newMemoryQueries()
+	calls := 0
+
+	// a request which crashed left its key in flight
+	userID := int64(1)
+	_, err := repo.Reserve(hash(http.MethodPost, "/api/users", "1", "abc"), &userID, "fingerprint", time.Now().Add(-time.Second), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	w := post(middleware(repo, Options{})(counter(&calls, http.StatusCreated)), "abc", `{}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, calls)
+}
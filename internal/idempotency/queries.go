@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+type IdempotencyQueries interface {
+	Reserve(key string, userID *int64, fingerprint string, lockedUntil time.Time, expiresAt time.Time) (bool, error)
+	Get(key string) (*entity.IdempotencyKey, error)
+	Complete(key string, status int, headers entity.JSONMap, body []byte) error
+	Release(key string) error
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// idempotencyQueries struct for queries from the idempotency_keys table.
+type idempotencyQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewIdempotencyQueries(db *sqlx.DB, tx *sqlx.Tx) IdempotencyQueries {
+	return &idempotencyQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *idempotencyQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// Reserve implements IdempotencyQueries
+//
+// It reports whether the key was free, expired or left in flight past its
+// lock by a request which crashed, and is now held by the caller until
+// lockedUntil. The primary key makes concurrent reservations of a key fail.
+// userID is nil for anonymous requests.
+func (q *idempotencyQueries) Reserve(key string, userID *int64, fingerprint string, lockedUntil time.Time, expiresAt time.Time) (bool, error) {
+	query := `INSERT INTO idempotency_keys (key, user_id, fingerprint, locked_until, expires_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (key) DO UPDATE SET user_id = EXCLUDED.user_id, fingerprint = EXCLUDED.fingerprint, completed = FALSE,
+		response_status = 0, response_headers = '{}', response_body = NULL,
+		locked_until = EXCLUDED.locked_until, expires_at = EXCLUDED.expires_at, created_at = NOW()
+		WHERE idempotency_keys.expires_at < NOW() OR (NOT idempotency_keys.completed AND idempotency_keys.locked_until < NOW())`
+
+	res, err := q.conn().Exec(query, key, userID, fingerprint, lockedUntil, expiresAt)
+	if err != nil {
+		return false, errors.Wrap(err, "reserve idempotency key error")
+	}
+
+	n, err := res.RowsAffected()
+
+	return n == 1, err
+}
+
+// Get implements IdempotencyQueries
+func (q *idempotencyQueries) Get(key string) (*entity.IdempotencyKey, error) {
+	var k entity.IdempotencyKey
+
+	query := `SELECT * FROM idempotency_keys WHERE key = $1`
+
+	err := sqlx.Get(q.conn(), &k, query, key)
+
+	return &k, err
+}
+
+// Complete implements IdempotencyQueries
+func (q *idempotencyQueries) Complete(key string, status int, headers entity.JSONMap, body []byte) error {
+	query := `UPDATE idempotency_keys SET completed = TRUE, response_status = $2, response_headers = $3, response_body = $4
+		WHERE key = $1`
+
+	_, err := q.conn().Exec(query, key, status, headers, body)
+	if err != nil {
+		return errors.Wrap(err, "complete idempotency key error")
+	}
+
+	return nil
+}
+
+// Release implements IdempotencyQueries
+//
+// Only keys still in flight are released, stored responses are kept.
+func (q *idempotencyQueries) Release(key string) error {
+	_, err := q.conn().Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND NOT completed`, key)
+	if err != nil {
+		return errors.Wrap(err, "release idempotency key error")
+	}
+
+	return nil
+}
+
+// DeleteExpired implements IdempotencyQueries
+func (q *idempotencyQueries) DeleteExpired(now time.Time) (int64, error) {
+	res, err := q.conn().Exec(`DELETE FROM idempotency_keys WHERE expires_at < $1`, now)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete expired idempotency keys error")
+	}
+
+	return res.RowsAffected()
+}
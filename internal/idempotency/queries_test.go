@@ -0,0 +1,170 @@
+package idempotency
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type queriesSuiteTest struct {
+	test.TSuite
+}
+
+func TestQueriesSuiteTest(t *testing.T) {
+	suite.Run(t, new(queriesSuiteTest))
+}
+
+func (t *queriesSuiteTest) createUser() *entity.User {
+	u, err := user.NewUserQueries(t.DB, t.TX).CreateUser(&test.GenerateUsers(1)[0])
+	require.NoError(t.T(), err)
+
+	return u
+}
+
+func (t *queriesSuiteTest) TestReserveAndComplete() {
+	queries := NewIdempotencyQueries(t.DB, t.TX)
+	u := t.createUser()
+
+	reserved, err := queries.Reserve("key", &u.ID, "fingerprint", time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+	assert.True(t.T(), reserved)
+
+	// the key is held until it expires
+	reserved, err = queries.Reserve("key", &u.ID, "other", time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+	assert.False(t.T(), reserved)
+
+	err = queries.Complete("key", http.StatusCreated, entity.JSONMap{"Location": "/api/users/1"}, []byte(`{"id":1}`))
+	require.NoError(t.T(), err)
+
+	stored, err := queries.Get("key")
+	require.NoError(t.T(), err)
+
+	assert.True(t.T(), stored.Completed)
+	assert.Equal(t.T(), "fingerprint", stored.Fingerprint)
+	assert.Equal(t.T(), http.StatusCreated, stored.ResponseStatus)
+	assert.Equal(t.T(), "/api/users/1", stored.ResponseHeaders["Location"])
+	assert.Equal(t.T(), `{"id":1}`, string(stored.ResponseBody))
+
+	// stored responses are not released
+	require.NoError(t.T(), queries.Release("key"))
+	_, err = queries.Get("key")
+	assert.NoError(t.T(), err)
+}
+
+func (t *queriesSuiteTest) TestRelease() {
+	queries := NewIdempotencyQueries(t.DB, t.TX)
+	u := t.createUser()
+
+	_, err := queries.Reserve("key", &u.ID, "fingerprint", time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), queries.Release("key"))
+
+	_, err = queries.Get("key")
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+}
+
+func (t *queriesSuiteTest) TestExpiredKeys() {
+	queries := NewIdempotencyQueries(t.DB, t.TX)
+	u := t.createUser()
+
+	_, err := queries.Reserve("expired", &u.ID, "fingerprint", time.Now().Add(-time.Minute), time.Now().Add(-time.Minute))
+	require.NoError(t.T(), err)
+	require.NoError(t.T(), queries.Complete("expired", http.StatusOK, entity.JSONMap{}, nil))
+
+	_, err = queries.Reserve("valid", &u.ID, "fingerprint", time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+
+	// an expired key can be reserved again, for a new request
+	reserved, err := queries.Reserve("expired", &u.ID, "other", time.Now().Add(-time.Minute), time.Now().Add(-time.Minute))
+	require.NoError(t.T(), err)
+	assert.True(t.T(), reserved)
+
+	stored, err := queries.Get("expired")
+	require.NoError(t.T(), err)
+	assert.False(t.T(), stored.Completed)
+	assert.Equal(t.T(), "other", stored.Fingerprint)
+
+	n, err := queries.DeleteExpired(time.Now())
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(1), n)
+
+	_, err = queries.Get("valid")
+	assert.NoError(t.T(), err)
+}
+
+func (t *queriesSuiteTest) TestLeaseExpires() {
+	queries := NewIdempotencyQueries(t.DB, t.TX)
+	u := t.createUser()
+
+	// the request holding the key crashed before its lock ended
+	_, err := queries.Reserve("key", &u.ID, "fingerprint", time.Now().Add(-time.Second), time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+
+	reserved, err := queries.Reserve("key", &u.ID, "fingerprint", time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+	assert.True(t.T(), reserved)
+
+	stored, err := queries.Get("key")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), &u.ID, stored.UserID)
+
+	// a completed response is replayed whatever its lock
+	require.NoError(t.T(), queries.Complete("key", http.StatusCreated, entity.JSONMap{}, nil))
+	_, err = t.TX.Exec(`UPDATE idempotency_keys SET locked_until = NOW() - INTERVAL '1 minute'`)
+	require.NoError(t.T(), err)
+
+	reserved, err = queries.Reserve("key", &u.ID, "fingerprint", time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+	assert.False(t.T(), reserved)
+}
+
+type noopVerifier struct{}
+
+func (noopVerifier) StartVerification(u *entity.User) error {
+	return nil
+}
+
+// TestAnonymousSignup checks a signup retried with the same key creates one
+// user.
+func (t *queriesSuiteTest) TestAnonymousSignup() {
+	policy, err := user.NewPasswordPolicy(8, "")
+	require.NoError(t.T(), err)
+
+	// the service runs its own transactions, it cannot share the one of the test
+	users := user.NewService(t.DB, user.NewUserQueries(t.DB, nil), noopVerifier{}, policy)
+	h := Middleware(NewIdempotencyQueries(t.DB, nil), tokens{}, Options{TTL: time.Hour})(user.RegisterHandlers(users))
+
+	signup := func() *httptest.ResponseRecorder {
+		body := `{"first_name":"User","last_name":"Example","email":"user01@example.com","password":"correct horse battery"}`
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(Header, "signup-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	first := signup()
+	require.Equal(t.T(), http.StatusCreated, first.Code, first.Body.String())
+
+	retry := signup()
+	assert.Equal(t.T(), http.StatusCreated, retry.Code)
+	assert.Equal(t.T(), "true", retry.Header().Get(ReplayedHeader))
+	assert.Equal(t.T(), first.Body.String(), retry.Body.String())
+
+	count, err := users.Count()
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 1, count)
+}
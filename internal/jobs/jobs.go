@@ -0,0 +1,78 @@
+// Package jobs runs tasks in the background, out of the HTTP handlers. Jobs
+// are rows of the jobs table, enqueued in the transaction of the change
+// asking for them, and run by a pool of workers in any process. A failing
+// job is retried with an exponential backoff until it runs out of attempts.
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxAttempts is the number of runs of a job before it fails.
+const DefaultMaxAttempts = 5
+
+// Job is the payload of a job, it is stored as JSON. Kind names the handler
+// running it, it must not depend on the value of the job.
+type Job interface {
+	Kind() string
+}
+
+// Option configures an enqueued job.
+type Option func(*entity.Job)
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) Option {
+	return func(j *entity.Job) {
+		j.RunAt.Time = t
+	}
+}
+
+// Unique enqueues the job only if no job of the same kind and key is queued
+// or running.
+func Unique(key string) Option {
+	return func(j *entity.Job) {
+		j.UniqueKey = &key
+	}
+}
+
+// MaxAttempts sets the number of runs of the job before it fails.
+func MaxAttempts(n int) Option {
+	return func(j *entity.Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// Enqueue inserts job with the queries bound to tx, or db when tx is nil.
+// Bound to a transaction the job only runs if it commits. It returns nil,
+// and no error, when a unique job is already waiting.
+func Enqueue(db *sqlx.DB, tx *sqlx.Tx, job Job, opts ...Option) (*entity.Job, error) {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal job error")
+	}
+
+	j := &entity.Job{
+		Kind:        job.Kind(),
+		Payload:     payload,
+		MaxAttempts: DefaultMaxAttempts,
+	}
+	j.RunAt.Time = time.Now()
+	j.RunAt.Valid = true
+
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	created, err := NewJobQueries(db, tx).Insert(j)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return created, err
+}
@@ -0,0 +1,215 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+// Options configures the worker pool.
+type Options struct {
+	// Concurrency is the number of jobs run at the same time
+	Concurrency int
+	// PollInterval is how often idle workers look for jobs
+	PollInterval time.Duration
+	// BaseBackoff is the delay before the first retry, it doubles with every
+	// attempt until MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// StaleAfter is how long a job can run before it is considered
+	// abandoned by a dead worker and queued again
+	StaleAfter time.Duration
+}
+
+// Backoff returns the delay before retrying a job run attempts times.
+func (o Options) Backoff(attempts int) time.Duration {
+	d := o.BaseBackoff
+
+	for i := 1; i < attempts && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if o.MaxBackoff > 0 && d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+
+	return d
+}
+
+// handler runs the payload of a job.
+type handler func(ctx context.Context, payload json.RawMessage) error
+
+// Pool runs the jobs of the kinds registered with it.
+type Pool struct {
+	db   *sqlx.DB
+	opts Options
+
+	mu       sync.RWMutex
+	handlers map[string]handler
+}
+
+func NewPool(db *sqlx.DB, opts Options) *Pool {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.StaleAfter <= 0 {
+		opts.StaleAfter = time.Hour
+	}
+
+	return &Pool{db: db, opts: opts, handlers: map[string]handler{}}
+}
+
+// Register makes p run the jobs of type T with h. The payload of the jobs is
+// decoded into a T.
+func Register[T Job](p *Pool, h func(ctx context.Context, job T) error) {
+	var zero T
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handlers[zero.Kind()] = func(ctx context.Context, payload json.RawMessage) error {
+		var job T
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return errors.Wrap(err, "unmarshal job error")
+		}
+		return h(ctx, job)
+	}
+}
+
+// Enqueue inserts job outside of any transaction, see Enqueue.
+func (p *Pool) Enqueue(job Job, opts ...Option) (*entity.Job, error) {
+	return Enqueue(p.db, nil, job, opts...)
+}
+
+// Kinds returns the kinds of jobs p runs.
+func (p *Pool) Kinds() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	kinds := make([]string, 0, len(p.handlers))
+	for kind := range p.handlers {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// Run runs jobs with Concurrency workers until ctx is done. It then drains:
+// it returns once the jobs being run are finished. Jobs are not interrupted,
+// their context is not canceled by ctx.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.rescue(ctx)
+	}()
+
+	wg.Wait()
+}
+
+// work runs jobs one after the other until ctx is done.
+func (p *Pool) work(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		ran, err := p.RunNext(context.Background())
+		if err != nil {
+			log.Printf("run job error: %v", err)
+		}
+
+		// keep going while there are jobs
+		if ran && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rescue periodically queues again the jobs abandoned by dead workers.
+func (p *Pool) rescue(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.StaleAfter / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := NewJobQueries(p.db, nil).RescueStale(time.Now().Add(-p.opts.StaleAfter))
+			if err != nil {
+				log.Printf("rescue stale jobs error: %v", err)
+			} else if n > 0 {
+				log.Printf("rescued %d stale jobs", n)
+			}
+		}
+	}
+}
+
+// RunNext claims a due job and runs it. It reports whether there was one.
+func (p *Pool) RunNext(ctx context.Context) (bool, error) {
+	kinds := p.Kinds()
+	if len(kinds) == 0 {
+		return false, nil
+	}
+
+	repo := NewJobQueries(p.db, nil)
+
+	job, err := repo.Claim(kinds)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := p.run(ctx, job); err != nil {
+		if job.Attempts >= job.MaxAttempts {
+			log.Printf("job %d (%s) failed after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+			return true, repo.Fail(job.ID, err.Error())
+		}
+		return true, repo.Retry(job.ID, err.Error(), time.Now().Add(p.opts.Backoff(job.Attempts)))
+	}
+
+	return true, repo.Complete(job.ID)
+}
+
+// run calls the handler of job, a panic is returned as an error.
+func (p *Pool) run(ctx context.Context, job *entity.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panic: %v", r)
+		}
+	}()
+
+	p.mu.RLock()
+	h := p.handlers[job.Kind]
+	p.mu.RUnlock()
+
+	return h(ctx, job.Payload)
+}
@@ -0,0 +1,189 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type testJob struct {
+	Name string `json:"name"`
+}
+
+func (testJob) Kind() string {
+	return "test.job"
+}
+
+func TestBackoff(t *testing.T) {
+	opts := Options{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, opts.Backoff(1))
+	assert.Equal(t, 2*time.Second, opts.Backoff(2))
+	assert.Equal(t, 8*time.Second, opts.Backoff(4))
+	assert.Equal(t, 10*time.Second, opts.Backoff(50))
+}
+
+func TestKinds(t *testing.T) {
+	p := NewPool(nil, Options{})
+	assert.Empty(t, p.Kinds())
+
+	Register(p, func(ctx context.Context, job testJob) error { return nil })
+	assert.Equal(t, []string{"test.job"}, p.Kinds())
+}
+
+type poolSuiteTest struct {
+	test.TSuite
+}
+
+func TestPoolSuiteTest(t *testing.T) {
+	suite.Run(t, new(poolSuiteTest))
+}
+
+func (t *poolSuiteTest) TestRunNext() {
+	_, err := Enqueue(t.DB, nil, testJob{Name: "first"})
+	require.NoError(t.T(), err)
+
+	p := NewPool(t.DB, Options{})
+
+	var names []string
+	Register(p, func(ctx context.Context, job testJob) error {
+		names = append(names, job.Name)
+		return nil
+	})
+
+	ran, err := p.RunNext(context.Background())
+	require.NoError(t.T(), err)
+	assert.True(t.T(), ran)
+	assert.Equal(t.T(), []string{"first"}, names)
+
+	// succeeded jobs are not run again
+	ran, err = p.RunNext(context.Background())
+	require.NoError(t.T(), err)
+	assert.False(t.T(), ran)
+}
+
+func (t *poolSuiteTest) TestEnqueueInTransaction() {
+	rollback := errors.New("rollback")
+
+	err := database.WithTx(t.DB, func(tx *sqlx.Tx) error {
+		if _, err := Enqueue(t.DB, tx, testJob{}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	require.ErrorIs(t.T(), err, rollback)
+
+	p := NewPool(t.DB, Options{})
+	Register(p, func(ctx context.Context, job testJob) error { return nil })
+
+	// the job was rolled back with the transaction
+	ran, err := p.RunNext(context.Background())
+	require.NoError(t.T(), err)
+	assert.False(t.T(), ran)
+}
+
+func (t *poolSuiteTest) TestEnqueueOptions() {
+	job, err := Enqueue(t.DB, nil, testJob{}, Unique("key"), MaxAttempts(2))
+	require.NoError(t.T(), err)
+	require.NotNil(t.T(), job)
+	assert.Equal(t.T(), 2, job.MaxAttempts)
+
+	// a unique job is enqueued once while it waits
+	dup, err := Enqueue(t.DB, nil, testJob{}, Unique("key"))
+	require.NoError(t.T(), err)
+	assert.Nil(t.T(), dup)
+
+	// jobs run later are not due yet
+	_, err = Enqueue(t.DB, nil, testJob{Name: "later"}, RunAt(time.Now().Add(time.Hour)))
+	require.NoError(t.T(), err)
+
+	p := NewPool(t.DB, Options{})
+
+	var names []string
+	Register(p, func(ctx context.Context, job testJob) error {
+		names = append(names, job.Name)
+		return nil
+	})
+
+	for {
+		ran, err := p.RunNext(context.Background())
+		require.NoError(t.T(), err)
+		if !ran {
+			break
+		}
+	}
+	assert.Equal(t.T(), []string{""}, names)
+
+	// once it has run the unique job can be enqueued again
+	again, err := Enqueue(t.DB, nil, testJob{}, Unique("key"))
+	require.NoError(t.T(), err)
+	assert.NotNil(t.T(), again)
+}
+
+func (t *poolSuiteTest) TestRunNextRetries() {
+	job, err := Enqueue(t.DB, nil, testJob{}, MaxAttempts(2))
+	require.NoError(t.T(), err)
+
+	p := NewPool(t.DB, Options{})
+
+	calls := 0
+	Register(p, func(ctx context.Context, job testJob) error {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		return errors.New("unavailable")
+	})
+
+	// without backoff the job is due again right away
+	_, err = p.RunNext(context.Background())
+	require.NoError(t.T(), err)
+
+	got, err := NewJobQueries(t.DB, nil).GetJob(job.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.JobQueued, got.Status)
+	assert.Equal(t.T(), "job panic: boom", got.LastError)
+
+	_, err = p.RunNext(context.Background())
+	require.NoError(t.T(), err)
+
+	got, err = NewJobQueries(t.DB, nil).GetJob(job.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.JobFailed, got.Status)
+	assert.Equal(t.T(), 2, got.Attempts)
+	assert.Equal(t.T(), "unavailable", got.LastError)
+	assert.True(t.T(), got.FinishedAt.Valid)
+
+	assert.Equal(t.T(), 2, calls)
+}
+
+func (t *poolSuiteTest) TestRescueStale() {
+	job, err := Enqueue(t.DB, nil, testJob{})
+	require.NoError(t.T(), err)
+
+	queries := NewJobQueries(t.DB, nil)
+
+	_, err = queries.Claim([]string{"test.job"})
+	require.NoError(t.T(), err)
+
+	n, err := queries.RescueStale(time.Now().Add(-time.Hour))
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(0), n)
+
+	n, err = queries.RescueStale(time.Now().Add(time.Second))
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(1), n)
+
+	got, err := queries.GetJob(job.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.JobQueued, got.Status)
+}
@@ -0,0 +1,163 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+type JobQueries interface {
+	Insert(job *entity.Job) (*entity.Job, error)
+	GetJob(id int64) (*entity.Job, error)
+	Claim(kinds []string) (*entity.Job, error)
+	Complete(id int64) error
+	Retry(id int64, message string, runAt time.Time) error
+	Fail(id int64, message string) error
+	RescueStale(lockedBefore time.Time) (int64, error)
+	DeleteFinished(before time.Time) (int64, error)
+}
+
+// jobQueries struct for queries from the jobs table.
+type jobQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewJobQueries(db *sqlx.DB, tx *sqlx.Tx) JobQueries {
+	return &jobQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *jobQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// Insert implements JobQueries
+//
+// sql.ErrNoRows is returned when a job of the same kind and unique key is
+// already queued or running.
+func (q *jobQueries) Insert(j *entity.Job) (*entity.Job, error) {
+	query := `INSERT INTO jobs (kind, payload, unique_key, max_attempts, run_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (kind, unique_key) WHERE unique_key IS NOT NULL AND status IN ('queued', 'running')
+		DO NOTHING RETURNING *`
+
+	var job entity.Job
+
+	err := q.conn().QueryRowx(query, j.Kind, string(j.Payload), j.UniqueKey, j.MaxAttempts, j.RunAt).StructScan(&job)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "insert job error")
+	}
+
+	return &job, nil
+}
+
+// GetJob implements JobQueries
+func (q *jobQueries) GetJob(id int64) (*entity.Job, error) {
+	var job entity.Job
+
+	err := sqlx.Get(q.conn(), &job, `SELECT * FROM jobs WHERE id = $1`, id)
+
+	return &job, err
+}
+
+// Claim implements JobQueries
+//
+// It marks the oldest due job of one of kinds as running and returns it,
+// jobs being claimed by other workers are skipped. sql.ErrNoRows is returned
+// when there is none.
+func (q *jobQueries) Claim(kinds []string) (*entity.Job, error) {
+	b, err := json.Marshal(kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE jobs SET status = $1, attempts = attempts + 1, locked_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = $2 AND run_at <= NOW() AND kind IN (SELECT jsonb_array_elements_text($3::jsonb))
+			ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED
+		) RETURNING *`
+
+	var job entity.Job
+
+	if err := q.conn().QueryRowx(query, entity.JobRunning, entity.JobQueued, string(b)).StructScan(&job); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "claim job error")
+	}
+
+	return &job, nil
+}
+
+// Complete implements JobQueries
+func (q *jobQueries) Complete(id int64) error {
+	query := `UPDATE jobs SET status = $2, locked_at = NULL, finished_at = NOW() WHERE id = $1`
+
+	if _, err := q.conn().Exec(query, id, entity.JobSucceeded); err != nil {
+		return errors.Wrap(err, "complete job error")
+	}
+
+	return nil
+}
+
+// Retry implements JobQueries
+func (q *jobQueries) Retry(id int64, message string, runAt time.Time) error {
+	query := `UPDATE jobs SET status = $2, locked_at = NULL, last_error = $3, run_at = $4 WHERE id = $1`
+
+	if _, err := q.conn().Exec(query, id, entity.JobQueued, message, runAt); err != nil {
+		return errors.Wrap(err, "retry job error")
+	}
+
+	return nil
+}
+
+// Fail implements JobQueries
+func (q *jobQueries) Fail(id int64, message string) error {
+	query := `UPDATE jobs SET status = $2, locked_at = NULL, last_error = $3, finished_at = NOW() WHERE id = $1`
+
+	if _, err := q.conn().Exec(query, id, entity.JobFailed, message); err != nil {
+		return errors.Wrap(err, "fail job error")
+	}
+
+	return nil
+}
+
+// RescueStale implements JobQueries
+//
+// Jobs claimed before lockedBefore were left running by a worker which died,
+// they are queued again, or failed when they ran out of attempts.
+func (q *jobQueries) RescueStale(lockedBefore time.Time) (int64, error) {
+	query := `UPDATE jobs SET locked_at = NULL, last_error = 'worker stopped responding',
+		status = CASE WHEN attempts >= max_attempts THEN $3 ELSE $2 END,
+		finished_at = CASE WHEN attempts >= max_attempts THEN NOW() END
+		WHERE status = $1 AND locked_at < $4`
+
+	res, err := q.conn().Exec(query, entity.JobRunning, entity.JobQueued, entity.JobFailed, lockedBefore)
+	if err != nil {
+		return 0, errors.Wrap(err, "rescue stale jobs error")
+	}
+
+	return res.RowsAffected()
+}
+
+// DeleteFinished implements JobQueries
+func (q *jobQueries) DeleteFinished(before time.Time) (int64, error) {
+	res, err := q.conn().Exec(`DELETE FROM jobs WHERE finished_at < $1`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete finished jobs error")
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer returns a Mailer that writes every message as an .eml file in
+// dir instead of sending it. Useful for development. The messages carry
+// verification and reset links, only the owner of the process can read them.
+func NewFileMailer(dir string, from string) (Mailer, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "myserver-mail")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("mailer: %w", err)
+	}
+
+	// MkdirAll leaves the mode of an existing directory untouched
+	if err := os.Chmod(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("mailer: %w", err)
+	}
+
+	return &fileMailer{dir, from}, nil
+}
+
+// Send implements Mailer
+func (m *fileMailer) Send(msg Message) error {
+	msg = withDefaults(msg, m.from)
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	suffix, err := randomBoundary()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), suffix[:8])
+	path := filepath.Join(m.dir, name)
+
+	if err := os.WriteFile(path, body, 0o600); err != nil {
+		return fmt.Errorf("mailer: %w", err)
+	}
+
+	log.Printf("mail to %s written to %s", strings.Join(msg.To, ", "), path)
+
+	return nil
+}
+
+type logMailer struct {
+	from string
+}
+
+// NewLogMailer returns a Mailer that logs the recipients and subject of
+// every message instead of sending it. The bodies are left out, they carry
+// links and tokens, the file driver keeps them.
+func NewLogMailer(from string) Mailer {
+	return logMailer{from}
+}
+
+// Send implements Mailer
+func (m logMailer) Send(msg Message) error {
+	msg = withDefaults(msg, m.from)
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	log.Printf("mail from %s to %s: %s", msg.From, strings.Join(msg.To, ", "), msg.Subject)
+
+	return nil
+}
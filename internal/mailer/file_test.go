@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailerPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+
+	m, err := NewFileMailer(dir, "My Server <no-reply@localhost>")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send(Message{To: []string{"user01@example.com"}, Subject: "Verify", Text: "http://localhost:3000/verify?token=abc"}))
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	info, err = files[0].Info()
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestNewRequiresDriver(t *testing.T) {
+	_, err := New(Config{From: "My Server <no-reply@localhost>"})
+	assert.Error(t, err)
+}
+
+func TestLogMailerLeavesOutBody(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	m := NewLogMailer("My Server <no-reply@localhost>")
+	require.NoError(t, m.Send(Message{To: []string{"user01@example.com"}, Subject: "Verify", Text: "http://localhost:3000/verify?token=abc"}))
+
+	assert.Contains(t, out.String(), "Verify")
+	assert.NotContains(t, out.String(), "token=abc")
+}
@@ -0,0 +1,73 @@
+// Package mailer sends the emails of the application through pluggable
+// transports.
+package mailer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Message is an email with a plain text body and an optional HTML alternative.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Config holds the settings used to build a Mailer.
+type Config struct {
+	// Driver is one of "smtp", "file" or "log"
+	Driver   string
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// TLS is the TLS mode of the SMTP connections, TLSStartTLS or TLSNone
+	TLS string
+	// Dir is where the file driver writes messages
+	Dir string
+}
+
+// New builds the Mailer selected by cfg.Driver.
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.From, cfg.TLS)
+	case "file":
+		return NewFileMailer(cfg.Dir, cfg.From)
+	case "log":
+		return NewLogMailer(cfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// validate checks msg has what every transport needs.
+func validate(msg Message) error {
+	if msg.From == "" {
+		return errors.New("mailer: missing sender")
+	}
+	if len(msg.To) == 0 {
+		return errors.New("mailer: missing recipient")
+	}
+	if msg.Text == "" && msg.HTML == "" {
+		return errors.New("mailer: empty body")
+	}
+	return nil
+}
+
+// withDefaults fills the sender of msg when it was not set.
+func withDefaults(msg Message, from string) Message {
+	if msg.From == "" {
+		msg.From = from
+	}
+	return msg
+}
@@ -0,0 +1,80 @@
+// Package mailertest provides a Mailer that records messages so tests can
+// assert on what would have been sent.
+package mailertest
+
+import (
+	"sync"
+
+	"github.com/opaulochaves/myserver/internal/mailer"
+)
+
+// Recorder is a mailer.Mailer keeping every message in memory.
+type Recorder struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+	// Err, when set, is returned by Send and the message is not recorded
+	Err error
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Send implements mailer.Mailer
+func (r *Recorder) Send(msg mailer.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Err != nil {
+		return r.Err
+	}
+
+	r.messages = append(r.messages, msg)
+
+	return nil
+}
+
+// Messages returns a copy of the recorded messages in the order they were sent.
+func (r *Recorder) Messages() []mailer.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]mailer.Message(nil), r.messages...)
+}
+
+// Last returns the last recorded message and false when nothing was sent.
+func (r *Recorder) Last() (mailer.Message, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.messages) == 0 {
+		return mailer.Message{}, false
+	}
+
+	return r.messages[len(r.messages)-1], true
+}
+
+// SentTo returns the messages addressed to email.
+func (r *Recorder) SentTo(email string) []mailer.Message {
+	var res []mailer.Message
+
+	for _, msg := range r.Messages() {
+		for _, to := range msg.To {
+			if to == email {
+				res = append(res, msg)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// Reset forgets the recorded messages.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
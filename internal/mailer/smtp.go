@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const smtpTimeout = 10 * time.Second
+
+// The TLS modes of the SMTP connections.
+const (
+	// TLSStartTLS upgrades the connection with STARTTLS, servers not
+	// offering it are refused
+	TLSStartTLS = "starttls"
+	// TLSNone keeps the connection in plain text, for local SMTP sinks like
+	// MailHog or Mailpit
+	TLSNone = "none"
+)
+
+type smtpMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	// tlsConfig is used to upgrade the connection with STARTTLS, it is nil
+	// when the connection stays in plain text
+	tlsConfig *tls.Config
+}
+
+// NewSMTPMailer returns a Mailer delivering through an SMTP server. With
+// TLSStartTLS the connection is upgraded with STARTTLS before
+// authenticating, servers not offering it are refused. With TLSNone it
+// stays in plain text, the credentials are then only sent to a server on
+// localhost.
+func NewSMTPMailer(host string, port int, username, password, from string, tlsMode string) (Mailer, error) {
+	m := &smtpMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+
+	switch tlsMode {
+	case TLSStartTLS, "":
+		m.tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	case TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown SMTP TLS mode %q", tlsMode)
+	}
+
+	return m, nil
+}
+
+// Send implements Mailer
+func (m *smtpMailer) Send(msg Message) error {
+	msg = withDefaults(msg, m.from)
+	if err := validate(msg); err != nil {
+		return err
+	}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+
+	conn, err := net.DialTimeout("tcp", addr, smtpTimeout)
+	if err != nil {
+		return fmt.Errorf("mailer: dial %s: %w", addr, err)
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mailer: %w", err)
+	}
+	defer c.Close()
+
+	if m.tlsConfig != nil {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("mailer: server does not support STARTTLS")
+		}
+
+		if err := c.StartTLS(m.tlsConfig); err != nil {
+			return fmt.Errorf("mailer: starttls: %w", err)
+		}
+	}
+
+	if m.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("mailer: auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(address(msg.From)); err != nil {
+		return fmt.Errorf("mailer: mail from: %w", err)
+	}
+
+	for _, to := range msg.To {
+		if err := c.Rcpt(address(to)); err != nil {
+			return fmt.Errorf("mailer: rcpt to: %w", err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("mailer: data: %w", err)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("mailer: write: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mailer: data: %w", err)
+	}
+
+	return c.Quit()
+}
+
+// address returns the bare address of a "Name <addr>" string.
+func address(s string) string {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return s
+	}
+	return a.Address
+}
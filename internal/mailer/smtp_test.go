@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpSink is a plain text SMTP server like MailHog or Mailpit, it does not
+// offer STARTTLS and records the data of the messages.
+func smtpSink(t *testing.T) (net.Listener, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		write("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				write("250-localhost")
+				write("250 8BITMIME")
+			case cmd == "DATA":
+				write("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				write("250 queued")
+			case cmd == "QUIT":
+				write("221 bye")
+				return
+			default:
+				write("250 ok")
+			}
+		}
+	}()
+
+	return l, received
+}
+
+func TestSMTPWithoutTLS(t *testing.T) {
+	l, received := smtpSink(t)
+	addr := l.Addr().(*net.TCPAddr)
+
+	m, err := New(Config{Driver: "smtp", From: "no-reply@localhost", Host: "127.0.0.1", Port: addr.Port, TLS: TLSNone})
+	require.NoError(t, err)
+
+	require.NoError(t, m.Send(Message{To: []string{"user01@example.com"}, Subject: "Verify", Text: "hello"}))
+	assert.Contains(t, <-received, "Subject: Verify")
+}
+
+func TestSMTPRequiresStartTLS(t *testing.T) {
+	l, _ := smtpSink(t)
+	addr := l.Addr().(*net.TCPAddr)
+
+	m, err := New(Config{Driver: "smtp", From: "no-reply@localhost", Host: "127.0.0.1", Port: addr.Port, TLS: TLSStartTLS})
+	require.NoError(t, err)
+
+	err = m.Send(Message{To: []string{"user01@example.com"}, Subject: "Verify", Text: "hello"})
+	assert.ErrorContains(t, err, "STARTTLS")
+}
+
+func TestNewRefusesTLSMode(t *testing.T) {
+	_, err := New(Config{Driver: "smtp", From: "no-reply@localhost", Host: "localhost", Port: 25, TLS: "ssl"})
+	assert.Error(t, err)
+}
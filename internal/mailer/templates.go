@@ -0,0 +1,133 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+const layoutTemplate = "templates/layout.html"
+
+// Templates renders the emails found in the templates directory.
+//
+// Every email has a "<name>.txt" file defining a "subject" block and the
+// plain text body, and optionally a "<name>.html" file defining a "content"
+// block that is wrapped in layout.html. The HTML version is rendered with
+// html/template so the data is escaped; the text version is rendered with
+// text/template as escaping HTML entities would corrupt it (eg, links).
+type Templates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// NewTemplates parses the embedded templates.
+func NewTemplates() (*Templates, error) {
+	return ParseTemplates(templateFS)
+}
+
+// ParseTemplates parses the templates found under the "templates" directory of fsys.
+func ParseTemplates(fsys fs.FS) (*Templates, error) {
+	t := &Templates{
+		text: map[string]*texttemplate.Template{},
+		html: map[string]*htmltemplate.Template{},
+	}
+
+	layout, err := htmltemplate.ParseFS(fsys, layoutTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: %w", err)
+	}
+
+	texts, err := fs.Glob(fsys, "templates/*.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range texts {
+		name := strings.TrimSuffix(path.Base(file), ".txt")
+
+		tmpl, err := texttemplate.ParseFS(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("mailer: %w", err)
+		}
+
+		if tmpl.Lookup("subject") == nil {
+			return nil, fmt.Errorf("mailer: template %s has no subject", file)
+		}
+
+		t.text[name] = tmpl
+	}
+
+	htmls, err := fs.Glob(fsys, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range htmls {
+		if file == layoutTemplate {
+			continue
+		}
+
+		name := strings.TrimSuffix(path.Base(file), ".html")
+		if _, ok := t.text[name]; !ok {
+			return nil, fmt.Errorf("mailer: template %s has no text version", file)
+		}
+
+		base, err := layout.Clone()
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := base.ParseFS(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("mailer: %w", err)
+		}
+
+		t.html[name] = tmpl
+	}
+
+	return t, nil
+}
+
+// Render builds the message named name for the recipients in to.
+func (t *Templates) Render(name string, to []string, data interface{}) (Message, error) {
+	text, ok := t.text[name]
+	if !ok {
+		return Message{}, fmt.Errorf("mailer: unknown template %q", name)
+	}
+
+	var subject, body bytes.Buffer
+
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("mailer: %w", err)
+	}
+
+	if err := text.Execute(&body, data); err != nil {
+		return Message{}, fmt.Errorf("mailer: %w", err)
+	}
+
+	msg := Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+	}
+
+	if html, ok := t.html[name]; ok {
+		var buf bytes.Buffer
+
+		if err := html.ExecuteTemplate(&buf, "layout", data); err != nil {
+			return Message{}, fmt.Errorf("mailer: %w", err)
+		}
+
+		msg.HTML = buf.String()
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplatesRender(t *testing.T) {
+	templates, err := NewTemplates()
+	require.NoError(t, err)
+
+	msg, err := templates.Render("verification", []string{"user01@example.com"}, map[string]interface{}{
+		"Name": "<b>User</b>",
+		"Link": "http://localhost:3000/verify?token=a&b=c",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"user01@example.com"}, msg.To)
+	assert.Equal(t, "Confirm your email address", msg.Subject)
+
+	// text is not escaped, html is
+	assert.Contains(t, msg.Text, "Hi <b>User</b>,")
+	assert.Contains(t, msg.Text, "http://localhost:3000/verify?token=a&b=c")
+	assert.Contains(t, msg.HTML, "Hi &lt;b&gt;User&lt;/b&gt;,")
+	assert.Contains(t, msg.HTML, `href="http://localhost:3000/verify?token=a&amp;b=c"`)
+	assert.True(t, strings.HasPrefix(msg.HTML, "<!DOCTYPE html>"))
+
+	_, err = templates.Render("unknown", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		From:    "My Server <no-reply@localhost>",
+		To:      []string{"user01@example.com"},
+		Subject: "Hello\r\nBcc: someone@example.com",
+		Text:    "text body",
+		HTML:    "<p>html body</p>",
+	}
+
+	b, err := msg.Bytes()
+	require.NoError(t, err)
+
+	raw := string(b)
+	assert.Contains(t, raw, "From: \"My Server\" <no-reply@localhost>\r\n")
+	assert.Contains(t, raw, "To: <user01@example.com>\r\n")
+	assert.NotContains(t, raw, "\r\nBcc:")
+	assert.Contains(t, raw, "multipart/alternative")
+	assert.Contains(t, raw, "text body")
+	assert.Contains(t, raw, "<p>html body</p>")
+
+	_, err = Message{From: "not an address", To: []string{"user01@example.com"}, Text: "x"}.Bytes()
+	assert.Error(t, err)
+}
@@ -0,0 +1,961 @@
+package note
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/pkg/etag"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+)
+
+// RegisterHandlers returns the router of the notes API. Users only see
+// their own notes.
+func RegisterHandlers(service Service, authService auth.Service) *chi.Mux {
+	res := resource{service}
+	r := chi.NewRouter()
+
+	r.Use(auth.Authenticate(authService))
+	r.Use(auth.RequireVerified(authService))
+
+	r.Get("/", res.list)         // GET /notes?tags=a,b&match=any|all - read a list of notes, with any or all of the tags
+	r.Post("/", res.create)      // POST /notes - create a new note
+	r.Get("/search", res.search) // GET /notes/search?q= - search the notes, best matches first
+	r.Get("/shared", res.shared) // GET /notes/shared - read the notes other users shared with me
+
+	r.Get("/export", res.exportNotes)  // GET /notes/export - download my notes as a ZIP archive of Markdown files
+	r.Post("/import", res.importNotes) // POST /notes/import - create notes from a ZIP archive of Markdown files, the body or the multipart/form-data "file" field
+
+	r.Get("/trash", res.trash)                     // GET /notes/trash - read the deleted notes, the last deleted first
+	r.Delete("/trash", res.emptyTrash)             // DELETE /notes/trash - delete the notes of the trash permanently
+	r.Post("/trash/{id}/restore", res.restoreNote) // POST /notes/trash/{id}/restore - move a note out of the trash
+	r.Delete("/trash/{id}", res.purgeNote)         // DELETE /notes/trash/{id} - delete a note of the trash permanently
+
+	r.Get("/tags", res.tags)                    // GET /notes/tags - read the tags and their number of notes
+	r.Patch("/tags/{tagID}", res.renameTag)     // PATCH /notes/tags/{tagID} - rename a tag
+	r.Post("/tags/{tagID}/merge", res.mergeTag) // POST /notes/tags/{tagID}/merge - move the notes of a tag to another one
+	r.Delete("/tags/{tagID}", res.deleteTag)    // DELETE /notes/tags/{tagID} - delete a tag, its notes are kept
+
+	r.Get("/settings", res.settings)       // GET /notes/settings - read the note settings, the revision limit included
+	r.Get("/usage", res.usage)             // GET /notes/usage - read the size of my attachments and my quota
+	r.Put("/settings", res.updateSettings) // PUT /notes/settings - change the note settings
+
+	r.Route("/{id}", func(r chi.Router) {
+		r.Use(res.noteContext)
+		r.Get("/", res.get)                      // GET /notes/{id}?render=html - read a single note and its ETag, with its sanitised HTML
+		r.Put("/", res.replace)                  // PUT /notes/{id} - replace a note, requires If-Match
+		r.Patch("/", res.update)                 // PATCH /notes/{id} - update some fields of a note, requires If-Match
+		r.Delete("/", res.delete)                // DELETE /notes/{id} - move a note to the trash, requires If-Match
+		r.Post("/tags", res.addTags)             // POST /notes/{id}/tags - add tags to a note, new tags are created
+		r.Delete("/tags/{tagID}", res.removeTag) // DELETE /notes/{id}/tags/{tagID} - remove a tag from a note
+
+		r.Get("/revisions", res.revisions)                             // GET /notes/{id}/revisions - read the revisions of a note, newest first
+		r.Get("/revisions/diff", res.diff)                             // GET /notes/{id}/revisions/diff?from=&to= - compare two revisions
+		r.Get("/revisions/{revisionID}", res.revision)                 // GET /notes/{id}/revisions/{revisionID} - read a single revision
+		r.Post("/revisions/{revisionID}/restore", res.restoreRevision) // POST /notes/{id}/revisions/{revisionID}/restore - save a revision as the new version, requires If-Match
+
+		r.Get("/shares", res.shares)                // GET /notes/{id}/shares - read the users a note is shared with
+		r.Post("/shares", res.share)                // POST /notes/{id}/shares - share a note with a user, or change their permission
+		r.Delete("/shares/{shareID}", res.unshare)  // DELETE /notes/{id}/shares/{shareID} - revoke a share, or leave a note shared with me
+		r.Get("/links", res.links)                  // GET /notes/{id}/links - read the public links to a note
+		r.Post("/links", res.createLink)            // POST /notes/{id}/links - create a public link, its token is only returned once
+		r.Delete("/links/{linkID}", res.deleteLink) // DELETE /notes/{id}/links/{linkID} - revoke a public link
+
+		r.Get("/attachments", res.attachments)                               // GET /notes/{id}/attachments - read the files attached to a note
+		r.Post("/attachments", res.attach)                                   // POST /notes/{id}/attachments - attach a file, the multipart/form-data "file" field
+		r.Get("/attachments/{attachmentID}", res.attachment)                 // GET /notes/{id}/attachments/{attachmentID} - read a single attachment
+		r.Get("/attachments/{attachmentID}/content", res.downloadAttachment) // GET /notes/{id}/attachments/{attachmentID}/content - download an attachment, with Range support
+		r.Delete("/attachments/{attachmentID}", res.deleteAttachment)        // DELETE /notes/{id}/attachments/{attachmentID} - delete an attachment
+	})
+
+	return r
+}
+
+// LinkPasswordHeader carries the password of a protected public link.
+const LinkPasswordHeader = "X-Link-Password"
+
+// RegisterPublicHandlers returns the router of the public links, it does
+// not require an authenticated user.
+func RegisterPublicHandlers(service Service) *chi.Mux {
+	res := resource{service}
+	r := chi.NewRouter()
+
+	r.Get("/{token}", res.readLink) // GET /public/notes/{token}?render=html - read the note of a public link, with X-Link-Password when it is protected
+
+	return r
+}
+
+type resource struct {
+	service Service
+}
+
+type noteKey struct{}
+
+func (c resource) list(w http.ResponseWriter, r *http.Request) {
+	userID := auth.CurrentUser(r.Context()).ID
+
+	filter, err := filterFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	count, err := c.service.Count(userID, filter)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	pages := pagination.NewFromRequest(r, count)
+	notes, err := c.service.Query(userID, filter, pages.Offset(), pages.Limit())
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	pages.Items = notes
+
+	if err := render.Render(w, r, pages); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) search(w http.ResponseWriter, r *http.Request) {
+	userID := auth.CurrentUser(r.Context()).ID
+	input := SearchRequest{Query: r.URL.Query().Get("q")}
+
+	count, err := c.service.CountMatches(userID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	pages := pagination.NewFromRequest(r, count)
+	matches, err := c.service.Search(userID, input, pages.Offset(), pages.Limit())
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	pages.Items = matches
+
+	if err := render.Render(w, r, pages); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) create(w http.ResponseWriter, r *http.Request) {
+	input := CreateNoteRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	note, err := c.service.Create(r.Context(), auth.CurrentUser(r.Context()).ID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, note.Version)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &NoteResponse{Note: note})
+}
+
+func (c resource) get(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	html, err := renderFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if etag.NotModifiedVariant(w, r, note.Version, renderVariant(html)) {
+		return
+	}
+
+	response := &NoteResponse{Note: note}
+
+	if html {
+		rendered, err := c.service.Render(note.Note)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrInternalError(err))
+			return
+		}
+		response.Rendered = &rendered
+	}
+
+	if err := render.Render(w, r, response); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) replace(w http.ResponseWriter, r *http.Request) {
+	input := CreateNoteRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	c.save(w, r, UpdateNoteRequest{Title: &input.Title, Content: &input.Content, Attrs: &input.Attrs})
+}
+
+func (c resource) update(w http.ResponseWriter, r *http.Request) {
+	input := UpdateNoteRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	c.save(w, r, input)
+}
+
+func (c resource) save(w http.ResponseWriter, r *http.Request, input UpdateNoteRequest) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	updated, err := c.service.Update(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, input, version)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, updated.Version)
+	render.Render(w, r, &NoteResponse{Note: updated})
+}
+
+func (c resource) delete(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	if err := c.service.Delete(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, version); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) tags(w http.ResponseWriter, r *http.Request) {
+	tags, err := c.service.Tags(auth.CurrentUser(r.Context()).ID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, tag := range tags {
+		list = append(list, &TagCountResponse{tag})
+	}
+
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) renameTag(w http.ResponseWriter, r *http.Request) {
+	tagID, err := strconv.ParseInt(chi.URLParam(r, "tagID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	input := RenameTagRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	tag, err := c.service.RenameTag(r.Context(), auth.CurrentUser(r.Context()).ID, tagID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &TagResponse{tag})
+}
+
+func (c resource) mergeTag(w http.ResponseWriter, r *http.Request) {
+	tagID, err := strconv.ParseInt(chi.URLParam(r, "tagID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	input := MergeTagRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	tag, err := c.service.MergeTag(r.Context(), auth.CurrentUser(r.Context()).ID, tagID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &TagResponse{tag})
+}
+
+func (c resource) deleteTag(w http.ResponseWriter, r *http.Request) {
+	tagID, err := strconv.ParseInt(chi.URLParam(r, "tagID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.DeleteTag(r.Context(), auth.CurrentUser(r.Context()).ID, tagID); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) addTags(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+	input := TagNoteRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	updated, err := c.service.AddTags(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, updated.Version)
+	render.Render(w, r, &NoteResponse{Note: updated})
+}
+
+func (c resource) removeTag(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	tagID, err := strconv.ParseInt(chi.URLParam(r, "tagID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	updated, err := c.service.RemoveTag(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, tagID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, updated.Version)
+	render.Render(w, r, &NoteResponse{Note: updated})
+}
+
+func (c resource) revisions(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	page, err := pagination.NewCursorFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.Revisions(auth.CurrentUser(r.Context()).ID, note.ID, page); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, page)
+}
+
+func (c resource) revision(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	revisionID, err := strconv.ParseInt(chi.URLParam(r, "revisionID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	revision, err := c.service.Revision(auth.CurrentUser(r.Context()).ID, note.ID, revisionID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &RevisionResponse{revision})
+}
+
+func (c resource) diff(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(errors.New("from must be the ID of a revision")))
+		return
+	}
+
+	to, err := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(errors.New("to must be the ID of a revision")))
+		return
+	}
+
+	result, err := c.service.Diff(auth.CurrentUser(r.Context()).ID, note.ID, from, to)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &result)
+}
+
+func (c resource) restoreRevision(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	revisionID, err := strconv.ParseInt(chi.URLParam(r, "revisionID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	updated, err := c.service.RestoreRevision(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, revisionID, version)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, updated.Version)
+	render.Render(w, r, &NoteResponse{Note: updated})
+}
+
+func (c resource) settings(w http.ResponseWriter, r *http.Request) {
+	settings, err := c.service.Settings(auth.CurrentUser(r.Context()).ID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	render.Render(w, r, &settings)
+}
+
+func (c resource) updateSettings(w http.ResponseWriter, r *http.Request) {
+	input := SettingsRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	settings, err := c.service.UpdateSettings(r.Context(), auth.CurrentUser(r.Context()).ID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &settings)
+}
+
+func (c resource) shared(w http.ResponseWriter, r *http.Request) {
+	userID := auth.CurrentUser(r.Context()).ID
+
+	count, err := c.service.CountSharedWithMe(userID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	pages := pagination.NewFromRequest(r, count)
+	notes, err := c.service.SharedWithMe(userID, pages.Offset(), pages.Limit())
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	pages.Items = notes
+
+	if err := render.Render(w, r, pages); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+// exportNotes streams the archive of the notes, an error once it started
+// can only cut it short.
+func (c resource) exportNotes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="notes-%s.zip"`, time.Now().Format("20060102")))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := c.service.Export(r.Context(), auth.CurrentUser(r.Context()).ID, w); err != nil {
+		log.Printf("note: export error: %v", err)
+	}
+}
+
+// importNotes imports the archive sent as the body, or as the "file" part of
+// a multipart/form-data body.
+func (c resource) importNotes(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+		part, err := filePart(r)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrInvalidRequest(err))
+			return
+		}
+		defer part.Close()
+
+		body = part
+	}
+
+	result, err := c.service.Import(r.Context(), auth.CurrentUser(r.Context()).ID, body)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &result)
+}
+
+func (c resource) shares(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	shares, err := c.service.Shares(auth.CurrentUser(r.Context()).ID, note.ID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, share := range shares {
+		list = append(list, &ShareResponse{share})
+	}
+
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) share(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+	input := ShareRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	share, err := c.service.Share(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &ShareResponse{share})
+}
+
+func (c resource) unshare(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	shareID, err := strconv.ParseInt(chi.URLParam(r, "shareID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.Unshare(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, shareID); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) links(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	links, err := c.service.Links(auth.CurrentUser(r.Context()).ID, note.ID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, link := range links {
+		list = append(list, &LinkResponse{NoteLink: link, Protected: link.HasPassword()})
+	}
+
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) createLink(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+	input := LinkRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	link, err := c.service.CreateLink(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &link)
+}
+
+func (c resource) deleteLink(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	linkID, err := strconv.ParseInt(chi.URLParam(r, "linkID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.DeleteLink(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, linkID); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) readLink(w http.ResponseWriter, r *http.Request) {
+	html, err := renderFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	note, err := c.service.ReadLink(chi.URLParam(r, "token"), r.Header.Get(LinkPasswordHeader), auth.ClientFromRequest(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	// the response depends on the password header
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Add("Vary", LinkPasswordHeader)
+
+	if etag.NotModifiedVariant(w, r, note.Version, renderVariant(html)) {
+		return
+	}
+
+	if html {
+		rendered, err := c.service.Render(note.note)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrInternalError(err))
+			return
+		}
+		note.Rendered = &rendered
+	}
+
+	render.Render(w, r, &note)
+}
+
+func (c resource) trash(w http.ResponseWriter, r *http.Request) {
+	userID := auth.CurrentUser(r.Context()).ID
+
+	count, err := c.service.CountTrash(userID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	pages := pagination.NewFromRequest(r, count)
+	notes, err := c.service.Trash(userID, pages.Offset(), pages.Limit())
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	pages.Items = notes
+
+	if err := render.Render(w, r, pages); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) restoreNote(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	note, err := c.service.RestoreNote(r.Context(), auth.CurrentUser(r.Context()).ID, id)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, note.Version)
+	render.Render(w, r, &NoteResponse{Note: note})
+}
+
+func (c resource) purgeNote(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.PurgeNote(r.Context(), auth.CurrentUser(r.Context()).ID, id); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) emptyTrash(w http.ResponseWriter, r *http.Request) {
+	if _, err := c.service.EmptyTrash(r.Context(), auth.CurrentUser(r.Context()).ID); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) usage(w http.ResponseWriter, r *http.Request) {
+	usage, err := c.service.Usage(auth.CurrentUser(r.Context()).ID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	render.Render(w, r, &usage)
+}
+
+func (c resource) attachments(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	attachments, err := c.service.Attachments(auth.CurrentUser(r.Context()).ID, note.ID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, attachment := range attachments {
+		list = append(list, &AttachmentResponse{attachment})
+	}
+
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+// attach streams the "file" part of a multipart/form-data body to the
+// service.
+func (c resource) attach(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+		render.Render(w, r, apperrors.ErrFromError(apperrors.NewUnsupportedMediaType("Attachments are uploaded as multipart/form-data")))
+		return
+	}
+
+	part, err := filePart(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+	defer part.Close()
+
+	attachment, err := c.service.Attach(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, part.FileName(), part)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &AttachmentResponse{attachment})
+}
+
+// filePart returns the "file" part of a multipart/form-data body, the parts
+// before it are skipped.
+func filePart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New(`the "file" field is missing`)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "file" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+func (c resource) attachment(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	attachment, err := c.service.Attachment(auth.CurrentUser(r.Context()).ID, note.ID, attachmentID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &AttachmentResponse{attachment})
+}
+
+// downloadAttachment serves the content of an attachment with its sniffed
+// content type. Only the types in inlineTypes are displayed by the browsers,
+// the others are downloaded.
+func (c resource) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	attachment, object, err := c.service.OpenAttachment(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, attachmentID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+	defer object.Close()
+
+	disposition := "attachment"
+	if inlineTypes[attachment.ContentType] {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, attachment.Checksum))
+
+	http.ServeContent(w, r, "", attachment.CreatedAt.Time, object)
+}
+
+func (c resource) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	note := r.Context().Value(noteKey{}).(Note)
+
+	attachmentID, err := strconv.ParseInt(chi.URLParam(r, "attachmentID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.DeleteAttachment(r.Context(), auth.CurrentUser(r.Context()).ID, note.ID, attachmentID); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// filterFromRequest reads the tags (comma separated names) and match ("any",
+// the default, or "all") query parameters.
+func filterFromRequest(r *http.Request) (TagFilter, error) {
+	q := r.URL.Query()
+
+	filter := TagFilter{}
+
+	if v := q.Get("tags"); v != "" {
+		filter.Tags = cleanTags(strings.Split(v, ","))
+	}
+
+	switch q.Get("match") {
+	case "", "any":
+	case "all":
+		filter.All = true
+	default:
+		return filter, errors.New(`match must be "any" or "all"`)
+	}
+
+	return filter, nil
+}
+
+// noteContext loads the note of the {id} URL parameter, it must belong to
+// the authenticated user.
+func (c resource) noteContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noteID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrInvalidRequest(err))
+			return
+		}
+
+		note, err := c.service.Get(auth.CurrentUser(r.Context()).ID, noteID)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrFromError(err))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), noteKey{}, note)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
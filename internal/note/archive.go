@@ -0,0 +1,488 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultImportMaxSize is the largest archive imported when the server
+	// does not set one, 50 MiB
+	DefaultImportMaxSize = 50 << 20
+	// MaxImportFiles is the number of files of an imported archive
+	MaxImportFiles = 1000
+	// maxImportFileSize is the largest Markdown file of an imported archive,
+	// uncompressed
+	maxImportFileSize = 1 << 20
+	// importBatchSize is the number of notes inserted by statement
+	importBatchSize = 100
+	// exportBatchSize is the number of notes read by query
+	exportBatchSize = 200
+	// frontMatterDelimiter opens and closes the front matter of a file
+	frontMatterDelimiter = "---"
+)
+
+// frontMatter is the YAML header of the Markdown files of the archives.
+type frontMatter struct {
+	Title     string    `yaml:"title"`
+	Tags      []string  `yaml:"tags,omitempty"`
+	Color     string    `yaml:"color,omitempty"`
+	Icon      string    `yaml:"icon,omitempty"`
+	Format    string    `yaml:"format,omitempty"`
+	CreatedAt time.Time `yaml:"created_at,omitempty"`
+	UpdatedAt time.Time `yaml:"updated_at,omitempty"`
+}
+
+// ImportResult reports an import: the notes created and the files refused,
+// with the reason.
+type ImportResult struct {
+	Imported []ImportedNote `json:"imported"`
+	Errors   []ImportError  `json:"errors"`
+}
+
+// Render implements render.Renderer
+func (*ImportResult) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// ImportedNote is a note created from a file of an archive.
+type ImportedNote struct {
+	File  string `json:"file"`
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+// ImportError is a file of an archive which was not imported.
+type ImportError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// importedFile is a file of an archive parsed into a note.
+type importedFile struct {
+	name string
+	note entity.Note
+	tags []string
+}
+
+// marshalNote returns the Markdown file of a note, its content after its
+// front matter.
+func marshalNote(note *entity.Note) ([]byte, error) {
+	header := frontMatter{
+		Title:  note.Title,
+		Color:  note.Attrs.Color,
+		Icon:   note.Attrs.Icon,
+		Format: note.Attrs.Format,
+	}
+	// the files without a format are read as Markdown
+	if header.Format == "" {
+		header.Format = entity.FormatPlain
+	}
+	for _, tag := range note.Tags {
+		header.Tags = append(header.Tags, tag.Name)
+	}
+	if note.CreatedAt.Valid {
+		header.CreatedAt = note.CreatedAt.Time.UTC()
+	}
+	if note.UpdatedAt.Valid {
+		header.UpdatedAt = note.UpdatedAt.Time.UTC()
+	}
+
+	b, err := yaml.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(frontMatterDelimiter + "\n")
+	buf.Write(b)
+	buf.WriteString(frontMatterDelimiter + "\n")
+	buf.WriteString(note.Content)
+
+	return buf.Bytes(), nil
+}
+
+// splitFrontMatter returns the front matter and the content of a file, ok
+// is false when it does not start with a front matter.
+func splitFrontMatter(s string) (header string, content string, ok bool) {
+	first, rest, found := strings.Cut(s, "\n")
+	if !found || strings.TrimRight(first, "\r") != frontMatterDelimiter {
+		return "", s, false
+	}
+
+	for offset := 0; ; {
+		line, _, found := strings.Cut(rest[offset:], "\n")
+
+		if strings.TrimRight(line, "\r") == frontMatterDelimiter {
+			end := offset + len(line)
+			if found {
+				end++
+			}
+			return rest[:offset], rest[end:], true
+		}
+
+		if !found {
+			return "", s, false
+		}
+		offset += len(line) + 1
+	}
+}
+
+// parseNote parses a Markdown file of an archive. The title is the one of
+// the front matter, the first heading or the name of the file, in this
+// order.
+func parseNote(name string, data []byte) (importedFile, error) {
+	if !utf8.Valid(data) {
+		return importedFile{}, fmt.Errorf("the file is not valid UTF-8")
+	}
+
+	var header frontMatter
+
+	raw, content, ok := splitFrontMatter(strings.TrimPrefix(string(data), "\ufeff"))
+	if ok {
+		if err := yaml.Unmarshal([]byte(raw), &header); err != nil {
+			return importedFile{}, fmt.Errorf("invalid front matter: %v", err)
+		}
+	}
+
+	title := strings.TrimSpace(header.Title)
+	if title == "" {
+		if first, _, _ := strings.Cut(content, "\n"); strings.HasPrefix(first, "# ") {
+			title = strings.TrimSpace(strings.TrimPrefix(first, "# "))
+		}
+	}
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+
+	format := header.Format
+	if format == "" {
+		format = entity.FormatMarkdown
+	}
+
+	input := CreateNoteRequest{
+		Title:   title,
+		Content: content,
+		Attrs:   entity.NoteAttrs{Color: header.Color, Icon: header.Icon, Format: format},
+	}
+	if err := input.Validate(); err != nil {
+		return importedFile{}, err
+	}
+
+	tags := cleanTags(header.Tags)
+	if err := validation.Validate(tags, validation.Length(0, MaxTagsPerRequest), validation.Each(tagName...)); err != nil {
+		return importedFile{}, fmt.Errorf("tags: %v", err)
+	}
+
+	file := importedFile{
+		name: name,
+		note: entity.Note{Title: input.Title, Content: input.Content, Attrs: input.Attrs},
+		tags: tags,
+	}
+
+	if !header.CreatedAt.IsZero() {
+		file.note.CreatedAt.Time, file.note.CreatedAt.Valid = header.CreatedAt, true
+	}
+	if !header.UpdatedAt.IsZero() {
+		file.note.UpdatedAt.Time, file.note.UpdatedAt.Valid = header.UpdatedAt, true
+	}
+
+	return file, nil
+}
+
+// errImportTooLarge is returned by readNote once the files read exceed the
+// budget of the import.
+var errImportTooLarge = errors.New("the files of the archive are too large")
+
+// readNote reads and parses a file of an archive. The bytes read are taken
+// from budget, the uncompressed size left to the import, a file exceeding
+// it fails with errImportTooLarge.
+func readNote(f *zip.File, budget *int64) (importedFile, error) {
+	if ext := strings.ToLower(path.Ext(f.Name)); ext != ".md" && ext != ".markdown" {
+		return importedFile{}, fmt.Errorf("not a Markdown file")
+	}
+
+	if f.UncompressedSize64 > maxImportFileSize {
+		return importedFile{}, fmt.Errorf("the file is larger than %d bytes", maxImportFileSize)
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return importedFile{}, err
+	}
+	defer r.Close()
+
+	limit := int64(maxImportFileSize)
+	if *budget < limit {
+		limit = *budget
+	}
+
+	// the declared size is not trusted
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return importedFile{}, err
+	}
+	if int64(len(data)) > *budget {
+		return importedFile{}, errImportTooLarge
+	}
+	*budget -= int64(len(data))
+
+	if len(data) > maxImportFileSize {
+		return importedFile{}, fmt.Errorf("the file is larger than %d bytes", maxImportFileSize)
+	}
+
+	return parseNote(f.Name, data)
+}
+
+// skipped reports whether a file of an archive is left out of an import
+// silently, like the metadata added by the archivers.
+func skipped(f *zip.File) bool {
+	return f.FileInfo().IsDir() || strings.HasPrefix(path.Base(f.Name), ".") || strings.HasPrefix(f.Name, "__MACOSX/")
+}
+
+// exportName returns the name of the file of a note in an archive, made of
+// its title and unique in the archive.
+func exportName(title string, used map[string]bool) string {
+	var sb strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			dash = false
+		} else if !dash && sb.Len() > 0 {
+			sb.WriteByte('-')
+			dash = true
+		}
+		if sb.Len() >= 80 {
+			break
+		}
+	}
+
+	base := strings.TrimRight(sb.String(), "-")
+	if base == "" {
+		base = "note"
+	}
+
+	name := base + ".md"
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%d.md", base, i)
+	}
+	used[name] = true
+
+	return name
+}
+
+// Export implements Service
+func (s service) Export(ctx context.Context, userID int64, w io.Writer) error {
+	archive := zip.NewWriter(w)
+	used := map[string]bool{}
+
+	for after := int64(0); ; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		notes, err := s.repo.GetNotesAfter(userID, after, exportBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range notes {
+			note := &notes[i]
+
+			data, err := marshalNote(note)
+			if err != nil {
+				return err
+			}
+
+			header := &zip.FileHeader{Name: exportName(note.Title, used), Method: zip.Deflate}
+			if note.UpdatedAt.Valid {
+				header.Modified = note.UpdatedAt.Time
+			} else {
+				header.Modified = note.CreatedAt.Time
+			}
+
+			f, err := archive.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(data); err != nil {
+				return err
+			}
+
+			after = note.ID
+		}
+
+		if len(notes) < exportBatchSize {
+			break
+		}
+	}
+
+	return archive.Close()
+}
+
+// Import implements Service
+//
+// The files are parsed first, the notes of the valid ones are then created
+// in a single transaction, by batches. An invalid file is reported and left
+// out, it does not fail the import.
+func (s service) Import(ctx context.Context, userID int64, r io.Reader) (ImportResult, error) {
+	upload, err := spool(r, s.opts.ImportMaxSize)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer upload.remove()
+
+	archive, err := zip.NewReader(upload.file, upload.size)
+	if err != nil {
+		return ImportResult{}, apperrors.NewBadRequest("The archive is not a valid ZIP file")
+	}
+
+	if len(archive.File) > MaxImportFiles {
+		return ImportResult{}, apperrors.NewBadRequest(fmt.Sprintf("Archives have up to %d files, this one has %d", MaxImportFiles, len(archive.File)))
+	}
+
+	result := ImportResult{Imported: []ImportedNote{}, Errors: []ImportError{}}
+	files := []importedFile{}
+	names := []string{}
+
+	// the files uncompressed are bound like the archive, a small archive
+	// can expand to much more
+	budget := s.opts.ImportMaxSize
+
+	for _, f := range archive.File {
+		if skipped(f) {
+			continue
+		}
+
+		file, err := readNote(f, &budget)
+		if errors.Is(err, errImportTooLarge) {
+			return ImportResult{}, &apperrors.Error{
+				Type:    apperrors.PayloadTooLarge,
+				Message: fmt.Sprintf("The files of an archive are up to %d bytes uncompressed", s.opts.ImportMaxSize),
+			}
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, ImportError{File: f.Name, Error: err.Error()})
+			continue
+		}
+
+		file.note.UserID = userID
+		file.note.Language = s.opts.Language
+
+		files = append(files, file)
+		names = append(names, file.tags...)
+	}
+
+	if len(files) == 0 {
+		return result, nil
+	}
+
+	imported := []ImportedNote{}
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		tags := map[string]entity.Tag{}
+		if names = cleanTags(names); len(names) > 0 {
+			ensured, err := repo.EnsureTags(userID, names)
+			if err != nil {
+				return err
+			}
+			for _, tag := range ensured {
+				tags[strings.ToLower(tag.Name)] = tag
+			}
+		}
+
+		for start := 0; start < len(files); start += importBatchSize {
+			end := start + importBatchSize
+			if end > len(files) {
+				end = len(files)
+			}
+			batch := files[start:end]
+
+			notes := make([]entity.Note, len(batch))
+			for i, file := range batch {
+				notes[i] = file.note
+			}
+
+			created, err := repo.CreateNotes(notes)
+			if err != nil {
+				return err
+			}
+
+			for i := range created {
+				note := &created[i]
+
+				if err := s.tagImported(repo, note, batch[i].tags, tags); err != nil {
+					return err
+				}
+
+				if _, err := repo.CreateRevision(note, audit.FromContext(ctx).ActorID); err != nil {
+					return err
+				}
+
+				if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteCreated, targetNote, note.ID, note); err != nil {
+					return err
+				}
+
+				event := audit.FromContext(ctx).Event(EventNoteCreated, targetNote, note.ID)
+				event.Changes = audit.Diff(nil, note)
+				event.Details["imported_from"] = batch[i].name
+
+				if err := audit.NewAuditQueries(s.db, tx).Insert(event); err != nil {
+					return err
+				}
+
+				imported = append(imported, ImportedNote{File: batch[i].name, ID: note.ID, Title: note.Title})
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result.Imported = imported
+
+	return result, nil
+}
+
+// tagImported adds the tags named names, found in tags by lower case name,
+// to an imported note.
+func (s service) tagImported(repo NoteQueries, note *entity.Note, names []string, tags map[string]entity.Tag) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(names))
+	for i, name := range names {
+		tag := tags[strings.ToLower(name)]
+		ids[i] = tag.ID
+		note.Tags = append(note.Tags, entity.NoteTag{ID: tag.ID, Name: tag.Name})
+	}
+
+	return repo.TagNote(note.ID, ids)
+}
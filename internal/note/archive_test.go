@@ -0,0 +1,119 @@
+package note
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalNote(t *testing.T) {
+	note := &entity.Note{
+		Title:   "Groceries: week 1",
+		Content: "---\n- milk\n",
+		Attrs:   entity.NoteAttrs{Color: "yellow", Icon: "cart", Format: entity.FormatMarkdown},
+		Tags:    entity.NoteTags{{ID: 1, Name: "home"}, {ID: 2, Name: "todo"}},
+	}
+	note.CreatedAt.Time, note.CreatedAt.Valid = time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC), true
+
+	data, err := marshalNote(note)
+	require.NoError(t, err)
+
+	assert.Equal(t, "---\n"+
+		"title: 'Groceries: week 1'\n"+
+		"tags:\n    - home\n    - todo\n"+
+		"color: yellow\n"+
+		"icon: cart\n"+
+		"format: markdown\n"+
+		"created_at: 2022-05-01T10:00:00Z\n"+
+		"---\n"+
+		"---\n- milk\n", string(data))
+
+	// an exported note is imported back as it was
+	file, err := parseNote("groceries-week-1.md", data)
+	require.NoError(t, err)
+
+	assert.Equal(t, note.Title, file.note.Title)
+	assert.Equal(t, note.Content, file.note.Content)
+	assert.Equal(t, note.Attrs, file.note.Attrs)
+	assert.Equal(t, []string{"home", "todo"}, file.tags)
+	assert.True(t, note.CreatedAt.Time.Equal(file.note.CreatedAt.Time))
+	assert.False(t, file.note.UpdatedAt.Valid)
+}
+
+func TestParseNote(t *testing.T) {
+	// without a front matter, the first heading is the title
+	file, err := parseNote("notes/a.md", []byte("# Plans\r\nfor the week"))
+	require.NoError(t, err)
+	assert.Equal(t, "Plans", file.note.Title)
+	assert.Equal(t, "# Plans\r\nfor the week", file.note.Content)
+	assert.Equal(t, entity.FormatMarkdown, file.note.Attrs.Format)
+
+	// then the name of the file
+	file, err = parseNote("notes/ideas.md", []byte("---\r\ncolor: red\r\n---\r\nsome"))
+	require.NoError(t, err)
+	assert.Equal(t, "ideas", file.note.Title)
+	assert.Equal(t, "red", file.note.Attrs.Color)
+	assert.Equal(t, "some", file.note.Content)
+
+	// an unclosed front matter is content
+	file, err = parseNote("a.md", []byte("---\ntitle: x\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a", file.note.Title)
+	assert.Equal(t, "---\ntitle: x\n", file.note.Content)
+
+	_, err = parseNote("a.md", []byte("---\ntitle: [x\n---\n"))
+	assert.ErrorContains(t, err, "invalid front matter")
+
+	_, err = parseNote("a.md", []byte("---\nformat: rtf\n---\n"))
+	assert.ErrorContains(t, err, "format")
+
+	_, err = parseNote("a.md", []byte("---\ntags: [a, 'b,c']\n---\n"))
+	assert.ErrorContains(t, err, "tags")
+
+	_, err = parseNote("a.md", []byte{0xff, 0xfe})
+	assert.ErrorContains(t, err, "UTF-8")
+}
+
+func TestExportName(t *testing.T) {
+	used := map[string]bool{}
+
+	assert.Equal(t, "groceries-week-1.md", exportName("Groceries: week #1!", used))
+	assert.Equal(t, "groceries-week-1-2.md", exportName("groceries week 1", used))
+	assert.Equal(t, "café.md", exportName("Café", used))
+	assert.Equal(t, "note.md", exportName("???", used))
+	assert.Equal(t, "note-2.md", exportName("", used))
+}
+
+func TestReadNoteBudget(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	content := strings.Repeat("a", 600<<10)
+	for _, name := range []string{"a.md", "b.md"} {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	// the archive is small but its files expand past the budget
+	budget := int64(1 << 20)
+
+	file, err := readNote(r.File[0], &budget)
+	require.NoError(t, err)
+	assert.Equal(t, content, file.note.Content)
+	assert.Equal(t, int64(1<<20-600<<10), budget)
+
+	_, err = readNote(r.File[1], &budget)
+	assert.ErrorIs(t, err, errImportTooLarge)
+}
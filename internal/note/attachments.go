@@ -0,0 +1,413 @@
+package note
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	"github.com/opaulochaves/myserver/internal/storage"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+// Audited events of the attachments, they are also published to the outbox.
+const (
+	EventAttachmentAdded   = "note.attachment_added"
+	EventAttachmentDeleted = "note.attachment_deleted"
+
+	targetAttachment = "note_attachment"
+)
+
+const (
+	// DefaultAttachmentMaxSize is the largest attachment when the server
+	// does not set one, 25 MiB
+	DefaultAttachmentMaxSize = 25 << 20
+	// DefaultAttachmentQuota is the size of the attachments of a user when
+	// the server does not set one, 1 GiB
+	DefaultAttachmentQuota = 1 << 30
+	// attachmentPrefix starts the storage keys of the attachments
+	attachmentPrefix = "attachments/"
+	// sniffLen is the number of bytes content types are sniffed from
+	sniffLen = 512
+)
+
+// inlineTypes are the content types of the attachments displayed by the
+// browsers rather than downloaded. The others, HTML and SVG included, could
+// run scripts in the origin of the server.
+var inlineTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+type AttachmentResponse struct {
+	entity.NoteAttachment
+}
+
+// Render implements render.Renderer
+func (*AttachmentResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// UsageResponse represents the size of the attachments of a user and their
+// quota, in bytes.
+type UsageResponse struct {
+	Used    int64 `json:"used"`
+	Quota   int64 `json:"quota"`
+	MaxSize int64 `json:"max_size"`
+}
+
+// Render implements render.Renderer
+func (*UsageResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// spooled is an upload written to a temporary file, with what is known of
+// its content.
+type spooled struct {
+	file        *os.File
+	size        int64
+	checksum    string
+	contentType string
+}
+
+// remove closes and removes the temporary file.
+func (s spooled) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// spool writes the content of r to a temporary file, up to max bytes. The
+// content type is sniffed from its first bytes.
+func spool(r io.Reader, max int64) (_ spooled, err error) {
+	f, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return spooled{}, err
+	}
+
+	upload := spooled{file: f}
+
+	defer func() {
+		if err != nil {
+			upload.remove()
+		}
+	}()
+
+	hash := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(r, max+1))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return spooled{}, apperrors.NewPayloadTooLarge(max, tooLarge.Limit)
+		}
+		return spooled{}, err
+	}
+	if n > max {
+		return spooled{}, apperrors.NewPayloadTooLarge(max, n)
+	}
+
+	head := make([]byte, sniffLen)
+	read, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return spooled{}, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return spooled{}, err
+	}
+
+	upload.size = n
+	upload.checksum = hex.EncodeToString(hash.Sum(nil))
+	upload.contentType = http.DetectContentType(head[:read])
+
+	return upload, nil
+}
+
+// cleanFilename returns the base name of a file name given by a client,
+// without control characters and up to 255 bytes.
+func cleanFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" || strings.TrimSpace(name) == "" {
+		name = "file"
+	}
+
+	for len(name) > 255 {
+		runes := []rune(name)
+		name = string(runes[:len(runes)-1])
+	}
+
+	return name
+}
+
+// Attachments implements Service
+func (s service) Attachments(userID int64, id int64) ([]entity.NoteAttachment, error) {
+	if _, err := s.Get(userID, id); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetAttachments(id)
+}
+
+// Attachment implements Service
+func (s service) Attachment(userID int64, id int64, attachmentID int64) (entity.NoteAttachment, error) {
+	if _, err := s.Get(userID, id); err != nil {
+		return entity.NoteAttachment{}, err
+	}
+
+	attachment, err := s.repo.GetAttachment(id, attachmentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.NoteAttachment{}, apperrors.NewNotFound("attachment", fmt.Sprint(attachmentID))
+		}
+		return entity.NoteAttachment{}, err
+	}
+
+	return *attachment, nil
+}
+
+// OpenAttachment implements Service
+func (s service) OpenAttachment(ctx context.Context, userID int64, id int64, attachmentID int64) (entity.NoteAttachment, storage.Object, error) {
+	attachment, err := s.Attachment(userID, id, attachmentID)
+	if err != nil {
+		return entity.NoteAttachment{}, nil, err
+	}
+
+	object, err := s.opts.Storage.Open(ctx, attachment.StorageKey)
+	if err != nil {
+		return entity.NoteAttachment{}, nil, err
+	}
+
+	return attachment, object, nil
+}
+
+// Usage implements Service
+func (s service) Usage(userID int64) (UsageResponse, error) {
+	used, err := s.repo.AttachmentUsage(userID)
+	if err != nil {
+		return UsageResponse{}, err
+	}
+
+	return UsageResponse{Used: used, Quota: s.opts.AttachmentQuota, MaxSize: s.opts.AttachmentMaxSize}, nil
+}
+
+// Attach implements Service
+//
+// The file is spooled to a temporary file and stored before its metadata is
+// saved, the blobs of the failed uploads are left to PurgeOrphanedBlobs.
+func (s service) Attach(ctx context.Context, userID int64, id int64, filename string, r io.Reader) (entity.NoteAttachment, error) {
+	note, err := s.authorize(userID, id, entity.PermissionEdit)
+	if err != nil {
+		return entity.NoteAttachment{}, err
+	}
+
+	upload, err := spool(r, s.opts.AttachmentMaxSize)
+	if err != nil {
+		return entity.NoteAttachment{}, err
+	}
+	defer upload.remove()
+
+	// refused before the upload is stored, it is checked again once locked
+	if err := s.checkQuota(s.repo, note.UserID, upload.size); err != nil {
+		return entity.NoteAttachment{}, err
+	}
+
+	token, err := util.RandomToken(16)
+	if err != nil {
+		return entity.NoteAttachment{}, err
+	}
+
+	key := fmt.Sprintf("%s%d/%s", attachmentPrefix, id, token)
+
+	if err := s.opts.Storage.Put(ctx, key, upload.file, upload.size, upload.contentType); err != nil {
+		return entity.NoteAttachment{}, err
+	}
+
+	var attachment *entity.NoteAttachment
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		if err := repo.LockAttachments(note.UserID); err != nil {
+			return err
+		}
+
+		if err := s.checkQuota(repo, note.UserID, upload.size); err != nil {
+			return err
+		}
+
+		var err error
+		attachment, err = repo.CreateAttachment(&entity.NoteAttachment{
+			NoteID:      id,
+			UserID:      note.UserID,
+			Filename:    cleanFilename(filename),
+			ContentType: upload.contentType,
+			Size:        upload.size,
+			Checksum:    upload.checksum,
+			StorageKey:  key,
+			CreatedBy:   audit.FromContext(ctx).ActorID,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventAttachmentAdded, targetAttachment, attachment.ID, attachment); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventAttachmentAdded, targetAttachment, attachment.ID)
+		event.Changes = audit.Diff(nil, attachment)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		s.deleteBlob(key)
+		return entity.NoteAttachment{}, err
+	}
+
+	return *attachment, nil
+}
+
+// checkQuota refuses size more bytes of attachments to the user over their
+// quota.
+func (s service) checkQuota(repo NoteQueries, userID int64, size int64) error {
+	used, err := repo.AttachmentUsage(userID)
+	if err != nil {
+		return err
+	}
+
+	if used+size > s.opts.AttachmentQuota {
+		return apperrors.NewInsufficientStorage(fmt.Sprintf("The attachments would exceed the quota of %d bytes, %d are used", s.opts.AttachmentQuota, used))
+	}
+
+	return nil
+}
+
+// DeleteAttachment implements Service
+func (s service) DeleteAttachment(ctx context.Context, userID int64, id int64, attachmentID int64) error {
+	if _, err := s.authorize(userID, id, entity.PermissionEdit); err != nil {
+		return err
+	}
+
+	var attachment *entity.NoteAttachment
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if attachment, err = NewNoteQueries(s.db, tx).DeleteAttachment(id, attachmentID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("attachment", fmt.Sprint(attachmentID))
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventAttachmentDeleted, targetAttachment, attachmentID, attachment); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventAttachmentDeleted, targetAttachment, attachmentID)
+		event.Changes = audit.Diff(attachment, nil)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.deleteBlob(attachment.StorageKey)
+
+	return nil
+}
+
+// deleteBlob deletes the blob at key, out of any request. A blob it fails to
+// delete is left to PurgeOrphanedBlobs.
+func (s service) deleteBlob(key string) {
+	if err := s.opts.Storage.Delete(context.Background(), key); err != nil {
+		log.Printf("note: unable to delete the blob %s: %v", key, err)
+	}
+}
+
+// PurgeOrphanedBlobs implements Service
+//
+// The blobs without an attachment are those of the failed uploads, of the
+// failed deletions and of the notes deleted permanently. The blobs younger
+// than grace are kept, their upload may not be saved yet.
+func (s service) PurgeOrphanedBlobs(ctx context.Context, grace time.Duration) (int64, error) {
+	const batchSize = 500
+
+	var purged int64
+	batch := []string{}
+
+	flush := func() error {
+		stored, err := s.repo.GetStoredKeys(batch)
+		if err != nil {
+			return err
+		}
+
+		kept := map[string]bool{}
+		for _, key := range stored {
+			kept[key] = true
+		}
+
+		for _, key := range batch {
+			if kept[key] {
+				continue
+			}
+			if err := s.opts.Storage.Delete(ctx, key); err != nil {
+				return err
+			}
+			purged++
+		}
+
+		batch = batch[:0]
+
+		return nil
+	}
+
+	cutoff := time.Now().Add(-grace)
+
+	err := s.opts.Storage.Walk(ctx, attachmentPrefix, func(info storage.ObjectInfo) error {
+		if info.ModTime.After(cutoff) {
+			return nil
+		}
+
+		batch = append(batch, info.Key)
+		if len(batch) < batchSize {
+			return nil
+		}
+
+		return flush()
+	})
+
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+
+	return purged, err
+}
@@ -0,0 +1,42 @@
+package note
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 32)...)
+
+	upload, err := spool(bytes.NewReader(png), 64)
+	require.NoError(t, err)
+	defer upload.remove()
+
+	assert.Equal(t, int64(len(png)), upload.size)
+	assert.Equal(t, "image/png", upload.contentType)
+	assert.Len(t, upload.checksum, 64)
+
+	// the file is read from its start
+	b, err := io.ReadAll(upload.file)
+	require.NoError(t, err)
+	assert.Equal(t, png, b)
+
+	_, err = spool(strings.NewReader("too large"), 4)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, apperrors.Status(err))
+}
+
+func TestCleanFilename(t *testing.T) {
+	assert.Equal(t, "report.pdf", cleanFilename("report.pdf"))
+	assert.Equal(t, "passwd", cleanFilename("../../etc/passwd"))
+	assert.Equal(t, "photo.jpg", cleanFilename(`C:\Users\me\photo.jpg`))
+	assert.Equal(t, "ab.txt", cleanFilename("a\x00b.txt"))
+	assert.Equal(t, "file", cleanFilename(""))
+	assert.Len(t, cleanFilename(strings.Repeat("é", 200)), 254)
+}
@@ -0,0 +1,997 @@
+package note
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+type NoteQueries interface {
+	GetNotes(userID int64, filter TagFilter, offset, limit int) ([]entity.Note, error)
+	GetNote(userID int64, id int64) (*entity.Note, error)
+	CreateNote(note *entity.Note) (*entity.Note, error)
+	CreateNotes(notes []entity.Note) ([]entity.Note, error)
+	GetNotesAfter(userID int64, after int64, limit int) ([]entity.Note, error)
+	UpdateNote(note *entity.Note) (*entity.Note, error)
+	DeleteNote(userID int64, id int64, version int64) error
+	Count(userID int64, filter TagFilter) (int, error)
+	Search(userID int64, query string, language string, offset, limit int) ([]entity.NoteMatch, error)
+	CountMatches(userID int64, query string, language string) (int, error)
+	TouchNote(userID int64, id int64) (*entity.Note, error)
+	GetTags(userID int64) ([]entity.TagCount, error)
+	GetTag(userID int64, id int64) (*entity.Tag, error)
+	EnsureTags(userID int64, names []string) ([]entity.Tag, error)
+	TagNote(noteID int64, tagIDs []int64) error
+	UntagNote(noteID int64, tagID int64) error
+	RenameTag(userID int64, id int64, name string) (*entity.Tag, error)
+	MergeTag(userID int64, id int64, into int64) error
+	DeleteTag(userID int64, id int64) error
+	TouchTaggedNotes(tagID int64) error
+	CreateRevision(note *entity.Note, authorID *int64) (*entity.NoteRevision, error)
+	GetRevisions(noteID int64, after int64, limit int) ([]entity.NoteRevision, error)
+	GetRevision(noteID int64, id int64) (*entity.NoteRevision, error)
+	PruneRevisions(noteID int64, keep int) (int64, error)
+	PruneUserRevisions(userID int64, keep int) (int64, error)
+	GetSettings(userID int64) (*entity.NoteSettings, error)
+	SaveSettings(settings *entity.NoteSettings) (*entity.NoteSettings, error)
+	GetAccessibleNote(userID int64, id int64) (*entity.Note, error)
+	GetSharedNotes(userID int64, offset, limit int) ([]entity.Note, error)
+	CountShared(userID int64) (int, error)
+	ShareNote(noteID int64, email string, permission string, createdBy *int64) (*entity.NoteShare, error)
+	GetShares(noteID int64) ([]entity.NoteShare, error)
+	GetShare(noteID int64, id int64) (*entity.NoteShare, error)
+	DeleteShare(noteID int64, id int64) error
+	CreateLink(link *entity.NoteLink) (*entity.NoteLink, error)
+	GetLinks(noteID int64) ([]entity.NoteLink, error)
+	GetLink(noteID int64, id int64) (*entity.NoteLink, error)
+	GetLinkByToken(tokenHash string) (*entity.NoteLink, error)
+	DeleteLink(noteID int64, id int64) error
+	GetTrash(userID int64, offset, limit int) ([]entity.Note, error)
+	CountTrash(userID int64) (int, error)
+	GetTrashedNote(userID int64, id int64) (*entity.Note, error)
+	RestoreNote(userID int64, id int64) (*entity.Note, error)
+	PurgeNote(userID int64, id int64) error
+	EmptyTrash(userID int64) ([]PurgedNote, error)
+	PurgeTrash(deletedBefore time.Time) ([]PurgedNote, error)
+	CreateAttachment(attachment *entity.NoteAttachment) (*entity.NoteAttachment, error)
+	GetAttachments(noteID int64) ([]entity.NoteAttachment, error)
+	GetAttachment(noteID int64, id int64) (*entity.NoteAttachment, error)
+	DeleteAttachment(noteID int64, id int64) (*entity.NoteAttachment, error)
+	LockAttachments(userID int64) error
+	AttachmentUsage(userID int64) (int64, error)
+	GetStoredKeys(keys []string) ([]string, error)
+}
+
+// PurgedNote identifies a note deleted permanently.
+type PurgedNote struct {
+	ID     int64 `db:"id" json:"id"`
+	UserID int64 `db:"user_id" json:"user_id"`
+}
+
+// TagFilter restricts a listing to the notes with any, or all, of Tags.
+// Names are compared ignoring case, an empty filter lets every note through.
+type TagFilter struct {
+	Tags []string
+	All  bool
+}
+
+// args returns the number of tags a note needs and the names of the tags,
+// as a JSON array.
+func (f TagFilter) args() (int, string) {
+	seen := map[string]bool{}
+	names := []string{}
+
+	for _, name := range f.Tags {
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			names = append(names, key)
+		}
+	}
+
+	b, _ := json.Marshal(names)
+
+	switch {
+	case len(names) == 0:
+		return 0, string(b)
+	case f.All:
+		return len(names), string(b)
+	default:
+		return 1, string(b)
+	}
+}
+
+// tagFilter is the condition of a TagFilter on notes, $n is the number of
+// tags a note needs and $n+1 their names.
+func tagFilter(n int) string {
+	return fmt.Sprintf(`($%d = 0 OR (SELECT COUNT(*) FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+		WHERE nt.note_id = notes.id AND LOWER(t.name) IN (SELECT jsonb_array_elements_text($%d::jsonb))) >= $%d)`, n, n+1, n)
+}
+
+// DefaultLanguage is the text search configuration of the notes created
+// without one.
+const DefaultLanguage = "english"
+
+// noteColumns are the columns of entity.Note, the search vector is left out
+// and the tags are aggregated.
+//
+// The notes in the trash are only read by the trash queries, the others
+// ignore them.
+const noteColumns = `id, title, content, user_id, attrs, language, created_at, updated_at, version, deleted_at,
+	(SELECT COALESCE(jsonb_agg(jsonb_build_object('id', t.id, 'name', t.name) ORDER BY LOWER(t.name)), '[]')
+		FROM note_tags nt JOIN tags t ON t.id = nt.tag_id WHERE nt.note_id = notes.id) AS tags`
+
+// noteQueries struct for queries from Note model. Every query is scoped to
+// the notes of one user.
+type noteQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewNoteQueries(db *sqlx.DB, tx *sqlx.Tx) NoteQueries {
+	return &noteQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *noteQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// GetNotes implements NoteQueries
+func (q *noteQueries) GetNotes(userID int64, filter TagFilter, offset, limit int) ([]entity.Note, error) {
+	notes := []entity.Note{}
+
+	query := `SELECT ` + noteColumns + ` FROM notes WHERE user_id = $1 AND deleted_at IS NULL AND ` + tagFilter(4) + `
+		ORDER BY id DESC LIMIT $2 OFFSET $3`
+
+	count, names := filter.args()
+
+	err := sqlx.Select(q.conn(), &notes, query, userID, limit, offset, count, names)
+
+	return notes, err
+}
+
+// GetNote implements NoteQueries
+func (q *noteQueries) GetNote(userID int64, id int64) (*entity.Note, error) {
+	var note entity.Note
+
+	query := `SELECT ` + noteColumns + ` FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`
+
+	err := sqlx.Get(q.conn(), &note, query, id, userID)
+
+	return &note, err
+}
+
+// CreateNote implements NoteQueries
+func (q *noteQueries) CreateNote(n *entity.Note) (*entity.Note, error) {
+	query := `INSERT INTO notes (title, content, user_id, attrs, language) VALUES ($1, $2, $3, $4, $5) RETURNING ` + noteColumns
+
+	language := n.Language
+	if language == "" {
+		language = DefaultLanguage
+	}
+
+	var note entity.Note
+
+	err := q.conn().QueryRowx(query, n.Title, n.Content, n.UserID, n.Attrs, language).StructScan(&note)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert note error")
+	}
+
+	return &note, nil
+}
+
+// CreateNotes implements NoteQueries
+//
+// The notes are inserted by a single statement and returned in the same
+// order. Their timestamps are kept when they are set, a note without a
+// creation time is created now.
+func (q *noteQueries) CreateNotes(notes []entity.Note) ([]entity.Note, error) {
+	type row struct {
+		Ord       int              `json:"ord"`
+		Title     string           `json:"title"`
+		Content   string           `json:"content"`
+		UserID    int64            `json:"user_id"`
+		Attrs     entity.NoteAttrs `json:"attrs"`
+		Language  string           `json:"language"`
+		CreatedAt *time.Time       `json:"created_at"`
+		UpdatedAt *time.Time       `json:"updated_at"`
+	}
+
+	rows := make([]row, len(notes))
+	for i, n := range notes {
+		rows[i] = row{Ord: i, Title: n.Title, Content: n.Content, UserID: n.UserID, Attrs: n.Attrs, Language: n.Language}
+		if rows[i].Language == "" {
+			rows[i].Language = DefaultLanguage
+		}
+		if n.CreatedAt.Valid {
+			rows[i].CreatedAt = &n.CreatedAt.Time
+		}
+		if n.UpdatedAt.Valid {
+			rows[i].UpdatedAt = &n.UpdatedAt.Time
+		}
+	}
+
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ids are drawn with the rows so the created notes are joined back to
+	// their ord, they are returned in the order of notes
+	query := `WITH input AS (
+			SELECT nextval(pg_get_serial_sequence('notes', 'id')) AS id, n.*
+			FROM jsonb_to_recordset($1::jsonb) AS n(ord int, title text, content text, user_id int, attrs jsonb,
+				language text, created_at timestamptz, updated_at timestamptz)
+		), created AS (
+			INSERT INTO notes (id, title, content, user_id, attrs, language, created_at, updated_at)
+			SELECT i.id, i.title, i.content, i.user_id, i.attrs, i.language::regconfig, COALESCE(i.created_at, NOW()), i.updated_at
+			FROM input i
+			RETURNING ` + noteColumns + `
+		)
+		SELECT created.* FROM created JOIN input ON input.id = created.id ORDER BY input.ord`
+
+	created := []entity.Note{}
+
+	if err := sqlx.Select(q.conn(), &created, query, string(b)); err != nil {
+		return nil, errors.Wrap(err, "insert notes error")
+	}
+
+	return created, nil
+}
+
+// GetNotesAfter implements NoteQueries
+//
+// The notes are read by id, after is the id of the last note of the
+// previous batch, or 0.
+func (q *noteQueries) GetNotesAfter(userID int64, after int64, limit int) ([]entity.Note, error) {
+	notes := []entity.Note{}
+
+	query := `SELECT ` + noteColumns + ` FROM notes WHERE user_id = $1 AND deleted_at IS NULL AND id > $2
+		ORDER BY id LIMIT $3`
+
+	err := sqlx.Select(q.conn(), &notes, query, userID, after, limit)
+
+	return notes, err
+}
+
+// UpdateNote implements NoteQueries
+//
+// Unless n.Version is etag.Any it must be the current version of the note,
+// sql.ErrNoRows is returned otherwise.
+func (q *noteQueries) UpdateNote(n *entity.Note) (*entity.Note, error) {
+	query := `UPDATE notes SET title = $3, content = $4, attrs = $5, updated_at = $6, version = version + 1
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL AND ($7 = 0 OR version = $7) RETURNING ` + noteColumns
+
+	var note entity.Note
+
+	err := q.conn().QueryRowx(query, n.ID, n.UserID, n.Title, n.Content, n.Attrs, time.Now(), n.Version).StructScan(&note)
+	if err != nil {
+		return nil, errors.Wrap(err, "update note error")
+	}
+
+	return &note, nil
+}
+
+// DeleteNote implements NoteQueries
+//
+// It moves the note to the trash. Unless version is etag.Any it must be the
+// current version of the note, sql.ErrNoRows is returned otherwise.
+func (q *noteQueries) DeleteNote(userID int64, id int64, version int64) error {
+	query := `UPDATE notes SET deleted_at = $4, updated_at = $4, version = version + 1
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL AND ($3 = 0 OR version = $3)`
+
+	res, err := q.conn().Exec(query, id, userID, version, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "delete note error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Count implements NoteQueries
+func (q *noteQueries) Count(userID int64, filter TagFilter) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(id) FROM notes WHERE user_id = $1 AND deleted_at IS NULL AND ` + tagFilter(2)
+
+	tags, names := filter.args()
+
+	err := q.conn().QueryRowx(query, userID, tags, names).Scan(&count)
+
+	return count, err
+}
+
+// The delimiters ts_headline surrounds the matched words with. They are
+// control characters so they survive the escaping of the highlights and are
+// not mistaken for the text of a note.
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// Search implements NoteQueries
+//
+// query has the syntax of websearch_to_tsquery, it is parsed with the text
+// search configuration language. The matches are ranked best first.
+func (q *noteQueries) Search(userID int64, query string, language string, offset, limit int) ([]entity.NoteMatch, error) {
+	matches := []entity.NoteMatch{}
+
+	sel := `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `"`
+
+	search := `SELECT ` + noteColumns + `, ts_rank(search, tsq) AS rank,
+			ts_headline(language, title, tsq, 'HighlightAll=true, ` + sel + `') AS title_highlight,
+			ts_headline(language, content, tsq, 'MaxFragments=2, MaxWords=30, MinWords=10, ` + sel + `') AS snippet
+		FROM notes, websearch_to_tsquery($2::regconfig, $3) tsq
+		WHERE user_id = $1 AND deleted_at IS NULL AND search @@ tsq
+		ORDER BY rank DESC, id DESC LIMIT $4 OFFSET $5`
+
+	if err := sqlx.Select(q.conn(), &matches, search, userID, language, query, limit, offset); err != nil {
+		return matches, err
+	}
+
+	for i := range matches {
+		matches[i].TitleHighlight = highlight(matches[i].TitleHighlight)
+		matches[i].Snippet = highlight(matches[i].Snippet)
+	}
+
+	return matches, nil
+}
+
+// highlight escapes a headline as HTML and replaces its delimiters with
+// <mark> tags.
+func highlight(headline string) string {
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(html.EscapeString(headline))
+}
+
+// CountMatches implements NoteQueries
+func (q *noteQueries) CountMatches(userID int64, query string, language string) (int, error) {
+	var count int
+
+	search := `SELECT COUNT(id) FROM notes WHERE user_id = $1 AND deleted_at IS NULL AND search @@ websearch_to_tsquery($2::regconfig, $3)`
+
+	err := q.conn().QueryRowx(search, userID, language, query).Scan(&count)
+
+	return count, err
+}
+
+// TouchNote implements NoteQueries
+//
+// It increments the version of a note whose tags changed, its ETag changes
+// with them.
+func (q *noteQueries) TouchNote(userID int64, id int64) (*entity.Note, error) {
+	query := `UPDATE notes SET updated_at = $3, version = version + 1 WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+		RETURNING ` + noteColumns
+
+	var note entity.Note
+
+	err := q.conn().QueryRowx(query, id, userID, time.Now()).StructScan(&note)
+
+	return &note, err
+}
+
+// GetTags implements NoteQueries
+func (q *noteQueries) GetTags(userID int64) ([]entity.TagCount, error) {
+	tags := []entity.TagCount{}
+
+	query := `SELECT t.*, COUNT(n.id) AS notes FROM tags t LEFT JOIN note_tags nt ON nt.tag_id = t.id
+		LEFT JOIN notes n ON n.id = nt.note_id AND n.deleted_at IS NULL
+		WHERE t.user_id = $1 GROUP BY t.id ORDER BY LOWER(t.name)`
+
+	err := sqlx.Select(q.conn(), &tags, query, userID)
+
+	return tags, err
+}
+
+// GetTag implements NoteQueries
+func (q *noteQueries) GetTag(userID int64, id int64) (*entity.Tag, error) {
+	var tag entity.Tag
+
+	err := sqlx.Get(q.conn(), &tag, `SELECT * FROM tags WHERE id = $1 AND user_id = $2`, id, userID)
+
+	return &tag, err
+}
+
+// EnsureTags implements NoteQueries
+//
+// It returns the tags of the user named names, creating the missing ones.
+func (q *noteQueries) EnsureTags(userID int64, names []string) ([]entity.Tag, error) {
+	b, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+
+	insert := `INSERT INTO tags (user_id, name) SELECT $1, name FROM jsonb_array_elements_text($2::jsonb) name
+		ON CONFLICT (user_id, LOWER(name)) DO NOTHING`
+
+	if _, err := q.conn().Exec(insert, userID, string(b)); err != nil {
+		return nil, errors.Wrap(err, "insert tags error")
+	}
+
+	tags := []entity.Tag{}
+
+	query := `SELECT * FROM tags WHERE user_id = $1
+		AND LOWER(name) IN (SELECT LOWER(jsonb_array_elements_text($2::jsonb))) ORDER BY LOWER(name)`
+
+	if err := sqlx.Select(q.conn(), &tags, query, userID, string(b)); err != nil {
+		return nil, errors.Wrap(err, "select tags error")
+	}
+
+	return tags, nil
+}
+
+// TagNote implements NoteQueries
+func (q *noteQueries) TagNote(noteID int64, tagIDs []int64) error {
+	b, err := json.Marshal(tagIDs)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO note_tags (note_id, tag_id) SELECT $1, tag_id::int FROM jsonb_array_elements_text($2::jsonb) tag_id
+		ON CONFLICT DO NOTHING`
+
+	if _, err := q.conn().Exec(query, noteID, string(b)); err != nil {
+		return errors.Wrap(err, "tag note error")
+	}
+
+	return nil
+}
+
+// UntagNote implements NoteQueries
+//
+// sql.ErrNoRows is returned when the note does not have the tag.
+func (q *noteQueries) UntagNote(noteID int64, tagID int64) error {
+	res, err := q.conn().Exec(`DELETE FROM note_tags WHERE note_id = $1 AND tag_id = $2`, noteID, tagID)
+	if err != nil {
+		return errors.Wrap(err, "untag note error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// RenameTag implements NoteQueries
+func (q *noteQueries) RenameTag(userID int64, id int64, name string) (*entity.Tag, error) {
+	var tag entity.Tag
+
+	err := q.conn().QueryRowx(`UPDATE tags SET name = $3 WHERE id = $1 AND user_id = $2 RETURNING *`, id, userID, name).
+		StructScan(&tag)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "rename tag error")
+	}
+
+	return &tag, nil
+}
+
+// MergeTag implements NoteQueries
+//
+// The notes with the tag id get the tag into instead, then id is deleted.
+// Both must belong to the user.
+func (q *noteQueries) MergeTag(userID int64, id int64, into int64) error {
+	query := `INSERT INTO note_tags (note_id, tag_id) SELECT nt.note_id, $3 FROM note_tags nt
+		JOIN tags t ON t.id = nt.tag_id JOIN tags i ON i.id = $3
+		WHERE nt.tag_id = $1 AND t.user_id = $2 AND i.user_id = $2
+		ON CONFLICT DO NOTHING`
+
+	if _, err := q.conn().Exec(query, id, userID, into); err != nil {
+		return errors.Wrap(err, "merge tag error")
+	}
+
+	return q.DeleteTag(userID, id)
+}
+
+// DeleteTag implements NoteQueries
+//
+// The tag is removed from its notes. sql.ErrNoRows is returned when the user
+// has no tag id.
+func (q *noteQueries) DeleteTag(userID int64, id int64) error {
+	res, err := q.conn().Exec(`DELETE FROM tags WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return errors.Wrap(err, "delete tag error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TouchTaggedNotes implements NoteQueries
+//
+// It increments the version of the notes with the tag, before the tag is
+// renamed, merged or deleted.
+func (q *noteQueries) TouchTaggedNotes(tagID int64) error {
+	query := `UPDATE notes SET updated_at = $2, version = version + 1
+		WHERE id IN (SELECT note_id FROM note_tags WHERE tag_id = $1)`
+
+	if _, err := q.conn().Exec(query, tagID, time.Now()); err != nil {
+		return errors.Wrap(err, "touch tagged notes error")
+	}
+
+	return nil
+}
+
+// CreateRevision implements NoteQueries
+//
+// It saves the current title, content and attrs of the note as the revision
+// of its version.
+func (q *noteQueries) CreateRevision(n *entity.Note, authorID *int64) (*entity.NoteRevision, error) {
+	query := `INSERT INTO note_revisions (note_id, version, title, content, attrs, author_id)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING *`
+
+	var revision entity.NoteRevision
+
+	err := q.conn().QueryRowx(query, n.ID, n.Version, n.Title, n.Content, n.Attrs, authorID).StructScan(&revision)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert note revision error")
+	}
+
+	return &revision, nil
+}
+
+// GetRevisions implements NoteQueries
+//
+// The revisions are read newest first, after is the ID of the last revision
+// of the previous page, or 0.
+func (q *noteQueries) GetRevisions(noteID int64, after int64, limit int) ([]entity.NoteRevision, error) {
+	revisions := []entity.NoteRevision{}
+
+	query := `SELECT * FROM note_revisions WHERE note_id = $1 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`
+
+	err := sqlx.Select(q.conn(), &revisions, query, noteID, after, limit)
+
+	return revisions, err
+}
+
+// GetRevision implements NoteQueries
+func (q *noteQueries) GetRevision(noteID int64, id int64) (*entity.NoteRevision, error) {
+	var revision entity.NoteRevision
+
+	err := sqlx.Get(q.conn(), &revision, `SELECT * FROM note_revisions WHERE id = $1 AND note_id = $2`, id, noteID)
+
+	return &revision, err
+}
+
+// PruneRevisions implements NoteQueries
+//
+// It deletes the revisions of the note but the keep newest ones.
+func (q *noteQueries) PruneRevisions(noteID int64, keep int) (int64, error) {
+	query := `DELETE FROM note_revisions WHERE note_id = $1
+		AND id NOT IN (SELECT id FROM note_revisions WHERE note_id = $1 ORDER BY id DESC LIMIT $2)`
+
+	res, err := q.conn().Exec(query, noteID, keep)
+	if err != nil {
+		return 0, errors.Wrap(err, "prune note revisions error")
+	}
+
+	return res.RowsAffected()
+}
+
+// PruneUserRevisions implements NoteQueries
+//
+// It deletes the revisions of every note of the user but the keep newest
+// ones of each.
+func (q *noteQueries) PruneUserRevisions(userID int64, keep int) (int64, error) {
+	query := `DELETE FROM note_revisions WHERE id IN (
+		SELECT id FROM (
+			SELECT r.id, ROW_NUMBER() OVER (PARTITION BY r.note_id ORDER BY r.id DESC) AS n
+			FROM note_revisions r JOIN notes ON notes.id = r.note_id WHERE notes.user_id = $1
+		) ranked WHERE n > $2)`
+
+	res, err := q.conn().Exec(query, userID, keep)
+	if err != nil {
+		return 0, errors.Wrap(err, "prune user revisions error")
+	}
+
+	return res.RowsAffected()
+}
+
+// GetSettings implements NoteQueries
+//
+// The users who never changed their settings get the defaults.
+func (q *noteQueries) GetSettings(userID int64) (*entity.NoteSettings, error) {
+	var settings entity.NoteSettings
+
+	err := sqlx.Get(q.conn(), &settings, `SELECT * FROM note_settings WHERE user_id = $1`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &entity.NoteSettings{UserID: userID}, nil
+	}
+
+	return &settings, err
+}
+
+// SaveSettings implements NoteQueries
+func (q *noteQueries) SaveSettings(s *entity.NoteSettings) (*entity.NoteSettings, error) {
+	query := `INSERT INTO note_settings (user_id, revision_limit, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET revision_limit = EXCLUDED.revision_limit, updated_at = EXCLUDED.updated_at
+		RETURNING *`
+
+	var settings entity.NoteSettings
+
+	err := q.conn().QueryRowx(query, s.UserID, s.RevisionLimit, time.Now()).StructScan(&settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "save note settings error")
+	}
+
+	return &settings, nil
+}
+
+// permissionColumn is the permission of the user $2 on the note, NULL when the
+// note is not shared with them.
+const permissionColumn = `CASE WHEN notes.user_id = $2 THEN 'owner'
+	ELSE (SELECT s.permission FROM note_shares s WHERE s.note_id = notes.id AND s.user_id = $2) END AS permission`
+
+// GetAccessibleNote implements NoteQueries
+//
+// It reads the note id if the user owns it or it is shared with them, with
+// the permission of the user.
+func (q *noteQueries) GetAccessibleNote(userID int64, id int64) (*entity.Note, error) {
+	var note entity.Note
+
+	query := `SELECT * FROM (SELECT ` + noteColumns + `, ` + permissionColumn + ` FROM notes WHERE id = $1 AND deleted_at IS NULL) n
+		WHERE permission IS NOT NULL`
+
+	err := sqlx.Get(q.conn(), &note, query, id, userID)
+
+	return &note, err
+}
+
+// GetSharedNotes implements NoteQueries
+//
+// It reads the notes other users shared with the user, the last shared
+// first.
+func (q *noteQueries) GetSharedNotes(userID int64, offset, limit int) ([]entity.Note, error) {
+	notes := []entity.Note{}
+
+	query := `SELECT ` + noteColumns + `, s.permission FROM notes
+		JOIN (SELECT id AS share_id, note_id, permission FROM note_shares WHERE user_id = $1) s ON s.note_id = notes.id
+		WHERE notes.deleted_at IS NULL ORDER BY s.share_id DESC LIMIT $2 OFFSET $3`
+
+	err := sqlx.Select(q.conn(), &notes, query, userID, limit, offset)
+
+	return notes, err
+}
+
+// CountShared implements NoteQueries
+func (q *noteQueries) CountShared(userID int64) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(s.id) FROM note_shares s JOIN notes n ON n.id = s.note_id WHERE s.user_id = $1 AND n.deleted_at IS NULL`
+
+	err := q.conn().QueryRowx(query, userID).Scan(&count)
+
+	return count, err
+}
+
+// ShareNote implements NoteQueries
+//
+// It shares the note with the live user whose email is email, or changes
+// the permission of the user when it is already shared with them.
+// sql.ErrNoRows is returned when there is no such user.
+func (q *noteQueries) ShareNote(noteID int64, email string, permission string, createdBy *int64) (*entity.NoteShare, error) {
+	query := `WITH share AS (
+			INSERT INTO note_shares (note_id, user_id, permission, created_by)
+			SELECT $1, id, $3, $4 FROM users WHERE LOWER(email) = LOWER($2) AND deleted_at IS NULL
+			ON CONFLICT (note_id, user_id) DO UPDATE SET permission = EXCLUDED.permission, updated_at = NOW()
+			RETURNING *
+		)
+		SELECT share.*, users.email FROM share JOIN users ON users.id = share.user_id`
+
+	var share entity.NoteShare
+
+	err := q.conn().QueryRowx(query, noteID, email, permission, createdBy).StructScan(&share)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "share note error")
+	}
+
+	return &share, nil
+}
+
+// GetShares implements NoteQueries
+func (q *noteQueries) GetShares(noteID int64) ([]entity.NoteShare, error) {
+	shares := []entity.NoteShare{}
+
+	query := `SELECT s.*, u.email FROM note_shares s JOIN users u ON u.id = s.user_id WHERE s.note_id = $1 ORDER BY s.id`
+
+	err := sqlx.Select(q.conn(), &shares, query, noteID)
+
+	return shares, err
+}
+
+// GetShare implements NoteQueries
+func (q *noteQueries) GetShare(noteID int64, id int64) (*entity.NoteShare, error) {
+	var share entity.NoteShare
+
+	query := `SELECT s.*, u.email FROM note_shares s JOIN users u ON u.id = s.user_id WHERE s.id = $1 AND s.note_id = $2`
+
+	err := sqlx.Get(q.conn(), &share, query, id, noteID)
+
+	return &share, err
+}
+
+// DeleteShare implements NoteQueries
+//
+// sql.ErrNoRows is returned when the note has no share id.
+func (q *noteQueries) DeleteShare(noteID int64, id int64) error {
+	res, err := q.conn().Exec(`DELETE FROM note_shares WHERE id = $1 AND note_id = $2`, id, noteID)
+	if err != nil {
+		return errors.Wrap(err, "delete note share error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreateLink implements NoteQueries
+func (q *noteQueries) CreateLink(l *entity.NoteLink) (*entity.NoteLink, error) {
+	query := `INSERT INTO note_links (note_id, token_hash, password, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING *`
+
+	var link entity.NoteLink
+
+	err := q.conn().QueryRowx(query, l.NoteID, l.TokenHash, l.Password, l.ExpiresAt, l.CreatedBy).StructScan(&link)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert note link error")
+	}
+
+	return &link, nil
+}
+
+// GetLinks implements NoteQueries
+func (q *noteQueries) GetLinks(noteID int64) ([]entity.NoteLink, error) {
+	links := []entity.NoteLink{}
+
+	err := sqlx.Select(q.conn(), &links, `SELECT * FROM note_links WHERE note_id = $1 ORDER BY id`, noteID)
+
+	return links, err
+}
+
+// GetLink implements NoteQueries
+func (q *noteQueries) GetLink(noteID int64, id int64) (*entity.NoteLink, error) {
+	var link entity.NoteLink
+
+	err := sqlx.Get(q.conn(), &link, `SELECT * FROM note_links WHERE id = $1 AND note_id = $2`, id, noteID)
+
+	return &link, err
+}
+
+// GetLinkByToken implements NoteQueries
+//
+// The link is read with the owner of its note, expired links included. The
+// links to the notes in the trash are not found.
+func (q *noteQueries) GetLinkByToken(tokenHash string) (*entity.NoteLink, error) {
+	var link entity.NoteLink
+
+	query := `SELECT l.*, n.user_id AS owner_id FROM note_links l JOIN notes n ON n.id = l.note_id
+		WHERE l.token_hash = $1 AND n.deleted_at IS NULL`
+
+	err := sqlx.Get(q.conn(), &link, query, tokenHash)
+
+	return &link, err
+}
+
+// DeleteLink implements NoteQueries
+//
+// sql.ErrNoRows is returned when the note has no link id.
+func (q *noteQueries) DeleteLink(noteID int64, id int64) error {
+	res, err := q.conn().Exec(`DELETE FROM note_links WHERE id = $1 AND note_id = $2`, id, noteID)
+	if err != nil {
+		return errors.Wrap(err, "delete note link error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetTrash implements NoteQueries
+//
+// It reads the notes of the user in the trash, the last deleted first.
+func (q *noteQueries) GetTrash(userID int64, offset, limit int) ([]entity.Note, error) {
+	notes := []entity.Note{}
+
+	query := `SELECT ` + noteColumns + ` FROM notes WHERE user_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC LIMIT $2 OFFSET $3`
+
+	err := sqlx.Select(q.conn(), &notes, query, userID, limit, offset)
+
+	return notes, err
+}
+
+// CountTrash implements NoteQueries
+func (q *noteQueries) CountTrash(userID int64) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(id) FROM notes WHERE user_id = $1 AND deleted_at IS NOT NULL`
+
+	err := q.conn().QueryRowx(query, userID).Scan(&count)
+
+	return count, err
+}
+
+// GetTrashedNote implements NoteQueries
+func (q *noteQueries) GetTrashedNote(userID int64, id int64) (*entity.Note, error) {
+	var note entity.Note
+
+	query := `SELECT ` + noteColumns + ` FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`
+
+	err := sqlx.Get(q.conn(), &note, query, id, userID)
+
+	return &note, err
+}
+
+// RestoreNote implements NoteQueries
+//
+// It moves the note out of the trash, sql.ErrNoRows is returned when it is
+// not in the trash.
+func (q *noteQueries) RestoreNote(userID int64, id int64) (*entity.Note, error) {
+	query := `UPDATE notes SET deleted_at = NULL, updated_at = $3, version = version + 1
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL RETURNING ` + noteColumns
+
+	var note entity.Note
+
+	err := q.conn().QueryRowx(query, id, userID, time.Now()).StructScan(&note)
+
+	return &note, err
+}
+
+// PurgeNote implements NoteQueries
+//
+// It deletes a note of the trash permanently, sql.ErrNoRows is returned when
+// it is not in the trash.
+func (q *noteQueries) PurgeNote(userID int64, id int64) error {
+	res, err := q.conn().Exec(`DELETE FROM notes WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`, id, userID)
+	if err != nil {
+		return errors.Wrap(err, "purge note error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// EmptyTrash implements NoteQueries
+func (q *noteQueries) EmptyTrash(userID int64) ([]PurgedNote, error) {
+	notes := []PurgedNote{}
+
+	query := `DELETE FROM notes WHERE user_id = $1 AND deleted_at IS NOT NULL RETURNING id, user_id`
+
+	if err := sqlx.Select(q.conn(), &notes, query, userID); err != nil {
+		return nil, errors.Wrap(err, "empty trash error")
+	}
+
+	return notes, nil
+}
+
+// PurgeTrash implements NoteQueries
+//
+// It deletes permanently the notes of every user moved to the trash before
+// deletedBefore.
+func (q *noteQueries) PurgeTrash(deletedBefore time.Time) ([]PurgedNote, error) {
+	notes := []PurgedNote{}
+
+	query := `DELETE FROM notes WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING id, user_id`
+
+	if err := sqlx.Select(q.conn(), &notes, query, deletedBefore); err != nil {
+		return nil, errors.Wrap(err, "purge trash error")
+	}
+
+	return notes, nil
+}
+
+// CreateAttachment implements NoteQueries
+func (q *noteQueries) CreateAttachment(a *entity.NoteAttachment) (*entity.NoteAttachment, error) {
+	query := `INSERT INTO note_attachments (note_id, user_id, filename, content_type, size, checksum, storage_key, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *`
+
+	var attachment entity.NoteAttachment
+
+	err := q.conn().QueryRowx(query, a.NoteID, a.UserID, a.Filename, a.ContentType, a.Size, a.Checksum, a.StorageKey, a.CreatedBy).StructScan(&attachment)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert note attachment error")
+	}
+
+	return &attachment, nil
+}
+
+// GetAttachments implements NoteQueries
+func (q *noteQueries) GetAttachments(noteID int64) ([]entity.NoteAttachment, error) {
+	attachments := []entity.NoteAttachment{}
+
+	err := sqlx.Select(q.conn(), &attachments, `SELECT * FROM note_attachments WHERE note_id = $1 ORDER BY id`, noteID)
+
+	return attachments, err
+}
+
+// GetAttachment implements NoteQueries
+func (q *noteQueries) GetAttachment(noteID int64, id int64) (*entity.NoteAttachment, error) {
+	var attachment entity.NoteAttachment
+
+	err := sqlx.Get(q.conn(), &attachment, `SELECT * FROM note_attachments WHERE id = $1 AND note_id = $2`, id, noteID)
+
+	return &attachment, err
+}
+
+// DeleteAttachment implements NoteQueries
+//
+// It returns the deleted attachment, sql.ErrNoRows is returned when the note
+// has no attachment id. The blob of the attachment is left to the caller.
+func (q *noteQueries) DeleteAttachment(noteID int64, id int64) (*entity.NoteAttachment, error) {
+	var attachment entity.NoteAttachment
+
+	err := q.conn().QueryRowx(`DELETE FROM note_attachments WHERE id = $1 AND note_id = $2 RETURNING *`, id, noteID).StructScan(&attachment)
+
+	return &attachment, err
+}
+
+// LockAttachments implements NoteQueries
+//
+// It locks the row of the user until the end of the transaction, so the
+// concurrent uploads of the user check their quota one after the other.
+func (q *noteQueries) LockAttachments(userID int64) error {
+	var id int64
+
+	return q.conn().QueryRowx(`SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id)
+}
+
+// AttachmentUsage implements NoteQueries
+//
+// It returns the size of the attachments of the notes of the user, the
+// notes in the trash included.
+func (q *noteQueries) AttachmentUsage(userID int64) (int64, error) {
+	var usage int64
+
+	err := q.conn().QueryRowx(`SELECT COALESCE(SUM(size), 0) FROM note_attachments WHERE user_id = $1`, userID).Scan(&usage)
+
+	return usage, err
+}
+
+// GetStoredKeys implements NoteQueries
+//
+// It returns the storage keys of keys held by an attachment.
+func (q *noteQueries) GetStoredKeys(keys []string) ([]string, error) {
+	stored := []string{}
+
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT storage_key FROM note_attachments
+		WHERE storage_key IN (SELECT jsonb_array_elements_text($1::jsonb))`
+
+	err = sqlx.Select(q.conn(), &stored, query, string(b))
+
+	return stored, err
+}
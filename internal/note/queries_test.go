@@ -0,0 +1,476 @@
+package note
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/user"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type queriesSuiteTest struct {
+	test.TSuite
+}
+
+func TestQueriesSuiteTest(t *testing.T) {
+	suite.Run(t, new(queriesSuiteTest))
+}
+
+func (t *queriesSuiteTest) createNote() (*entity.User, *entity.Note) {
+	u, err := user.NewUserQueries(t.DB, t.TX).CreateUser(&test.GenerateUsers(1)[0])
+	require.NoError(t.T(), err)
+
+	note, err := NewNoteQueries(t.DB, t.TX).CreateNote(&entity.Note{
+		Title:   "Groceries",
+		Content: "milk",
+		UserID:  u.ID,
+		Attrs:   entity.NoteAttrs{Color: "yellow"},
+	})
+	require.NoError(t.T(), err)
+
+	return u, note
+}
+
+func (t *queriesSuiteTest) TestCreateNote() {
+	u, note := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	saved, err := queries.GetNote(u.ID, note.ID)
+	require.NoError(t.T(), err)
+
+	assert.Equal(t.T(), "Groceries", saved.Title)
+	assert.Equal(t.T(), "yellow", saved.Attrs.Color)
+	assert.Equal(t.T(), int64(1), saved.Version)
+
+	// notes of other users are invisible
+	_, err = queries.GetNote(u.ID+1, note.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+}
+
+func (t *queriesSuiteTest) TestCreateNotes() {
+	u, first := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	notes := []entity.Note{
+		{Title: "a", Content: "1", UserID: u.ID},
+		{Title: "b", Content: "2", UserID: u.ID, Attrs: entity.NoteAttrs{Format: entity.FormatMarkdown}},
+		{Title: "c", Content: "3", UserID: u.ID},
+	}
+	notes[1].CreatedAt.Time, notes[1].CreatedAt.Valid = createdAt, true
+
+	created, err := queries.CreateNotes(notes)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), created, 3)
+
+	for i, note := range created {
+		assert.Equal(t.T(), notes[i].Title, note.Title)
+		assert.Equal(t.T(), int64(1), note.Version)
+	}
+	assert.Equal(t.T(), entity.FormatMarkdown, created[1].Attrs.Format)
+	assert.Equal(t.T(), 2020, created[1].CreatedAt.Time.Year())
+
+	read, err := queries.GetNotesAfter(u.ID, 0, 2)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), read, 2)
+	assert.Equal(t.T(), first.ID, read[0].ID)
+	assert.Equal(t.T(), created[0].ID, read[1].ID)
+
+	read, err = queries.GetNotesAfter(u.ID, read[1].ID, 10)
+	require.NoError(t.T(), err)
+	assert.Len(t.T(), read, 2)
+}
+
+func (t *queriesSuiteTest) TestUpdateNoteVersion() {
+	_, note := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	note.Content = "milk, eggs"
+
+	updated, err := queries.UpdateNote(note)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), note.Version+1, updated.Version)
+
+	// the version read first is stale now
+	_, err = queries.UpdateNote(note)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	assert.ErrorIs(t.T(), queries.DeleteNote(note.UserID, note.ID, note.Version), sql.ErrNoRows)
+	assert.NoError(t.T(), queries.DeleteNote(note.UserID, note.ID, updated.Version))
+}
+
+func (t *queriesSuiteTest) TestSearch() {
+	u, _ := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	for _, n := range []entity.Note{
+		{Title: "Milk", Content: "whole or skimmed", UserID: u.ID},
+		{Title: "Running", Content: "ran five kilometers", UserID: u.ID},
+	} {
+		_, err := queries.CreateNote(&n)
+		require.NoError(t.T(), err)
+	}
+
+	// words are stemmed, matches in the title rank first
+	matches, err := queries.Search(u.ID, "milks", DefaultLanguage, 0, 10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), matches, 2)
+	assert.Equal(t.T(), "<mark>Milk</mark>", matches[0].TitleHighlight)
+	assert.Equal(t.T(), "Groceries", matches[1].TitleHighlight)
+	assert.Equal(t.T(), "<mark>milk</mark>", matches[1].Snippet)
+	assert.Greater(t.T(), matches[0].Rank, matches[1].Rank)
+
+	// the highlights are escaped, the markup of the notes is not rendered
+	_, err = queries.CreateNote(&entity.Note{Title: "<b>Eggs</b>", Content: `eggs <img src=x onerror="alert(1)">`, UserID: u.ID})
+	require.NoError(t.T(), err)
+
+	matches, err = queries.Search(u.ID, "eggs", DefaultLanguage, 0, 10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), matches, 1)
+	assert.Equal(t.T(), "&lt;b&gt;<mark>Eggs</mark>&lt;/b&gt;", matches[0].TitleHighlight)
+	assert.NotContains(t.T(), matches[0].Snippet, "<img")
+	assert.Contains(t.T(), matches[0].Snippet, "<mark>eggs</mark>")
+
+	// web search syntax
+	matches, err = queries.Search(u.ID, "milk -skimmed", DefaultLanguage, 0, 10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), matches, 1)
+	assert.Equal(t.T(), "Groceries", matches[0].Title)
+
+	count, err := queries.CountMatches(u.ID, `"five kilometers" or groceries`, DefaultLanguage)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 2, count)
+
+	// notes of other users are not searched
+	count, err = queries.CountMatches(u.ID+1, "milk", DefaultLanguage)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 0, count)
+}
+
+func (t *queriesSuiteTest) TestTags() {
+	u, groceries := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	recipes, err := queries.CreateNote(&entity.Note{Title: "Recipes", UserID: u.ID})
+	require.NoError(t.T(), err)
+
+	tags, err := queries.EnsureTags(u.ID, []string{"Home", "shopping"})
+	require.NoError(t.T(), err)
+	require.Len(t.T(), tags, 2)
+	home, shopping := tags[0], tags[1]
+
+	// existing tags are reused, ignoring case
+	tags, err = queries.EnsureTags(u.ID, []string{"home", "food"})
+	require.NoError(t.T(), err)
+	require.Len(t.T(), tags, 2)
+	assert.Equal(t.T(), home.ID, tags[1].ID)
+	food := tags[0]
+
+	require.NoError(t.T(), queries.TagNote(groceries.ID, []int64{home.ID, shopping.ID}))
+	require.NoError(t.T(), queries.TagNote(recipes.ID, []int64{home.ID, food.ID}))
+	// tagging twice is harmless
+	require.NoError(t.T(), queries.TagNote(recipes.ID, []int64{food.ID}))
+
+	note, err := queries.GetNote(u.ID, groceries.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.NoteTags{{ID: home.ID, Name: "Home"}, {ID: shopping.ID, Name: "shopping"}}, note.Tags)
+
+	count, err := queries.Count(u.ID, TagFilter{Tags: []string{"HOME", "food"}})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 2, count)
+
+	notes, err := queries.GetNotes(u.ID, TagFilter{Tags: []string{"home", "food"}, All: true}, 0, 10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), notes, 1)
+	assert.Equal(t.T(), "Recipes", notes[0].Title)
+
+	counts, err := queries.GetTags(u.ID)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), counts, 3)
+	assert.Equal(t.T(), "food", counts[0].Name)
+	assert.Equal(t.T(), 1, counts[0].Notes)
+	assert.Equal(t.T(), 2, counts[1].Notes)
+
+	// the failed rename aborts the transaction, keep it usable afterwards
+	_, err = t.TX.Exec(`SAVEPOINT duplicate`)
+	require.NoError(t.T(), err)
+
+	_, err = queries.RenameTag(u.ID, food.ID, "SHOPPING")
+	assert.True(t.T(), database.IsUniqueViolation(err))
+
+	_, err = t.TX.Exec(`ROLLBACK TO SAVEPOINT duplicate`)
+	require.NoError(t.T(), err)
+
+	// the notes of the merged tag get the other one once
+	require.NoError(t.T(), queries.MergeTag(u.ID, home.ID, shopping.ID))
+
+	notes, err = queries.GetNotes(u.ID, TagFilter{Tags: []string{"shopping"}}, 0, 10)
+	require.NoError(t.T(), err)
+	assert.Len(t.T(), notes, 2)
+
+	_, err = queries.GetTag(u.ID, home.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	assert.NoError(t.T(), queries.UntagNote(recipes.ID, food.ID))
+	assert.ErrorIs(t.T(), queries.UntagNote(recipes.ID, food.ID), sql.ErrNoRows)
+
+	// tags of other users are invisible
+	assert.ErrorIs(t.T(), queries.DeleteTag(u.ID+1, food.ID), sql.ErrNoRows)
+	assert.NoError(t.T(), queries.DeleteTag(u.ID, food.ID))
+}
+
+func (t *queriesSuiteTest) TestRevisions() {
+	u, note := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	first, err := queries.CreateRevision(note, &u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), note.Version, first.Version)
+	assert.Equal(t.T(), "yellow", first.Attrs.Color)
+	assert.Equal(t.T(), u.ID, *first.AuthorID)
+
+	for _, content := range []string{"milk, eggs", "milk, eggs, bread"} {
+		note.Content = content
+		note, err = queries.UpdateNote(note)
+		require.NoError(t.T(), err)
+
+		_, err = queries.CreateRevision(note, nil)
+		require.NoError(t.T(), err)
+	}
+
+	revisions, err := queries.GetRevisions(note.ID, 0, 2)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), revisions, 2)
+	assert.Equal(t.T(), "milk, eggs, bread", revisions[0].Content)
+	assert.Nil(t.T(), revisions[0].AuthorID)
+
+	revisions, err = queries.GetRevisions(note.ID, revisions[1].ID, 2)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), revisions, 1)
+	assert.Equal(t.T(), first.ID, revisions[0].ID)
+
+	// revisions of other notes are not found
+	_, err = queries.GetRevision(note.ID+1, first.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	pruned, err := queries.PruneRevisions(note.ID, 2)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(1), pruned)
+
+	pruned, err = queries.PruneUserRevisions(u.ID, 1)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(1), pruned)
+
+	revisions, err = queries.GetRevisions(note.ID, 0, 10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), revisions, 1)
+	assert.Equal(t.T(), note.Version, revisions[0].Version)
+}
+
+func (t *queriesSuiteTest) TestSettings() {
+	u, _ := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	// users who never saved their settings get the defaults
+	settings, err := queries.GetSettings(u.ID)
+	require.NoError(t.T(), err)
+	assert.Nil(t.T(), settings.RevisionLimit)
+
+	limit := 10
+	_, err = queries.SaveSettings(&entity.NoteSettings{UserID: u.ID, RevisionLimit: &limit})
+	require.NoError(t.T(), err)
+
+	settings, err = queries.GetSettings(u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 10, *settings.RevisionLimit)
+
+	settings, err = queries.SaveSettings(&entity.NoteSettings{UserID: u.ID})
+	require.NoError(t.T(), err)
+	assert.Nil(t.T(), settings.RevisionLimit)
+}
+
+func (t *queriesSuiteTest) TestShares() {
+	owner, note := t.createNote()
+
+	users := test.GenerateUsers(3)
+	reader, err := user.NewUserQueries(t.DB, t.TX).CreateUser(&users[1])
+	require.NoError(t.T(), err)
+	stranger, err := user.NewUserQueries(t.DB, t.TX).CreateUser(&users[2])
+	require.NoError(t.T(), err)
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	share, err := queries.ShareNote(note.ID, "USER02@example.com", entity.PermissionRead, &owner.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), reader.ID, share.UserID)
+	assert.Equal(t.T(), reader.Email, share.Email)
+
+	_, err = queries.ShareNote(note.ID, "nobody@example.com", entity.PermissionRead, &owner.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	shared, err := queries.GetAccessibleNote(reader.ID, note.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.PermissionRead, shared.Permission)
+
+	owned, err := queries.GetAccessibleNote(owner.ID, note.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.PermissionOwner, owned.Permission)
+
+	_, err = queries.GetAccessibleNote(stranger.ID, note.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	// sharing again changes the permission
+	again, err := queries.ShareNote(note.ID, reader.Email, entity.PermissionEdit, &owner.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), share.ID, again.ID)
+
+	notes, err := queries.GetSharedNotes(reader.ID, 0, 10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), notes, 1)
+	assert.Equal(t.T(), entity.PermissionEdit, notes[0].Permission)
+
+	count, err := queries.CountShared(reader.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 1, count)
+
+	require.NoError(t.T(), queries.DeleteShare(note.ID, share.ID))
+	assert.ErrorIs(t.T(), queries.DeleteShare(note.ID, share.ID), sql.ErrNoRows)
+
+	_, err = queries.GetAccessibleNote(reader.ID, note.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+}
+
+func (t *queriesSuiteTest) TestLinks() {
+	owner, note := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	link, err := queries.CreateLink(&entity.NoteLink{NoteID: note.ID, TokenHash: "hash", CreatedBy: &owner.ID})
+	require.NoError(t.T(), err)
+	assert.False(t.T(), link.HasPassword())
+	assert.False(t.T(), link.ExpiresAt.Valid)
+
+	found, err := queries.GetLinkByToken("hash")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), link.ID, found.ID)
+	assert.Equal(t.T(), owner.ID, found.OwnerID)
+
+	links, err := queries.GetLinks(note.ID)
+	require.NoError(t.T(), err)
+	assert.Len(t.T(), links, 1)
+
+	require.NoError(t.T(), queries.DeleteLink(note.ID, link.ID))
+
+	_, err = queries.GetLinkByToken("hash")
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+}
+
+func (t *queriesSuiteTest) TestTrash() {
+	u, note := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	require.NoError(t.T(), queries.DeleteNote(u.ID, note.ID, note.Version))
+
+	// the notes in the trash are only read from the trash
+	_, err := queries.GetNote(u.ID, note.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	count, err := queries.Count(u.ID, TagFilter{})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 0, count)
+
+	trashed, err := queries.GetTrash(u.ID, 0, 10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), trashed, 1)
+	assert.True(t.T(), trashed[0].DeletedAt.Valid)
+
+	restored, err := queries.RestoreNote(u.ID, note.ID)
+	require.NoError(t.T(), err)
+	assert.False(t.T(), restored.DeletedAt.Valid)
+	assert.Equal(t.T(), note.Version+2, restored.Version)
+
+	// only the notes in the trash can be purged
+	assert.ErrorIs(t.T(), queries.PurgeNote(u.ID, note.ID), sql.ErrNoRows)
+
+	require.NoError(t.T(), queries.DeleteNote(u.ID, note.ID, 0))
+
+	purged, err := queries.PurgeTrash(time.Now().Add(-time.Hour))
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), purged)
+
+	purged, err = queries.EmptyTrash(u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), []PurgedNote{{ID: note.ID, UserID: u.ID}}, purged)
+
+	count, err = queries.CountTrash(u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 0, count)
+}
+
+func (t *queriesSuiteTest) TestAttachments() {
+	u, note := t.createNote()
+
+	queries := NewNoteQueries(t.DB, t.TX)
+
+	attachment, err := queries.CreateAttachment(&entity.NoteAttachment{
+		NoteID:      note.ID,
+		UserID:      u.ID,
+		Filename:    "list.txt",
+		ContentType: "text/plain; charset=utf-8",
+		Size:        5,
+		Checksum:    "checksum",
+		StorageKey:  "attachments/1/a",
+	})
+	require.NoError(t.T(), err)
+
+	attachments, err := queries.GetAttachments(note.ID)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), attachments, 1)
+	assert.Equal(t.T(), "list.txt", attachments[0].Filename)
+
+	require.NoError(t.T(), queries.LockAttachments(u.ID))
+
+	usage, err := queries.AttachmentUsage(u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(5), usage)
+
+	stored, err := queries.GetStoredKeys([]string{"attachments/1/a", "attachments/1/b"})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), []string{"attachments/1/a"}, stored)
+
+	// attachments are read through their note
+	_, err = queries.GetAttachment(note.ID+1, attachment.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	deleted, err := queries.DeleteAttachment(note.ID, attachment.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "attachments/1/a", deleted.StorageKey)
+
+	_, err = queries.DeleteAttachment(note.ID, attachment.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	usage, err = queries.AttachmentUsage(u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(0), usage)
+}
+
+func TestHighlight(t *testing.T) {
+	headline := highlightStart + "eggs" + highlightStop + ` <img src=x onerror="alert(1)">`
+
+	assert.Equal(t, `<mark>eggs</mark> &lt;img src=x onerror=&#34;alert(1)&#34;&gt;`, highlight(headline))
+}
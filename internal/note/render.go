@@ -0,0 +1,187 @@
+package note
+
+import (
+	"container/list"
+	"errors"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/pkg/markdown"
+)
+
+// DefaultRenderCacheSize is the number of rendered notes kept in memory when
+// the server does not set one.
+const DefaultRenderCacheSize = 1000
+
+// RenderHTML is the value of the render parameter of the note reads
+// returning the HTML of the note.
+const RenderHTML = "html"
+
+// Rendered is the content of a note rendered to HTML. Headings are the
+// headings of a table of contents, they are only found in markdown.
+type Rendered struct {
+	Format   string             `json:"format"`
+	HTML     string             `json:"html"`
+	Headings []markdown.Heading `json:"headings"`
+}
+
+// formatRule validates the content format of note attrs. The attrs are not
+// read with validation.Indirect, it would read them as driver.Valuer.
+var formatRule = validation.By(func(value interface{}) error {
+	var attrs entity.NoteAttrs
+
+	switch v := value.(type) {
+	case entity.NoteAttrs:
+		attrs = v
+	case *entity.NoteAttrs:
+		if v == nil {
+			return nil
+		}
+		attrs = *v
+	default:
+		return nil
+	}
+
+	if err := validation.Validate(attrs.Format, validation.In(entity.FormatPlain, entity.FormatMarkdown)); err != nil {
+		return validation.Errors{"format": err}
+	}
+
+	return nil
+})
+
+// renderFromRequest reports whether the request asks for the HTML of the
+// notes with ?render=html.
+func renderFromRequest(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("render") {
+	case "":
+		return false, nil
+	case RenderHTML:
+		return true, nil
+	default:
+		return false, errors.New("render must be html")
+	}
+}
+
+// renderVariant returns the ETag variant of a note read with its HTML or
+// not, the two responses differ for the same version.
+func renderVariant(html bool) string {
+	if html {
+		return RenderHTML
+	}
+	return ""
+}
+
+// Render implements Service
+//
+// The versions of the notes are immutable, the rendered ones are cached by
+// note version.
+func (s service) Render(note *entity.Note) (Rendered, error) {
+	key := renderKey{note.ID, note.Version}
+
+	if rendered, ok := s.rendered.get(key); ok {
+		return rendered, nil
+	}
+
+	rendered, err := renderContent(note.Attrs.Format, note.Content)
+	if err != nil {
+		return Rendered{}, err
+	}
+
+	s.rendered.add(key, rendered)
+
+	return rendered, nil
+}
+
+// renderContent renders content in format, plain text when it is empty.
+func renderContent(format string, content string) (Rendered, error) {
+	if format != entity.FormatMarkdown {
+		return Rendered{Format: entity.FormatPlain, HTML: renderPlain(content), Headings: []markdown.Heading{}}, nil
+	}
+
+	doc, err := markdown.Render(content)
+	if err != nil {
+		return Rendered{}, err
+	}
+
+	return Rendered{Format: entity.FormatMarkdown, HTML: doc.HTML, Headings: doc.Headings}, nil
+}
+
+// renderPlain escapes text into paragraphs, separated by blank lines, and
+// keeps its line breaks.
+func renderPlain(text string) string {
+	var sb strings.Builder
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.Trim(paragraph, "\n")
+		if strings.TrimSpace(paragraph) == "" {
+			continue
+		}
+
+		sb.WriteString("<p>")
+		sb.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>\n"))
+		sb.WriteString("</p>\n")
+	}
+
+	return sb.String()
+}
+
+type renderKey struct {
+	id      int64
+	version int64
+}
+
+type renderEntry struct {
+	key      renderKey
+	rendered Rendered
+}
+
+// renderCache keeps the last used rendered notes, up to its size. It is
+// safe for concurrent use.
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	entries map[renderKey]*list.Element
+}
+
+func newRenderCache(size int) *renderCache {
+	return &renderCache{size: size, order: list.New(), entries: map[renderKey]*list.Element{}}
+}
+
+func (c *renderCache) get(key renderKey) (Rendered, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return Rendered{}, false
+	}
+
+	c.order.MoveToFront(e)
+
+	return e.Value.(*renderEntry).rendered, true
+}
+
+func (c *renderCache) add(key renderKey, rendered Rendered) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&renderEntry{key, rendered})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*renderEntry).key)
+	}
+}
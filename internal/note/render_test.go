@@ -0,0 +1,52 @@
+package note
+
+import (
+	"testing"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	s := service{rendered: newRenderCache(1)}
+
+	note := &entity.Note{Content: "<b>bold</b>\nline\n\n\nnext", Attrs: entity.NoteAttrs{}}
+	note.ID, note.Version = 1, 1
+
+	rendered, err := s.Render(note)
+	require.NoError(t, err)
+	assert.Equal(t, entity.FormatPlain, rendered.Format)
+	assert.Equal(t, "<p>&lt;b&gt;bold&lt;/b&gt;<br>\nline</p>\n<p>next</p>\n", rendered.HTML)
+
+	// the versions of a note are rendered once
+	note.Content = "changed"
+	rendered, err = s.Render(note)
+	require.NoError(t, err)
+	assert.Contains(t, rendered.HTML, "bold")
+
+	note.Version = 2
+	note.Attrs.Format = entity.FormatMarkdown
+	note.Content = "# Title"
+	rendered, err = s.Render(note)
+	require.NoError(t, err)
+	assert.Equal(t, entity.FormatMarkdown, rendered.Format)
+	assert.Equal(t, "<h1 id=\"title\">Title</h1>\n", rendered.HTML)
+	assert.Len(t, rendered.Headings, 1)
+
+	// the least recently used versions are evicted
+	_, ok := s.rendered.get(renderKey{1, 1})
+	assert.False(t, ok)
+}
+
+func TestFormatRule(t *testing.T) {
+	markdown := entity.NoteAttrs{Format: entity.FormatMarkdown}
+
+	assert.NoError(t, CreateNoteRequest{Title: "a", Attrs: markdown}.Validate())
+	assert.NoError(t, CreateNoteRequest{Title: "a"}.Validate())
+	assert.Error(t, CreateNoteRequest{Title: "a", Attrs: entity.NoteAttrs{Format: "html"}}.Validate())
+
+	assert.NoError(t, UpdateNoteRequest{}.Validate())
+	assert.NoError(t, UpdateNoteRequest{Attrs: &markdown}.Validate())
+	assert.Error(t, UpdateNoteRequest{Attrs: &entity.NoteAttrs{Format: "html"}}.Validate())
+}
@@ -0,0 +1,250 @@
+package note
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/opaulochaves/myserver/pkg/diff"
+	"github.com/opaulochaves/myserver/pkg/etag"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+	"github.com/pkg/errors"
+)
+
+// Audited event of the note settings.
+const (
+	EventSettingsUpdated = "note_settings.updated"
+
+	targetSettings = "note_settings"
+)
+
+const (
+	// DefaultRevisionLimit is the number of revisions kept per note when
+	// neither the server nor the user sets one
+	DefaultRevisionLimit = 50
+	// MaxRevisionLimit is the largest number of revisions a user can keep
+	MaxRevisionLimit = 1000
+	// DiffContext is the number of unchanged lines around the changes of a
+	// unified diff
+	DiffContext = 3
+)
+
+type RevisionResponse struct {
+	entity.NoteRevision
+}
+
+// Render implements render.Renderer
+func (*RevisionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// RevisionDiff compares two revisions of a note, line by line. Unified is
+// the comparison of the contents as a unified diff.
+type RevisionDiff struct {
+	From    *entity.NoteRevision `json:"from"`
+	To      *entity.NoteRevision `json:"to"`
+	Title   []diff.Line          `json:"title"`
+	Content []diff.Line          `json:"content"`
+	Unified string               `json:"unified"`
+}
+
+// Render implements render.Renderer
+func (*RevisionDiff) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// SettingsRequest represents a change of the note settings. A null
+// revision_limit restores the default of the server.
+type SettingsRequest struct {
+	RevisionLimit *int `json:"revision_limit"`
+}
+
+// Bind implements render.Binder
+func (*SettingsRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the SettingsRequest fields.
+func (c SettingsRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.RevisionLimit, validation.Min(1), validation.Max(MaxRevisionLimit)),
+	)
+}
+
+// SettingsResponse represents the note settings of a user.
+type SettingsResponse struct {
+	entity.NoteSettings
+	// DefaultRevisionLimit applies while RevisionLimit is null
+	DefaultRevisionLimit int `json:"default_revision_limit"`
+}
+
+// Render implements render.Renderer
+func (*SettingsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// addRevision saves note as a revision authored by the actor of ctx, and
+// deletes the revisions past the limit of its user.
+func (s service) addRevision(ctx context.Context, repo NoteQueries, note *entity.Note) error {
+	if _, err := repo.CreateRevision(note, audit.FromContext(ctx).ActorID); err != nil {
+		return err
+	}
+
+	settings, err := repo.GetSettings(note.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.PruneRevisions(note.ID, s.revisionLimit(settings))
+
+	return err
+}
+
+// revisionLimit returns the number of revisions kept per note with settings.
+func (s service) revisionLimit(settings *entity.NoteSettings) int {
+	if settings.RevisionLimit != nil {
+		return *settings.RevisionLimit
+	}
+	return s.opts.RevisionLimit
+}
+
+// Revisions implements Service
+func (s service) Revisions(userID int64, id int64, page *pagination.Cursor) error {
+	if _, err := s.Get(userID, id); err != nil {
+		return err
+	}
+
+	revisions, err := s.repo.GetRevisions(id, page.After, page.Fetch())
+	if err != nil {
+		return err
+	}
+
+	n := page.Page(len(revisions), func(i int) int64 { return revisions[i].ID })
+
+	page.Items = revisions[:n]
+
+	return nil
+}
+
+// Revision implements Service
+func (s service) Revision(userID int64, id int64, revisionID int64) (entity.NoteRevision, error) {
+	if _, err := s.Get(userID, id); err != nil {
+		return entity.NoteRevision{}, err
+	}
+
+	revision, err := s.repo.GetRevision(id, revisionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.NoteRevision{}, apperrors.NewNotFound("revision", fmt.Sprint(revisionID))
+		}
+		return entity.NoteRevision{}, err
+	}
+
+	return *revision, nil
+}
+
+// Diff implements Service
+func (s service) Diff(userID int64, id int64, from int64, to int64) (RevisionDiff, error) {
+	a, err := s.Revision(userID, id, from)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+
+	b, err := s.Revision(userID, id, to)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+
+	content := diff.Lines(a.Content, b.Content)
+
+	return RevisionDiff{
+		From:    &a,
+		To:      &b,
+		Title:   diff.Lines(a.Title, b.Title),
+		Content: content,
+		Unified: diff.Unified(fmt.Sprintf("version %d", a.Version), fmt.Sprintf("version %d", b.Version), content, DiffContext),
+	}, nil
+}
+
+// RestoreRevision implements Service
+func (s service) RestoreRevision(ctx context.Context, userID int64, id int64, revisionID int64, version int64) (Note, error) {
+	before, err := s.authorize(userID, id, entity.PermissionEdit)
+	if err != nil {
+		return Note{}, err
+	}
+
+	if version != etag.Any && version != before.Version {
+		return Note{}, etag.Mismatch()
+	}
+
+	revision, err := s.Revision(userID, id, revisionID)
+	if err != nil {
+		return Note{}, err
+	}
+
+	changes := *before.Note
+	changes.Version = version
+	changes.Title = revision.Title
+	changes.Content = revision.Content
+	changes.Attrs = revision.Attrs
+
+	return s.save(ctx, before, changes, entity.JSONMap{"restored_revision": revision.ID, "restored_version": revision.Version})
+}
+
+// Settings implements Service
+func (s service) Settings(userID int64) (SettingsResponse, error) {
+	settings, err := s.repo.GetSettings(userID)
+	if err != nil {
+		return SettingsResponse{}, err
+	}
+
+	return SettingsResponse{*settings, s.opts.RevisionLimit}, nil
+}
+
+// UpdateSettings implements Service
+//
+// Lowering the revision limit deletes the revisions past it right away.
+func (s service) UpdateSettings(ctx context.Context, userID int64, input SettingsRequest) (SettingsResponse, error) {
+	if err := input.Validate(); err != nil {
+		return SettingsResponse{}, err
+	}
+
+	before, err := s.repo.GetSettings(userID)
+	if err != nil {
+		return SettingsResponse{}, err
+	}
+
+	var after *entity.NoteSettings
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		if after, err = repo.SaveSettings(&entity.NoteSettings{UserID: userID, RevisionLimit: input.RevisionLimit}); err != nil {
+			return err
+		}
+
+		pruned, err := repo.PruneUserRevisions(userID, s.revisionLimit(after))
+		if err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventSettingsUpdated, targetSettings, userID)
+		event.Changes = audit.Diff(before, after)
+		event.Details = entity.JSONMap{"pruned_revisions": pruned}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return SettingsResponse{}, err
+	}
+
+	return SettingsResponse{*after, s.opts.RevisionLimit}, nil
+}
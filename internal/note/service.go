@@ -0,0 +1,453 @@
+package note
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	"github.com/opaulochaves/myserver/internal/storage"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/opaulochaves/myserver/pkg/etag"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+	"github.com/pkg/errors"
+)
+
+// Audited events of the note service, they are also published to the
+// outbox.
+const (
+	EventNoteCreated = "note.created"
+	EventNoteUpdated = "note.updated"
+	EventNoteDeleted = "note.deleted"
+
+	targetNote = "note"
+)
+
+// Service manages the notes of a user. Changes are recorded in the audit
+// log, in the same transaction, with the actor and request found in ctx.
+//
+// Notes can be shared with other users: userID is the user acting, the
+// service checks their permission on the note. Listings and tags only cover
+// the notes of the user.
+type Service interface {
+	// Get reads a note of the user or shared with them, with the permission
+	// of the user
+	Get(userID int64, id int64) (Note, error)
+	Query(userID int64, filter TagFilter, offset int, limit int) ([]Note, error)
+	Count(userID int64, filter TagFilter) (int, error)
+	// Search reads the notes matching a full-text search, best first, with
+	// highlights of the matched words
+	Search(userID int64, input SearchRequest, offset int, limit int) ([]entity.NoteMatch, error)
+	CountMatches(userID int64, input SearchRequest) (int, error)
+	Create(ctx context.Context, userID int64, input CreateNoteRequest) (Note, error)
+	// Update and Delete require the current version of the note, or etag.Any.
+	// Editors can update a note, only its owner can delete it: Delete moves
+	// it to the trash
+	Update(ctx context.Context, userID int64, id int64, input UpdateNoteRequest, version int64) (Note, error)
+	Delete(ctx context.Context, userID int64, id int64, version int64) error
+	// Tags reads the tags of a user with the number of notes they label
+	Tags(userID int64) ([]entity.TagCount, error)
+	// AddTags and RemoveTag change the tags of a note, tags are created as
+	// they are first added
+	AddTags(ctx context.Context, userID int64, id int64, input TagNoteRequest) (Note, error)
+	RemoveTag(ctx context.Context, userID int64, id int64, tagID int64) (Note, error)
+	RenameTag(ctx context.Context, userID int64, tagID int64, input RenameTagRequest) (entity.Tag, error)
+	// MergeTag moves the notes of a tag to another one and deletes it, the
+	// tag merged into is returned
+	MergeTag(ctx context.Context, userID int64, tagID int64, input MergeTagRequest) (entity.Tag, error)
+	DeleteTag(ctx context.Context, userID int64, tagID int64) error
+	// Revisions reads a page of the revisions of a note, newest first. Every
+	// change of the title, content or attrs of a note adds a revision
+	Revisions(userID int64, id int64, page *pagination.Cursor) error
+	Revision(userID int64, id int64, revisionID int64) (entity.NoteRevision, error)
+	// Diff compares the revision from of a note to the revision to
+	Diff(userID int64, id int64, from int64, to int64) (RevisionDiff, error)
+	// RestoreRevision saves the content of a revision as a new version of
+	// the note, it requires the current version of the note, or etag.Any
+	RestoreRevision(ctx context.Context, userID int64, id int64, revisionID int64, version int64) (Note, error)
+	Settings(userID int64) (SettingsResponse, error)
+	UpdateSettings(ctx context.Context, userID int64, input SettingsRequest) (SettingsResponse, error)
+	// SharedWithMe reads the notes other users shared with the user
+	SharedWithMe(userID int64, offset int, limit int) ([]Note, error)
+	CountSharedWithMe(userID int64) (int, error)
+	// Shares reads the users a note is shared with, Share shares it with
+	// another one and Unshare revokes a share. Only the owner manages the
+	// shares, a user can revoke their own
+	Shares(userID int64, id int64) ([]entity.NoteShare, error)
+	Share(ctx context.Context, userID int64, id int64, input ShareRequest) (entity.NoteShare, error)
+	Unshare(ctx context.Context, userID int64, id int64, shareID int64) error
+	// Links reads the public links to a note, they are managed by its owner
+	Links(userID int64, id int64) ([]entity.NoteLink, error)
+	CreateLink(ctx context.Context, userID int64, id int64, input LinkRequest) (LinkResponse, error)
+	DeleteLink(ctx context.Context, userID int64, id int64, linkID int64) error
+	// ReadLink reads the note of a public link, password is required by the
+	// links protected by one
+	ReadLink(token string, password string, client auth.Client) (PublicNote, error)
+	// Trash reads the notes of the user in the trash, the last deleted first
+	Trash(userID int64, offset int, limit int) ([]Note, error)
+	CountTrash(userID int64) (int, error)
+	// RestoreNote moves a note out of the trash
+	RestoreNote(ctx context.Context, userID int64, id int64) (Note, error)
+	// PurgeNote deletes a note of the trash permanently, EmptyTrash deletes
+	// all of them and returns how many
+	PurgeNote(ctx context.Context, userID int64, id int64) error
+	EmptyTrash(ctx context.Context, userID int64) (int64, error)
+	// PurgeTrash deletes permanently the notes in the trash for longer than
+	// retention, it returns how many
+	PurgeTrash(retention time.Duration) (int64, error)
+	// Render renders the content of a note to sanitised HTML, in the format
+	// of its attrs, with the headings of a table of contents
+	Render(note *entity.Note) (Rendered, error)
+	// Attachments reads the files attached to a note, OpenAttachment opens
+	// the content of one of them
+	Attachments(userID int64, id int64) ([]entity.NoteAttachment, error)
+	Attachment(userID int64, id int64, attachmentID int64) (entity.NoteAttachment, error)
+	OpenAttachment(ctx context.Context, userID int64, id int64, attachmentID int64) (entity.NoteAttachment, storage.Object, error)
+	// Attach attaches the content of r to a note, editors can attach files.
+	// The attachments count against the quota of the owner of the note
+	Attach(ctx context.Context, userID int64, id int64, filename string, r io.Reader) (entity.NoteAttachment, error)
+	DeleteAttachment(ctx context.Context, userID int64, id int64, attachmentID int64) error
+	// Usage reads the size of the attachments of the user and their quota
+	Usage(userID int64) (UsageResponse, error)
+	// PurgeOrphanedBlobs deletes the blobs of the storage without an
+	// attachment and older than grace, it returns how many
+	PurgeOrphanedBlobs(ctx context.Context, grace time.Duration) (int64, error)
+	// Export writes the notes of the user to w as a ZIP archive of Markdown
+	// files with a YAML front matter, Import creates notes from such an
+	// archive and reports the files it refused
+	Export(ctx context.Context, userID int64, w io.Writer) error
+	Import(ctx context.Context, userID int64, r io.Reader) (ImportResult, error)
+}
+
+// Note represents the data about a note.
+type Note struct {
+	*entity.Note
+}
+
+type NoteResponse struct {
+	Note
+	// Rendered is only set when the HTML of the note is requested
+	Rendered *Rendered `json:"rendered,omitempty"`
+}
+
+// Render implements render.Renderer
+func (n *NoteResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// CreateNoteRequest represents a note creation request. It also replaces
+// a note on PUT.
+type CreateNoteRequest struct {
+	Title   string           `json:"title"`
+	Content string           `json:"content"`
+	Attrs   entity.NoteAttrs `json:"attrs"`
+}
+
+// Bind implements render.Binder
+func (*CreateNoteRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the CreateNoteRequest fields.
+func (c CreateNoteRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Title, validation.Required, validation.Length(1, 255)),
+		validation.Field(&c.Attrs, formatRule),
+	)
+}
+
+// UpdateNoteRequest represents a partial note update, nil fields are left
+// unchanged.
+type UpdateNoteRequest struct {
+	Title   *string           `json:"title"`
+	Content *string           `json:"content"`
+	Attrs   *entity.NoteAttrs `json:"attrs"`
+}
+
+// Bind implements render.Binder
+func (*UpdateNoteRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the UpdateNoteRequest fields.
+func (c UpdateNoteRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Title, validation.NilOrNotEmpty, validation.Length(1, 255)),
+		validation.Field(&c.Attrs, formatRule),
+	)
+}
+
+// SearchRequest represents a full-text search of the notes. Query has the
+// syntax of web search engines: "quoted phrases", or, -excluded words.
+type SearchRequest struct {
+	Query string
+}
+
+// Validate validates the SearchRequest fields.
+func (c SearchRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Query, validation.Required, validation.Length(1, 255)),
+	)
+}
+
+// Options configures the note service.
+type Options struct {
+	// Language is the text search configuration of the new notes and of the
+	// search queries (eg, "english", "simple")
+	Language string
+	// RevisionLimit is the number of revisions kept per note of the users
+	// who did not set theirs
+	RevisionLimit int
+	// RenderCacheSize is the number of rendered notes kept in memory
+	RenderCacheSize int
+	// Storage keeps the files attached to the notes
+	Storage storage.Storage
+	// AttachmentMaxSize is the largest attachment and AttachmentQuota the
+	// size of the attachments of a user, in bytes
+	AttachmentMaxSize int64
+	AttachmentQuota   int64
+	// ImportMaxSize is the largest archive imported, in bytes
+	ImportMaxSize int64
+	// Throttle limits the password attempts on the links, there is no limit
+	// without it
+	Throttle Throttle
+}
+
+// Throttle refuses the clients which failed too many attempts.
+type Throttle interface {
+	// CheckClient returns a too many requests error once client is refused
+	CheckClient(client auth.Client) error
+	// RecordFailure counts a failed attempt of client on subject
+	RecordFailure(client auth.Client, subject string)
+}
+
+type service struct {
+	db       *sqlx.DB
+	repo     NoteQueries
+	opts     Options
+	rendered *renderCache
+}
+
+func NewService(db *sqlx.DB, repo NoteQueries, opts Options) Service {
+	if opts.Language == "" {
+		opts.Language = DefaultLanguage
+	}
+	if opts.RevisionLimit <= 0 {
+		opts.RevisionLimit = DefaultRevisionLimit
+	}
+	if opts.RenderCacheSize <= 0 {
+		opts.RenderCacheSize = DefaultRenderCacheSize
+	}
+	if opts.AttachmentMaxSize <= 0 {
+		opts.AttachmentMaxSize = DefaultAttachmentMaxSize
+	}
+	if opts.AttachmentQuota <= 0 {
+		opts.AttachmentQuota = DefaultAttachmentQuota
+	}
+	if opts.ImportMaxSize <= 0 {
+		opts.ImportMaxSize = DefaultImportMaxSize
+	}
+	return service{db, repo, opts, newRenderCache(opts.RenderCacheSize)}
+}
+
+// Get implements Service
+func (s service) Get(userID int64, id int64) (Note, error) {
+	note, err := s.repo.GetAccessibleNote(userID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Note{}, apperrors.NewNotFound("note", fmt.Sprint(id))
+		}
+		return Note{}, err
+	}
+
+	return Note{note}, nil
+}
+
+// Query implements Service
+func (s service) Query(userID int64, filter TagFilter, offset int, limit int) ([]Note, error) {
+	notes, err := s.repo.GetNotes(userID, filter, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Note{}
+
+	for i := range notes {
+		result = append(result, Note{&notes[i]})
+	}
+
+	return result, nil
+}
+
+// Count implements Service
+func (s service) Count(userID int64, filter TagFilter) (int, error) {
+	return s.repo.Count(userID, filter)
+}
+
+// Search implements Service
+func (s service) Search(userID int64, input SearchRequest, offset int, limit int) ([]entity.NoteMatch, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s.repo.Search(userID, input.Query, s.opts.Language, offset, limit)
+}
+
+// CountMatches implements Service
+func (s service) CountMatches(userID int64, input SearchRequest) (int, error) {
+	if err := input.Validate(); err != nil {
+		return 0, err
+	}
+
+	return s.repo.CountMatches(userID, input.Query, s.opts.Language)
+}
+
+// Create implements Service
+func (s service) Create(ctx context.Context, userID int64, input CreateNoteRequest) (Note, error) {
+	if err := input.Validate(); err != nil {
+		return Note{}, err
+	}
+
+	var note *entity.Note
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		var err error
+		note, err = repo.CreateNote(&entity.Note{
+			Title:    input.Title,
+			Content:  input.Content,
+			UserID:   userID,
+			Attrs:    input.Attrs,
+			Language: s.opts.Language,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := s.addRevision(ctx, repo, note); err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteCreated, targetNote, note.ID, note); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteCreated, targetNote, note.ID)
+		event.Changes = audit.Diff(nil, note)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return Note{}, err
+	}
+
+	return Note{note}, nil
+}
+
+// Update implements Service
+func (s service) Update(ctx context.Context, userID int64, id int64, input UpdateNoteRequest, version int64) (Note, error) {
+	if err := input.Validate(); err != nil {
+		return Note{}, err
+	}
+
+	before, err := s.authorize(userID, id, entity.PermissionEdit)
+	if err != nil {
+		return Note{}, err
+	}
+
+	if version != etag.Any && version != before.Version {
+		return Note{}, etag.Mismatch()
+	}
+
+	changes := *before.Note
+	changes.Version = version
+	if input.Title != nil {
+		changes.Title = *input.Title
+	}
+	if input.Content != nil {
+		changes.Content = *input.Content
+	}
+	if input.Attrs != nil {
+		changes.Attrs = *input.Attrs
+	}
+
+	return s.save(ctx, before, changes, nil)
+}
+
+// save writes changes to the note before and adds a revision of the new
+// version. details are added to the audit event.
+func (s service) save(ctx context.Context, before Note, changes entity.Note, details entity.JSONMap) (Note, error) {
+	var after *entity.Note
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		var err error
+		if after, err = repo.UpdateNote(&changes); err != nil {
+			// updated or deleted since it was read
+			if errors.Is(err, sql.ErrNoRows) {
+				return etag.Mismatch()
+			}
+			return err
+		}
+
+		if err := s.addRevision(ctx, repo, after); err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteUpdated, targetNote, after.ID, after); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteUpdated, targetNote, after.ID)
+		event.Changes = audit.Diff(before.Note, after)
+		if details != nil {
+			event.Details = details
+		}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return Note{}, err
+	}
+
+	return Note{after}, nil
+}
+
+// Delete implements Service
+func (s service) Delete(ctx context.Context, userID int64, id int64, version int64) error {
+	before, err := s.authorize(userID, id, entity.PermissionOwner)
+	if err != nil {
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewNoteQueries(s.db, tx).DeleteNote(userID, id, version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return etag.Mismatch()
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteDeleted, targetNote, id, before.Note); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteDeleted, targetNote, id)
+		event.Changes = audit.Diff(before.Note, nil)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+}
@@ -0,0 +1,450 @@
+package note
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+// Audited events of the sharing of notes, they are also published to the
+// outbox. Their target is the shared note.
+const (
+	EventNoteShared      = "note.shared"
+	EventNoteUnshared    = "note.unshared"
+	EventNoteLinkCreated = "note.link_created"
+	EventNoteLinkDeleted = "note.link_deleted"
+)
+
+// linkTokenSize is the number of random bytes of a link token.
+const linkTokenSize = 32
+
+// sharePayload is a share as it is published to the outbox. UserID is the
+// owner of the note, so the events reach the webhooks of the owner, and
+// RecipientID the user the note is shared with.
+type sharePayload struct {
+	ID          int64  `json:"id"`
+	NoteID      int64  `json:"note_id"`
+	UserID      int64  `json:"user_id"`
+	RecipientID int64  `json:"recipient_id"`
+	Permission  string `json:"permission"`
+}
+
+func newSharePayload(ownerID int64, share *entity.NoteShare) sharePayload {
+	return sharePayload{share.ID, share.NoteID, ownerID, share.UserID, share.Permission}
+}
+
+// linkPayload is a link as it is published to the outbox, UserID is the
+// owner of the note.
+type linkPayload struct {
+	ID        int64            `json:"id"`
+	NoteID    int64            `json:"note_id"`
+	UserID    int64            `json:"user_id"`
+	Protected bool             `json:"protected"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+func newLinkPayload(ownerID int64, link *entity.NoteLink) linkPayload {
+	return linkPayload{link.ID, link.NoteID, ownerID, link.HasPassword(), link.ExpiresAt}
+}
+
+// ShareRequest represents a request sharing a note with the user whose
+// email is Email. Sharing it again changes the permission.
+type ShareRequest struct {
+	Email      string `json:"email"`
+	Permission string `json:"permission"`
+}
+
+// Bind implements render.Binder
+func (*ShareRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the ShareRequest fields.
+func (c ShareRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Email, validation.Required, is.Email),
+		validation.Field(&c.Permission, validation.Required, validation.In(entity.PermissionRead, entity.PermissionEdit)),
+	)
+}
+
+type ShareResponse struct {
+	entity.NoteShare
+}
+
+// Render implements render.Renderer
+func (*ShareResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// LinkRequest represents a request creating a public link to a note. The
+// link never expires without ExpiresAt and requires no password without
+// Password.
+type LinkRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	Password  string     `json:"password"`
+}
+
+// Bind implements render.Binder
+func (*LinkRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the LinkRequest fields.
+func (c LinkRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.ExpiresAt, validation.By(func(value interface{}) error {
+			if t, _ := value.(*time.Time); t != nil && !t.After(time.Now()) {
+				return errors.New("must be in the future")
+			}
+			return nil
+		})),
+		validation.Field(&c.Password, validation.Length(8, 100)),
+	)
+}
+
+// LinkResponse represents a public link. Token is only set in the response
+// creating the link, it cannot be read again.
+type LinkResponse struct {
+	entity.NoteLink
+	Protected bool   `json:"protected"`
+	Token     string `json:"token,omitempty"`
+}
+
+// Render implements render.Renderer
+//
+// The response carrying the token must not be cached nor stored for
+// idempotent replays.
+func (l *LinkResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	if l.Token != "" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	return nil
+}
+
+// PublicNote is a note as it is read through a public link.
+type PublicNote struct {
+	Title     string           `json:"title"`
+	Content   string           `json:"content"`
+	Attrs     entity.NoteAttrs `json:"attrs"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	UpdatedAt pgtype.Timestamp `json:"updated_at"`
+	Version   int64            `json:"version"`
+	// Rendered is only set when the HTML of the note is requested
+	Rendered *Rendered `json:"rendered,omitempty"`
+
+	note *entity.Note
+}
+
+// Render implements render.Renderer
+func (*PublicNote) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// allows reports whether the permission have includes the permission need.
+func allows(have string, need string) bool {
+	switch need {
+	case entity.PermissionRead:
+		return have != ""
+	case entity.PermissionEdit:
+		return have == entity.PermissionEdit || have == entity.PermissionOwner
+	default:
+		return have == entity.PermissionOwner
+	}
+}
+
+// authorize returns the note id when the user has the permission on it.
+// The notes the user has no access to are not found.
+func (s service) authorize(userID int64, id int64, permission string) (Note, error) {
+	note, err := s.Get(userID, id)
+	if err != nil {
+		return Note{}, err
+	}
+
+	if !allows(note.Permission, permission) {
+		if permission == entity.PermissionOwner {
+			return Note{}, apperrors.NewForbidden("Only the owner of the note can do this")
+		}
+		return Note{}, apperrors.NewForbidden(fmt.Sprintf("You need the %s permission on the note", permission))
+	}
+
+	return note, nil
+}
+
+// SharedWithMe implements Service
+func (s service) SharedWithMe(userID int64, offset int, limit int) ([]Note, error) {
+	notes, err := s.repo.GetSharedNotes(userID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Note{}
+
+	for i := range notes {
+		result = append(result, Note{&notes[i]})
+	}
+
+	return result, nil
+}
+
+// CountSharedWithMe implements Service
+func (s service) CountSharedWithMe(userID int64) (int, error) {
+	return s.repo.CountShared(userID)
+}
+
+// Shares implements Service
+func (s service) Shares(userID int64, id int64) ([]entity.NoteShare, error) {
+	if _, err := s.authorize(userID, id, entity.PermissionOwner); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetShares(id)
+}
+
+// Share implements Service
+func (s service) Share(ctx context.Context, userID int64, id int64, input ShareRequest) (entity.NoteShare, error) {
+	input.Email = strings.TrimSpace(input.Email)
+
+	if err := input.Validate(); err != nil {
+		return entity.NoteShare{}, err
+	}
+
+	if _, err := s.authorize(userID, id, entity.PermissionOwner); err != nil {
+		return entity.NoteShare{}, err
+	}
+
+	var share *entity.NoteShare
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if share, err = NewNoteQueries(s.db, tx).ShareNote(id, input.Email, input.Permission, &userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("user", input.Email)
+			}
+			return err
+		}
+
+		if share.UserID == userID {
+			return apperrors.NewBadRequest("a note cannot be shared with its owner")
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteShared, targetNote, id, newSharePayload(userID, share)); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteShared, targetNote, id)
+		event.Details = entity.JSONMap{"share_id": share.ID, "user_id": share.UserID, "permission": share.Permission}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return entity.NoteShare{}, err
+	}
+
+	return *share, nil
+}
+
+// Unshare implements Service
+func (s service) Unshare(ctx context.Context, userID int64, id int64, shareID int64) error {
+	note, err := s.authorize(userID, id, entity.PermissionRead)
+	if err != nil {
+		return err
+	}
+
+	share, err := s.repo.GetShare(id, shareID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewNotFound("share", fmt.Sprint(shareID))
+		}
+		return err
+	}
+
+	// users can leave the notes shared with them
+	if note.Permission != entity.PermissionOwner && share.UserID != userID {
+		return apperrors.NewForbidden("Only the owner of the note can do this")
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewNoteQueries(s.db, tx).DeleteShare(id, shareID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("share", fmt.Sprint(shareID))
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteUnshared, targetNote, id, newSharePayload(note.UserID, share)); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteUnshared, targetNote, id)
+		event.Details = entity.JSONMap{"share_id": share.ID, "user_id": share.UserID, "permission": share.Permission}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+}
+
+// Links implements Service
+func (s service) Links(userID int64, id int64) ([]entity.NoteLink, error) {
+	if _, err := s.authorize(userID, id, entity.PermissionOwner); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetLinks(id)
+}
+
+// CreateLink implements Service
+func (s service) CreateLink(ctx context.Context, userID int64, id int64, input LinkRequest) (LinkResponse, error) {
+	if err := input.Validate(); err != nil {
+		return LinkResponse{}, err
+	}
+
+	if _, err := s.authorize(userID, id, entity.PermissionOwner); err != nil {
+		return LinkResponse{}, err
+	}
+
+	token, err := util.RandomToken(linkTokenSize)
+	if err != nil {
+		return LinkResponse{}, err
+	}
+
+	link := entity.NoteLink{NoteID: id, TokenHash: util.HashToken(token), CreatedBy: &userID}
+
+	if input.ExpiresAt != nil {
+		link.ExpiresAt = pgtype.Timestamp{Time: *input.ExpiresAt, Valid: true}
+	}
+
+	if input.Password != "" {
+		if link.Password, err = util.HashPassword(input.Password); err != nil {
+			return LinkResponse{}, err
+		}
+	}
+
+	var created *entity.NoteLink
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if created, err = NewNoteQueries(s.db, tx).CreateLink(&link); err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteLinkCreated, targetNote, id, newLinkPayload(userID, created)); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteLinkCreated, targetNote, id)
+		event.Details = entity.JSONMap{"link_id": created.ID, "protected": created.HasPassword(), "expires_at": created.ExpiresAt}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return LinkResponse{}, err
+	}
+
+	return LinkResponse{NoteLink: *created, Protected: created.HasPassword(), Token: token}, nil
+}
+
+// DeleteLink implements Service
+func (s service) DeleteLink(ctx context.Context, userID int64, id int64, linkID int64) error {
+	if _, err := s.authorize(userID, id, entity.PermissionOwner); err != nil {
+		return err
+	}
+
+	link, err := s.repo.GetLink(id, linkID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewNotFound("link", fmt.Sprint(linkID))
+		}
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewNoteQueries(s.db, tx).DeleteLink(id, linkID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("link", fmt.Sprint(linkID))
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteLinkDeleted, targetNote, id, newLinkPayload(userID, link)); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteLinkDeleted, targetNote, id)
+		event.Details = entity.JSONMap{"link_id": link.ID}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+}
+
+// ReadLink implements Service
+//
+// Unknown and expired links are not found, a wrong or missing password is
+// an authorization error. Wrong passwords count towards the lockout of the
+// client, as failed logins do.
+func (s service) ReadLink(token string, password string, client auth.Client) (PublicNote, error) {
+	link, err := s.repo.GetLinkByToken(util.HashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PublicNote{}, apperrors.NewNotFound("link", "")
+		}
+		return PublicNote{}, err
+	}
+
+	if link.ExpiresAt.Valid && !link.ExpiresAt.Time.After(time.Now()) {
+		return PublicNote{}, apperrors.NewNotFound("link", "")
+	}
+
+	if link.HasPassword() {
+		if password == "" {
+			return PublicNote{}, apperrors.NewAuthorization("The link requires a password")
+		}
+
+		if s.opts.Throttle != nil {
+			if err := s.opts.Throttle.CheckClient(client); err != nil {
+				return PublicNote{}, err
+			}
+		}
+
+		ok, err := util.ComparePasswords(link.Password, password)
+		if err != nil {
+			return PublicNote{}, err
+		}
+		if !ok {
+			if s.opts.Throttle != nil {
+				s.opts.Throttle.RecordFailure(client, fmt.Sprintf("link:%d", link.ID))
+			}
+			return PublicNote{}, apperrors.NewAuthorization("Invalid link password")
+		}
+	}
+
+	note, err := s.repo.GetNote(link.OwnerID, link.NoteID)
+	if err != nil {
+		return PublicNote{}, err
+	}
+
+	return PublicNote{
+		Title:     note.Title,
+		Content:   note.Content,
+		Attrs:     note.Attrs,
+		CreatedAt: note.CreatedAt,
+		UpdatedAt: note.UpdatedAt,
+		Version:   note.Version,
+		note:      note,
+	}, nil
+}
@@ -0,0 +1,231 @@
+package note
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestAllows(t *testing.T) {
+	assert.True(t, allows(entity.PermissionRead, entity.PermissionRead))
+	assert.False(t, allows(entity.PermissionRead, entity.PermissionEdit))
+	assert.True(t, allows(entity.PermissionEdit, entity.PermissionEdit))
+	assert.False(t, allows(entity.PermissionEdit, entity.PermissionOwner))
+	assert.True(t, allows(entity.PermissionOwner, entity.PermissionOwner))
+	assert.True(t, allows(entity.PermissionOwner, entity.PermissionEdit))
+
+	// users without a permission have no access
+	assert.False(t, allows("", entity.PermissionRead))
+}
+
+type sharingSuiteTest struct {
+	test.TSuite
+	service  Service
+	throttle *countingThrottle
+	users    []*entity.User
+}
+
+func TestSharingSuiteTest(t *testing.T) {
+	suite.Run(t, new(sharingSuiteTest))
+}
+
+// countingThrottle refuses the clients after limit failures.
+type countingThrottle struct {
+	mu       sync.Mutex
+	limit    int
+	failures map[string]int
+}
+
+func (c *countingThrottle) CheckClient(client auth.Client) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures[client.IP] >= c.limit {
+		return apperrors.NewTooManyRequests("too many failed attempts")
+	}
+	return nil
+}
+
+func (c *countingThrottle) RecordFailure(client auth.Client, subject string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures[client.IP]++
+}
+
+func (t *sharingSuiteTest) SetupTest() {
+	t.TSuite.SetupTest()
+
+	t.throttle = &countingThrottle{limit: 3, failures: map[string]int{}}
+	// the service runs its own transactions, it cannot share the one of the test
+	t.service = NewService(t.DB, NewNoteQueries(t.DB, nil), Options{Throttle: t.throttle})
+
+	t.users = nil
+	for _, u := range test.GenerateUsers(3) {
+		created, err := user.NewUserQueries(t.DB, nil).CreateUser(&u)
+		require.NoError(t.T(), err)
+		t.users = append(t.users, created)
+	}
+}
+
+func (t *sharingSuiteTest) createNote(ownerID int64) Note {
+	note, err := t.service.Create(context.Background(), ownerID, CreateNoteRequest{Title: "Groceries", Content: "milk"})
+	require.NoError(t.T(), err)
+	return note
+}
+
+func (t *sharingSuiteTest) TestPermissions() {
+	ctx := context.Background()
+	owner, reader, stranger := t.users[0], t.users[1], t.users[2]
+	note := t.createNote(owner.ID)
+
+	share, err := t.service.Share(ctx, owner.ID, note.ID, ShareRequest{Email: reader.Email, Permission: entity.PermissionRead})
+	require.NoError(t.T(), err)
+
+	// readers read but do not edit or manage the note
+	read, err := t.service.Get(reader.ID, note.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.PermissionRead, read.Permission)
+
+	title := "Shopping"
+	_, err = t.service.Update(ctx, reader.ID, note.ID, UpdateNoteRequest{Title: &title}, read.Version)
+	assert.Equal(t.T(), http.StatusForbidden, apperrors.Status(err))
+
+	_, err = t.service.Share(ctx, reader.ID, note.ID, ShareRequest{Email: stranger.Email, Permission: entity.PermissionRead})
+	assert.Equal(t.T(), http.StatusForbidden, apperrors.Status(err))
+
+	// the others do not find it
+	_, err = t.service.Get(stranger.ID, note.ID)
+	assert.Equal(t.T(), http.StatusNotFound, apperrors.Status(err))
+
+	// editors edit but only the owner deletes, shares or links
+	_, err = t.service.Share(ctx, owner.ID, note.ID, ShareRequest{Email: reader.Email, Permission: entity.PermissionEdit})
+	require.NoError(t.T(), err)
+
+	updated, err := t.service.Update(ctx, reader.ID, note.ID, UpdateNoteRequest{Title: &title}, read.Version)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), title, updated.Title)
+
+	err = t.service.Delete(ctx, reader.ID, note.ID, updated.Version)
+	assert.Equal(t.T(), http.StatusForbidden, apperrors.Status(err))
+
+	_, err = t.service.CreateLink(ctx, reader.ID, note.ID, LinkRequest{})
+	assert.Equal(t.T(), http.StatusForbidden, apperrors.Status(err))
+
+	_, err = t.service.Share(ctx, owner.ID, note.ID, ShareRequest{Email: owner.Email, Permission: entity.PermissionRead})
+	assert.Equal(t.T(), http.StatusBadRequest, apperrors.Status(err))
+
+	// users leave the notes shared with them
+	require.NoError(t.T(), t.service.Unshare(ctx, reader.ID, note.ID, share.ID))
+
+	_, err = t.service.Get(reader.ID, note.ID)
+	assert.Equal(t.T(), http.StatusNotFound, apperrors.Status(err))
+}
+
+func (t *sharingSuiteTest) TestEventsOfOwner() {
+	ctx := context.Background()
+	owner, reader := t.users[0], t.users[1]
+	note := t.createNote(owner.ID)
+
+	share, err := t.service.Share(ctx, owner.ID, note.ID, ShareRequest{Email: reader.Email, Permission: entity.PermissionRead})
+	require.NoError(t.T(), err)
+	require.NoError(t.T(), t.service.Unshare(ctx, reader.ID, note.ID, share.ID))
+
+	_, err = t.service.CreateLink(ctx, owner.ID, note.ID, LinkRequest{})
+	require.NoError(t.T(), err)
+
+	var payloads []string
+	err = t.DB.Select(&payloads, `SELECT payload FROM outbox WHERE event_type IN ($1, $2, $3) ORDER BY id`,
+		EventNoteShared, EventNoteUnshared, EventNoteLinkCreated)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), payloads, 3)
+
+	// the events reach the webhooks of the owner, even when the recipient
+	// leaves the note
+	type event struct {
+		UserID      int64  `json:"user_id"`
+		RecipientID int64  `json:"recipient_id"`
+		Email       string `json:"email"`
+	}
+
+	events := make([]event, len(payloads))
+	for i, payload := range payloads {
+		require.NoError(t.T(), json.Unmarshal([]byte(payload), &events[i]))
+		assert.Equal(t.T(), owner.ID, events[i].UserID)
+		assert.Empty(t.T(), events[i].Email)
+	}
+
+	assert.Equal(t.T(), reader.ID, events[0].RecipientID)
+	assert.Equal(t.T(), reader.ID, events[1].RecipientID)
+}
+
+func (t *sharingSuiteTest) TestLinkExpiry() {
+	ctx := context.Background()
+	owner := t.users[0]
+	note := t.createNote(owner.ID)
+
+	expiresAt := time.Now().Add(time.Hour)
+	link, err := t.service.CreateLink(ctx, owner.ID, note.ID, LinkRequest{ExpiresAt: &expiresAt})
+	require.NoError(t.T(), err)
+	assert.NotEmpty(t.T(), link.Token)
+
+	public, err := t.service.ReadLink(link.Token, "", auth.Client{})
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "Groceries", public.Title)
+
+	_, err = t.DB.Exec(`UPDATE note_links SET expires_at = NOW() - INTERVAL '1 second' WHERE id = $1`, link.ID)
+	require.NoError(t.T(), err)
+
+	_, err = t.service.ReadLink(link.Token, "", auth.Client{})
+	assert.Equal(t.T(), http.StatusNotFound, apperrors.Status(err))
+
+	// links are not created expired
+	past := time.Now().Add(-time.Minute)
+	_, err = t.service.CreateLink(ctx, owner.ID, note.ID, LinkRequest{ExpiresAt: &past})
+	assert.Error(t.T(), err)
+}
+
+func (t *sharingSuiteTest) TestLinkPassword() {
+	owner := t.users[0]
+	note := t.createNote(owner.ID)
+	client := auth.Client{IP: "203.0.113.7"}
+
+	link, err := t.service.CreateLink(context.Background(), owner.ID, note.ID, LinkRequest{Password: "correct horse"})
+	require.NoError(t.T(), err)
+	assert.True(t.T(), link.Protected)
+
+	_, err = t.service.ReadLink(link.Token, "", client)
+	assert.Equal(t.T(), http.StatusUnauthorized, apperrors.Status(err))
+
+	_, err = t.service.ReadLink(link.Token, "wrong password", client)
+	assert.Equal(t.T(), http.StatusUnauthorized, apperrors.Status(err))
+
+	public, err := t.service.ReadLink(link.Token, "correct horse", client)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), "milk", public.Content)
+
+	// wrong passwords count towards the lockout of the client
+	for i := 0; i < 2; i++ {
+		_, err = t.service.ReadLink(link.Token, "wrong password", client)
+		assert.Equal(t.T(), http.StatusUnauthorized, apperrors.Status(err))
+	}
+
+	_, err = t.service.ReadLink(link.Token, "correct horse", client)
+	assert.Equal(t.T(), http.StatusTooManyRequests, apperrors.Status(err))
+
+	// other clients are not refused
+	_, err = t.service.ReadLink(link.Token, "correct horse", auth.Client{IP: "198.51.100.1"})
+	assert.NoError(t.T(), err)
+}
@@ -0,0 +1,366 @@
+package note
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+// Audited events of the tags, they are also published to the outbox. Adding
+// or removing a tag is a note.updated event.
+const (
+	EventTagRenamed = "tag.renamed"
+	EventTagMerged  = "tag.merged"
+	EventTagDeleted = "tag.deleted"
+
+	targetTag = "tag"
+)
+
+// MaxTagsPerRequest is the number of tags which can be added at once.
+const MaxTagsPerRequest = 20
+
+type TagResponse struct {
+	entity.Tag
+}
+
+// Render implements render.Renderer
+func (t *TagResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type TagCountResponse struct {
+	entity.TagCount
+}
+
+// Render implements render.Renderer
+func (t *TagCountResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// tagName validates a tag name. Names are separated by commas in the
+// filters, they cannot contain one.
+var tagName = []validation.Rule{
+	validation.Required,
+	validation.Length(1, 64),
+	validation.By(func(value interface{}) error {
+		if strings.Contains(value.(string), ",") {
+			return errors.New("must not contain a comma")
+		}
+		return nil
+	}),
+}
+
+// TagNoteRequest represents a request adding tags to a note.
+type TagNoteRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// Bind implements render.Binder
+func (*TagNoteRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the TagNoteRequest fields.
+func (c TagNoteRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Tags, validation.Required, validation.Length(1, MaxTagsPerRequest), validation.Each(tagName...)),
+	)
+}
+
+// RenameTagRequest represents a request renaming a tag.
+type RenameTagRequest struct {
+	Name string `json:"name"`
+}
+
+// Bind implements render.Binder
+func (*RenameTagRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the RenameTagRequest fields.
+func (c RenameTagRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Name, tagName...),
+	)
+}
+
+// MergeTagRequest represents a request merging a tag into another one.
+type MergeTagRequest struct {
+	Into int64 `json:"into"`
+}
+
+// Bind implements render.Binder
+func (*MergeTagRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the MergeTagRequest fields.
+func (c MergeTagRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Into, validation.Required),
+	)
+}
+
+// cleanTags trims names and drops the empty ones and the duplicates,
+// ignoring case.
+func cleanTags(names []string) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if key := strings.ToLower(name); name != "" && !seen[key] {
+			seen[key] = true
+			tags = append(tags, name)
+		}
+	}
+
+	return tags
+}
+
+// Tags implements Service
+func (s service) Tags(userID int64) ([]entity.TagCount, error) {
+	return s.repo.GetTags(userID)
+}
+
+// getTag returns the tag id of the user.
+func (s service) getTag(userID int64, id int64) (*entity.Tag, error) {
+	tag, err := s.repo.GetTag(userID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.NewNotFound("tag", fmt.Sprint(id))
+		}
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+// AddTags implements Service
+func (s service) AddTags(ctx context.Context, userID int64, id int64, input TagNoteRequest) (Note, error) {
+	input.Tags = cleanTags(input.Tags)
+
+	if err := input.Validate(); err != nil {
+		return Note{}, err
+	}
+
+	return s.retag(ctx, userID, id, func(repo NoteQueries) error {
+		tags, err := repo.EnsureTags(userID, input.Tags)
+		if err != nil {
+			return err
+		}
+
+		ids := make([]int64, len(tags))
+		for i, tag := range tags {
+			ids[i] = tag.ID
+		}
+
+		return repo.TagNote(id, ids)
+	})
+}
+
+// RemoveTag implements Service
+func (s service) RemoveTag(ctx context.Context, userID int64, id int64, tagID int64) (Note, error) {
+	return s.retag(ctx, userID, id, func(repo NoteQueries) error {
+		if err := repo.UntagNote(id, tagID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("tag", fmt.Sprint(tagID))
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// retag changes the tags of the note id with change. The version of the
+// note is incremented and the change recorded as an update of the note.
+func (s service) retag(ctx context.Context, userID int64, id int64, change func(repo NoteQueries) error) (Note, error) {
+	// the tags belong to the owner of the note
+	before, err := s.authorize(userID, id, entity.PermissionOwner)
+	if err != nil {
+		return Note{}, err
+	}
+
+	var after *entity.Note
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		// locks the note, it may have been deleted since it was read
+		if _, err := repo.TouchNote(userID, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("note", fmt.Sprint(id))
+			}
+			return err
+		}
+
+		if err := change(repo); err != nil {
+			return err
+		}
+
+		if after, err = repo.GetNote(userID, id); err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteUpdated, targetNote, id, after); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventNoteUpdated, targetNote, id)
+		event.Changes = audit.Diff(before.Note, after)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return Note{}, err
+	}
+
+	return Note{after}, nil
+}
+
+// RenameTag implements Service
+func (s service) RenameTag(ctx context.Context, userID int64, tagID int64, input RenameTagRequest) (entity.Tag, error) {
+	input.Name = strings.TrimSpace(input.Name)
+
+	if err := input.Validate(); err != nil {
+		return entity.Tag{}, err
+	}
+
+	before, err := s.getTag(userID, tagID)
+	if err != nil {
+		return entity.Tag{}, err
+	}
+
+	var after *entity.Tag
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		if err := repo.TouchTaggedNotes(tagID); err != nil {
+			return err
+		}
+
+		if after, err = repo.RenameTag(userID, tagID, input.Name); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("tag", fmt.Sprint(tagID))
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventTagRenamed, targetTag, tagID, after); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventTagRenamed, targetTag, tagID)
+		event.Changes = audit.Diff(before, after)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		// merge the tags to give a tag the name of another one
+		if database.IsUniqueViolation(err) {
+			return entity.Tag{}, apperrors.NewConflict("tag", input.Name)
+		}
+		return entity.Tag{}, err
+	}
+
+	return *after, nil
+}
+
+// MergeTag implements Service
+func (s service) MergeTag(ctx context.Context, userID int64, tagID int64, input MergeTagRequest) (entity.Tag, error) {
+	if err := input.Validate(); err != nil {
+		return entity.Tag{}, err
+	}
+
+	if input.Into == tagID {
+		return entity.Tag{}, apperrors.NewBadRequest("a tag cannot be merged into itself")
+	}
+
+	tag, err := s.getTag(userID, tagID)
+	if err != nil {
+		return entity.Tag{}, err
+	}
+
+	into, err := s.getTag(userID, input.Into)
+	if err != nil {
+		return entity.Tag{}, err
+	}
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		if err := repo.TouchTaggedNotes(tagID); err != nil {
+			return err
+		}
+
+		if err := repo.MergeTag(userID, tagID, into.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("tag", fmt.Sprint(tagID))
+			}
+			return err
+		}
+
+		payload := map[string]interface{}{"id": tagID, "user_id": userID, "name": tag.Name, "into": into}
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventTagMerged, targetTag, tagID, payload); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventTagMerged, targetTag, tagID)
+		event.Changes = audit.Diff(tag, nil)
+		event.Details = entity.JSONMap{"into": into.ID}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return entity.Tag{}, err
+	}
+
+	return *into, nil
+}
+
+// DeleteTag implements Service
+func (s service) DeleteTag(ctx context.Context, userID int64, tagID int64) error {
+	tag, err := s.getTag(userID, tagID)
+	if err != nil {
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewNoteQueries(s.db, tx)
+
+		if err := repo.TouchTaggedNotes(tagID); err != nil {
+			return err
+		}
+
+		if err := repo.DeleteTag(userID, tagID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("tag", fmt.Sprint(tagID))
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventTagDeleted, targetTag, tagID, tag); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventTagDeleted, targetTag, tagID)
+		event.Changes = audit.Diff(tag, nil)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+}
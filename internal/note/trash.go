@@ -0,0 +1,157 @@
+package note
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+// Audited events of the trash, they are also published to the outbox.
+// Deleting a note moves it to the trash, it is a note.deleted event.
+const (
+	EventNoteRestored = "note.restored"
+	EventNotePurged   = "note.purged"
+)
+
+// Trash implements Service
+func (s service) Trash(userID int64, offset int, limit int) ([]Note, error) {
+	notes, err := s.repo.GetTrash(userID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Note{}
+
+	for i := range notes {
+		result = append(result, Note{&notes[i]})
+	}
+
+	return result, nil
+}
+
+// CountTrash implements Service
+func (s service) CountTrash(userID int64) (int, error) {
+	return s.repo.CountTrash(userID)
+}
+
+// getTrashed returns the note id of the trash of the user.
+func (s service) getTrashed(userID int64, id int64) (*entity.Note, error) {
+	note, err := s.repo.GetTrashedNote(userID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.NewNotFound("note", fmt.Sprint(id))
+		}
+		return nil, err
+	}
+
+	return note, nil
+}
+
+// RestoreNote implements Service
+func (s service) RestoreNote(ctx context.Context, userID int64, id int64) (Note, error) {
+	if _, err := s.getTrashed(userID, id); err != nil {
+		return Note{}, err
+	}
+
+	var note *entity.Note
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if note, err = NewNoteQueries(s.db, tx).RestoreNote(userID, id); err != nil {
+			// restored or purged since it was read
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("note", fmt.Sprint(id))
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventNoteRestored, targetNote, id, note); err != nil {
+			return err
+		}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(audit.FromContext(ctx).Event(EventNoteRestored, targetNote, id))
+	})
+
+	if err != nil {
+		return Note{}, err
+	}
+
+	return Note{note}, nil
+}
+
+// PurgeNote implements Service
+func (s service) PurgeNote(ctx context.Context, userID int64, id int64) error {
+	note, err := s.getTrashed(userID, id)
+	if err != nil {
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewNoteQueries(s.db, tx).PurgeNote(userID, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("note", fmt.Sprint(id))
+			}
+			return err
+		}
+
+		return s.purged(ctx, tx, []PurgedNote{{ID: note.ID, UserID: note.UserID}})
+	})
+}
+
+// EmptyTrash implements Service
+func (s service) EmptyTrash(ctx context.Context, userID int64) (int64, error) {
+	var notes []PurgedNote
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if notes, err = NewNoteQueries(s.db, tx).EmptyTrash(userID); err != nil {
+			return err
+		}
+
+		return s.purged(ctx, tx, notes)
+	})
+
+	return int64(len(notes)), err
+}
+
+// PurgeTrash implements Service
+func (s service) PurgeTrash(retention time.Duration) (int64, error) {
+	var notes []PurgedNote
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if notes, err = NewNoteQueries(s.db, tx).PurgeTrash(time.Now().Add(-retention)); err != nil {
+			return err
+		}
+
+		return s.purged(context.Background(), tx, notes)
+	})
+
+	return int64(len(notes)), err
+}
+
+// purged records the permanent deletion of notes in tx.
+func (s service) purged(ctx context.Context, tx *sqlx.Tx, notes []PurgedNote) error {
+	repo := audit.NewAuditQueries(s.db, tx)
+	events := outbox.NewOutboxQueries(s.db, tx)
+
+	for _, note := range notes {
+		if err := repo.Insert(audit.FromContext(ctx).Event(EventNotePurged, targetNote, note.ID)); err != nil {
+			return err
+		}
+		if err := events.Publish(EventNotePurged, targetNote, note.ID, note); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
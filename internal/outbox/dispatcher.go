@@ -0,0 +1,166 @@
+// Package outbox publishes domain events reliably. Services insert events
+// with the queries bound to the transaction of the change they describe, and
+// the dispatcher delivers them to the registered handlers once committed.
+// Delivery is at least once, handlers must be idempotent.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	database "github.com/opaulochaves/myserver/pkg/db"
+)
+
+// AllEvents subscribes a handler to every event type.
+const AllEvents = "*"
+
+// Handler handles a dispatched event, an error schedules a retry.
+type Handler func(ctx context.Context, event entity.OutboxEvent) error
+
+// Options configures the dispatcher.
+type Options struct {
+	// PollInterval is how often the outbox is polled when it is drained
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxAttempts is the number of deliveries after which an event is given up
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry, it doubles with every
+	// attempt until MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Backoff returns the delay before retrying an event delivered attempts times.
+func (o Options) Backoff(attempts int) time.Duration {
+	d := o.BaseBackoff
+
+	for i := 1; i < attempts && d < o.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if o.MaxBackoff > 0 && d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+
+	return d
+}
+
+// Dispatcher delivers the events of the outbox to the handlers subscribed to
+// their type. Several dispatchers, in as many processes, can share the outbox.
+type Dispatcher struct {
+	db   *sqlx.DB
+	opts Options
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewDispatcher(db *sqlx.DB, opts Options) *Dispatcher {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	return &Dispatcher{db: db, opts: opts, handlers: map[string][]Handler{}}
+}
+
+// Subscribe registers h for the events of eventType, or AllEvents. An event
+// is processed once all of its handlers succeed, they are all called again
+// on a retry.
+func (d *Dispatcher) Subscribe(eventType string, h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handlers[eventType] = append(d.handlers[eventType], h)
+}
+
+// Run dispatches events until ctx is done. The batch in flight is finished
+// before it returns.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := d.Dispatch(ctx)
+		if err != nil {
+			log.Printf("dispatch outbox events error: %v", err)
+		}
+
+		// keep going while there is a backlog
+		if err == nil && n == d.opts.BatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch delivers one batch of pending events and returns its size.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	var n int
+
+	err := database.WithTx(d.db, func(tx *sqlx.Tx) error {
+		repo := NewOutboxQueries(d.db, tx)
+
+		events, err := repo.LockPending(d.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		n = len(events)
+
+		for _, event := range events {
+			if err := d.deliver(ctx, event); err != nil {
+				if event.Attempts+1 >= d.opts.MaxAttempts {
+					log.Printf("outbox event %d (%s) failed after %d attempts: %v", event.ID, event.EventType, event.Attempts+1, err)
+					err = repo.MarkFailed(event.ID, err.Error())
+				} else {
+					err = repo.MarkRetry(event.ID, err.Error(), time.Now().Add(d.opts.Backoff(event.Attempts+1)))
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := repo.MarkProcessed(event.ID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+// deliver calls the handlers of the event, it stops at the first error.
+func (d *Dispatcher) deliver(ctx context.Context, event entity.OutboxEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+
+	d.mu.RLock()
+	handlers := make([]Handler, 0, len(d.handlers[event.EventType])+len(d.handlers[AllEvents]))
+	handlers = append(handlers, d.handlers[event.EventType]...)
+	handlers = append(handlers, d.handlers[AllEvents]...)
+	d.mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
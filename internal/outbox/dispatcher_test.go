@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBackoff(t *testing.T) {
+	opts := Options{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, opts.Backoff(1))
+	assert.Equal(t, 2*time.Second, opts.Backoff(2))
+	assert.Equal(t, 8*time.Second, opts.Backoff(4))
+	assert.Equal(t, 10*time.Second, opts.Backoff(5))
+	assert.Equal(t, 10*time.Second, opts.Backoff(50))
+}
+
+type dispatcherSuiteTest struct {
+	test.TSuite
+}
+
+func TestDispatcherSuiteTest(t *testing.T) {
+	suite.Run(t, new(dispatcherSuiteTest))
+}
+
+func (t *dispatcherSuiteTest) TestDispatch() {
+	queries := NewOutboxQueries(t.DB, nil)
+	require.NoError(t.T(), queries.Publish("user.created", "user", 1, nil))
+	require.NoError(t.T(), queries.Publish("note.created", "note", 2, nil))
+
+	d := NewDispatcher(t.DB, Options{MaxAttempts: 3, BaseBackoff: time.Hour})
+
+	var users, all []string
+	d.Subscribe("user.created", func(ctx context.Context, e entity.OutboxEvent) error {
+		users = append(users, e.AggregateID)
+		return nil
+	})
+	d.Subscribe(AllEvents, func(ctx context.Context, e entity.OutboxEvent) error {
+		all = append(all, e.EventType)
+		return nil
+	})
+
+	n, err := d.Dispatch(context.Background())
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 2, n)
+
+	assert.Equal(t.T(), []string{"1"}, users)
+	assert.Equal(t.T(), []string{"user.created", "note.created"}, all)
+
+	// processed events are not delivered again
+	n, err = d.Dispatch(context.Background())
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 0, n)
+}
+
+func (t *dispatcherSuiteTest) TestDispatchRetries() {
+	require.NoError(t.T(), NewOutboxQueries(t.DB, nil).Publish("user.created", "user", 1, nil))
+
+	d := NewDispatcher(t.DB, Options{MaxAttempts: 2})
+
+	calls := 0
+	d.Subscribe("user.created", func(ctx context.Context, e entity.OutboxEvent) error {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		return errors.New("unavailable")
+	})
+
+	// without backoff the event is due again right away
+	_, err := d.Dispatch(context.Background())
+	require.NoError(t.T(), err)
+
+	var event entity.OutboxEvent
+	require.NoError(t.T(), t.DB.Get(&event, `SELECT * FROM outbox`))
+	assert.Equal(t.T(), 1, event.Attempts)
+	assert.Equal(t.T(), "handler panic: boom", event.LastError)
+	assert.False(t.T(), event.FailedAt.Valid)
+
+	_, err = d.Dispatch(context.Background())
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), t.DB.Get(&event, `SELECT * FROM outbox`))
+	assert.Equal(t.T(), 2, event.Attempts)
+	assert.Equal(t.T(), "unavailable", event.LastError)
+	assert.True(t.T(), event.FailedAt.Valid)
+	assert.False(t.T(), event.ProcessedAt.Valid)
+
+	// failed events are given up
+	n, err := d.Dispatch(context.Background())
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 0, n)
+	assert.Equal(t.T(), 2, calls)
+}
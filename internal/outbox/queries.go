@@ -0,0 +1,120 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+type OutboxQueries interface {
+	Publish(eventType string, aggregateType string, aggregateID interface{}, payload interface{}) error
+	LockPending(limit int) ([]entity.OutboxEvent, error)
+	MarkProcessed(id int64) error
+	MarkRetry(id int64, message string, availableAt time.Time) error
+	MarkFailed(id int64, message string) error
+	DeleteProcessed(before time.Time) (int64, error)
+}
+
+// outboxQueries struct for queries from the outbox table.
+type outboxQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewOutboxQueries(db *sqlx.DB, tx *sqlx.Tx) OutboxQueries {
+	return &outboxQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *outboxQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// Publish implements OutboxQueries
+//
+// The payload is stored as JSON. Bound to the transaction of a change, the
+// event is only dispatched if the change commits.
+func (q *outboxQueries) Publish(eventType string, aggregateType string, aggregateID interface{}, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "marshal outbox payload error")
+	}
+
+	query := `INSERT INTO outbox (event_type, aggregate_type, aggregate_id, payload) VALUES ($1, $2, $3, $4)`
+
+	if _, err := q.conn().Exec(query, eventType, aggregateType, fmt.Sprint(aggregateID), string(b)); err != nil {
+		return errors.Wrap(err, "insert outbox event error")
+	}
+
+	return nil
+}
+
+// LockPending implements OutboxQueries
+//
+// It returns the oldest events due for delivery and locks them until the
+// transaction ends, events locked by another dispatcher are skipped. It must
+// run in a transaction.
+func (q *outboxQueries) LockPending(limit int) ([]entity.OutboxEvent, error) {
+	events := []entity.OutboxEvent{}
+
+	query := `SELECT * FROM outbox
+		WHERE processed_at IS NULL AND failed_at IS NULL AND available_at <= NOW()
+		ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`
+
+	err := sqlx.Select(q.conn(), &events, query, limit)
+
+	return events, err
+}
+
+// MarkProcessed implements OutboxQueries
+func (q *outboxQueries) MarkProcessed(id int64) error {
+	query := `UPDATE outbox SET attempts = attempts + 1, processed_at = NOW() WHERE id = $1`
+
+	if _, err := q.conn().Exec(query, id); err != nil {
+		return errors.Wrap(err, "mark outbox event processed error")
+	}
+
+	return nil
+}
+
+// MarkRetry implements OutboxQueries
+func (q *outboxQueries) MarkRetry(id int64, message string, availableAt time.Time) error {
+	query := `UPDATE outbox SET attempts = attempts + 1, last_error = $2, available_at = $3 WHERE id = $1`
+
+	if _, err := q.conn().Exec(query, id, message, availableAt); err != nil {
+		return errors.Wrap(err, "mark outbox event for retry error")
+	}
+
+	return nil
+}
+
+// MarkFailed implements OutboxQueries
+func (q *outboxQueries) MarkFailed(id int64, message string) error {
+	query := `UPDATE outbox SET attempts = attempts + 1, last_error = $2, failed_at = NOW() WHERE id = $1`
+
+	if _, err := q.conn().Exec(query, id, message); err != nil {
+		return errors.Wrap(err, "mark outbox event failed error")
+	}
+
+	return nil
+}
+
+// DeleteProcessed implements OutboxQueries
+//
+// Failed events are kept for inspection.
+func (q *outboxQueries) DeleteProcessed(before time.Time) (int64, error) {
+	res, err := q.conn().Exec(`DELETE FROM outbox WHERE processed_at < $1`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete processed outbox events error")
+	}
+
+	return res.RowsAffected()
+}
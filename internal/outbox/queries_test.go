@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type queriesSuiteTest struct {
+	test.TSuite
+}
+
+func TestQueriesSuiteTest(t *testing.T) {
+	suite.Run(t, new(queriesSuiteTest))
+}
+
+func (t *queriesSuiteTest) TestPublish() {
+	queries := NewOutboxQueries(t.DB, t.TX)
+
+	require.NoError(t.T(), queries.Publish("note.created", "note", int64(7), map[string]string{"title": "Groceries"}))
+
+	events, err := queries.LockPending(10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), events, 1)
+
+	assert.Equal(t.T(), "note.created", events[0].EventType)
+	assert.Equal(t.T(), "note", events[0].AggregateType)
+	assert.Equal(t.T(), "7", events[0].AggregateID)
+	assert.JSONEq(t.T(), `{"title":"Groceries"}`, string(events[0].Payload))
+	assert.Equal(t.T(), 0, events[0].Attempts)
+}
+
+func (t *queriesSuiteTest) TestLockPendingSkipsLocked() {
+	queries := NewOutboxQueries(t.DB, nil)
+
+	require.NoError(t.T(), queries.Publish("note.created", "note", 1, nil))
+	require.NoError(t.T(), queries.Publish("note.created", "note", 2, nil))
+
+	first := t.DB.MustBegin()
+	defer first.Rollback()
+
+	locked, err := NewOutboxQueries(t.DB, first).LockPending(1)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), locked, 1)
+
+	second := t.DB.MustBegin()
+	defer second.Rollback()
+
+	// another dispatcher only sees the event left unlocked
+	others, err := NewOutboxQueries(t.DB, second).LockPending(10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), others, 1)
+	assert.NotEqual(t.T(), locked[0].ID, others[0].ID)
+}
+
+func (t *queriesSuiteTest) TestMarkRetryAndProcessed() {
+	queries := NewOutboxQueries(t.DB, t.TX)
+
+	require.NoError(t.T(), queries.Publish("note.created", "note", 1, nil))
+	require.NoError(t.T(), queries.Publish("note.created", "note", 2, nil))
+	require.NoError(t.T(), queries.Publish("note.created", "note", 3, nil))
+
+	events, err := queries.LockPending(10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), events, 3)
+
+	require.NoError(t.T(), queries.MarkProcessed(events[0].ID))
+	require.NoError(t.T(), queries.MarkRetry(events[1].ID, "timeout", time.Now().Add(time.Hour)))
+	require.NoError(t.T(), queries.MarkFailed(events[2].ID, "gone"))
+
+	// processed, delayed and failed events are not pending
+	events, err = queries.LockPending(10)
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), events)
+
+	n, err := queries.DeleteProcessed(time.Now().Add(time.Minute))
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), int64(1), n)
+}
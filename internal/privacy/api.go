@@ -0,0 +1,103 @@
+package privacy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+)
+
+// RegisterHandlers adds the data export and erasure endpoints to r, the
+// router of the users API. They are open to the user themselves and admins.
+func RegisterHandlers(r chi.Router, service Service, authService auth.Service) {
+	res := resource{service}
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Authenticate(authService))
+		r.Use(auth.RequireVerified(authService))
+		r.Use(auth.RequireSelfOrAdmin)
+
+		r.Get("/{id}/export", res.export)                             // GET /users/{id}/export - start an export of the user's data, or read the current one
+		r.Get("/{id}/export/{exportID}", res.getExport)               // GET /users/{id}/export/{exportID} - read the status of an export
+		r.Get("/{id}/export/{exportID}/download", res.downloadExport) // GET /users/{id}/export/{exportID}/download - download a ready export
+		r.Post("/{id}/erase", res.erase)                              // POST /users/{id}/erase - anonymise the user and delete their data
+	})
+}
+
+type resource struct {
+	service Service
+}
+
+func (c resource) export(w http.ResponseWriter, r *http.Request) {
+	export, err := c.service.Export(userID(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &export)
+}
+
+func (c resource) getExport(w http.ResponseWriter, r *http.Request) {
+	export, ok := c.loadExport(w, r)
+	if !ok {
+		return
+	}
+
+	render.Render(w, r, &export)
+}
+
+func (c resource) downloadExport(w http.ResponseWriter, r *http.Request) {
+	export, ok := c.loadExport(w, r)
+	if !ok {
+		return
+	}
+
+	if export.Status != entity.ExportReady || !export.IsAvailable() {
+		render.Render(w, r, apperrors.ErrFromError(apperrors.NewNotFound("export", fmt.Sprint(export.ID))))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%d.zip"`, export.ID))
+	w.Header().Set("Cache-Control", "no-store")
+
+	http.ServeFile(w, r, export.FilePath)
+}
+
+func (c resource) erase(w http.ResponseWriter, r *http.Request) {
+	err := c.service.Erase(userID(r), auth.CurrentUser(r.Context()), auth.ClientFromRequest(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) loadExport(w http.ResponseWriter, r *http.Request) (Export, bool) {
+	exportID, err := strconv.ParseInt(chi.URLParam(r, "exportID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return Export{}, false
+	}
+
+	export, err := c.service.GetExport(userID(r), exportID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return Export{}, false
+	}
+
+	return export, true
+}
+
+// userID returns the {id} URL parameter, already validated by auth.RequireSelfOrAdmin.
+func userID(r *http.Request) int64 {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id
+}
@@ -0,0 +1,263 @@
+package privacy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+// Note is a note as it appears in an export, attrs included.
+type Note struct {
+	ID      int64             `db:"id" json:"id"`
+	Title   string            `db:"title" json:"title"`
+	Content string            `db:"content" json:"content"`
+	Attrs   json.RawMessage   `db:"attrs" json:"attrs"`
+	Tags    entity.StringList `db:"tags" json:"tags"`
+	// Revisions are the saved versions of the note, oldest first
+	Revisions json.RawMessage `db:"revisions" json:"revisions"`
+	// Attachments describe the files attached to the note, their content
+	// is not exported
+	Attachments json.RawMessage  `db:"attachments" json:"attachments"`
+	CreatedAt   pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt   pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	// DeletedAt is set for the notes in the trash
+	DeletedAt pgtype.Timestamp `db:"deleted_at" json:"deleted_at"`
+}
+
+// LoginAttempt is a login of the user as it appears in an export.
+type LoginAttempt struct {
+	IP        string           `db:"ip" json:"ip"`
+	Success   bool             `db:"success" json:"success"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
+type PrivacyQueries interface {
+	CreateExport(userID int64, expiresAt time.Time) (*entity.DataExport, error)
+	GetExport(id int64) (*entity.DataExport, error)
+	LastExport(userID int64) (*entity.DataExport, error)
+	CompleteExport(id int64, filePath string) error
+	FailExport(id int64, message string) error
+	DeleteExpiredExports(now time.Time) ([]string, error)
+	GetNotes(userID int64) ([]Note, error)
+	GetSessions(userID int64) ([]entity.Session, error)
+	GetLoginAttempts(userID int64) ([]LoginAttempt, error)
+	EraseUser(userID int64) ([]string, error)
+}
+
+// privacyQueries struct for queries from the data_exports table and for
+// gathering or erasing the data of a user.
+type privacyQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewPrivacyQueries(db *sqlx.DB, tx *sqlx.Tx) PrivacyQueries {
+	return &privacyQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *privacyQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// CreateExport implements PrivacyQueries
+func (q *privacyQueries) CreateExport(userID int64, expiresAt time.Time) (*entity.DataExport, error) {
+	query := `INSERT INTO data_exports (user_id, expires_at) VALUES ($1, $2) RETURNING *`
+
+	var export entity.DataExport
+
+	err := q.conn().QueryRowx(query, userID, expiresAt).StructScan(&export)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert data export error")
+	}
+
+	return &export, nil
+}
+
+// GetExport implements PrivacyQueries
+func (q *privacyQueries) GetExport(id int64) (*entity.DataExport, error) {
+	var export entity.DataExport
+
+	query := `SELECT * FROM data_exports WHERE id = $1`
+
+	err := sqlx.Get(q.conn(), &export, query, id)
+
+	return &export, err
+}
+
+// LastExport implements PrivacyQueries
+func (q *privacyQueries) LastExport(userID int64) (*entity.DataExport, error) {
+	var export entity.DataExport
+
+	query := `SELECT * FROM data_exports WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1`
+
+	err := sqlx.Get(q.conn(), &export, query, userID)
+
+	return &export, err
+}
+
+// CompleteExport implements PrivacyQueries
+func (q *privacyQueries) CompleteExport(id int64, filePath string) error {
+	query := `UPDATE data_exports SET status = $2, file_path = $3, completed_at = NOW() WHERE id = $1`
+
+	_, err := q.conn().Exec(query, id, entity.ExportReady, filePath)
+	if err != nil {
+		return errors.Wrap(err, "complete data export error")
+	}
+
+	return nil
+}
+
+// FailExport implements PrivacyQueries
+func (q *privacyQueries) FailExport(id int64, message string) error {
+	query := `UPDATE data_exports SET status = $2, error = $3, completed_at = NOW() WHERE id = $1`
+
+	_, err := q.conn().Exec(query, id, entity.ExportFailed, message)
+	if err != nil {
+		return errors.Wrap(err, "fail data export error")
+	}
+
+	return nil
+}
+
+// DeleteExpiredExports implements PrivacyQueries
+//
+// It returns the archives of the deleted exports so they can be removed.
+func (q *privacyQueries) DeleteExpiredExports(now time.Time) ([]string, error) {
+	var paths []string
+
+	query := `DELETE FROM data_exports WHERE expires_at < $1 RETURNING file_path`
+
+	if err := sqlx.Select(q.conn(), &paths, query, now); err != nil {
+		return nil, errors.Wrap(err, "delete expired data exports error")
+	}
+
+	return paths, nil
+}
+
+// GetNotes implements PrivacyQueries
+func (q *privacyQueries) GetNotes(userID int64) ([]Note, error) {
+	notes := []Note{}
+
+	query := `SELECT id, title, content, attrs, created_at, updated_at, deleted_at,
+		(SELECT COALESCE(jsonb_agg(t.name ORDER BY LOWER(t.name)), '[]') FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.note_id = notes.id) AS tags,
+		(SELECT COALESCE(jsonb_agg(jsonb_build_object('version', r.version, 'title', r.title, 'content', r.content,
+			'attrs', r.attrs, 'created_at', r.created_at) ORDER BY r.id), '[]') FROM note_revisions r
+			WHERE r.note_id = notes.id) AS revisions,
+		(SELECT COALESCE(jsonb_agg(jsonb_build_object('filename', a.filename, 'content_type', a.content_type, 'size', a.size,
+			'checksum', a.checksum, 'created_at', a.created_at) ORDER BY a.id), '[]') FROM note_attachments a
+			WHERE a.note_id = notes.id) AS attachments
+		FROM notes WHERE user_id = $1 ORDER BY id`
+
+	err := sqlx.Select(q.conn(), &notes, query, userID)
+
+	return notes, err
+}
+
+// GetSessions implements PrivacyQueries
+func (q *privacyQueries) GetSessions(userID int64) ([]entity.Session, error) {
+	sessions := []entity.Session{}
+
+	query := `SELECT * FROM sessions WHERE user_id = $1 ORDER BY id`
+
+	err := sqlx.Select(q.conn(), &sessions, query, userID)
+
+	return sessions, err
+}
+
+// GetLoginAttempts implements PrivacyQueries
+func (q *privacyQueries) GetLoginAttempts(userID int64) ([]LoginAttempt, error) {
+	attempts := []LoginAttempt{}
+
+	query := `SELECT ip, success, created_at FROM login_attempts WHERE user_id = $1 ORDER BY id`
+
+	err := sqlx.Select(q.conn(), &attempts, query, userID)
+
+	return attempts, err
+}
+
+// EraseUser implements PrivacyQueries
+//
+// The users row is kept, anonymised and soft deleted, so rows referencing it
+// stay valid until the purge removes it. Personal data hanging off the user
+// is deleted, login attempts are kept for the lockout counters but lose their
+// email and IP address, the images of the avatar are left to the purge of
+// the orphaned avatars. The events about the user, in the outbox and in the
+// deliveries of the global webhooks, and the stored idempotent responses
+// are deleted too, they carry copies of the profile and notes. It returns
+// the archives of the deleted exports so they can be removed. It must run
+// in a transaction.
+func (q *privacyQueries) EraseUser(userID int64) ([]string, error) {
+	query := `UPDATE users SET email = $2, first_name = '', last_name = '', password = '', avatar = '',
+		verified_at = NULL, is_admin = FALSE, updated_at = NOW(), version = version + 1,
+		deleted_at = COALESCE(deleted_at, NOW()), erased_at = NOW()
+		WHERE id = $1 AND erased_at IS NULL`
+
+	// the placeholder stays unique without revealing anything
+	res, err := q.conn().Exec(query, userID, fmt.Sprintf("erased-%d@invalid", userID))
+	if err != nil {
+		return nil, errors.Wrap(err, "anonymise user error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	deletes := []string{
+		`DELETE FROM notes WHERE user_id = $1`,
+		`DELETE FROM tags WHERE user_id = $1`,
+		`DELETE FROM note_settings WHERE user_id = $1`,
+		`DELETE FROM note_shares WHERE user_id = $1`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM verification_tokens WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM account_lockouts WHERE user_id = $1`,
+		`DELETE FROM webhooks WHERE user_id = $1`,
+		`DELETE FROM idempotency_keys WHERE user_id = $1`,
+	}
+
+	for _, query := range deletes {
+		if _, err := q.conn().Exec(query, userID); err != nil {
+			return nil, errors.Wrap(err, "erase user data error")
+		}
+	}
+
+	if _, err := q.conn().Exec(`UPDATE login_attempts SET email = '', ip = '' WHERE user_id = $1`, userID); err != nil {
+		return nil, errors.Wrap(err, "anonymise login attempts error")
+	}
+
+	// events are about the user itself, or carry its user_id, see
+	// webhook.eventOwner. Deliveries wrap the event in their data field.
+	id := fmt.Sprint(userID)
+
+	query = `DELETE FROM outbox WHERE (aggregate_type = 'user' AND aggregate_id = $1) OR payload->>'user_id' = $1`
+	if _, err := q.conn().Exec(query, id); err != nil {
+		return nil, errors.Wrap(err, "delete outbox events error")
+	}
+
+	query = `DELETE FROM webhook_deliveries WHERE payload::jsonb->'data'->>'user_id' = $1
+		OR (event_type LIKE 'user.%' AND payload::jsonb->'data'->>'id' = $1)`
+	if _, err := q.conn().Exec(query, id); err != nil {
+		return nil, errors.Wrap(err, "delete webhook deliveries error")
+	}
+
+	var paths []string
+
+	if err := sqlx.Select(q.conn(), &paths, `DELETE FROM data_exports WHERE user_id = $1 RETURNING file_path`, userID); err != nil {
+		return nil, errors.Wrap(err, "delete data exports error")
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,134 @@
+package privacy
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type queriesSuiteTest struct {
+	test.TSuite
+}
+
+func TestQueriesSuiteTest(t *testing.T) {
+	suite.Run(t, new(queriesSuiteTest))
+}
+
+func (t *queriesSuiteTest) createUser() *entity.User {
+	u, err := user.NewUserQueries(t.DB, t.TX).CreateUser(&test.GenerateUsers(1)[0])
+	require.NoError(t.T(), err)
+
+	return u
+}
+
+func (t *queriesSuiteTest) TestExports() {
+	u := t.createUser()
+
+	queries := NewPrivacyQueries(t.DB, t.TX)
+
+	_, err := queries.LastExport(u.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	export, err := queries.CreateExport(u.ID, time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.ExportPending, export.Status)
+
+	require.NoError(t.T(), queries.CompleteExport(export.ID, "/tmp/export.zip"))
+
+	last, err := queries.LastExport(u.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), export.ID, last.ID)
+	assert.Equal(t.T(), entity.ExportReady, last.Status)
+	assert.Equal(t.T(), "/tmp/export.zip", last.FilePath)
+
+	paths, err := queries.DeleteExpiredExports(time.Now())
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), paths)
+
+	paths, err = queries.DeleteExpiredExports(time.Now().Add(2 * time.Hour))
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), []string{"/tmp/export.zip"}, paths)
+}
+
+func (t *queriesSuiteTest) TestEraseUser() {
+	u := t.createUser()
+
+	_, err := t.TX.Exec(`INSERT INTO notes (title, content, user_id, attrs) VALUES ('note', 'content', $1, '{}')`, u.ID)
+	require.NoError(t.T(), err)
+
+	_, err = t.TX.Exec(`INSERT INTO login_attempts (user_id, email, ip, success) VALUES ($1, $2, '127.0.0.1', true)`, u.ID, u.Email)
+	require.NoError(t.T(), err)
+
+	// copies of the data of the user, and an event about another user
+	_, err = t.TX.Exec(`INSERT INTO outbox (event_type, aggregate_type, aggregate_id, payload) VALUES
+		('user.created', 'user', $1, jsonb_build_object('id', $2::int, 'email', $3::text)),
+		('note.created', 'note', '1', jsonb_build_object('id', 1, 'user_id', $2::int)),
+		('user.created', 'user', '0', '{"id":0}')`, fmt.Sprint(u.ID), u.ID, u.Email)
+	require.NoError(t.T(), err)
+
+	_, err = t.TX.Exec(`INSERT INTO webhooks (id, url, secret) VALUES (1, 'https://example.com', '')`)
+	require.NoError(t.T(), err)
+
+	_, err = t.TX.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_type, payload) VALUES
+		(1, 'user.created', json_build_object('data', json_build_object('id', $1::int))::text),
+		(1, 'note.created', json_build_object('data', json_build_object('id', 1, 'user_id', $1::int))::text),
+		(1, 'note.created', '{"data":{"id":2,"user_id":0}}')`, u.ID)
+	require.NoError(t.T(), err)
+
+	_, err = t.TX.Exec(`INSERT INTO idempotency_keys (key, user_id, fingerprint, expires_at) VALUES ('key', $1, '', NOW() + INTERVAL '1 day')`, u.ID)
+	require.NoError(t.T(), err)
+
+	queries := NewPrivacyQueries(t.DB, t.TX)
+
+	notes, err := queries.GetNotes(u.ID)
+	require.NoError(t.T(), err)
+	assert.Len(t.T(), notes, 1)
+
+	_, err = queries.EraseUser(u.ID)
+	require.NoError(t.T(), err)
+
+	// erasing twice finds nothing to erase
+	_, err = queries.EraseUser(u.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	var erased entity.User
+	require.NoError(t.T(), t.TX.Get(&erased, `SELECT * FROM users WHERE id = $1`, u.ID))
+
+	assert.Equal(t.T(), fmt.Sprintf("erased-%d@invalid", u.ID), erased.Email)
+	assert.Empty(t.T(), erased.FirstName)
+	assert.Empty(t.T(), erased.Password)
+	assert.True(t.T(), erased.DeletedAt.Valid)
+	assert.True(t.T(), erased.ErasedAt.Valid)
+
+	notes, err = queries.GetNotes(u.ID)
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), notes)
+
+	attempts, err := queries.GetLoginAttempts(u.ID)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), attempts, 1)
+	assert.Empty(t.T(), attempts[0].IP)
+
+	var left struct {
+		Outbox     int `db:"outbox"`
+		Deliveries int `db:"deliveries"`
+		Keys       int `db:"keys"`
+	}
+	require.NoError(t.T(), t.TX.Get(&left, `SELECT (SELECT COUNT(*) FROM outbox) AS outbox,
+		(SELECT COUNT(*) FROM webhook_deliveries) AS deliveries, (SELECT COUNT(*) FROM idempotency_keys) AS keys`))
+	assert.Equal(t.T(), 1, left.Outbox)
+	assert.Equal(t.T(), 1, left.Deliveries)
+	assert.Equal(t.T(), 0, left.Keys)
+
+	// an erased user cannot be restored
+	_, err = user.NewUserQueries(t.DB, t.TX).RestoreUser(u.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+}
@@ -0,0 +1,360 @@
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/jobs"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/pkg/errors"
+)
+
+// Events recorded by the privacy service
+const (
+	EventDataExported = "user.data_exported"
+	EventUserErased   = "user.erased"
+)
+
+type Service interface {
+	// Export returns the last export of the user when it is still being
+	// generated or can be downloaded, or starts a new one.
+	Export(userID int64) (Export, error)
+	GetExport(userID int64, exportID int64) (Export, error)
+	// Erase anonymises the user and deletes their personal data.
+	Erase(userID int64, actor *entity.User, client auth.Client) error
+	PurgeExpiredExports() (int, error)
+	// Generate writes the archive of an export, it is the handler of ExportJob.
+	Generate(ctx context.Context, job ExportJob) error
+}
+
+// ExportJob generates a data export in the background.
+type ExportJob struct {
+	ExportID int64 `json:"export_id"`
+}
+
+// Kind implements jobs.Job
+func (ExportJob) Kind() string {
+	return "privacy.export"
+}
+
+// Options configures the privacy service.
+type Options struct {
+	// Dir is where the export archives are written
+	Dir string
+	// ExportTTL is how long an export can be downloaded
+	ExportTTL time.Duration
+}
+
+// Export is a data export and the path to download it once ready.
+type Export struct {
+	*entity.DataExport
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// Render implements render.Renderer
+func (e *Export) Render(w http.ResponseWriter, r *http.Request) error {
+	// still being generated
+	if e.Status == entity.ExportPending {
+		render.Status(r, http.StatusAccepted)
+	}
+	return nil
+}
+
+// IsAvailable reports whether the export is being generated or can be downloaded.
+func (e Export) IsAvailable() bool {
+	switch e.Status {
+	case entity.ExportPending:
+		return true
+	case entity.ExportReady:
+		return time.Now().Before(e.ExpiresAt.Time)
+	default:
+		return false
+	}
+}
+
+type service struct {
+	db     *sqlx.DB
+	repo   PrivacyQueries
+	users  user.UserQueries
+	events audit.AuditQueries
+	opts   Options
+}
+
+func NewService(db *sqlx.DB, repo PrivacyQueries, users user.UserQueries, events audit.AuditQueries, opts Options) Service {
+	return &service{db: db, repo: repo, users: users, events: events, opts: opts}
+}
+
+// Export implements Service
+func (s *service) Export(userID int64) (Export, error) {
+	u, err := s.getUser(userID)
+	if err != nil {
+		return Export{}, err
+	}
+
+	last, err := s.repo.LastExport(u.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Export{}, err
+	}
+
+	if err == nil {
+		if export := newExport(last); export.IsAvailable() {
+			return export, nil
+		}
+	}
+
+	var record *entity.DataExport
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if record, err = NewPrivacyQueries(s.db, tx).CreateExport(u.ID, time.Now().Add(s.opts.ExportTTL)); err != nil {
+			return err
+		}
+
+		_, err = jobs.Enqueue(s.db, tx, ExportJob{ExportID: record.ID})
+
+		return err
+	})
+
+	if err != nil {
+		return Export{}, err
+	}
+
+	return newExport(record), nil
+}
+
+// GetExport implements Service
+func (s *service) GetExport(userID int64, exportID int64) (Export, error) {
+	record, err := s.repo.GetExport(exportID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Export{}, apperrors.NewNotFound("export", fmt.Sprint(exportID))
+		}
+		return Export{}, err
+	}
+
+	// do not reveal the exports of other users
+	if record.UserID != userID {
+		return Export{}, apperrors.NewNotFound("export", fmt.Sprint(exportID))
+	}
+
+	return newExport(record), nil
+}
+
+// Erase implements Service
+func (s *service) Erase(userID int64, actor *entity.User, client auth.Client) error {
+	var paths []string
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if paths, err = NewPrivacyQueries(s.db, tx).EraseUser(userID); err != nil {
+			return err
+		}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(client.Metadata(actor).Event(EventUserErased, audit.TargetUser, userID))
+	})
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewNotFound("user", fmt.Sprint(userID))
+		}
+		return err
+	}
+
+	removeFiles(paths)
+
+	return nil
+}
+
+// PurgeExpiredExports implements Service
+func (s *service) PurgeExpiredExports() (int, error) {
+	paths, err := s.repo.DeleteExpiredExports(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	removeFiles(paths)
+
+	return len(paths), nil
+}
+
+func (s *service) getUser(userID int64) (*entity.User, error) {
+	u, err := s.users.GetUser(userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apperrors.NewNotFound("user", fmt.Sprint(userID))
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Generate implements Service
+//
+// It writes the archive of the export and marks it ready, or failed. Errors
+// writing the archive are not retried, the user can ask for a new export.
+func (s *service) Generate(ctx context.Context, job ExportJob) error {
+	record, err := s.repo.GetExport(job.ExportID)
+	if err != nil {
+		// erased with the user in the meantime
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if record.Status != entity.ExportPending {
+		return nil
+	}
+
+	u, err := s.users.GetUser(record.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.repo.FailExport(record.ID, apperrors.ServerError)
+		}
+		return err
+	}
+
+	path, err := s.writeArchive(u, record)
+	if err != nil {
+		log.Printf("data export %d error: %v", record.ID, err)
+
+		return s.repo.FailExport(record.ID, apperrors.ServerError)
+	}
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewPrivacyQueries(s.db, tx).CompleteExport(record.ID, path); err != nil {
+			return err
+		}
+
+		event := audit.Metadata{}.Event(EventDataExported, audit.TargetUser, u.ID)
+		event.Details["export_id"] = record.ID
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		removeFiles([]string{path})
+	}
+
+	return err
+}
+
+// writeArchive writes a ZIP archive with one JSON document per kind of data.
+func (s *service) writeArchive(u *entity.User, record *entity.DataExport) (_ string, err error) {
+	notes, err := s.repo.GetNotes(u.ID)
+	if err != nil {
+		return "", errors.Wrap(err, "select notes error")
+	}
+
+	sessions, err := s.repo.GetSessions(u.ID)
+	if err != nil {
+		return "", errors.Wrap(err, "select sessions error")
+	}
+
+	attempts, err := s.repo.GetLoginAttempts(u.ID)
+	if err != nil {
+		return "", errors.Wrap(err, "select login attempts error")
+	}
+
+	events, err := s.events.GetUserEvents(u.ID)
+	if err != nil {
+		return "", errors.Wrap(err, "select audit events error")
+	}
+
+	if err := os.MkdirAll(s.opts.Dir, 0o700); err != nil {
+		return "", err
+	}
+
+	// the name must not be guessable from the export id alone
+	suffix, err := util.RandomToken(8)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.opts.Dir, fmt.Sprintf("export-%d-%s.zip", record.ID, suffix))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	archive := zip.NewWriter(f)
+
+	documents := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", u},
+		{"notes.json", notes},
+		{"sessions.json", sessions},
+		{"login_attempts.json", attempts},
+		{"audit_events.json", events},
+	}
+
+	for _, doc := range documents {
+		w, err := archive.Create(doc.name)
+		if err != nil {
+			return "", err
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(doc.data); err != nil {
+			return "", errors.Wrapf(err, "encoding %s error", doc.name)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+func newExport(record *entity.DataExport) Export {
+	export := Export{DataExport: record}
+
+	if record.Status == entity.ExportReady {
+		export.DownloadURL = fmt.Sprintf("/api/users/%d/export/%d/download", record.UserID, record.ID)
+	}
+
+	return export
+}
+
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("remove export archive error: %v", err)
+		}
+	}
+}
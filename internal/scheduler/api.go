@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+)
+
+// RegisterAdminHandlers adds the endpoints of the schedules to r, which must
+// already require an admin.
+func RegisterAdminHandlers(r chi.Router, scheduler *Scheduler) {
+	res := resource{scheduler}
+
+	r.Get("/schedules", res.list)                // GET /admin/schedules - read the schedules with their next tick and last run
+	r.Get("/schedules/{name}/runs", res.runs)    // GET /admin/schedules/{name}/runs - read the run history of a schedule, newest first
+	r.Post("/schedules/{name}/run", res.trigger) // POST /admin/schedules/{name}/run - run a schedule now, the response is the finished run
+}
+
+type resource struct {
+	scheduler *Scheduler
+}
+
+func (c resource) list(w http.ResponseWriter, r *http.Request) {
+	schedules, err := c.scheduler.Schedules()
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for i := range schedules {
+		list = append(list, &schedules[i])
+	}
+
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) runs(w http.ResponseWriter, r *http.Request) {
+	page, err := pagination.NewCursorFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.scheduler.Runs(chi.URLParam(r, "name"), page); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, page)
+}
+
+func (c resource) trigger(w http.ResponseWriter, r *http.Request) {
+	run, err := c.scheduler.Trigger(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &RunResponse{*run})
+}
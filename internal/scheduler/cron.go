@@ -0,0 +1,194 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression of five fields: minute, hour, day of
+// month, month and day of week. Each field is "*", a value, a range "a-b" or
+// a comma separated list of those, optionally stepped with "/n". Months and
+// days of week can be named ("jan", "mon"), Sunday is 0 or 7. The
+// descriptors @yearly, @monthly, @weekly, @daily and @hourly are accepted.
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// when both days are restricted a day matching either runs, as in cron
+	domAny, dowAny bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	months = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	days   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseCron parses a cron expression.
+func ParseCron(spec string) (*Cron, error) {
+	expr := strings.TrimSpace(spec)
+	if d, ok := descriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var c Cron
+	var err error
+
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q: minute: %w", spec, err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q: hour: %w", spec, err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q: day of month: %w", spec, err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12, months); err != nil {
+		return nil, fmt.Errorf("cron expression %q: month: %w", spec, err)
+	}
+	if c.dow, err = parseField(fields[4], 0, 7, days); err != nil {
+		return nil, fmt.Errorf("cron expression %q: day of week: %w", spec, err)
+	}
+
+	// 7 is another Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+
+	c.domAny = strings.HasPrefix(fields[2], "*")
+	c.dowAny = strings.HasPrefix(fields[4], "*")
+
+	return &c, nil
+}
+
+// parseField returns the bit set of the values of field within [min, max].
+func parseField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(field, ",") {
+		expr, step := item, 1
+
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			expr, step = item[:i], n
+		}
+
+		lo, hi := min, max
+
+		switch {
+		case expr == "*":
+		case strings.Contains(expr, "-"):
+			parts := strings.SplitN(expr, "-", 2)
+
+			var err error
+			if lo, err = parseValue(parts[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(parts[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", expr)
+			}
+		default:
+			v, err := parseValue(expr, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			// "5/15" runs from 5 to the end of the range
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// parseValue parses a number, or a name when names are given, within
+// [min, max].
+func parseValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, min, max)
+	}
+
+	return v, nil
+}
+
+// Next returns the first time after t matching c, in the location of t. It
+// returns the zero time when there is none within five years (eg, "0 0 30 2 *").
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+
+	for i := 0; i < 5*366; i++ {
+		if c.month&(1<<uint(t.Month())) != 0 && c.matchDay(t) {
+			for h := t.Hour(); h < 24; h++ {
+				if c.hour&(1<<uint(h)) == 0 {
+					continue
+				}
+
+				m := 0
+				if h == t.Hour() {
+					m = t.Minute()
+				}
+
+				for ; m < 60; m++ {
+					if c.minute&(1<<uint(m)) != 0 {
+						return time.Date(t.Year(), t.Month(), t.Day(), h, m, 0, 0, loc)
+					}
+				}
+			}
+		}
+
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+	}
+
+	return time.Time{}
+}
+
+// matchDay reports whether the day of t matches the day of month and day of
+// week fields.
+func (c *Cron) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
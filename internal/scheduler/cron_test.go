@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, spec := range []string{"* * * * *", "*/15 0-6,22 1 jan-mar mon-fri", "5/10 * * * 7", "@daily", "@Hourly"} {
+		_, err := ParseCron(spec)
+		assert.NoError(t, err, spec)
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *", "@every 1h"} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2022, time.December, 31, 23, 50, 30, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2022, time.December, 31, 23, 51, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"50 23 * * *", time.Date(2023, time.January, 1, 23, 50, 0, 0, time.UTC)},
+		{"30 4 * * mon", time.Date(2023, time.January, 2, 4, 30, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// restricted days of month and week match either
+		{"0 12 15 * sun", time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+
+	for _, c := range cases {
+		cron, err := ParseCron(c.spec)
+		require.NoError(t, err, c.spec)
+		assert.Equal(t, c.next, cron.Next(from), c.spec)
+	}
+}
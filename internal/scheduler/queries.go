@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+type ScheduleQueries interface {
+	StartRun(schedule string, triggeredBy string, scheduledFor *time.Time) (*entity.ScheduleRun, error)
+	FinishRun(id int64, status string, message string, duration time.Duration) (*entity.ScheduleRun, error)
+	GetRuns(schedule string, before int64, limit int) ([]entity.ScheduleRun, error)
+	LastRuns() (map[string]entity.ScheduleRun, error)
+	LastTicks() (map[string]time.Time, error)
+	DeleteRuns(before time.Time) (int64, error)
+}
+
+// scheduleQueries struct for queries from the schedule_runs table.
+type scheduleQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewScheduleQueries(db *sqlx.DB, tx *sqlx.Tx) ScheduleQueries {
+	return &scheduleQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *scheduleQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// StartRun implements ScheduleQueries
+//
+// sql.ErrNoRows is returned when the tick scheduledFor already has a run.
+func (q *scheduleQueries) StartRun(schedule string, triggeredBy string, scheduledFor *time.Time) (*entity.ScheduleRun, error) {
+	query := `INSERT INTO schedule_runs (schedule, triggered_by, scheduled_for, status) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (schedule, scheduled_for) DO NOTHING RETURNING *`
+
+	var run entity.ScheduleRun
+
+	err := q.conn().QueryRowx(query, schedule, triggeredBy, scheduledFor, entity.RunRunning).StructScan(&run)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "start schedule run error")
+	}
+
+	return &run, nil
+}
+
+// FinishRun implements ScheduleQueries
+func (q *scheduleQueries) FinishRun(id int64, status string, message string, duration time.Duration) (*entity.ScheduleRun, error) {
+	query := `UPDATE schedule_runs SET status = $2, error = $3, duration_ms = $4, finished_at = NOW()
+		WHERE id = $1 RETURNING *`
+
+	var run entity.ScheduleRun
+
+	err := q.conn().QueryRowx(query, id, status, message, duration.Milliseconds()).StructScan(&run)
+	if err != nil {
+		return nil, errors.Wrap(err, "finish schedule run error")
+	}
+
+	return &run, nil
+}
+
+// GetRuns implements ScheduleQueries
+func (q *scheduleQueries) GetRuns(schedule string, before int64, limit int) ([]entity.ScheduleRun, error) {
+	runs := []entity.ScheduleRun{}
+
+	query := `SELECT * FROM schedule_runs WHERE schedule = $1 AND ($2 = 0 OR id < $2) ORDER BY id DESC LIMIT $3`
+
+	err := sqlx.Select(q.conn(), &runs, query, schedule, before, limit)
+
+	return runs, err
+}
+
+// LastRuns implements ScheduleQueries
+//
+// It returns the latest run of every schedule which ran, by schedule.
+func (q *scheduleQueries) LastRuns() (map[string]entity.ScheduleRun, error) {
+	runs := []entity.ScheduleRun{}
+
+	query := `SELECT DISTINCT ON (schedule) * FROM schedule_runs ORDER BY schedule, id DESC`
+
+	if err := sqlx.Select(q.conn(), &runs, query); err != nil {
+		return nil, errors.Wrap(err, "select last schedule runs error")
+	}
+
+	last := make(map[string]entity.ScheduleRun, len(runs))
+	for _, run := range runs {
+		last[run.Schedule] = run
+	}
+
+	return last, nil
+}
+
+// LastTicks implements ScheduleQueries
+//
+// It returns the latest tick which was run of every schedule, by schedule.
+func (q *scheduleQueries) LastTicks() (map[string]time.Time, error) {
+	rows, err := q.conn().Queryx(`SELECT schedule, MAX(scheduled_for) FROM schedule_runs
+		WHERE scheduled_for IS NOT NULL GROUP BY schedule`)
+	if err != nil {
+		return nil, errors.Wrap(err, "select last schedule ticks error")
+	}
+	defer rows.Close()
+
+	ticks := map[string]time.Time{}
+
+	for rows.Next() {
+		var schedule string
+		var tick time.Time
+
+		if err := rows.Scan(&schedule, &tick); err != nil {
+			return nil, errors.Wrap(err, "scan last schedule tick error")
+		}
+		ticks[schedule] = tick
+	}
+
+	return ticks, rows.Err()
+}
+
+// DeleteRuns implements ScheduleQueries
+func (q *scheduleQueries) DeleteRuns(before time.Time) (int64, error) {
+	res, err := q.conn().Exec(`DELETE FROM schedule_runs WHERE started_at < $1 AND status <> $2`, before, entity.RunRunning)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete schedule runs error")
+	}
+
+	return res.RowsAffected()
+}
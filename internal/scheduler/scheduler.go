@@ -0,0 +1,374 @@
+// Package scheduler runs recurring tasks on cron schedules registered in
+// code. Every replica runs a Scheduler but only the leader, the one holding
+// a Postgres advisory lock, starts the scheduled runs: each tick runs once
+// cluster-wide. The runs are recorded in the schedule_runs table and a
+// schedule can also be run manually. Schedules are evaluated in UTC.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+	"github.com/pkg/errors"
+)
+
+// Audited actions
+const (
+	EventScheduleTriggered = "schedule.triggered"
+
+	targetSchedule = "schedule"
+)
+
+const (
+	// leaderLock is the advisory lock held by the leader
+	leaderLock int64 = 0x5343484544 // "SCHED"
+	// runLock is the first key of the advisory lock held while a schedule
+	// runs, the second one is the hash of its name
+	runLock int32 = 0x52554e // "RUN"
+)
+
+// ErrRunning is returned when a schedule is run while it is already running.
+var ErrRunning = errors.New("schedule is already running")
+
+// Task is the work of a schedule.
+type Task func(ctx context.Context) error
+
+// Options configures the scheduler.
+type Options struct {
+	// PollInterval is how often the leader looks for due schedules, and the
+	// other replicas try to become the leader
+	PollInterval time.Duration
+}
+
+// Schedule represents the data about a registered schedule.
+type Schedule struct {
+	Name        string `json:"name"`
+	Spec        string `json:"spec"`
+	Description string `json:"description"`
+	// NextRunAt is the next tick of the schedule
+	NextRunAt time.Time           `json:"next_run_at"`
+	LastRun   *entity.ScheduleRun `json:"last_run"`
+}
+
+// Render implements render.Renderer
+func (*Schedule) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// RunResponse represents a run of a schedule.
+type RunResponse struct {
+	entity.ScheduleRun
+}
+
+// Render implements render.Renderer
+func (*RunResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type entry struct {
+	name        string
+	spec        string
+	description string
+	cron        *Cron
+	task        Task
+}
+
+// Scheduler runs the tasks of the registered schedules.
+type Scheduler struct {
+	db   *sqlx.DB
+	opts Options
+
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+func NewScheduler(db *sqlx.DB, opts Options) *Scheduler {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	return &Scheduler{db: db, opts: opts, entries: map[string]*entry{}}
+}
+
+// Register adds the schedule name running task on the cron expression spec.
+func (s *Scheduler) Register(name string, spec string, description string, task Task) error {
+	cron, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[name]; ok {
+		return fmt.Errorf("schedule %q is already registered", name)
+	}
+
+	s.entries[name] = &entry{name, spec, description, cron, task}
+
+	return nil
+}
+
+// entry returns the registered schedule name.
+func (s *Scheduler) entry(name string) (*entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.entries[name]
+	if !ok {
+		return nil, apperrors.NewNotFound("schedule", name)
+	}
+
+	return e, nil
+}
+
+// sorted returns the registered schedules by name.
+func (s *Scheduler) sorted() []*entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]*entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	return entries
+}
+
+// Schedules returns the registered schedules by name, with their last run.
+func (s *Scheduler) Schedules() ([]Schedule, error) {
+	last, err := NewScheduleQueries(s.db, nil).LastRuns()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	schedules := []Schedule{}
+
+	for _, e := range s.sorted() {
+		schedule := Schedule{
+			Name:        e.name,
+			Spec:        e.spec,
+			Description: e.description,
+			NextRunAt:   e.cron.Next(now),
+		}
+		if run, ok := last[e.name]; ok {
+			schedule.LastRun = &run
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
+
+// Runs reads a page of the runs of the schedule name, newest first.
+func (s *Scheduler) Runs(name string, page *pagination.Cursor) error {
+	if _, err := s.entry(name); err != nil {
+		return err
+	}
+
+	runs, err := NewScheduleQueries(s.db, nil).GetRuns(name, page.After, page.Fetch())
+	if err != nil {
+		return err
+	}
+
+	n := page.Page(len(runs), func(i int) int64 { return runs[i].ID })
+
+	page.Items = runs[:n]
+
+	return nil
+}
+
+// Trigger runs the schedule name now and returns the run once it finished.
+// A failing task is not an error, it is recorded in the run.
+func (s *Scheduler) Trigger(ctx context.Context, name string) (*entity.ScheduleRun, error) {
+	e, err := s.entry(name)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := s.run(ctx, e, nil)
+	if errors.Is(err, ErrRunning) {
+		return nil, &apperrors.Error{Type: apperrors.Conflict, Message: fmt.Sprintf("The schedule %s is already running", name)}
+	}
+
+	return run, err
+}
+
+// Run starts the scheduled runs while this process is the leader, and tries
+// to become the leader otherwise, until ctx is done. It then drains: it
+// returns once the runs it started are finished.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.lead(ctx, ticker.C); err != nil {
+			log.Printf("scheduler error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead starts the runs of the due schedules for as long as it holds the
+// leader lock. It returns right away when another replica holds it.
+func (s *Scheduler) lead(ctx context.Context, tick <-chan time.Time) error {
+	// the lock belongs to the session, a dedicated connection keeps it
+	conn, err := s.db.Connx(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "scheduler connection error")
+	}
+	defer conn.Close()
+
+	var leader bool
+	if err := conn.GetContext(ctx, &leader, `SELECT pg_try_advisory_lock($1)`, leaderLock); err != nil {
+		return errors.Wrap(err, "scheduler leader lock error")
+	}
+
+	if !leader {
+		return nil
+	}
+
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, leaderLock)
+
+	next, err := s.plan(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		now := time.Now().UTC()
+
+		for _, e := range s.sorted() {
+			at, ok := next[e.name]
+			if !ok || at.IsZero() || at.After(now) {
+				continue
+			}
+
+			next[e.name] = e.cron.Next(now)
+
+			wg.Add(1)
+			go func(e *entry, at time.Time) {
+				defer wg.Done()
+
+				if _, err := s.run(context.Background(), e, &at); err != nil && !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("schedule %s error: %v", e.name, err)
+				}
+			}(e, at)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+		}
+
+		// the lock is gone with the connection, another replica may lead
+		if err := conn.PingContext(context.Background()); err != nil {
+			return errors.Wrap(err, "scheduler connection lost")
+		}
+	}
+}
+
+// plan returns the next tick of every schedule. A tick missed while no
+// replica was leading runs once, right away.
+func (s *Scheduler) plan(now time.Time) (map[string]time.Time, error) {
+	last, err := NewScheduleQueries(s.db, nil).LastTicks()
+	if err != nil {
+		return nil, err
+	}
+
+	next := map[string]time.Time{}
+
+	for _, e := range s.sorted() {
+		if tick, ok := last[e.name]; ok {
+			next[e.name] = e.cron.Next(tick.UTC())
+		} else {
+			next[e.name] = e.cron.Next(now)
+		}
+	}
+
+	return next, nil
+}
+
+// run runs e under its advisory lock, so that a schedule never runs twice at
+// the same time, and records the run. scheduledFor is the tick of scheduled
+// runs, sql.ErrNoRows is returned when the tick already ran.
+func (s *Scheduler) run(ctx context.Context, e *entry, scheduledFor *time.Time) (*entity.ScheduleRun, error) {
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "schedule connection error")
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock($1, hashtext($2))`, runLock, e.name); err != nil {
+		return nil, errors.Wrap(err, "schedule lock error")
+	}
+
+	if !locked {
+		return nil, ErrRunning
+	}
+
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, runLock, e.name)
+
+	var run *entity.ScheduleRun
+
+	if scheduledFor != nil {
+		run, err = NewScheduleQueries(s.db, nil).StartRun(e.name, entity.TriggerSchedule, scheduledFor)
+	} else {
+		err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+			if run, err = NewScheduleQueries(s.db, tx).StartRun(e.name, entity.TriggerManual, nil); err != nil {
+				return err
+			}
+
+			return audit.NewAuditQueries(s.db, tx).Insert(audit.FromContext(ctx).Event(EventScheduleTriggered, targetSchedule, e.name))
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	status, message := entity.RunSucceeded, ""
+
+	if err := call(e.task); err != nil {
+		status, message = entity.RunFailed, err.Error()
+		log.Printf("schedule %s failed: %v", e.name, err)
+	}
+
+	return NewScheduleQueries(s.db, nil).FinishRun(run.ID, status, message, time.Since(start))
+}
+
+// call runs task, a panic is returned as an error. Tasks are not interrupted
+// by the shutdown, their context is never canceled.
+func call(task Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("schedule panic: %v", r)
+		}
+	}()
+
+	return task(context.Background())
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRegister(t *testing.T) {
+	s := NewScheduler(nil, Options{})
+	task := func(ctx context.Context) error { return nil }
+
+	require.NoError(t, s.Register("b", "@daily", "", task))
+	require.NoError(t, s.Register("a", "@hourly", "", task))
+
+	assert.Error(t, s.Register("a", "@daily", "", task))
+	assert.Error(t, s.Register("c", "every day", "", task))
+
+	var names []string
+	for _, e := range s.sorted() {
+		names = append(names, e.name)
+	}
+	assert.Equal(t, []string{"a", "b"}, names)
+}
+
+type schedulerSuiteTest struct {
+	test.TSuite
+}
+
+func TestSchedulerSuiteTest(t *testing.T) {
+	suite.Run(t, new(schedulerSuiteTest))
+}
+
+func (t *schedulerSuiteTest) TestTrigger() {
+	s := NewScheduler(t.DB, Options{})
+
+	calls := 0
+	require.NoError(t.T(), s.Register("purge", "@daily", "", func(ctx context.Context) error {
+		calls++
+		if calls == 2 {
+			panic("boom")
+		}
+		return nil
+	}))
+
+	run, err := s.Trigger(context.Background(), "purge")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.RunSucceeded, run.Status)
+	assert.Equal(t.T(), entity.TriggerManual, run.TriggeredBy)
+	assert.False(t.T(), run.ScheduledFor.Valid)
+	assert.True(t.T(), run.FinishedAt.Valid)
+
+	// a failing task is recorded in the run
+	run, err = s.Trigger(context.Background(), "purge")
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.RunFailed, run.Status)
+	assert.Equal(t.T(), "schedule panic: boom", run.Error)
+
+	_, err = s.Trigger(context.Background(), "unknown")
+	assert.Equal(t.T(), apperrors.NotFound, err.(*apperrors.Error).Type)
+
+	page := &pagination.Cursor{Limit: 10}
+	require.NoError(t.T(), s.Runs("purge", page))
+	runs := page.Items.([]entity.ScheduleRun)
+	require.Len(t.T(), runs, 2)
+	assert.Equal(t.T(), entity.RunFailed, runs[0].Status)
+
+	schedules, err := s.Schedules()
+	require.NoError(t.T(), err)
+	require.Len(t.T(), schedules, 1)
+	assert.Equal(t.T(), runs[0].ID, schedules[0].LastRun.ID)
+	assert.True(t.T(), schedules[0].NextRunAt.After(time.Now()))
+}
+
+func (t *schedulerSuiteTest) TestTriggerWhileRunning() {
+	s := NewScheduler(t.DB, Options{})
+	require.NoError(t.T(), s.Register("purge", "@daily", "", func(ctx context.Context) error { return nil }))
+
+	// another replica runs the schedule
+	conn, err := t.DB.Connx(context.Background())
+	require.NoError(t.T(), err)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1, hashtext($2))`, runLock, "purge")
+	require.NoError(t.T(), err)
+
+	_, err = s.Trigger(context.Background(), "purge")
+	assert.Equal(t.T(), apperrors.Conflict, err.(*apperrors.Error).Type)
+
+	_, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, runLock, "purge")
+	require.NoError(t.T(), err)
+
+	_, err = s.Trigger(context.Background(), "purge")
+	assert.NoError(t.T(), err)
+}
+
+func (t *schedulerSuiteTest) TestTickRunsOnce() {
+	s := NewScheduler(t.DB, Options{})
+
+	calls := 0
+	require.NoError(t.T(), s.Register("purge", "@hourly", "", func(ctx context.Context) error {
+		calls++
+		return errors.New("unavailable")
+	}))
+
+	e, err := s.entry("purge")
+	require.NoError(t.T(), err)
+
+	tick := time.Date(2022, time.December, 31, 23, 0, 0, 0, time.UTC)
+
+	run, err := s.run(context.Background(), e, &tick)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.TriggerSchedule, run.TriggeredBy)
+	assert.Equal(t.T(), "unavailable", run.Error)
+
+	// the leader which took over does not run the tick again
+	_, err = s.run(context.Background(), e, &tick)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+	assert.Equal(t.T(), 1, calls)
+
+	// the tick missed since then runs right away
+	next, err := s.plan(time.Date(2023, time.January, 2, 10, 30, 0, 0, time.UTC))
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), next["purge"])
+}
+
+func (t *schedulerSuiteTest) TestLead() {
+	s := NewScheduler(t.DB, Options{PollInterval: 10 * time.Millisecond})
+	require.NoError(t.T(), s.Register("purge", "@hourly", "", func(ctx context.Context) error { return nil }))
+
+	// another replica leads
+	conn, err := t.DB.Connx(context.Background())
+	require.NoError(t.T(), err)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, leaderLock)
+	require.NoError(t.T(), err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// lead returns right away when it is not the leader
+	require.NoError(t.T(), s.lead(ctx, nil))
+
+	_, err = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, leaderLock)
+	require.NoError(t.T(), err)
+
+	tick := make(chan time.Time)
+	done := make(chan error)
+
+	go func() {
+		done <- s.lead(ctx, tick)
+	}()
+
+	// lead holds the lock until ctx is done
+	time.Sleep(20 * time.Millisecond)
+
+	var locked bool
+	require.NoError(t.T(), conn.GetContext(context.Background(), &locked, `SELECT pg_try_advisory_lock($1)`, leaderLock))
+	assert.False(t.T(), locked)
+
+	require.NoError(t.T(), <-done)
+
+	require.NoError(t.T(), conn.GetContext(context.Background(), &locked, `SELECT pg_try_advisory_lock($1)`, leaderLock))
+	assert.True(t.T(), locked)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefix starts the names of the files being written, they are not
+// blobs yet.
+const tempPrefix = ".tmp-"
+
+type localStorage struct {
+	dir string
+}
+
+// NewLocalStorage returns a Storage keeping the blobs as files of dir, the
+// segments of the keys are directories.
+func NewLocalStorage(dir string) (Storage, error) {
+	if dir == "" {
+		return nil, errors.New("storage: missing directory")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+
+	return &localStorage{dir}, nil
+}
+
+func (s *localStorage) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Put implements Storage
+//
+// The blob is written to a temporary file renamed once complete, readers
+// never see a partial blob.
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (err error) {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	path := s.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	if n != size {
+		return fmt.Errorf("storage: wrote %d bytes of %d", n, size)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+
+	return nil
+}
+
+type localObject struct {
+	*os.File
+	info ObjectInfo
+}
+
+// Info implements Object
+func (o localObject) Info() ObjectInfo {
+	return o.info
+}
+
+// Open implements Storage
+func (s *localStorage) Open(ctx context.Context, key string) (Object, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+
+	return localObject{f, ObjectInfo{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}}, nil
+}
+
+// Delete implements Storage
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("storage: %w", err)
+	}
+
+	return nil
+}
+
+// Walk implements Storage
+func (s *localStorage) Walk(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// deleted while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		return fn(ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+
+	return err
+}
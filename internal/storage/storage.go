@@ -0,0 +1,95 @@
+// Package storage stores blobs, like the attachments of the notes, through
+// pluggable backends: a directory of the local filesystem or an
+// S3-compatible object store.
+//
+// Blobs are addressed by keys of slash separated segments (eg,
+// "attachments/12/3f2a..."), the metadata of the blobs is kept by their
+// owners, in the database.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned when opening a key without a blob.
+var ErrNotExist = errors.New("storage: blob does not exist")
+
+// ObjectInfo describes a stored blob.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Object is a blob opened for reading. It can seek, so it serves range
+// requests through http.ServeContent.
+type Object interface {
+	io.ReadSeekCloser
+	Info() ObjectInfo
+}
+
+// Storage stores blobs by key.
+type Storage interface {
+	// Put stores the size bytes of r at key, replacing the blob it held
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open opens the blob at key, or returns ErrNotExist
+	Open(ctx context.Context, key string) (Object, error)
+	// Delete deletes the blob at key, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn with the blobs whose key starts with prefix, it stops at
+	// the first error of fn
+	Walk(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// Config holds the settings used to build a Storage.
+type Config struct {
+	// Driver is one of "local" or "s3"
+	Driver string
+	// Dir is where the local driver stores the blobs
+	Dir string
+	// Endpoint is the URL of the S3-compatible store, AWS when it is empty
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket in the path of the URLs rather than in
+	// the host name, most stores other than AWS require it
+	PathStyle bool
+}
+
+// New builds the Storage selected by cfg.Driver.
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "local", "":
+		return NewLocalStorage(cfg.Dir)
+	case "s3":
+		return NewS3Storage(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+
+// validKey checks key is made of non empty segments without dot segments,
+// so it cannot escape the directory of the local driver.
+func validKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("storage: invalid key %q", key)
+		}
+	}
+
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
-	_pg "github.com/golang-migrate/migrate/v4/database/pgx"
+	_pg "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
 )
@@ -39,6 +39,9 @@ func runMigration(db *sqlx.DB, migrationsDirLocation string) (*MigrationTest, er
 
 	pathToMigrate := strings.Join(dataPath, "")
 
+	// the postgres driver of migrate, the pgx one links the stdlib of pgx/v4
+	// which registers the "pgx" database/sql driver a second time, next to
+	// the one of pgx/v5, and panics
 	driver, err := _pg.WithInstance(db.DB, &_pg.Config{})
 	if err != nil {
 		return nil, err
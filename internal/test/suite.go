@@ -36,7 +36,7 @@ func (t *TSuite) SetupSuite() {
 		log.Fatalf("Could not ping db: %v", err)
 	}
 
-	t.TruncateTables = "notes, users"
+	t.TruncateTables = "note_attachments, note_links, note_shares, note_settings, note_revisions, note_tags, tags, schedule_runs, jobs, webhook_deliveries, webhooks, outbox, idempotency_keys, audit_events, data_exports, account_lockouts, login_attempts, recovery_codes, user_totp, sessions, verification_tokens, notes, users"
 	t.Migration, err = runMigration(t.DB, "../../db/migrations")
 
 	require.NoError(t.T(), err)
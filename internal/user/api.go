@@ -2,7 +2,6 @@ package user
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -10,6 +9,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/pkg/etag"
 	"github.com/opaulochaves/myserver/pkg/pagination"
 )
 
@@ -30,6 +30,23 @@ func RegisterHandlers(service Service) *chi.Mux {
 	return r
 }
 
+// RegisterAdminHandlers adds the admin endpoints of the user service to r,
+// which must already require an admin.
+func RegisterAdminHandlers(r chi.Router, service Service) {
+	res := resource{service}
+
+	r.Post("/users/{id}/restore", res.restore) // POST /admin/users/{id}/restore - restore a soft deleted user
+
+	r.Route("/users/{id}", func(r chi.Router) {
+		r.Use(res.userContext)
+		r.Get("/", res.get)                 // GET /admin/users/{id} - read a user and its ETag
+		r.Put("/", res.update(true))        // PUT /admin/users/{id} - replace the names of a user, requires If-Match
+		r.Patch("/", res.update(false))     // PATCH /admin/users/{id} - update some fields of a user, requires If-Match
+		r.Delete("/", res.delete)           // DELETE /admin/users/{id} - soft delete a user, requires If-Match
+		r.Put("/password", res.setPassword) // PUT /admin/users/{id}/password - replace the password of a user and revoke their sessions
+	})
+}
+
 type resource struct {
 	service Service
 }
@@ -50,7 +67,12 @@ func (r resource) list(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	pages.Items = users
+	items := []*UserResponse{}
+	for _, u := range users {
+		items = append(items, &UserResponse{User: u, AvatarURLs: AvatarURLs(u.User)})
+	}
+
+	pages.Items = items
 
 	if err := render.Render(w, req, pages); err != nil {
 		render.Render(w, req, apperrors.ErrRender(err))
@@ -66,10 +88,10 @@ func (c resource) create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := c.service.Create(input)
+	user, err := c.service.Create(r.Context(), input)
 
 	if err != nil {
-		render.Render(w, r, apperrors.ErrInternalError(err))
+		render.Render(w, r, apperrors.ErrFromError(err))
 		return
 	}
 
@@ -80,7 +102,9 @@ func (c resource) create(w http.ResponseWriter, r *http.Request) {
 func (c resource) get(w http.ResponseWriter, r *http.Request) {
 	user := r.Context().Value("user").(User)
 
-	fmt.Println(">>>>>>", user)
+	if etag.NotModified(w, r, user.Version) {
+		return
+	}
 
 	if err := render.Render(w, r, &UserResponse{User: user}); err != nil {
 		render.Render(w, r, apperrors.ErrRender(err))
@@ -111,3 +135,90 @@ func (c resource) userContext(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+func (c resource) restore(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	user, err := c.service.Restore(r.Context(), userID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, &UserResponse{User: user})
+}
+
+// update handles PUT when replace is set, the names are then required, and PATCH.
+func (c resource) update(replace bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(User)
+
+		version, err := etag.IfMatch(r)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrFromError(err))
+			return
+		}
+
+		input := UpdateUserRequest{}
+
+		if err := render.Bind(r, &input); err != nil {
+			render.Render(w, r, apperrors.ErrInvalidRequest(err))
+			return
+		}
+
+		if replace {
+			if err := input.ValidateReplace(); err != nil {
+				render.Render(w, r, apperrors.ErrFromError(err))
+				return
+			}
+		}
+
+		updated, err := c.service.Update(r.Context(), user.ID, input, version)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrFromError(err))
+			return
+		}
+
+		etag.Set(w, updated.Version)
+		render.Render(w, r, &UserResponse{User: updated})
+	}
+}
+
+func (c resource) delete(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(User)
+
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	if _, err := c.service.Delete(r.Context(), user.ID, version); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) setPassword(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(User)
+
+	input := SetPasswordRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.SetPassword(r.Context(), user.ID, input.Password); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.NoContent(w, r)
+}
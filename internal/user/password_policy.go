@@ -0,0 +1,85 @@
+package user
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// PasswordPolicy decides whether a password is strong enough.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+// NewPasswordPolicy returns a policy refusing passwords shorter than
+// minLength and those listed in the breachedList file (one password per
+// line, lines starting with # are ignored). An empty path disables the list.
+func NewPasswordPolicy(minLength int, breachedList string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{MinLength: minLength, MaxLength: 100, breached: map[string]struct{}{}}
+
+	if breachedList == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(breachedList)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breached password list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read breached password list: %w", err)
+	}
+
+	return p, nil
+}
+
+// Validate checks password for the user identified by email and names.
+// Failures are returned as validation.Errors on the "password" field.
+func (p *PasswordPolicy) Validate(password, email string, names ...string) error {
+	if err := p.check(password, email, names); err != nil {
+		return validation.Errors{"password": err}
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) check(password, email string, names []string) error {
+	length := len([]rune(password))
+
+	if length < p.MinLength || length > p.MaxLength {
+		return validation.NewError("validation_password_length", fmt.Sprintf("the length must be between %d and %d", p.MinLength, p.MaxLength))
+	}
+
+	lower := strings.ToLower(password)
+
+	if _, ok := p.breached[lower]; ok {
+		return validation.NewError("validation_password_breached", "must not be a commonly used password")
+	}
+
+	// the local part of the email and the names are the first guesses of an attacker
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	parts := append([]string{local}, names...)
+
+	for _, part := range parts {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if len(part) >= 3 && strings.Contains(lower, part) {
+			return validation.NewError("validation_password_personal", "must not contain your email or name")
+		}
+	}
+
+	return nil
+}
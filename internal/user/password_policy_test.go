@@ -0,0 +1,44 @@
+package user
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(list, []byte("# comment\nPassword123\nletmein1\n"), 0o644))
+
+	policy, err := NewPasswordPolicy(8, list)
+	require.NoError(t, err)
+
+	cases := map[string]bool{
+		"correct horse battery": true,
+		"short":                 false,
+		"password123":           false, // breached, case-insensitive
+		"LETMEIN1":              false,
+		"jdoe-is-great":         false, // contains the email local part
+		"i am Johnny":           false, // contains the first name
+		"doe a deer a female":   false, // contains the last name
+	}
+
+	for password, valid := range cases {
+		err := policy.Validate(password, "jdoe@example.com", "John", "Doe")
+		if valid {
+			assert.NoError(t, err, password)
+		} else {
+			assert.Error(t, err, password)
+		}
+	}
+}
+
+func TestPasswordPolicyDefaultList(t *testing.T) {
+	policy, err := NewPasswordPolicy(8, "../../config/breached_passwords.txt")
+	require.NoError(t, err)
+
+	assert.Error(t, policy.Validate("12345678", "user01@example.com"))
+}
@@ -1,12 +1,11 @@
 package user
 
 import (
-	"fmt"
+	"database/sql"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/opaulochaves/myserver/internal/entity"
-	"github.com/opaulochaves/myserver/internal/util"
 	"github.com/pkg/errors"
 )
 
@@ -16,11 +15,20 @@ type UserQueries interface {
 	GetUserByEmail(email string) (*entity.User, error)
 	CreateUser(user *entity.User) (*entity.User, error)
 	UpdateUser(user *entity.User) (*entity.User, error)
-	DeleteUser(id int64) error
+	DeleteUser(id int64, version int64) error
+	RestoreUser(id int64) (*entity.User, error)
+	PurgeDeletedUsers(deletedBefore time.Time) ([]int64, error)
+	VerifyUser(id int64) error
+	UpdatePassword(id int64, hashedPassword string) error
+	RevokeSessions(id int64) error
+	SetAdmin(id int64, isAdmin bool) error
 	Count() (int, error)
 }
 
 // userQueries struct for queries from User model.
+//
+// Soft deleted users (deleted_at set) are left out of every query except
+// RestoreUser and PurgeDeletedUsers.
 type userQueries struct {
 	db *sqlx.DB
 	tx *sqlx.Tx
@@ -30,13 +38,22 @@ func NewUserQueries(db *sqlx.DB, tx *sqlx.Tx) UserQueries {
 	return &userQueries{db, tx}
 }
 
-// BetUserByEmail implements UserQueries
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *userQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// GetUserByEmail implements UserQueries
 func (q *userQueries) GetUserByEmail(email string) (*entity.User, error) {
 	var user entity.User
 
-	query := `SELECT * FROM users WHERE email = $1`
+	query := `SELECT * FROM users WHERE email = $1 AND deleted_at IS NULL`
 
-	err := q.tx.Get(&user, query, email)
+	err := sqlx.Get(q.conn(), &user, query, email)
 	if err != nil {
 		return &user, err
 	}
@@ -45,17 +62,14 @@ func (q *userQueries) GetUserByEmail(email string) (*entity.User, error) {
 }
 
 // CreateUser implements UserQueries
+//
+// u.Password must already be hashed, hashing is owned by the service.
 func (q *userQueries) CreateUser(u *entity.User) (*entity.User, error) {
 	query := `INSERT INTO users (email, password, first_name, last_name) VALUES ($1, $2, $3, $4) RETURNING *`
 
 	var user entity.User
 
-	hashedPassword, err := util.HashPassword(u.Password)
-	if err != nil {
-		return nil, errors.Wrap(err, "hashing password error")
-	}
-
-	err = q.tx.QueryRowx(query, u.Email, hashedPassword, u.FirstName, u.LastName).StructScan(&user)
+	err := q.conn().QueryRowx(query, u.Email, u.Password, u.FirstName, u.LastName).StructScan(&user)
 	if err != nil {
 		return nil, errors.Wrap(err, "insert user error")
 	}
@@ -64,29 +78,64 @@ func (q *userQueries) CreateUser(u *entity.User) (*entity.User, error) {
 }
 
 // DeleteUser implements UserQueries
-func (q *userQueries) DeleteUser(id int64) error {
-	query := `DELETE FROM users WHERE id = $1`
-
-	_, err := q.tx.Exec(query, id)
+//
+// The user is soft deleted, PurgeDeletedUsers removes the row for good.
+// Unless version is etag.Any it must be the current version of the user,
+// sql.ErrNoRows is returned otherwise.
+func (q *userQueries) DeleteUser(id int64, version int64) error {
+	query := `UPDATE users SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)`
+
+	res, err := q.conn().Exec(query, id, version)
 	if err != nil {
 		return errors.Wrap(err, "delete user error")
 	}
 
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
+// RestoreUser implements UserQueries
+func (q *userQueries) RestoreUser(id int64) (*entity.User, error) {
+	query := `UPDATE users SET deleted_at = NULL, updated_at = $2, version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL AND erased_at IS NULL RETURNING *`
+
+	var user entity.User
+
+	err := q.conn().QueryRowx(query, id, time.Now()).StructScan(&user)
+	if err != nil {
+		return nil, errors.Wrap(err, "restore user error")
+	}
+
+	return &user, nil
+}
+
+// PurgeDeletedUsers implements UserQueries
+//
+// Dependent rows, notes included, are removed by the foreign keys. It
+// returns the IDs of the purged users.
+func (q *userQueries) PurgeDeletedUsers(deletedBefore time.Time) ([]int64, error) {
+	var ids []int64
+
+	query := `DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING id`
+
+	if err := sqlx.Select(q.conn(), &ids, query, deletedBefore); err != nil {
+		return nil, errors.Wrap(err, "purge users error")
+	}
+
+	return ids, nil
+}
+
 // GetUser implements UserQueries
 func (q *userQueries) GetUser(id int64) (*entity.User, error) {
 	var user entity.User
 
-	query := `SELECT * FROM users WHERE id = $1`
+	query := `SELECT * FROM users WHERE id = $1 AND deleted_at IS NULL`
 
-	// TODO: improve what to use db? or tx? when? pass as param?
-	// right now it means I must call this function GetUser within a transaction.
-	// I don't need to always get the data within a transaction
-	err := q.db.Get(&user, query, id)
+	err := sqlx.Get(q.conn(), &user, query, id)
 
-	fmt.Println(err)
 	// TODO throw not found err if not user for the given id
 
 	return &user, err
@@ -96,20 +145,25 @@ func (q *userQueries) GetUser(id int64) (*entity.User, error) {
 func (q *userQueries) GetUsers(offset, limit int) ([]entity.User, error) {
 	users := []entity.User{}
 
-	query := `SELECT * FROM users ORDER BY id LIMIT $1 OFFSET $2`
+	query := `SELECT * FROM users WHERE deleted_at IS NULL ORDER BY id LIMIT $1 OFFSET $2`
 
-	err := q.db.Select(&users, query, limit, offset)
+	err := sqlx.Select(q.conn(), &users, query, limit, offset)
 
 	return users, err
 }
 
 // UpdateUser implements UserQueries
+//
+// Unless u.Version is etag.Any it must be the current version of the user,
+// sql.ErrNoRows is returned otherwise. The password is changed with
+// UpdatePassword.
 func (q *userQueries) UpdateUser(u *entity.User) (*entity.User, error) {
-	query := `UPDATE users SET first_name = $2, last_name = $3, updated_at = $4 WHERE id = $1 RETURNING *`
+	query := `UPDATE users SET first_name = $2, last_name = $3, updated_at = $4, version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ($5 = 0 OR version = $5) RETURNING *`
 
 	var user entity.User
 
-	err := q.tx.QueryRowx(query, u.ID, u.FirstName, u.LastName, time.Now()).StructScan(&user)
+	err := q.conn().QueryRowx(query, u.ID, u.FirstName, u.LastName, time.Now(), u.Version).StructScan(&user)
 	if err != nil {
 		return nil, errors.Wrap(err, "update user error")
 	}
@@ -117,11 +171,85 @@ func (q *userQueries) UpdateUser(u *entity.User) (*entity.User, error) {
 	return &user, nil
 }
 
+// VerifyUser implements UserQueries
+//
+// Verifying a verified user changes nothing. sql.ErrNoRows is returned when
+// the user does not exist.
+func (q *userQueries) VerifyUser(id int64) error {
+	query := `UPDATE users SET verified_at = COALESCE(verified_at, NOW()),
+		version = CASE WHEN verified_at IS NULL THEN version + 1 ELSE version END
+		WHERE id = $1 AND deleted_at IS NULL`
+
+	res, err := q.conn().Exec(query, id)
+	if err != nil {
+		return errors.Wrap(err, "verify user error")
+	}
+
+	return expectRow(res)
+}
+
+// UpdatePassword implements UserQueries
+//
+// sql.ErrNoRows is returned when the user does not exist.
+func (q *userQueries) UpdatePassword(id int64, hashedPassword string) error {
+	query := `UPDATE users SET password = $2, updated_at = $3, version = version + 1 WHERE id = $1 AND deleted_at IS NULL`
+
+	res, err := q.conn().Exec(query, id, hashedPassword, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "update password error")
+	}
+
+	return expectRow(res)
+}
+
+// RevokeSessions implements UserQueries
+//
+// It revokes the sessions of the user, their refresh tokens are refused from
+// then on.
+func (q *userQueries) RevokeSessions(id int64) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	_, err := q.conn().Exec(query, id)
+	if err != nil {
+		return errors.Wrap(err, "revoke sessions error")
+	}
+
+	return nil
+}
+
+// SetAdmin implements UserQueries
+//
+// sql.ErrNoRows is returned when the user does not exist.
+func (q *userQueries) SetAdmin(id int64, isAdmin bool) error {
+	query := `UPDATE users SET is_admin = $2, updated_at = $3, version = version + 1 WHERE id = $1 AND deleted_at IS NULL`
+
+	res, err := q.conn().Exec(query, id, isAdmin, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "set admin error")
+	}
+
+	return expectRow(res)
+}
+
+// expectRow returns sql.ErrNoRows when res affected no row.
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 // TODO: use a criteria if present to count
-// Count returns the number of rows on the users table
+// Count returns the number of live users
 func (q *userQueries) Count() (int, error) {
 	var count int
-	query := `SELECT COUNT(id) FROM users`
-	err := q.db.QueryRow(query).Scan(&count)
+	query := `SELECT COUNT(id) FROM users WHERE deleted_at IS NULL`
+	err := q.conn().QueryRowx(query).Scan(&count)
 	return count, err
 }
@@ -1,9 +1,14 @@
 package user
 
 import (
+	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/opaulochaves/myserver/pkg/etag"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -28,6 +33,13 @@ func (t *queriesSuiteTest) TestCreateUser() {
 
 	assert.Equal(t.T(), mockUser.Email, res.Email)
 	assert.Equal(t.T(), mockUser.FirstName, res.FirstName)
+
+	// the hash is stored as given, not hashed a second time
+	assert.Equal(t.T(), mockUser.Password, res.Password)
+
+	ok, err := util.ComparePasswords(res.Password, "12345678")
+	require.NoError(t.T(), err)
+	assert.True(t.T(), ok)
 }
 
 func (t *queriesSuiteTest) TestGetUser() {
@@ -79,6 +91,16 @@ func (t *queriesSuiteTest) TestUpdateUser() {
 	require.NoError(t.T(), err)
 
 	require.Equal(t.T(), userUpdated.FirstName, "Update First")
+	assert.Equal(t.T(), user.Version+1, userUpdated.Version)
+
+	// the version read first is stale now
+	user.FirstName = "Lost Update"
+
+	_, err = queries.UpdateUser(user)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	assert.ErrorIs(t.T(), queries.DeleteUser(user.ID, user.Version), sql.ErrNoRows)
+	assert.NoError(t.T(), queries.DeleteUser(user.ID, userUpdated.Version))
 }
 
 func (t *queriesSuiteTest) TestDeleteUser() {
@@ -90,7 +112,117 @@ func (t *queriesSuiteTest) TestDeleteUser() {
 
 	require.NoError(t.T(), err)
 
-	err = queries.DeleteUser(user.ID)
+	err = queries.DeleteUser(user.ID, etag.Any)
 
 	assert.Nil(t.T(), err)
+
+	// deleted users are hidden from the default scope
+	_, err = queries.GetUser(user.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	_, err = queries.GetUserByEmail(user.Email)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+}
+
+func (t *queriesSuiteTest) TestRestoreUser() {
+	mockUser := &test.GenerateUsers(1)[0]
+
+	queries := NewUserQueries(t.DB, t.TX)
+
+	user, err := queries.CreateUser(mockUser)
+	require.NoError(t.T(), err)
+
+	// only deleted users can be restored
+	_, err = queries.RestoreUser(user.ID)
+	assert.ErrorIs(t.T(), err, sql.ErrNoRows)
+
+	require.NoError(t.T(), queries.DeleteUser(user.ID, etag.Any))
+
+	restored, err := queries.RestoreUser(user.ID)
+	require.NoError(t.T(), err)
+
+	assert.Equal(t.T(), user.ID, restored.ID)
+	assert.False(t.T(), restored.DeletedAt.Valid)
+
+	_, err = queries.GetUser(user.ID)
+	assert.NoError(t.T(), err)
+}
+
+func (t *queriesSuiteTest) TestEmailReusableAfterDelete() {
+	mockUser := &test.GenerateUsers(1)[0]
+
+	queries := NewUserQueries(t.DB, t.TX)
+
+	user, err := queries.CreateUser(mockUser)
+	require.NoError(t.T(), err)
+
+	// the failed insert aborts the transaction, keep it usable afterwards
+	_, err = t.TX.Exec(`SAVEPOINT duplicate`)
+	require.NoError(t.T(), err)
+
+	_, err = queries.CreateUser(mockUser)
+	assert.True(t.T(), database.IsUniqueViolation(err))
+
+	_, err = t.TX.Exec(`ROLLBACK TO SAVEPOINT duplicate`)
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), queries.DeleteUser(user.ID, etag.Any))
+
+	_, err = queries.CreateUser(mockUser)
+	require.NoError(t.T(), err)
+}
+
+func (t *queriesSuiteTest) TestPurgeDeletedUsers() {
+	mockUsers := test.GenerateUsers(2)
+
+	queries := NewUserQueries(t.DB, t.TX)
+
+	deleted, err := queries.CreateUser(&mockUsers[0])
+	require.NoError(t.T(), err)
+
+	live, err := queries.CreateUser(&mockUsers[1])
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), queries.DeleteUser(deleted.ID, etag.Any))
+
+	_, err = t.TX.Exec(`INSERT INTO notes (title, content, user_id, attrs) VALUES ('note', 'content', $1, '{}')`, deleted.ID)
+	require.NoError(t.T(), err)
+
+	// still within the retention window
+	ids, err := queries.PurgeDeletedUsers(time.Now().Add(-time.Hour))
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), ids)
+
+	ids, err = queries.PurgeDeletedUsers(time.Now().Add(time.Hour))
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), []int64{deleted.ID}, ids)
+
+	var notes int
+	require.NoError(t.T(), t.TX.Get(&notes, `SELECT COUNT(*) FROM notes WHERE user_id = $1`, deleted.ID))
+	assert.Equal(t.T(), 0, notes)
+
+	_, err = queries.GetUser(live.ID)
+	assert.NoError(t.T(), err)
+}
+
+func (t *queriesSuiteTest) TestDeletedUserUnchanged() {
+	queries := NewUserQueries(t.DB, t.TX)
+
+	user, err := queries.CreateUser(&test.GenerateUsers(1)[0])
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), queries.VerifyUser(user.ID))
+	// verifying again is a no-op
+	require.NoError(t.T(), queries.VerifyUser(user.ID))
+
+	verified, err := queries.GetUser(user.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), user.Version+1, verified.Version)
+
+	require.NoError(t.T(), queries.DeleteUser(user.ID, etag.Any))
+
+	assert.ErrorIs(t.T(), queries.UpdatePassword(user.ID, "hash"), sql.ErrNoRows)
+	assert.ErrorIs(t.T(), queries.SetAdmin(user.ID, true), sql.ErrNoRows)
+	assert.ErrorIs(t.T(), queries.VerifyUser(user.ID), sql.ErrNoRows)
+	assert.ErrorIs(t.T(), queries.UpdatePassword(user.ID+1, "hash"), sql.ErrNoRows)
 }
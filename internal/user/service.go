@@ -1,22 +1,61 @@
 package user
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
 	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
 	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/opaulochaves/myserver/pkg/etag"
 	"github.com/pkg/errors"
 )
 
+// Audited events of the user service. Creations, updates, deletions,
+// restores and purges are also published to the outbox.
+const (
+	EventUserCreated         = "user.created"
+	EventUserUpdated         = "user.updated"
+	EventUserDeleted         = "user.deleted"
+	EventUserRestored        = "user.restored"
+	EventUserPurged          = "user.purged"
+	EventUserPasswordChanged = "user.password_changed"
+	EventUserAdminGranted    = "user.admin_granted"
+	EventUserAdminRevoked    = "user.admin_revoked"
+)
+
+// wrongPassword is the message of the password changes whose current
+// password is wrong.
+const wrongPassword = "current password is incorrect"
+
+// Service manages users. Every change is recorded in the audit log, in the
+// same transaction, with the actor and request found in ctx.
 type Service interface {
 	Get(id int64) (User, error)
 	Query(offset int, limit int) ([]User, error)
 	Count() (int, error)
-	Create(input CreateUserRequest) (User, error)
-	Update(id int64, input UpdateUserRequest) (User, error)
-	Delete(id int64) (User, error)
+	Create(ctx context.Context, input CreateUserRequest) (User, error)
+	// Update and Delete require the current version of the user, or etag.Any
+	Update(ctx context.Context, id int64, input UpdateUserRequest, version int64) (User, error)
+	Delete(ctx context.Context, id int64, version int64) (User, error)
+	Restore(ctx context.Context, id int64) (User, error)
+	PurgeDeleted(retention time.Duration) (int64, error)
+	// SetPassword replaces the password of a user and revokes their
+	// sessions, ChangePassword does so once current is the password of the
+	// user.
+	SetPassword(ctx context.Context, id int64, password string) error
+	ChangePassword(ctx context.Context, id int64, input ChangePasswordRequest) error
+	SetAdmin(ctx context.Context, id int64, isAdmin bool) error
 }
 
 // User represents the data about an user.
@@ -39,13 +78,36 @@ func (*CreateUserRequest) Bind(r *http.Request) error {
 
 type UserResponse struct {
 	User
+	// AvatarURLs are the URLs of the images of the avatar by size, null
+	// without an avatar
+	AvatarURLs map[string]string `json:"avatar_urls"`
 }
 
 // Render implements render.Renderer
 func (u *UserResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	u.AvatarURLs = AvatarURLs(u.User.User)
 	return nil
 }
 
+// AvatarSizes are the sizes, in pixels, of the square images of the avatars.
+var AvatarSizes = []int{64, 128, 256, 512}
+
+// AvatarURLs returns the URLs of the images of the avatar of u by size, nil
+// without an avatar. They change with the avatar, so they can be cached for
+// good.
+func AvatarURLs(u *entity.User) map[string]string {
+	if u == nil || u.Avatar == "" {
+		return nil
+	}
+
+	urls := map[string]string{}
+	for _, size := range AvatarSizes {
+		urls[fmt.Sprint(size)] = fmt.Sprintf("/api/users/%d/avatar/%d?v=%s", u.ID, size, u.Avatar)
+	}
+
+	return urls
+}
+
 // Validate validates the CreateUserRequest fields.
 func (c CreateUserRequest) Validate() error {
 	return validation.ValidateStruct(&c,
@@ -60,25 +122,83 @@ func (c CreateUserRequest) Validate() error {
 type UpdateUserRequest struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
-	Password  string `json:"password"`
+	// Password is refused, passwords are changed with SetPassword
+	Password string `json:"password"`
 }
 
-// Validate validates the CreateUserRequest fields.
+// Bind implements render.Binder
+func (*UpdateUserRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the UpdateUserRequest fields.
 func (c UpdateUserRequest) Validate() error {
 	return validation.ValidateStruct(&c,
 		validation.Field(&c.FirstName, validation.Length(2, 255)),
 		validation.Field(&c.LastName, validation.Length(2, 255)),
-		validation.Field(&c.Password, validation.Length(8, 100)),
+		validation.Field(&c.Password, validation.Empty.Error("is changed through the password endpoints")),
+	)
+}
+
+// ValidateReplace requires the fields a PUT replaces.
+func (c UpdateUserRequest) ValidateReplace() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.FirstName, validation.Required),
+		validation.Field(&c.LastName, validation.Required),
+	)
+}
+
+// SetPasswordRequest represents a request setting the password of a user.
+type SetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// Bind implements render.Binder
+func (*SetPasswordRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the SetPasswordRequest fields.
+func (c SetPasswordRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Password, validation.Required, validation.Length(8, 100)),
+	)
+}
+
+// ChangePasswordRequest represents a user changing their own password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+// Bind implements render.Binder
+func (*ChangePasswordRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the ChangePasswordRequest fields.
+func (c ChangePasswordRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.CurrentPassword, validation.Required),
+		validation.Field(&c.Password, validation.Required, validation.Length(8, 100)),
 	)
 }
 
+// Verifier starts the email verification of a newly created user.
+type Verifier interface {
+	StartVerification(user *entity.User) error
+}
+
 type service struct {
-	repo UserQueries
+	db       *sqlx.DB
+	repo     UserQueries
+	verifier Verifier
+	policy   *PasswordPolicy
 	// logger log.Logger
 }
 
-func NewService(repo UserQueries) Service {
-	return service{repo}
+func NewService(db *sqlx.DB, repo UserQueries, verifier Verifier, policy *PasswordPolicy) Service {
+	return service{db, repo, verifier, policy}
 }
 
 // Count implements Service
@@ -87,27 +207,52 @@ func (s service) Count() (int, error) {
 }
 
 // Create implements Service
-func (s service) Create(input CreateUserRequest) (User, error) {
+func (s service) Create(ctx context.Context, input CreateUserRequest) (User, error) {
 	if err := input.Validate(); err != nil {
 		return User{}, err
 	}
 
-	hashedPassword, err := util.HashPassword(input.Password)
+	hashedPassword, err := s.hashPassword(input.Password, input.Email, input.FirstName, input.LastName)
 	if err != nil {
-		return User{}, errors.Wrap(err, "hashing password error")
+		return User{}, err
 	}
 
-	user, err := s.repo.CreateUser(&entity.User{
-		FirstName: input.FirstName,
-		LastName:  input.LastName,
-		Email:     input.Email,
-		Password:  hashedPassword,
+	var user *entity.User
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		user, err = NewUserQueries(s.db, tx).CreateUser(&entity.User{
+			FirstName: input.FirstName,
+			LastName:  input.LastName,
+			Email:     input.Email,
+			Password:  hashedPassword,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventUserCreated, audit.TargetUser, user.ID, user); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventUserCreated, audit.TargetUser, user.ID)
+		event.Changes = audit.Diff(nil, user)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
 	})
 
 	if err != nil {
+		if database.IsUniqueViolation(err) {
+			return User{}, apperrors.NewConflict("email", input.Email)
+		}
 		return User{}, err
 	}
 
+	// the account already exists at this point, the user can ask for a new
+	// verification email if this one could not be sent
+	if err := s.verifier.StartVerification(user); err != nil {
+		log.Printf("start verification error: %v", err)
+	}
+
 	return User{user}, nil
 }
 
@@ -140,51 +285,274 @@ func (s service) Query(offset int, limit int) ([]User, error) {
 }
 
 // Update implements Service
-func (s service) Update(id int64, input UpdateUserRequest) (User, error) {
+func (s service) Update(ctx context.Context, id int64, input UpdateUserRequest, version int64) (User, error) {
 	if err := input.Validate(); err != nil {
 		return User{}, err
 	}
 
-	var err error
-	var hashedPassword string
+	before, err := s.repo.GetUser(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, apperrors.NewNotFound("user", fmt.Sprint(id))
+		}
+		return User{}, err
+	}
+
+	if version != etag.Any && version != before.Version {
+		return User{}, etag.Mismatch()
+	}
 
-	if input.Password != "" {
-		hashedPassword, err = util.HashPassword(input.Password)
+	changes := *before
+	changes.Version = version
+	if input.FirstName != "" {
+		changes.FirstName = input.FirstName
 	}
+	if input.LastName != "" {
+		changes.LastName = input.LastName
+	}
+
+	var after *entity.User
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewUserQueries(s.db, tx)
+
+		if after, err = repo.UpdateUser(&changes); err != nil {
+			// updated or deleted since it was read
+			if errors.Is(err, sql.ErrNoRows) {
+				return etag.Mismatch()
+			}
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventUserUpdated, audit.TargetUser, id, after); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventUserUpdated, audit.TargetUser, id)
+		event.Changes = audit.Diff(before, after)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
 
 	if err != nil {
-		return User{}, errors.Wrap(err, "hashing password error")
+		return User{}, err
 	}
 
-	user, err := s.Get(id)
+	return User{after}, nil
+}
+
+// SetPassword implements Service
+//
+// This is the one place passwords change, the sessions opened with the
+// previous password are revoked in the same transaction.
+func (s service) SetPassword(ctx context.Context, id int64, password string) error {
+	if err := (SetPasswordRequest{Password: password}).Validate(); err != nil {
+		return err
+	}
+
+	user, err := s.repo.GetUser(id)
 	if err != nil {
-		return user, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewNotFound("user", fmt.Sprint(id))
+		}
+		return err
 	}
 
-	user.FirstName = input.FirstName
-	user.LastName = input.LastName
+	hashedPassword, err := s.hashPassword(password, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewUserQueries(s.db, tx)
+
+		if err := repo.UpdatePassword(id, hashedPassword); err != nil {
+			// deleted since it was read
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("user", fmt.Sprint(id))
+			}
+			return err
+		}
 
-	if hashedPassword != "" {
-		user.Password = hashedPassword
+		if err := repo.RevokeSessions(id); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventUserPasswordChanged, audit.TargetUser, id)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+}
+
+// ChangePassword implements Service
+func (s service) ChangePassword(ctx context.Context, id int64, input ChangePasswordRequest) error {
+	if err := input.Validate(); err != nil {
+		return err
 	}
 
-	if _, err := s.repo.UpdateUser(user.User); err != nil {
-		return user, err
+	user, err := s.repo.GetUser(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewNotFound("user", fmt.Sprint(id))
+		}
+		return err
 	}
 
-	return user, err
+	ok, err := util.ComparePasswords(user.Password, input.CurrentPassword)
+	if err != nil || !ok {
+		return apperrors.NewForbidden(wrongPassword)
+	}
+
+	return s.SetPassword(ctx, id, input.Password)
+}
+
+// SetAdmin implements Service
+func (s service) SetAdmin(ctx context.Context, id int64, isAdmin bool) error {
+	user, err := s.repo.GetUser(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.NewNotFound("user", fmt.Sprint(id))
+		}
+		return err
+	}
+
+	if user.IsAdmin == isAdmin {
+		return nil
+	}
+
+	action := EventUserAdminRevoked
+	if isAdmin {
+		action = EventUserAdminGranted
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewUserQueries(s.db, tx).SetAdmin(id, isAdmin); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperrors.NewNotFound("user", fmt.Sprint(id))
+			}
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(action, audit.TargetUser, id)
+		event.Changes["is_admin"] = entity.Change{From: user.IsAdmin, To: isAdmin}
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+}
+
+// hashPassword checks password against the policy and hashes it. This is
+// the only place passwords are hashed before being stored.
+func (s service) hashPassword(password, email string, names ...string) (string, error) {
+	if err := s.policy.Validate(password, email, names...); err != nil {
+		return "", err
+	}
+
+	hashedPassword, err := util.HashPassword(password)
+	if err != nil {
+		return "", errors.Wrap(err, "hashing password error")
+	}
+
+	return hashedPassword, nil
 }
 
 // Delete implements Service
-func (s service) Delete(id int64) (User, error) {
+func (s service) Delete(ctx context.Context, id int64, version int64) (User, error) {
 	user, err := s.Get(id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, apperrors.NewNotFound("user", fmt.Sprint(id))
+		}
 		return User{}, err
 	}
 
-	if err = s.repo.DeleteUser(id); err != nil {
-		return User{}, nil
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		repo := NewUserQueries(s.db, tx)
+
+		if err := repo.DeleteUser(id, version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return etag.Mismatch()
+			}
+			return err
+		}
+
+		// a restore must not bring the sessions back
+		if err := repo.RevokeSessions(id); err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventUserDeleted, audit.TargetUser, id, user); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventUserDeleted, audit.TargetUser, id)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return User{}, err
 	}
 
 	return user, nil
 }
+
+// Restore implements Service
+func (s service) Restore(ctx context.Context, id int64) (User, error) {
+	var user *entity.User
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if user, err = NewUserQueries(s.db, tx).RestoreUser(id); err != nil {
+			return err
+		}
+
+		if err := outbox.NewOutboxQueries(s.db, tx).Publish(EventUserRestored, audit.TargetUser, id, user); err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventUserRestored, audit.TargetUser, id)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, apperrors.NewNotFound("deleted user", fmt.Sprint(id))
+		}
+		// the email was taken by another account in the meantime
+		if database.IsUniqueViolation(err) {
+			return User{}, apperrors.NewConflict("user", fmt.Sprint(id))
+		}
+		return User{}, err
+	}
+
+	return User{user}, nil
+}
+
+// PurgeDeleted implements Service
+func (s service) PurgeDeleted(retention time.Duration) (int64, error) {
+	var ids []int64
+
+	err := database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		var err error
+		if ids, err = NewUserQueries(s.db, tx).PurgeDeletedUsers(time.Now().Add(-retention)); err != nil {
+			return err
+		}
+
+		repo := audit.NewAuditQueries(s.db, tx)
+		events := outbox.NewOutboxQueries(s.db, tx)
+
+		for _, id := range ids {
+			if err := repo.Insert(audit.Metadata{}.Event(EventUserPurged, audit.TargetUser, id)); err != nil {
+				return err
+			}
+			if err := events.Publish(EventUserPurged, audit.TargetUser, id, map[string]int64{"id": id}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return int64(len(ids)), err
+}
@@ -0,0 +1,230 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type serviceSuiteTest struct {
+	test.TSuite
+}
+
+func TestServiceSuiteTest(t *testing.T) {
+	suite.Run(t, new(serviceSuiteTest))
+}
+
+type noopVerifier struct{}
+
+func (noopVerifier) StartVerification(user *entity.User) error {
+	return nil
+}
+
+func (t *serviceSuiteTest) newService() Service {
+	policy, err := NewPasswordPolicy(8, "")
+	require.NoError(t.T(), err)
+
+	// the service runs its own transactions, it cannot share the one of the test
+	return NewService(t.DB, NewUserQueries(t.DB, nil), noopVerifier{}, policy)
+}
+
+func (t *serviceSuiteTest) TestCreateHashesPasswordOnce() {
+	service := t.newService()
+
+	created, err := service.Create(context.Background(), CreateUserRequest{
+		FirstName: "User",
+		LastName:  "Example",
+		Email:     "user01@example.com",
+		Password:  "correct horse battery",
+	})
+	require.NoError(t.T(), err)
+
+	stored, err := NewUserQueries(t.DB, nil).GetUserByEmail("user01@example.com")
+	require.NoError(t.T(), err)
+
+	assert.Equal(t.T(), created.ID, stored.ID)
+
+	ok, err := util.ComparePasswords(stored.Password, "correct horse battery")
+	require.NoError(t.T(), err)
+	assert.True(t.T(), ok)
+}
+
+func (t *serviceSuiteTest) TestSetPassword() {
+	service := t.newService()
+
+	created, err := service.Create(context.Background(), CreateUserRequest{
+		FirstName: "User",
+		LastName:  "Example",
+		Email:     "user01@example.com",
+		Password:  "correct horse battery",
+	})
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), service.SetPassword(context.Background(), created.ID, "staple battery horse"))
+
+	stored, err := NewUserQueries(t.DB, nil).GetUser(created.ID)
+	require.NoError(t.T(), err)
+
+	ok, err := util.ComparePasswords(stored.Password, "staple battery horse")
+	require.NoError(t.T(), err)
+	assert.True(t.T(), ok)
+
+	ok, err = util.ComparePasswords(stored.Password, "correct horse battery")
+	require.NoError(t.T(), err)
+	assert.False(t.T(), ok)
+
+	assert.Error(t.T(), service.SetPassword(context.Background(), created.ID, "user01-secret"), "contains the email")
+}
+
+func (t *serviceSuiteTest) TestChangePassword() {
+	service := t.newService()
+
+	created, err := service.Create(context.Background(), CreateUserRequest{
+		FirstName: "User",
+		LastName:  "Example",
+		Email:     "user01@example.com",
+		Password:  "correct horse battery",
+	})
+	require.NoError(t.T(), err)
+
+	_, err = t.DB.Exec(`INSERT INTO sessions (user_id, token_hash, user_agent, ip, expires_at)
+		VALUES ($1, 'hash', '', '', NOW() + INTERVAL '1 day')`, created.ID)
+	require.NoError(t.T(), err)
+
+	// the current password is required
+	err = service.ChangePassword(context.Background(), created.ID, ChangePasswordRequest{CurrentPassword: "wrong password", Password: "staple battery horse"})
+	assert.Equal(t.T(), http.StatusForbidden, apperrors.Status(err))
+
+	err = service.ChangePassword(context.Background(), created.ID, ChangePasswordRequest{CurrentPassword: "correct horse battery", Password: "staple battery horse"})
+	require.NoError(t.T(), err)
+
+	stored, err := NewUserQueries(t.DB, nil).GetUser(created.ID)
+	require.NoError(t.T(), err)
+
+	ok, err := util.ComparePasswords(stored.Password, "staple battery horse")
+	require.NoError(t.T(), err)
+	assert.True(t.T(), ok)
+
+	// the sessions opened with the previous password are revoked
+	var active int
+	require.NoError(t.T(), t.DB.Get(&active, `SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND revoked_at IS NULL`, created.ID))
+	assert.Equal(t.T(), 0, active)
+}
+
+func (t *serviceSuiteTest) TestChangesAreAudited() {
+	service := t.newService()
+
+	ctx := audit.WithActor(audit.WithMetadata(context.Background(), audit.Metadata{IP: "127.0.0.1", RequestID: "req-1"}), 42)
+
+	created, err := service.Create(ctx, CreateUserRequest{
+		FirstName: "User",
+		LastName:  "Example",
+		Email:     "user01@example.com",
+		Password:  "correct horse battery",
+	})
+	require.NoError(t.T(), err)
+
+	// passwords are not changed with the profile
+	_, err = service.Update(ctx, created.ID, UpdateUserRequest{FirstName: "Renamed", Password: "staple battery horse"}, created.Version)
+	assert.Equal(t.T(), http.StatusBadRequest, apperrors.Status(err))
+
+	updated, err := service.Update(ctx, created.ID, UpdateUserRequest{FirstName: "Renamed"}, created.Version)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), created.Version+1, updated.Version)
+
+	require.NoError(t.T(), service.SetPassword(ctx, created.ID, "staple battery horse"))
+
+	// a second update with the same version would overwrite the first one
+	_, err = service.Update(ctx, created.ID, UpdateUserRequest{LastName: "Stale"}, created.Version)
+	assert.Equal(t.T(), http.StatusPreconditionFailed, apperrors.Status(err))
+
+	events, err := audit.NewAuditQueries(t.DB, nil).GetUserEvents(created.ID)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), events, 3)
+
+	assert.Equal(t.T(), EventUserCreated, events[0].Action)
+	assert.Equal(t.T(), int64(42), *events[0].ActorID)
+	assert.Equal(t.T(), "127.0.0.1", events[0].IP)
+	assert.Equal(t.T(), "req-1", events[0].RequestID)
+	assert.NotContains(t.T(), events[0].Changes, "password")
+
+	assert.Equal(t.T(), EventUserUpdated, events[1].Action)
+	// personal data is not kept in the log
+	assert.Equal(t.T(), map[string]interface{}{"from": nil, "to": nil, "redacted": true}, events[1].Changes["first_name"])
+	assert.NotContains(t.T(), events[1].Changes, "last_name")
+	assert.NotContains(t.T(), events[1].Changes, "password")
+
+	assert.Equal(t.T(), EventUserPasswordChanged, events[2].Action)
+	assert.Empty(t.T(), events[2].Changes)
+}
+
+func (t *serviceSuiteTest) TestChangesArePublished() {
+	service := t.newService()
+
+	created, err := service.Create(context.Background(), CreateUserRequest{
+		FirstName: "User",
+		LastName:  "Example",
+		Email:     "user01@example.com",
+		Password:  "correct horse battery",
+	})
+	require.NoError(t.T(), err)
+
+	// rolled back changes are not published
+	_, err = service.Update(context.Background(), created.ID, UpdateUserRequest{LastName: "Stale"}, created.Version+1)
+	require.Error(t.T(), err)
+
+	_, err = service.Delete(context.Background(), created.ID, created.Version)
+	require.NoError(t.T(), err)
+
+	tx := t.DB.MustBegin()
+	defer tx.Rollback()
+
+	events, err := outbox.NewOutboxQueries(t.DB, tx).LockPending(10)
+	require.NoError(t.T(), err)
+	require.Len(t.T(), events, 2)
+
+	assert.Equal(t.T(), EventUserCreated, events[0].EventType)
+	assert.Equal(t.T(), fmt.Sprint(created.ID), events[0].AggregateID)
+	assert.Contains(t.T(), string(events[0].Payload), `"email":"user01@example.com"`)
+	assert.NotContains(t.T(), string(events[0].Payload), "password")
+
+	assert.Equal(t.T(), EventUserDeleted, events[1].EventType)
+}
+
+func (t *serviceSuiteTest) TestDeleteRevokesSessions() {
+	service := t.newService()
+
+	created, err := service.Create(context.Background(), CreateUserRequest{
+		FirstName: "User",
+		LastName:  "Example",
+		Email:     "user01@example.com",
+		Password:  "correct horse battery",
+	})
+	require.NoError(t.T(), err)
+
+	_, err = t.DB.Exec(`INSERT INTO sessions (user_id, token_hash, user_agent, ip, expires_at)
+		VALUES ($1, 'hash', '', '', NOW() + INTERVAL '1 day')`, created.ID)
+	require.NoError(t.T(), err)
+
+	_, err = service.Delete(context.Background(), created.ID, created.Version)
+	require.NoError(t.T(), err)
+
+	// the restored user logs in again
+	_, err = service.Restore(context.Background(), created.ID)
+	require.NoError(t.T(), err)
+
+	var active int
+	require.NoError(t.T(), t.DB.Get(&active, `SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND revoked_at IS NULL`, created.ID))
+	assert.Equal(t.T(), 0, active)
+}
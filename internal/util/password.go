@@ -2,53 +2,244 @@ package util
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"strconv"
 	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/scrypt"
 )
 
-// hashPassword hashes the given password using bcrypt
+// Password hashes are stored in the PHC string format so the algorithm and
+// its parameters travel with the hash and can be changed over time:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//	$scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//
+// Salt and hash are base64 encoded without padding. Hashes created before
+// this format was introduced are "<hex hash>.<hex salt>" scrypt hashes with
+// N=32768, r=8, p=1; they are still accepted and reported by NeedsRehash.
+
+// Supported password hashing algorithms
+const (
+	AlgArgon2id = "argon2id"
+	AlgScrypt   = "scrypt"
+)
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// Argon2Params are the cost parameters of argon2id.
+type Argon2Params struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// ScryptParams are the cost parameters of scrypt, N is 2^LogN.
+type ScryptParams struct {
+	LogN int
+	R    int
+	P    int
+}
+
+var (
+	// PasswordAlgorithm is the algorithm used for new hashes
+	PasswordAlgorithm = AlgArgon2id
+	// DefaultArgon2Params are used for new argon2id hashes
+	DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 2}
+	// DefaultScryptParams are used for new scrypt hashes
+	DefaultScryptParams = ScryptParams{LogN: 15, R: 8, P: 1}
+)
+
+var b64 = base64.RawStdEncoding
+
+// HashPassword hashes the given password with the current algorithm and
+// parameters and returns it in the PHC string format.
 func HashPassword(password string) (string, error) {
-	salt := make([]byte, 32)
-	_, err := rand.Read(salt)
-	if err != nil {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
 
-	// using recommended cost parameters from - https://godoc.org/golang.org/x/crypto/scrypt
-	shash, err := scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
+	switch PasswordAlgorithm {
+	case AlgArgon2id:
+		p := DefaultArgon2Params
+		key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, keySize)
+
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			AlgArgon2id, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+			b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	case AlgScrypt:
+		p := DefaultScryptParams
+		key, err := scrypt.Key([]byte(password), salt, 1<<p.LogN, p.R, p.P, keySize)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s",
+			AlgScrypt, p.LogN, p.R, p.P,
+			b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unknown password algorithm %q", PasswordAlgorithm)
+	}
+}
+
+// ComparePasswords compares the stored password with the supplied one in
+// constant time.
+func ComparePasswords(storedPassword string, suppliedPassword string) (bool, error) {
+	h, err := parseHash(storedPassword)
 	if err != nil {
-		return "", err
+		return false, err
 	}
 
-	// return hex-encoded string with salt appended to password
-	hashedPW := fmt.Sprintf("%s.%s", hex.EncodeToString(shash), hex.EncodeToString(salt))
+	key, err := h.derive(suppliedPassword)
+	if err != nil {
+		return false, fmt.Errorf("unable to verify user password")
+	}
 
-	return hashedPW, nil
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
 }
 
-// comparePasswords compares the stored password with the supplied one
-func ComparePasswords(storedPassword string, suppliedPassword string) (bool, error) {
-	pwsalt := strings.Split(storedPassword, ".")
+// NeedsRehash reports whether storedPassword was created with another
+// algorithm or other parameters than the current ones.
+func NeedsRehash(storedPassword string) bool {
+	h, err := parseHash(storedPassword)
+	if err != nil || h.legacy {
+		return true
+	}
 
-	if len(pwsalt) < 2 {
-		return false, fmt.Errorf("did not provide a valid hash")
+	switch h.alg {
+	case AlgArgon2id:
+		return PasswordAlgorithm != AlgArgon2id || h.argon2 != DefaultArgon2Params || len(h.key) != keySize
+	case AlgScrypt:
+		return PasswordAlgorithm != AlgScrypt || h.scrypt != DefaultScryptParams || len(h.key) != keySize
+	default:
+		return true
 	}
+}
 
-	// check supplied password salted with hash
-	salt, err := hex.DecodeString(pwsalt[1])
+type passwordHash struct {
+	alg    string
+	legacy bool
+	argon2 Argon2Params
+	scrypt ScryptParams
+	salt   []byte
+	key    []byte
+}
 
-	if err != nil {
-		return false, fmt.Errorf("unable to verify user password")
+func (h passwordHash) derive(password string) ([]byte, error) {
+	switch h.alg {
+	case AlgArgon2id:
+		p := h.argon2
+		return argon2.IDKey([]byte(password), h.salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(h.key))), nil
+	case AlgScrypt:
+		p := h.scrypt
+		return scrypt.Key([]byte(password), h.salt, 1<<p.LogN, p.R, p.P, len(h.key))
+	default:
+		return nil, fmt.Errorf("unknown password algorithm %q", h.alg)
+	}
+}
+
+func parseHash(stored string) (passwordHash, error) {
+	invalid := fmt.Errorf("did not provide a valid hash")
+
+	if !strings.HasPrefix(stored, "$") {
+		return parseLegacyHash(stored)
 	}
 
-	shash, err := scrypt.Key([]byte(suppliedPassword), salt, 32768, 8, 1, 32)
+	parts := strings.Split(stored[1:], "$")
+
+	var h passwordHash
+	var params, salt, key string
 
+	switch {
+	case len(parts) == 5 && parts[0] == AlgArgon2id:
+		if parts[1] != fmt.Sprintf("v=%d", argon2.Version) {
+			return h, invalid
+		}
+		h.alg, params, salt, key = parts[0], parts[2], parts[3], parts[4]
+	case len(parts) == 4 && parts[0] == AlgScrypt:
+		h.alg, params, salt, key = parts[0], parts[1], parts[2], parts[3]
+	default:
+		return h, invalid
+	}
+
+	values, err := parseParams(params)
 	if err != nil {
-		return false, fmt.Errorf("unable to verify user password")
+		return h, invalid
+	}
+
+	switch h.alg {
+	case AlgArgon2id:
+		m, t, p := values["m"], values["t"], values["p"]
+		if m <= 0 || t <= 0 || p <= 0 || p > 255 {
+			return h, invalid
+		}
+		h.argon2 = Argon2Params{Memory: uint32(m), Iterations: uint32(t), Parallelism: uint8(p)}
+	case AlgScrypt:
+		ln, r, p := values["ln"], values["r"], values["p"]
+		if ln <= 0 || ln > 30 || r <= 0 || p <= 0 {
+			return h, invalid
+		}
+		h.scrypt = ScryptParams{LogN: ln, R: r, P: p}
+	}
+
+	if h.salt, err = b64.DecodeString(salt); err != nil {
+		return h, invalid
+	}
+
+	if h.key, err = b64.DecodeString(key); err != nil || len(h.key) == 0 {
+		return h, invalid
+	}
+
+	return h, nil
+}
+
+// parseLegacyHash reads the "<hex hash>.<hex salt>" format.
+func parseLegacyHash(stored string) (passwordHash, error) {
+	h := passwordHash{alg: AlgScrypt, legacy: true, scrypt: ScryptParams{LogN: 15, R: 8, P: 1}}
+
+	pwsalt := strings.Split(stored, ".")
+	if len(pwsalt) != 2 {
+		return h, fmt.Errorf("did not provide a valid hash")
+	}
+
+	var err error
+
+	if h.key, err = hex.DecodeString(pwsalt[0]); err != nil || len(h.key) == 0 {
+		return h, fmt.Errorf("unable to verify user password")
+	}
+
+	if h.salt, err = hex.DecodeString(pwsalt[1]); err != nil {
+		return h, fmt.Errorf("unable to verify user password")
+	}
+
+	return h, nil
+}
+
+// parseParams reads a "k=v,k=v" list of integer parameters.
+func parseParams(s string) (map[string]int, error) {
+	values := map[string]int{}
+
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid parameter %q", kv)
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+
+		values[k] = n
 	}
 
-	return hex.EncodeToString(shash) == pwsalt[0], nil
+	return values, nil
 }
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/scrypt"
+)
+
+// cheapParams lowers the cost parameters for the duration of a test.
+func cheapParams(t *testing.T) {
+	alg, a, s := PasswordAlgorithm, DefaultArgon2Params, DefaultScryptParams
+	DefaultArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+	DefaultScryptParams = ScryptParams{LogN: 10, R: 8, P: 1}
+
+	t.Cleanup(func() {
+		PasswordAlgorithm, DefaultArgon2Params, DefaultScryptParams = alg, a, s
+	})
+}
+
+func TestHashPassword(t *testing.T) {
+	cheapParams(t)
+
+	for _, alg := range []string{AlgArgon2id, AlgScrypt} {
+		PasswordAlgorithm = alg
+
+		hash, err := HashPassword("12345678")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(hash, "$"+alg+"$"), hash)
+
+		ok, err := ComparePasswords(hash, "12345678")
+		require.NoError(t, err)
+		assert.True(t, ok, alg)
+
+		ok, err = ComparePasswords(hash, "87654321")
+		require.NoError(t, err)
+		assert.False(t, ok, alg)
+
+		assert.False(t, NeedsRehash(hash), alg)
+	}
+}
+
+func TestScryptFormat(t *testing.T) {
+	cheapParams(t)
+	PasswordAlgorithm = AlgScrypt
+
+	hash, err := HashPassword("12345678")
+	require.NoError(t, err)
+
+	assert.Regexp(t, `^\$scrypt\$ln=10,r=8,p=1\$[A-Za-z0-9+/]+\$[A-Za-z0-9+/]+$`, hash)
+}
+
+func TestLegacyPassword(t *testing.T) {
+	salt := []byte("0123456789abcdef0123456789abcdef")
+	key, err := scrypt.Key([]byte("12345678"), salt, 32768, 8, 1, 32)
+	require.NoError(t, err)
+
+	legacy := fmt.Sprintf("%s.%s", hex.EncodeToString(key), hex.EncodeToString(salt))
+
+	ok, err := ComparePasswords(legacy, "12345678")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = ComparePasswords(legacy, "87654321")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.True(t, NeedsRehash(legacy))
+}
+
+func TestNeedsRehash(t *testing.T) {
+	cheapParams(t)
+	PasswordAlgorithm = AlgScrypt
+
+	hash, err := HashPassword("12345678")
+	require.NoError(t, err)
+
+	PasswordAlgorithm = AlgArgon2id
+	assert.True(t, NeedsRehash(hash), "algorithm changed")
+
+	PasswordAlgorithm = AlgScrypt
+	DefaultScryptParams.LogN++
+	assert.True(t, NeedsRehash(hash), "parameters changed")
+}
+
+func TestComparePasswordsInvalidHash(t *testing.T) {
+	for _, hash := range []string{"", "nope", "$argon2id$v=19$m=1,t=1$x$y", "$scrypt$ln=99,r=8,p=1$c2FsdA$a2V5", "$md5$x$y"} {
+		_, err := ComparePasswords(hash, "12345678")
+		assert.Error(t, err, hash)
+	}
+}
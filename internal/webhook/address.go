@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrForbiddenAddress is returned for the webhook URLs and connections
+// reaching a loopback, private, link-local or otherwise internal address.
+var ErrForbiddenAddress = errors.New("must not be a local or private address")
+
+// allowedIP tells whether deliveries may connect to ip. Tests replace it to
+// deliver to local receivers.
+var allowedIP = publicIP
+
+// reservedNets are the ranges which are not public besides those the net
+// package recognises.
+var reservedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // this network
+		"100.64.0.0/10", // shared address space, carrier-grade NAT
+		"198.18.0.0/15", // benchmarking
+		"64:ff9b::/96",  // NAT64, it embeds IPv4 addresses
+		"64:ff9b:1::/48",
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP reports whether ip is a public unicast address.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkURL refuses the URLs whose host is localhost or an address which is
+// not allowed. Hosts which do not resolve yet are accepted, the connections
+// are checked again when delivering.
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenAddress
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !allowedIP(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+
+	for _, ip := range ips {
+		if !allowedIP(ip) {
+			return ErrForbiddenAddress
+		}
+	}
+
+	return nil
+}
+
+// dialControl refuses the connections to addresses which are not allowed.
+// It runs once the host is resolved, for every address dialed, so a name
+// resolving to an internal address after the webhook was validated is
+// refused as well.
+func dialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !allowedIP(ip) {
+		return fmt.Errorf("dial %s: %w", address, ErrForbiddenAddress)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublicIP(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "224.0.0.1",
+		"0.1.2.3", "100.64.0.1", "100.127.255.254", "198.18.0.1", "198.19.255.255", "64:ff9b::7f00:1", "64:ff9b:1::a00:1", "::ffff:127.0.0.1"} {
+		assert.False(t, publicIP(net.ParseIP(addr)), addr)
+	}
+
+	for _, addr := range []string{"93.184.216.34", "100.128.0.1", "198.20.0.1", "2606:2800:220:1:248:1893:25c8:1946"} {
+		assert.True(t, publicIP(net.ParseIP(addr)), addr)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, url := range []string{"http://localhost:8080", "http://api.localhost", "http://127.0.0.1/hook", "http://[::1]/hook", "http://169.254.169.254/latest/meta-data", "https://10.0.0.1"} {
+		err := CreateWebhookRequest{URL: url, EventTypes: []string{"*"}}.Validate()
+		assert.Error(t, err, url)
+	}
+
+	assert.NoError(t, CreateWebhookRequest{URL: "https://93.184.216.34/hook", EventTypes: []string{"*"}}.Validate())
+}
+
+// TestSendRefusesLocal checks a webhook whose host resolves to a local
+// address once validated is not reached.
+func TestSendRefusesLocal(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer server.Close()
+
+	d := NewDeliverer(nil, nil, Options{Timeout: time.Second, MaxAttempts: 3})
+	delivery := &entity.WebhookDelivery{ID: 1, EventType: "note.created", Payload: "{}"}
+
+	d.send(&entity.Webhook{URL: server.URL}, "secret", delivery)
+
+	assert.False(t, reached)
+	assert.Nil(t, delivery.ResponseStatus)
+	assert.Contains(t, delivery.Error, ErrForbiddenAddress.Error())
+	assert.Equal(t, entity.DeliveryPending, delivery.Status)
+}
@@ -0,0 +1,218 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/pkg/etag"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+)
+
+// RegisterHandlers returns the router of the webhooks of the authenticated
+// user, they receive the events concerning the user.
+func RegisterHandlers(service Service, authService auth.Service) *chi.Mux {
+	r := chi.NewRouter()
+
+	r.Use(auth.Authenticate(authService))
+	r.Use(auth.RequireVerified(authService))
+
+	routes(r, resource{service, func(r *http.Request) *int64 {
+		return &auth.CurrentUser(r.Context()).ID
+	}})
+
+	return r
+}
+
+// RegisterAdminHandlers adds the endpoints of the global webhooks, receiving
+// the events of every user, to r which must already require an admin.
+func RegisterAdminHandlers(r chi.Router, service Service) {
+	r.Route("/webhooks", func(r chi.Router) {
+		routes(r, resource{service, func(r *http.Request) *int64 { return nil }})
+	})
+}
+
+func routes(r chi.Router, res resource) {
+	r.Get("/", res.list)    // GET /webhooks - read the list of webhooks
+	r.Post("/", res.create) // POST /webhooks - register a webhook, the response holds its secret
+
+	r.Route("/{id}", func(r chi.Router) {
+		r.Use(res.webhookContext)
+		r.Get("/", res.get)                                         // GET /webhooks/{id} - read a single webhook and its ETag
+		r.Patch("/", res.update)                                    // PATCH /webhooks/{id} - update a webhook, requires If-Match
+		r.Delete("/", res.delete)                                   // DELETE /webhooks/{id} - delete a webhook, requires If-Match
+		r.Get("/deliveries", res.deliveries)                        // GET /webhooks/{id}/deliveries - read the deliveries log, newest first
+		r.Post("/deliveries/{deliveryID}/redeliver", res.redeliver) // POST /webhooks/{id}/deliveries/{deliveryID}/redeliver - send a delivery again
+	})
+}
+
+type resource struct {
+	service Service
+	// owner returns the owner of the webhooks managed by the request
+	owner func(r *http.Request) *int64
+}
+
+type webhookKey struct{}
+
+type deliveryResponse struct {
+	entity.WebhookDelivery
+}
+
+// Render implements render.Renderer
+func (d *deliveryResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (c resource) list(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := c.service.Query(c.owner(r))
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInternalError(err))
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, webhook := range webhooks {
+		list = append(list, &WebhookResponse{webhook})
+	}
+
+	if err := render.RenderList(w, r, list); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) create(w http.ResponseWriter, r *http.Request) {
+	input := CreateWebhookRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	webhook, err := c.service.Create(r.Context(), c.owner(r), input)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, webhook.Version)
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &WebhookResponse{webhook})
+}
+
+func (c resource) get(w http.ResponseWriter, r *http.Request) {
+	webhook := r.Context().Value(webhookKey{}).(Webhook)
+
+	if etag.NotModified(w, r, webhook.Version) {
+		return
+	}
+
+	if err := render.Render(w, r, &WebhookResponse{webhook}); err != nil {
+		render.Render(w, r, apperrors.ErrRender(err))
+		return
+	}
+}
+
+func (c resource) update(w http.ResponseWriter, r *http.Request) {
+	webhook := r.Context().Value(webhookKey{}).(Webhook)
+	input := UpdateWebhookRequest{}
+
+	if err := render.Bind(r, &input); err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	updated, err := c.service.Update(r.Context(), c.owner(r), webhook.ID, input, version)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	etag.Set(w, updated.Version)
+	render.Render(w, r, &WebhookResponse{updated})
+}
+
+func (c resource) delete(w http.ResponseWriter, r *http.Request) {
+	webhook := r.Context().Value(webhookKey{}).(Webhook)
+
+	version, err := etag.IfMatch(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	if err := c.service.Delete(r.Context(), c.owner(r), webhook.ID, version); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c resource) deliveries(w http.ResponseWriter, r *http.Request) {
+	webhook := r.Context().Value(webhookKey{}).(Webhook)
+
+	page, err := pagination.NewCursorFromRequest(r)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := c.service.Deliveries(c.owner(r), webhook.ID, page); err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Render(w, r, page)
+}
+
+func (c resource) redeliver(w http.ResponseWriter, r *http.Request) {
+	webhook := r.Context().Value(webhookKey{}).(Webhook)
+
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "deliveryID"), 10, 64)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrInvalidRequest(err))
+		return
+	}
+
+	delivery, err := c.service.Redeliver(c.owner(r), webhook.ID, deliveryID)
+	if err != nil {
+		render.Render(w, r, apperrors.ErrFromError(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.Render(w, r, &deliveryResponse{delivery})
+}
+
+// webhookContext loads the webhook of the {id} URL parameter, it must
+// belong to the owner of the request.
+func (c resource) webhookContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrInvalidRequest(err))
+			return
+		}
+
+		webhook, err := c.service.Get(c.owner(r), id)
+		if err != nil {
+			render.Render(w, r, apperrors.ErrFromError(err))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), webhookKey{}, webhook)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
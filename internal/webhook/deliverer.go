@@ -0,0 +1,225 @@
+// Package webhook lets users and admins register endpoints receiving the
+// domain events of the outbox. Deliveries are signed with the secret of the
+// webhook, retried with an exponential backoff and given up after too many
+// failures. Webhooks failing persistently are disabled.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+)
+
+// maxResponseBody is how much of the response bodies is read, so the
+// connection can be reused. Response bodies are not kept.
+const maxResponseBody = 1024
+
+// Options configures the deliverer.
+type Options struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// Timeout bounds every attempt, redirects are not followed
+	Timeout time.Duration
+	// MaxAttempts is the number of attempts after which a delivery is dead
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry, it doubles with every
+	// attempt until MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// DisableAfter is the number of failed attempts in a row, whatever the
+	// delivery, after which a webhook is disabled
+	DisableAfter int
+}
+
+// Deliverer attempts the pending deliveries. Several deliverers, in as many
+// processes, can share the deliveries.
+type Deliverer struct {
+	db     *sqlx.DB
+	cipher *util.Cipher
+	client *http.Client
+	opts   Options
+}
+
+func NewDeliverer(db *sqlx.DB, cipher *util.Cipher, opts Options) *Deliverer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 10
+	}
+
+	// no proxy, which would be dialed instead of the webhook, and only the
+	// addresses allowed
+	dialer := &net.Dialer{Timeout: opts.Timeout, Control: dialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &Deliverer{db, cipher, client, opts}
+}
+
+// Run attempts deliveries until ctx is done. The attempts in flight are
+// finished before it returns.
+func (d *Deliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := d.Deliver(ctx)
+		if err != nil {
+			log.Printf("deliver webhooks error: %v", err)
+		}
+
+		// keep going while there is a backlog
+		if err == nil && n == d.opts.BatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deliver attempts one batch of due deliveries, concurrently, and returns
+// its size.
+func (d *Deliverer) Deliver(ctx context.Context) (int, error) {
+	// the claim outlives the attempts, a minute is left to record them
+	deliveries, err := NewWebhookQueries(d.db, nil).ClaimDeliveries(d.opts.BatchSize, d.opts.Timeout+time.Minute)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+
+	for i := range deliveries {
+		wg.Add(1)
+		go func(delivery *entity.WebhookDelivery) {
+			defer wg.Done()
+			if err := d.attempt(delivery); err != nil {
+				log.Printf("webhook delivery %d error: %v", delivery.ID, err)
+			}
+		}(&deliveries[i])
+	}
+
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+// attempt sends a delivery and records the outcome. Attempts are not bound
+// to the context of Run so a shutdown does not count as a failure.
+func (d *Deliverer) attempt(delivery *entity.WebhookDelivery) error {
+	webhook, err := NewWebhookQueries(d.db, nil).FindWebhook(delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	secret, err := d.cipher.Decrypt(webhook.Secret)
+	if err != nil {
+		return err
+	}
+
+	d.send(webhook, string(secret), delivery)
+
+	return database.WithTx(d.db, func(tx *sqlx.Tx) error {
+		repo := NewWebhookQueries(d.db, tx)
+
+		if err := repo.RecordAttempt(delivery); err != nil {
+			return err
+		}
+
+		if delivery.Status == entity.DeliverySucceeded {
+			return repo.RecordSuccess(webhook.ID)
+		}
+
+		after, err := repo.RecordFailure(webhook.ID, d.opts.DisableAfter)
+		if err != nil {
+			return err
+		}
+
+		if !webhook.Active || after.Active {
+			return nil
+		}
+
+		log.Printf("webhook %d disabled after %d failed deliveries", webhook.ID, after.ConsecutiveFailures)
+
+		event := audit.Metadata{}.Event(EventWebhookDisabled, targetWebhook, webhook.ID)
+		event.Details["consecutive_failures"] = after.ConsecutiveFailures
+
+		return audit.NewAuditQueries(d.db, tx).Insert(event)
+	})
+}
+
+// send makes an attempt of the delivery and updates it with the response.
+func (d *Deliverer) send(webhook *entity.Webhook, secret string, delivery *entity.WebhookDelivery) {
+	now := time.Now()
+	body := []byte(delivery.Payload)
+
+	delivery.Attempts++
+	delivery.ResponseStatus = nil
+	delivery.Error = ""
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "myserver-webhooks")
+		req.Header.Set(HeaderEvent, delivery.EventType)
+		req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+		req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(HeaderSignature, Sign(secret, now, body))
+
+		var res *http.Response
+		if res, err = d.client.Do(req); err == nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, maxResponseBody))
+			res.Body.Close()
+
+			status := res.StatusCode
+			delivery.ResponseStatus = &status
+		}
+	}
+
+	delivery.DurationMs = int(time.Since(now).Milliseconds())
+
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	switch {
+	case delivery.ResponseStatus != nil && *delivery.ResponseStatus >= 200 && *delivery.ResponseStatus < 300:
+		delivery.Status = entity.DeliverySucceeded
+		delivery.DeliveredAt = pgtype.Timestamp{Time: time.Now(), Valid: true}
+	case delivery.Attempts >= d.opts.MaxAttempts:
+		delivery.Status = entity.DeliveryDead
+	default:
+		delivery.Status = entity.DeliveryPending
+		delivery.NextAttemptAt = pgtype.Timestamp{Time: time.Now().Add(d.backoff(delivery.Attempts)), Valid: true}
+	}
+}
+
+// backoff returns the delay before retrying a delivery attempted attempts
+// times, it grows like the retries of the outbox.
+func (d *Deliverer) backoff(attempts int) time.Duration {
+	return outbox.Options{BaseBackoff: d.opts.BaseBackoff, MaxBackoff: d.opts.MaxBackoff}.Backoff(attempts)
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type delivererSuiteTest struct {
+	test.TSuite
+	service   Service
+	deliverer *Deliverer
+}
+
+func TestDelivererSuiteTest(t *testing.T) {
+	suite.Run(t, new(delivererSuiteTest))
+}
+
+func (t *delivererSuiteTest) SetupTest() {
+	t.TSuite.SetupTest()
+
+	cipher, err := util.NewCipher("test-key")
+	require.NoError(t.T(), err)
+
+	t.service = NewService(t.DB, NewWebhookQueries(t.DB, nil), cipher)
+	// without backoff failed deliveries are due again right away
+	t.deliverer = NewDeliverer(t.DB, cipher, Options{Timeout: time.Second, MaxAttempts: 3, DisableAfter: 5})
+
+	// the receivers listen on the loopback
+	allowedIP = func(net.IP) bool { return true }
+}
+
+func (t *delivererSuiteTest) TearDownTest() {
+	allowedIP = publicIP
+	t.TSuite.TearDownTest()
+}
+
+// receiver is a webhook endpoint answering with status and keeping the
+// requests it received.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(status int) *receiver {
+	rec := &receiver{status: status}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+
+		w.WriteHeader(rec.status)
+		w.Write([]byte("received"))
+	}))
+	return rec
+}
+
+func (t *delivererSuiteTest) register(url string) Webhook {
+	w, err := t.service.Create(context.Background(), nil, CreateWebhookRequest{URL: url, EventTypes: []string{"*"}})
+	require.NoError(t.T(), err)
+
+	require.NoError(t.T(), t.service.Enqueue(context.Background(), eventOf("note", "1", `{"title":"Groceries"}`)))
+
+	return w
+}
+
+func (t *delivererSuiteTest) deliveries(id int64) []entity.WebhookDelivery {
+	deliveries, err := NewWebhookQueries(t.DB, nil).GetDeliveries(id, 0, 10)
+	require.NoError(t.T(), err)
+	return deliveries
+}
+
+func (t *delivererSuiteTest) TestDeliverSigned() {
+	rec := newReceiver(http.StatusNoContent)
+	defer rec.Close()
+
+	w := t.register(rec.URL)
+
+	n, err := t.deliverer.Deliver(context.Background())
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 1, n)
+
+	require.Len(t.T(), rec.requests, 1)
+	req := rec.requests[0]
+
+	assert.Equal(t.T(), "note.created", req.Header.Get(HeaderEvent))
+	assert.True(t.T(), Verify(w.SigningSecret, req.Header.Get(HeaderTimestamp), rec.bodies[0], req.Header.Get(HeaderSignature), time.Minute))
+
+	delivery := t.deliveries(w.ID)[0]
+	assert.Equal(t.T(), entity.DeliverySucceeded, delivery.Status)
+	assert.Equal(t.T(), 1, delivery.Attempts)
+	assert.Equal(t.T(), http.StatusNoContent, *delivery.ResponseStatus)
+	assert.True(t.T(), delivery.DeliveredAt.Valid)
+
+	// succeeded deliveries are not sent again
+	n, err = t.deliverer.Deliver(context.Background())
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), 0, n)
+}
+
+func (t *delivererSuiteTest) TestDeliverRetriesUntilDead() {
+	rec := newReceiver(http.StatusServiceUnavailable)
+	defer rec.Close()
+
+	w := t.register(rec.URL)
+
+	for i := 0; i < 4; i++ {
+		_, err := t.deliverer.Deliver(context.Background())
+		require.NoError(t.T(), err)
+	}
+
+	assert.Len(t.T(), rec.requests, 3)
+
+	delivery := t.deliveries(w.ID)[0]
+	assert.Equal(t.T(), entity.DeliveryDead, delivery.Status)
+	assert.Equal(t.T(), 3, delivery.Attempts)
+	assert.Equal(t.T(), http.StatusServiceUnavailable, *delivery.ResponseStatus)
+
+	// a dead delivery can be sent again by hand
+	rec.status = http.StatusOK
+
+	redelivery, err := t.service.Redeliver(nil, w.ID, delivery.ID)
+	require.NoError(t.T(), err)
+	assert.Equal(t.T(), entity.DeliveryPending, redelivery.Status)
+
+	_, err = t.deliverer.Deliver(context.Background())
+	require.NoError(t.T(), err)
+
+	require.Len(t.T(), rec.bodies, 4)
+	assert.Equal(t.T(), rec.bodies[0], rec.bodies[3])
+	assert.Equal(t.T(), entity.DeliverySucceeded, t.deliveries(w.ID)[0].Status)
+}
+
+func (t *delivererSuiteTest) TestDisableFailingWebhook() {
+	t.deliverer.opts.DisableAfter = 2
+
+	// nothing listens on the URL
+	rec := newReceiver(http.StatusOK)
+	rec.Close()
+
+	w := t.register(rec.URL)
+
+	for i := 0; i < 3; i++ {
+		_, err := t.deliverer.Deliver(context.Background())
+		require.NoError(t.T(), err)
+	}
+
+	disabled, err := t.service.Get(nil, w.ID)
+	require.NoError(t.T(), err)
+
+	assert.False(t.T(), disabled.Active)
+	assert.True(t.T(), disabled.DisabledAt.Valid)
+	assert.Equal(t.T(), 2, disabled.ConsecutiveFailures)
+
+	// the deliveries of a disabled webhook wait for it to be activated
+	delivery := t.deliveries(w.ID)[0]
+	assert.Equal(t.T(), entity.DeliveryPending, delivery.Status)
+	assert.Equal(t.T(), 2, delivery.Attempts)
+	assert.NotEmpty(t.T(), delivery.Error)
+	assert.Nil(t.T(), delivery.ResponseStatus)
+}
@@ -0,0 +1,262 @@
+package webhook
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/pkg/errors"
+)
+
+// Owners are a user ID, or nil for the global webhooks.
+type WebhookQueries interface {
+	GetWebhooks(owner *int64) ([]entity.Webhook, error)
+	GetWebhook(owner *int64, id int64) (*entity.Webhook, error)
+	FindWebhook(id int64) (*entity.Webhook, error)
+	CreateWebhook(w *entity.Webhook) (*entity.Webhook, error)
+	UpdateWebhook(w *entity.Webhook) (*entity.Webhook, error)
+	DeleteWebhook(owner *int64, id int64, version int64) error
+	Subscribers(eventType string, userID *int64) ([]entity.Webhook, error)
+	RecordSuccess(id int64) error
+	RecordFailure(id int64, disableAfter int) (*entity.Webhook, error)
+	CreateDelivery(d *entity.WebhookDelivery) (*entity.WebhookDelivery, error)
+	GetDelivery(webhookID int64, id int64) (*entity.WebhookDelivery, error)
+	GetDeliveries(webhookID int64, before int64, limit int) ([]entity.WebhookDelivery, error)
+	ClaimDeliveries(limit int, lease time.Duration) ([]entity.WebhookDelivery, error)
+	RecordAttempt(d *entity.WebhookDelivery) error
+}
+
+// webhookQueries struct for queries from the webhooks and
+// webhook_deliveries tables.
+type webhookQueries struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+func NewWebhookQueries(db *sqlx.DB, tx *sqlx.Tx) WebhookQueries {
+	return &webhookQueries{db, tx}
+}
+
+// conn returns the transaction the queries are bound to, or the database
+// handle when there is none.
+func (q *webhookQueries) conn() sqlx.Ext {
+	if q.tx != nil {
+		return q.tx
+	}
+	return q.db
+}
+
+// GetWebhooks implements WebhookQueries
+func (q *webhookQueries) GetWebhooks(owner *int64) ([]entity.Webhook, error) {
+	webhooks := []entity.Webhook{}
+
+	query := `SELECT * FROM webhooks WHERE user_id IS NOT DISTINCT FROM $1 ORDER BY id`
+
+	err := sqlx.Select(q.conn(), &webhooks, query, owner)
+
+	return webhooks, err
+}
+
+// GetWebhook implements WebhookQueries
+func (q *webhookQueries) GetWebhook(owner *int64, id int64) (*entity.Webhook, error) {
+	var w entity.Webhook
+
+	query := `SELECT * FROM webhooks WHERE id = $1 AND user_id IS NOT DISTINCT FROM $2`
+
+	err := sqlx.Get(q.conn(), &w, query, id, owner)
+
+	return &w, err
+}
+
+// FindWebhook implements WebhookQueries
+//
+// Unlike GetWebhook it finds the webhook whatever its owner.
+func (q *webhookQueries) FindWebhook(id int64) (*entity.Webhook, error) {
+	var w entity.Webhook
+
+	err := sqlx.Get(q.conn(), &w, `SELECT * FROM webhooks WHERE id = $1`, id)
+
+	return &w, err
+}
+
+// CreateWebhook implements WebhookQueries
+func (q *webhookQueries) CreateWebhook(w *entity.Webhook) (*entity.Webhook, error) {
+	query := `INSERT INTO webhooks (user_id, url, secret, event_types, description)
+		VALUES ($1, $2, $3, $4, $5) RETURNING *`
+
+	var webhook entity.Webhook
+
+	err := q.conn().QueryRowx(query, w.UserID, w.URL, w.Secret, w.EventTypes, w.Description).StructScan(&webhook)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert webhook error")
+	}
+
+	return &webhook, nil
+}
+
+// UpdateWebhook implements WebhookQueries
+//
+// Unless w.Version is etag.Any it must be the current version of the
+// webhook, sql.ErrNoRows is returned otherwise.
+func (q *webhookQueries) UpdateWebhook(w *entity.Webhook) (*entity.Webhook, error) {
+	query := `UPDATE webhooks SET url = $3, event_types = $4, description = $5, active = $6,
+		consecutive_failures = $7, disabled_at = $8, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND user_id IS NOT DISTINCT FROM $2 AND ($9 = 0 OR version = $9) RETURNING *`
+
+	var webhook entity.Webhook
+
+	err := q.conn().QueryRowx(query, w.ID, w.UserID, w.URL, w.EventTypes, w.Description, w.Active,
+		w.ConsecutiveFailures, w.DisabledAt, w.Version).StructScan(&webhook)
+	if err != nil {
+		return nil, errors.Wrap(err, "update webhook error")
+	}
+
+	return &webhook, nil
+}
+
+// DeleteWebhook implements WebhookQueries
+//
+// Unless version is etag.Any it must be the current version of the webhook,
+// sql.ErrNoRows is returned otherwise. Its deliveries are deleted with it.
+func (q *webhookQueries) DeleteWebhook(owner *int64, id int64, version int64) error {
+	query := `DELETE FROM webhooks WHERE id = $1 AND user_id IS NOT DISTINCT FROM $2 AND ($3 = 0 OR version = $3)`
+
+	res, err := q.conn().Exec(query, id, owner, version)
+	if err != nil {
+		return errors.Wrap(err, "delete webhook error")
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Subscribers implements WebhookQueries
+//
+// It returns the active webhooks subscribed to eventType, or to every event,
+// that may see the events of userID: the global ones and those of the user.
+func (q *webhookQueries) Subscribers(eventType string, userID *int64) ([]entity.Webhook, error) {
+	webhooks := []entity.Webhook{}
+
+	query := `SELECT * FROM webhooks WHERE active
+		AND (event_types @> '["*"]' OR event_types @> jsonb_build_array($1::text))
+		AND (user_id IS NULL OR user_id = $2) ORDER BY id`
+
+	err := sqlx.Select(q.conn(), &webhooks, query, eventType, userID)
+
+	return webhooks, err
+}
+
+// RecordSuccess implements WebhookQueries
+func (q *webhookQueries) RecordSuccess(id int64) error {
+	if _, err := q.conn().Exec(`UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1`, id); err != nil {
+		return errors.Wrap(err, "record webhook success error")
+	}
+
+	return nil
+}
+
+// RecordFailure implements WebhookQueries
+//
+// The webhook is disabled once it failed disableAfter times in a row.
+func (q *webhookQueries) RecordFailure(id int64, disableAfter int) (*entity.Webhook, error) {
+	query := `UPDATE webhooks SET consecutive_failures = consecutive_failures + 1,
+		active = active AND consecutive_failures + 1 < $2,
+		disabled_at = CASE WHEN active AND consecutive_failures + 1 >= $2 THEN NOW() ELSE disabled_at END
+		WHERE id = $1 RETURNING *`
+
+	var webhook entity.Webhook
+
+	if err := q.conn().QueryRowx(query, id, disableAfter).StructScan(&webhook); err != nil {
+		return nil, errors.Wrap(err, "record webhook failure error")
+	}
+
+	return &webhook, nil
+}
+
+// CreateDelivery implements WebhookQueries
+//
+// A delivery of an event already delivered to the webhook is ignored,
+// sql.ErrNoRows is returned.
+func (q *webhookQueries) CreateDelivery(d *entity.WebhookDelivery) (*entity.WebhookDelivery, error) {
+	query := `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING RETURNING *`
+
+	var delivery entity.WebhookDelivery
+
+	err := q.conn().QueryRowx(query, d.WebhookID, d.EventID, d.EventType, d.Payload).StructScan(&delivery)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "insert webhook delivery error")
+	}
+
+	return &delivery, nil
+}
+
+// GetDelivery implements WebhookQueries
+func (q *webhookQueries) GetDelivery(webhookID int64, id int64) (*entity.WebhookDelivery, error) {
+	var d entity.WebhookDelivery
+
+	query := `SELECT * FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2`
+
+	err := sqlx.Get(q.conn(), &d, query, id, webhookID)
+
+	return &d, err
+}
+
+// GetDeliveries implements WebhookQueries
+//
+// Deliveries are returned newest first, those with an ID below before when
+// it is not zero.
+func (q *webhookQueries) GetDeliveries(webhookID int64, before int64, limit int) ([]entity.WebhookDelivery, error) {
+	deliveries := []entity.WebhookDelivery{}
+
+	query := `SELECT * FROM webhook_deliveries WHERE webhook_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC LIMIT $3`
+
+	err := sqlx.Select(q.conn(), &deliveries, query, webhookID, before, limit)
+
+	return deliveries, err
+}
+
+// ClaimDeliveries implements WebhookQueries
+//
+// It returns the pending deliveries due to active webhooks and postpones
+// them by lease, so no other deliverer picks them up while they are being
+// attempted. A deliverer dying mid-attempt only delays the delivery.
+func (q *webhookQueries) ClaimDeliveries(limit int, lease time.Duration) ([]entity.WebhookDelivery, error) {
+	deliveries := []entity.WebhookDelivery{}
+
+	query := `UPDATE webhook_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT d.id FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.status = $3 AND d.next_attempt_at <= NOW() AND w.active
+			ORDER BY d.next_attempt_at, d.id LIMIT $1 FOR UPDATE OF d SKIP LOCKED
+		) RETURNING *`
+
+	err := sqlx.Select(q.conn(), &deliveries, query, limit, time.Now().Add(lease), entity.DeliveryPending)
+	if err != nil {
+		return nil, errors.Wrap(err, "claim webhook deliveries error")
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt implements WebhookQueries
+func (q *webhookQueries) RecordAttempt(d *entity.WebhookDelivery) error {
+	query := `UPDATE webhook_deliveries SET status = $2, attempts = $3, next_attempt_at = $4, response_status = $5,
+		error = $6, duration_ms = $7, delivered_at = $8 WHERE id = $1`
+
+	_, err := q.conn().Exec(query, d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.ResponseStatus,
+		d.Error, d.DurationMs, d.DeliveredAt)
+	if err != nil {
+		return errors.Wrap(err, "record webhook delivery attempt error")
+	}
+
+	return nil
+}
@@ -0,0 +1,420 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/util"
+	database "github.com/opaulochaves/myserver/pkg/db"
+	"github.com/opaulochaves/myserver/pkg/etag"
+	"github.com/opaulochaves/myserver/pkg/pagination"
+	"github.com/pkg/errors"
+)
+
+// Audited events of the webhook service
+const (
+	EventWebhookCreated  = "webhook.created"
+	EventWebhookUpdated  = "webhook.updated"
+	EventWebhookDeleted  = "webhook.deleted"
+	EventWebhookDisabled = "webhook.disabled"
+
+	targetWebhook = "webhook"
+)
+
+var eventTypeRule = validation.Match(regexp.MustCompile(`^(\*|[a-z_]+\.[a-z_]+)$`)).Error("must be an event type, eg user.created, or *")
+
+// Service manages webhooks and queues the deliveries of the events they
+// subscribe to. Owners are a user ID, whose webhooks receive the events
+// concerning the user, or nil for the global webhooks receiving every event.
+type Service interface {
+	Get(owner *int64, id int64) (Webhook, error)
+	Query(owner *int64) ([]Webhook, error)
+	// Create returns the webhook with its signing secret, it is not shown again
+	Create(ctx context.Context, owner *int64, input CreateWebhookRequest) (Webhook, error)
+	// Update and Delete require the current version of the webhook, or etag.Any
+	Update(ctx context.Context, owner *int64, id int64, input UpdateWebhookRequest, version int64) (Webhook, error)
+	Delete(ctx context.Context, owner *int64, id int64, version int64) error
+	// Deliveries reads a page of the deliveries of a webhook, newest first.
+	Deliveries(owner *int64, id int64, page *pagination.Cursor) error
+	// Redeliver queues a new delivery of the payload of a previous one.
+	Redeliver(owner *int64, id int64, deliveryID int64) (entity.WebhookDelivery, error)
+	// Enqueue queues the deliveries of an outbox event, it is an outbox.Handler.
+	Enqueue(ctx context.Context, event entity.OutboxEvent) error
+}
+
+// Webhook represents the data about a webhook.
+type Webhook struct {
+	*entity.Webhook
+	// SigningSecret is only set when the webhook is created
+	SigningSecret string `json:"secret,omitempty"`
+}
+
+type WebhookResponse struct {
+	Webhook
+}
+
+// Render implements render.Renderer
+func (w *WebhookResponse) Render(rw http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// CreateWebhookRequest represents a webhook registration request.
+type CreateWebhookRequest struct {
+	URL         string   `json:"url"`
+	EventTypes  []string `json:"event_types"`
+	Description string   `json:"description"`
+}
+
+// Bind implements render.Binder
+func (*CreateWebhookRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the CreateWebhookRequest fields.
+func (c CreateWebhookRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.URL, validation.Required, validation.Length(1, 2048), is.URL, validation.By(httpURL)),
+		validation.Field(&c.EventTypes, validation.Required, validation.Each(eventTypeRule)),
+		validation.Field(&c.Description, validation.Length(0, 255)),
+	)
+}
+
+// UpdateWebhookRequest represents a partial webhook update, nil fields are
+// left unchanged. Activating a webhook disabled after failures resets its
+// failure count.
+type UpdateWebhookRequest struct {
+	URL         *string   `json:"url"`
+	EventTypes  *[]string `json:"event_types"`
+	Description *string   `json:"description"`
+	Active      *bool     `json:"active"`
+}
+
+// Bind implements render.Binder
+func (*UpdateWebhookRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// Validate validates the UpdateWebhookRequest fields.
+func (c UpdateWebhookRequest) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.URL, validation.NilOrNotEmpty, validation.Length(1, 2048), is.URL, validation.By(httpURL)),
+		validation.Field(&c.EventTypes, validation.NilOrNotEmpty, validation.Each(eventTypeRule)),
+		validation.Field(&c.Description, validation.Length(0, 255)),
+	)
+}
+
+// httpURL refuses the URLs which are not http or https, or which reach a
+// local or private address.
+func httpURL(value interface{}) error {
+	var s string
+
+	switch v := value.(type) {
+	case string:
+		s = v
+	case *string:
+		if v == nil {
+			return nil
+		}
+		s = *v
+	}
+
+	if s != "" && !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") {
+		return errors.New("must be an http or https URL")
+	}
+
+	if s != "" {
+		return checkURL(s)
+	}
+
+	return nil
+}
+
+type service struct {
+	db     *sqlx.DB
+	repo   WebhookQueries
+	cipher *util.Cipher
+}
+
+func NewService(db *sqlx.DB, repo WebhookQueries, cipher *util.Cipher) Service {
+	return service{db, repo, cipher}
+}
+
+// Get implements Service
+func (s service) Get(owner *int64, id int64) (Webhook, error) {
+	w, err := s.repo.GetWebhook(owner, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, apperrors.NewNotFound("webhook", fmt.Sprint(id))
+		}
+		return Webhook{}, err
+	}
+
+	return Webhook{Webhook: w}, nil
+}
+
+// Query implements Service
+func (s service) Query(owner *int64) ([]Webhook, error) {
+	webhooks, err := s.repo.GetWebhooks(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Webhook{}
+
+	for i := range webhooks {
+		result = append(result, Webhook{Webhook: &webhooks[i]})
+	}
+
+	return result, nil
+}
+
+// Create implements Service
+func (s service) Create(ctx context.Context, owner *int64, input CreateWebhookRequest) (Webhook, error) {
+	if err := input.Validate(); err != nil {
+		return Webhook{}, err
+	}
+
+	token, err := util.RandomToken(32)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	secret := "whsec_" + token
+
+	encrypted, err := s.cipher.Encrypt([]byte(secret))
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	var w *entity.Webhook
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		w, err = NewWebhookQueries(s.db, tx).CreateWebhook(&entity.Webhook{
+			UserID:      owner,
+			URL:         input.URL,
+			Secret:      encrypted,
+			EventTypes:  input.EventTypes,
+			Description: input.Description,
+		})
+		if err != nil {
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventWebhookCreated, targetWebhook, w.ID)
+		event.Changes = audit.Diff(nil, w)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return Webhook{Webhook: w, SigningSecret: secret}, nil
+}
+
+// Update implements Service
+func (s service) Update(ctx context.Context, owner *int64, id int64, input UpdateWebhookRequest, version int64) (Webhook, error) {
+	if err := input.Validate(); err != nil {
+		return Webhook{}, err
+	}
+
+	before, err := s.Get(owner, id)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	if version != etag.Any && version != before.Version {
+		return Webhook{}, etag.Mismatch()
+	}
+
+	changes := *before.Webhook
+	changes.Version = version
+	if input.URL != nil {
+		changes.URL = *input.URL
+	}
+	if input.EventTypes != nil {
+		changes.EventTypes = *input.EventTypes
+	}
+	if input.Description != nil {
+		changes.Description = *input.Description
+	}
+	if input.Active != nil {
+		if *input.Active && !changes.Active {
+			changes.ConsecutiveFailures = 0
+			changes.DisabledAt = pgtype.Timestamp{}
+		}
+		changes.Active = *input.Active
+	}
+
+	var after *entity.Webhook
+
+	err = database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if after, err = NewWebhookQueries(s.db, tx).UpdateWebhook(&changes); err != nil {
+			// updated or deleted since it was read
+			if errors.Is(err, sql.ErrNoRows) {
+				return etag.Mismatch()
+			}
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventWebhookUpdated, targetWebhook, id)
+		event.Changes = audit.Diff(before.Webhook, after)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return Webhook{Webhook: after}, nil
+}
+
+// Delete implements Service
+func (s service) Delete(ctx context.Context, owner *int64, id int64, version int64) error {
+	before, err := s.Get(owner, id)
+	if err != nil {
+		return err
+	}
+
+	return database.WithTx(s.db, func(tx *sqlx.Tx) error {
+		if err := NewWebhookQueries(s.db, tx).DeleteWebhook(owner, id, version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return etag.Mismatch()
+			}
+			return err
+		}
+
+		event := audit.FromContext(ctx).Event(EventWebhookDeleted, targetWebhook, id)
+		event.Changes = audit.Diff(before.Webhook, nil)
+
+		return audit.NewAuditQueries(s.db, tx).Insert(event)
+	})
+}
+
+// Deliveries implements Service
+func (s service) Deliveries(owner *int64, id int64, page *pagination.Cursor) error {
+	if _, err := s.Get(owner, id); err != nil {
+		return err
+	}
+
+	deliveries, err := s.repo.GetDeliveries(id, page.After, page.Fetch())
+	if err != nil {
+		return err
+	}
+
+	n := page.Page(len(deliveries), func(i int) int64 { return deliveries[i].ID })
+
+	page.Items = deliveries[:n]
+
+	return nil
+}
+
+// Redeliver implements Service
+func (s service) Redeliver(owner *int64, id int64, deliveryID int64) (entity.WebhookDelivery, error) {
+	if _, err := s.Get(owner, id); err != nil {
+		return entity.WebhookDelivery{}, err
+	}
+
+	previous, err := s.repo.GetDelivery(id, deliveryID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.WebhookDelivery{}, apperrors.NewNotFound("delivery", fmt.Sprint(deliveryID))
+		}
+		return entity.WebhookDelivery{}, err
+	}
+
+	delivery, err := s.repo.CreateDelivery(&entity.WebhookDelivery{
+		WebhookID: id,
+		EventType: previous.EventType,
+		Payload:   previous.Payload,
+	})
+	if err != nil {
+		return entity.WebhookDelivery{}, err
+	}
+
+	return *delivery, nil
+}
+
+// envelope is the body of a delivery.
+type envelope struct {
+	ID        int64            `json:"id"`
+	Type      string           `json:"type"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+	Data      json.RawMessage  `json:"data"`
+}
+
+// Enqueue implements Service
+//
+// Deliveries are only created once per webhook and event, the outbox may
+// dispatch an event more than once.
+func (s service) Enqueue(ctx context.Context, event entity.OutboxEvent) error {
+	webhooks, err := s.repo.Subscribers(event.EventType, eventOwner(event))
+	if err != nil {
+		return err
+	}
+
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(envelope{
+		ID:        event.ID,
+		Type:      event.EventType,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, w := range webhooks {
+		_, err := s.repo.CreateDelivery(&entity.WebhookDelivery{
+			WebhookID: w.ID,
+			EventID:   &event.ID,
+			EventType: event.EventType,
+			Payload:   string(body),
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eventOwner returns the user an event is about: the user itself, or the
+// user_id of the payload. It returns nil when there is none, only global
+// webhooks receive the event then.
+func eventOwner(event entity.OutboxEvent) *int64 {
+	if event.AggregateType == audit.TargetUser {
+		if id, err := strconv.ParseInt(event.AggregateID, 10, 64); err == nil {
+			return &id
+		}
+		return nil
+	}
+
+	var payload struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	if err := json.Unmarshal(event.Payload, &payload); err != nil || payload.UserID == 0 {
+		return nil
+	}
+
+	return &payload.UserID
+}
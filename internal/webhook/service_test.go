@@ -0,0 +1,160 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/opaulochaves/myserver/internal/entity"
+	"github.com/opaulochaves/myserver/internal/test"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/opaulochaves/myserver/internal/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type serviceSuiteTest struct {
+	test.TSuite
+}
+
+func TestServiceSuiteTest(t *testing.T) {
+	suite.Run(t, new(serviceSuiteTest))
+}
+
+func eventOf(aggregateType string, aggregateID string, payload string) entity.OutboxEvent {
+	return entity.OutboxEvent{
+		ID:            1,
+		EventType:     aggregateType + ".created",
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Payload:       json.RawMessage(payload),
+	}
+}
+
+func (t *serviceSuiteTest) newService() Service {
+	cipher, err := util.NewCipher("test-key")
+	require.NoError(t.T(), err)
+
+	return NewService(t.DB, NewWebhookQueries(t.DB, nil), cipher)
+}
+
+func (t *serviceSuiteTest) createUsers() []entity.User {
+	var users []entity.User
+
+	for _, u := range test.GenerateUsers(2) {
+		created, err := user.NewUserQueries(t.DB, nil).CreateUser(&u)
+		require.NoError(t.T(), err)
+		users = append(users, *created)
+	}
+
+	return users
+}
+
+func (t *serviceSuiteTest) TestCreateValidates() {
+	service := t.newService()
+
+	_, err := service.Create(context.Background(), nil, CreateWebhookRequest{URL: "ftp://example.com", EventTypes: []string{"user.created"}})
+	assert.Error(t.T(), err)
+
+	_, err = service.Create(context.Background(), nil, CreateWebhookRequest{URL: "https://example.com", EventTypes: []string{"Users"}})
+	assert.Error(t.T(), err)
+
+	created, err := service.Create(context.Background(), nil, CreateWebhookRequest{URL: "https://example.com", EventTypes: []string{"*"}})
+	require.NoError(t.T(), err)
+
+	assert.Regexp(t.T(), `^whsec_[0-9a-f]{64}$`, created.SigningSecret)
+	assert.NotContains(t.T(), string(created.Secret), "whsec_", "stored encrypted")
+
+	// the secret is not shown again
+	got, err := service.Get(nil, created.ID)
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), got.SigningSecret)
+}
+
+func (t *serviceSuiteTest) TestOwnersAreIsolated() {
+	service := t.newService()
+	users := t.createUsers()
+
+	created, err := service.Create(context.Background(), &users[0].ID, CreateWebhookRequest{URL: "https://example.com", EventTypes: []string{"*"}})
+	require.NoError(t.T(), err)
+
+	_, err = service.Get(&users[1].ID, created.ID)
+	assert.Equal(t.T(), http.StatusNotFound, apperrors.Status(err))
+
+	_, err = service.Get(nil, created.ID)
+	assert.Equal(t.T(), http.StatusNotFound, apperrors.Status(err))
+
+	webhooks, err := service.Query(nil)
+	require.NoError(t.T(), err)
+	assert.Empty(t.T(), webhooks)
+}
+
+func (t *serviceSuiteTest) TestEnqueue() {
+	service := t.newService()
+	users := t.createUsers()
+	ctx := context.Background()
+
+	global, err := service.Create(ctx, nil, CreateWebhookRequest{URL: "https://example.com/all", EventTypes: []string{"*"}})
+	require.NoError(t.T(), err)
+	mine, err := service.Create(ctx, &users[0].ID, CreateWebhookRequest{URL: "https://example.com/mine", EventTypes: []string{"note.created"}})
+	require.NoError(t.T(), err)
+	others, err := service.Create(ctx, &users[1].ID, CreateWebhookRequest{URL: "https://example.com/others", EventTypes: []string{"note.created"}})
+	require.NoError(t.T(), err)
+	unsubscribed, err := service.Create(ctx, &users[0].ID, CreateWebhookRequest{URL: "https://example.com/users", EventTypes: []string{"user.deleted"}})
+	require.NoError(t.T(), err)
+
+	event := eventOf("note", "3", fmt.Sprintf(`{"title":"Groceries","user_id":%d}`, users[0].ID))
+
+	require.NoError(t.T(), service.Enqueue(ctx, event))
+	// the outbox delivers at least once
+	require.NoError(t.T(), service.Enqueue(ctx, event))
+
+	repo := NewWebhookQueries(t.DB, nil)
+
+	count := func(id int64) int {
+		deliveries, err := repo.GetDeliveries(id, 0, 10)
+		require.NoError(t.T(), err)
+		return len(deliveries)
+	}
+
+	assert.Equal(t.T(), 1, count(global.ID))
+	assert.Equal(t.T(), 1, count(mine.ID))
+	assert.Equal(t.T(), 0, count(others.ID))
+	assert.Equal(t.T(), 0, count(unsubscribed.ID))
+
+	deliveries, err := repo.GetDeliveries(mine.ID, 0, 10)
+	require.NoError(t.T(), err)
+
+	var body envelope
+	require.NoError(t.T(), json.Unmarshal([]byte(deliveries[0].Payload), &body))
+	assert.Equal(t.T(), "note.created", body.Type)
+	assert.JSONEq(t.T(), string(event.Payload), string(body.Data))
+}
+
+func (t *serviceSuiteTest) TestReactivateResetsFailures() {
+	service := t.newService()
+
+	created, err := service.Create(context.Background(), nil, CreateWebhookRequest{URL: "https://example.com", EventTypes: []string{"*"}})
+	require.NoError(t.T(), err)
+
+	disabled, err := NewWebhookQueries(t.DB, nil).RecordFailure(created.ID, 1)
+	require.NoError(t.T(), err)
+	require.False(t.T(), disabled.Active)
+	require.True(t.T(), disabled.DisabledAt.Valid)
+
+	active := true
+	updated, err := service.Update(context.Background(), nil, created.ID, UpdateWebhookRequest{Active: &active}, disabled.Version)
+	require.NoError(t.T(), err)
+
+	assert.True(t.T(), updated.Active)
+	assert.Equal(t.T(), 0, updated.ConsecutiveFailures)
+	assert.False(t.T(), updated.DisabledAt.Valid)
+
+	// failures do not change the version, updates do
+	_, err = service.Update(context.Background(), nil, created.ID, UpdateWebhookRequest{Active: &active}, disabled.Version)
+	assert.Equal(t.T(), http.StatusPreconditionFailed, apperrors.Status(err))
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Headers of a delivery
+const (
+	HeaderEvent     = "Webhook-Event"
+	HeaderDelivery  = "Webhook-Delivery"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+// Sign returns the signature of a delivery made at timestamp, the hex
+// HMAC-SHA256 of "<unix timestamp>.<body>" with the secret of the webhook.
+// Signing the timestamp lets receivers refuse replayed deliveries.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one of body sent at timestamp, a
+// unix time, and timestamp is within tolerance of now. It is what receivers
+// are expected to do.
+func Verify(secret string, timestamp string, body []byte, signature string, tolerance time.Duration) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	sent := time.Unix(unix, 0)
+	if d := time.Since(sent); d > tolerance || d < -tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(Sign(secret, sent, body)), []byte(signature))
+}
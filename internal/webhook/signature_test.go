@@ -0,0 +1,41 @@
+package webhook
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":1}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	signature := Sign("whsec_test", now, body)
+
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, signature)
+	assert.True(t, Verify("whsec_test", timestamp, body, signature, time.Minute))
+
+	assert.False(t, Verify("whsec_other", timestamp, body, signature, time.Minute), "wrong secret")
+	assert.False(t, Verify("whsec_test", timestamp, []byte(`{"id":2}`), signature, time.Minute), "tampered body")
+	assert.False(t, Verify("whsec_test", strconv.FormatInt(now.Unix()+1, 10), body, signature, time.Minute), "tampered timestamp")
+
+	old := now.Add(-time.Hour)
+	assert.False(t, Verify("whsec_test", strconv.FormatInt(old.Unix(), 10), body, Sign("whsec_test", old, body), time.Minute), "replayed")
+}
+
+func TestEventOwner(t *testing.T) {
+	owner := eventOwner(eventOf("user", "7", `{"email":"user@example.com"}`))
+	if assert.NotNil(t, owner) {
+		assert.Equal(t, int64(7), *owner)
+	}
+
+	owner = eventOwner(eventOf("note", "3", `{"title":"Groceries","user_id":9}`))
+	if assert.NotNil(t, owner) {
+		assert.Equal(t, int64(9), *owner)
+	}
+
+	assert.Nil(t, eventOwner(eventOf("note", "3", `{"title":"Groceries"}`)))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/opaulochaves/myserver/config"
+	"github.com/opaulochaves/myserver/internal/scheduler"
+)
+
+// registerMaintenance registers the schedules of the clean up tasks.
+func registerMaintenance(s *scheduler.Scheduler, cfg config.Config, svc *services) error {
+	schedules := []struct {
+		name        string
+		spec        string
+		description string
+		task        scheduler.Task
+	}{
+		{"purge-expired-tokens", "@hourly", "delete the expired verification tokens and sessions", func(ctx context.Context) error {
+			return logPurged("expired tokens")(svc.Auth.PurgeExpiredTokens())
+		}},
+		{"purge-login-attempts", "@daily", "delete the login attempts older than the retention", func(ctx context.Context) error {
+			return logPurged("login attempts")(svc.Auth.PurgeLoginAttempts(seconds(cfg.LoginAttemptRetention)))
+		}},
+		{"purge-deleted-users", "@hourly", "purge the users soft deleted for longer than the retention", func(ctx context.Context) error {
+			retention := time.Duration(cfg.UserRetentionDays) * 24 * time.Hour
+			return logPurged("deleted users")(svc.User.PurgeDeleted(retention))
+		}},
+		{"purge-trash", "@hourly", "delete the notes in the trash for longer than the retention", func(ctx context.Context) error {
+			retention := time.Duration(cfg.TrashRetentionDays) * 24 * time.Hour
+			return logPurged("notes from the trash")(svc.Note.PurgeTrash(retention))
+		}},
+		{"purge-orphaned-blobs", "@daily", "delete the stored files without an attachment", func(ctx context.Context) error {
+			// the blobs of the uploads in progress are not saved yet
+			return logPurged("orphaned blobs")(svc.Note.PurgeOrphanedBlobs(ctx, 24*time.Hour))
+		}},
+		{"purge-orphaned-avatars", "@daily", "delete the stored images of the replaced avatars and of the removed users", func(ctx context.Context) error {
+			return logPurged("orphaned avatar images")(svc.Avatar.PurgeOrphanedBlobs(ctx, 24*time.Hour))
+		}},
+		{"purge-expired-exports", "@hourly", "delete the expired data exports and their archives", func(ctx context.Context) error {
+			n, err := svc.Privacy.PurgeExpiredExports()
+			return logPurged("expired data exports")(int64(n), err)
+		}},
+		{"purge-idempotency-keys", "@hourly", "delete the expired idempotency keys", func(ctx context.Context) error {
+			return logPurged("expired idempotency keys")(svc.IdempotencyRepo.DeleteExpired(time.Now()))
+		}},
+		{"purge-outbox", "@daily", "delete the processed outbox events", func(ctx context.Context) error {
+			return logPurged("processed outbox events")(svc.OutboxRepo.DeleteProcessed(time.Now().Add(-seconds(cfg.OutboxRetention))))
+		}},
+		{"purge-jobs", "@daily", "delete the finished background jobs", func(ctx context.Context) error {
+			return logPurged("finished jobs")(svc.JobRepo.DeleteFinished(time.Now().Add(-seconds(cfg.JobRetention))))
+		}},
+		{"purge-schedule-runs", "@daily", "delete the old schedule runs", func(ctx context.Context) error {
+			return logPurged("schedule runs")(svc.ScheduleRepo.DeleteRuns(time.Now().Add(-seconds(cfg.ScheduleRunRetention))))
+		}},
+	}
+
+	for _, schedule := range schedules {
+		if err := s.Register(schedule.name, schedule.spec, schedule.description, schedule.task); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// logPurged returns a function logging how many records a purge deleted,
+// and returning its error.
+func logPurged(what string) func(n int64, err error) error {
+	return func(n int64, err error) error {
+		if err == nil && n > 0 {
+			log.Printf("purged %d %s", n, what)
+		}
+		return err
+	}
+}
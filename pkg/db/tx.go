@@ -0,0 +1,25 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// WithTx runs fn inside a database transaction. The transaction is committed
+// when fn returns nil and rolled back otherwise.
+func WithTx(db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("error, could not begin transaction, %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w, rollback failed, %v", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,308 @@
+// Package diff compares texts line by line and formats the differences as
+// unified diffs.
+//
+// The comparison is the O(ND) algorithm of Myers, the common prefix and
+// suffix of the texts are skipped first. Texts differing by more than
+// MaxEdits lines are not compared further: the rest of the first one is
+// deleted and the rest of the second one inserted.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxEdits is the number of deleted and inserted lines past which texts are
+// replaced rather than compared, it bounds the memory of a comparison.
+const MaxEdits = 2000
+
+// Op is what happens to a line.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+func (o Op) String() string {
+	switch o {
+	case Delete:
+		return "delete"
+	case Insert:
+		return "insert"
+	default:
+		return "equal"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (o Op) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// Line is a line of a comparison, without its line break.
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Split returns the lines of text without their line breaks, "\r\n" included.
+// A final line break does not start an empty line.
+func Split(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+
+	return lines
+}
+
+// Lines returns the lines turning a into b: the lines of a are kept or
+// deleted and the lines of b inserted, in order. The deletions of a change
+// come before its insertions.
+func Lines(a, b string) []Line {
+	return compare(Split(a), Split(b))
+}
+
+// Changed reports whether lines delete or insert any line.
+func Changed(lines []Line) bool {
+	for _, line := range lines {
+		if line.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(a, b []string) []Line {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(a)+len(b)-prefix-suffix)
+
+	for _, text := range a[:prefix] {
+		lines = append(lines, Line{Equal, text})
+	}
+
+	lines = append(lines, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, Line{Equal, text})
+	}
+
+	return lines
+}
+
+// myers returns the shortest edit script turning a into b. The furthest x
+// reached on every diagonal k = x - y is kept for each number of edits d,
+// the script is then read backwards from the end.
+func myers(a, b []string) []Line {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	// v[k+offset] is the furthest x reached on the diagonal k
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v[-d..d] before the d-th edit
+	trace := [][]int{}
+
+	for d := 0; d <= n+m; d++ {
+		if d > MaxEdits {
+			return replace(a, b)
+		}
+
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // a line of b inserted
+			} else {
+				x = v[offset+k-1] + 1 // a line of a deleted
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+
+	return replace(a, b)
+}
+
+// backtrack reads the edit script of myers from the end of a and b.
+func backtrack(a, b []string, trace [][]int) []Line {
+	x, y := len(a), len(b)
+	reversed := []Line{}
+
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, Line{Equal, a[x-1]})
+			x--
+			y--
+		}
+
+		if x == prevX {
+			reversed = append(reversed, Line{Insert, b[y-1]})
+		} else {
+			reversed = append(reversed, Line{Delete, a[x-1]})
+		}
+
+		x, y = prevX, prevY
+	}
+
+	// the lines before the first edit are the same
+	for x > 0 {
+		reversed = append(reversed, Line{Equal, a[x-1]})
+		x--
+	}
+
+	lines := make([]Line, len(reversed))
+	for i, line := range reversed {
+		lines[len(lines)-1-i] = line
+	}
+
+	return lines
+}
+
+// replace deletes every line of a and inserts every line of b.
+func replace(a, b []string) []Line {
+	lines := make([]Line, 0, len(a)+len(b))
+
+	for _, text := range a {
+		lines = append(lines, Line{Delete, text})
+	}
+	for _, text := range b {
+		lines = append(lines, Line{Insert, text})
+	}
+
+	return lines
+}
+
+// Unified formats lines as a unified diff from the file from to the file to,
+// with context unchanged lines around the changes. It returns an empty string
+// when nothing changed.
+func Unified(from string, to string, lines []Line, context int) string {
+	if !Changed(lines) {
+		return ""
+	}
+
+	if context < 0 {
+		context = 0
+	}
+
+	// before[i] is the number of lines of a, and b, before lines[i]
+	type position struct{ a, b int }
+	before := make([]position, len(lines)+1)
+	for i, line := range lines {
+		before[i+1] = before[i]
+		if line.Op != Insert {
+			before[i+1].a++
+		}
+		if line.Op != Delete {
+			before[i+1].b++
+		}
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", from, to)
+
+	for i := 0; i < len(lines); i++ {
+		if lines[i].Op == Equal {
+			continue
+		}
+
+		// changes closer than twice the context share a hunk
+		end := i
+		for j := i; j < len(lines) && j-end <= 2*context+1; j++ {
+			if lines[j].Op != Equal {
+				end = j
+			}
+		}
+
+		start, stop := max(i-context, 0), min(end+context+1, len(lines))
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			hunkRange(before[start].a, before[stop].a-before[start].a),
+			hunkRange(before[start].b, before[stop].b-before[start].b))
+
+		for _, line := range lines[start:stop] {
+			switch line.Op {
+			case Delete:
+				sb.WriteString("-")
+			case Insert:
+				sb.WriteString("+")
+			default:
+				sb.WriteString(" ")
+			}
+			sb.WriteString(line.Text)
+			sb.WriteString("\n")
+		}
+
+		i = end
+	}
+
+	return sb.String()
+}
+
+// hunkRange formats the range of count lines after the first skipped ones.
+// An empty range starts at the line before it.
+func hunkRange(skipped int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", skipped)
+	case 1:
+		return fmt.Sprint(skipped + 1)
+	default:
+		return fmt.Sprintf("%d,%d", skipped+1, count)
+	}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
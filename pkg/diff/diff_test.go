@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	assert.Nil(t, Split(""))
+	assert.Equal(t, []string{"a"}, Split("a\n"))
+	assert.Equal(t, []string{"a", "", "b"}, Split("a\r\n\r\nb"))
+}
+
+func TestLines(t *testing.T) {
+	lines := Lines("a\nb\nc\nd\n", "a\nc\nx\nd\n")
+
+	assert.Equal(t, []Line{
+		{Equal, "a"},
+		{Delete, "b"},
+		{Equal, "c"},
+		{Insert, "x"},
+		{Equal, "d"},
+	}, lines)
+
+	assert.False(t, Changed(Lines("a\nb", "a\nb\n")))
+	assert.Equal(t, []Line{{Insert, "a"}}, Lines("", "a"))
+	assert.Equal(t, []Line{{Delete, "a"}, {Insert, "b"}}, Lines("a", "b"))
+}
+
+// apply rebuilds both texts from lines.
+func apply(lines []Line) (string, string) {
+	var a, b []string
+	for _, line := range lines {
+		if line.Op != Insert {
+			a = append(a, line.Text)
+		}
+		if line.Op != Delete {
+			b = append(b, line.Text)
+		}
+	}
+	return strings.Join(a, "\n"), strings.Join(b, "\n")
+}
+
+func TestLinesMinimal(t *testing.T) {
+	from := "the\nquick\nbrown\nfox\njumps\nover\nthe\nlazy\ndog"
+	to := "a\nquick\nfox\njumps\nhigh\nover\nthe\ndog\n!"
+
+	lines := Lines(from, to)
+
+	a, b := apply(lines)
+	assert.Equal(t, from, a)
+	assert.Equal(t, to, b)
+
+	edits := 0
+	for _, line := range lines {
+		if line.Op != Equal {
+			edits++
+		}
+	}
+	assert.Equal(t, 6, edits)
+}
+
+func TestLinesMaxEdits(t *testing.T) {
+	var from, to strings.Builder
+	for i := 0; i <= MaxEdits; i++ {
+		from.WriteString("a\n")
+		to.WriteString("b\n")
+	}
+
+	lines := Lines("same\n"+from.String(), "same\n"+to.String())
+
+	a, b := apply(lines)
+	assert.Equal(t, "same\n"+strings.TrimSuffix(from.String(), "\n"), a)
+	assert.Equal(t, "same\n"+strings.TrimSuffix(to.String(), "\n"), b)
+}
+
+func TestUnified(t *testing.T) {
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	to := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+
+	assert.Equal(t, `--- a
++++ b
+@@ -1,5 +1,5 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+@@ -11,2 +11,3 @@
+ 11
+ 12
++13
+`, Unified("a", "b", Lines(from, to), 2))
+
+	// changes closer than twice the context share a hunk
+	assert.Equal(t, `--- a
++++ b
+@@ -2,5 +2,5 @@
+ 2
+-3
++three
+ 4
+ 5
+-6
++six
+`, Unified("a", "b", Lines("1\n2\n3\n4\n5\n6", "1\n2\nthree\n4\n5\nsix"), 1))
+
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n", Unified("a", "b", Lines("", "new"), 3))
+	assert.Equal(t, "", Unified("a", "b", Lines("same", "same"), 3))
+}
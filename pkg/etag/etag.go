@@ -0,0 +1,98 @@
+// Package etag implements conditional requests on versioned resources.
+//
+// A resource has an integer version incremented on every change, its ETag
+// is the quoted version. The other representations of a resource, eg its
+// rendered HTML, have the name of their variant appended to the version.
+// Reads honour If-None-Match and writes require If-Match so concurrent
+// edits do not overwrite each other.
+package etag
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/opaulochaves/myserver/apperrors"
+)
+
+// Any is returned by IfMatch for "If-Match: *", it matches every version.
+const Any int64 = 0
+
+// Format returns the ETag of version.
+func Format(version int64) string {
+	return FormatVariant(version, "")
+}
+
+// FormatVariant returns the ETag of the variant of version, or of version
+// when variant is empty.
+func FormatVariant(version int64, variant string) string {
+	if variant == "" {
+		return fmt.Sprintf(`"%d"`, version)
+	}
+	return fmt.Sprintf(`"%d-%s"`, version, variant)
+}
+
+// Set writes the ETag of version to the response headers.
+func Set(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", Format(version))
+}
+
+// NotModified sets the ETag of version and, when it matches If-None-Match,
+// answers 304 Not Modified. The caller must not write the body then.
+func NotModified(w http.ResponseWriter, r *http.Request, version int64) bool {
+	return NotModifiedVariant(w, r, version, "")
+}
+
+// NotModifiedVariant is NotModified for the variant of version.
+func NotModifiedVariant(w http.ResponseWriter, r *http.Request, version int64, variant string) bool {
+	tag := FormatVariant(version, variant)
+	w.Header().Set("ETag", tag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		// a weak comparison is enough for reads
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// IfMatch returns the version required by the If-Match header of a write,
+// or Any for "*". It fails with 428 when the header is missing and 412 when
+// it is not an ETag issued by Format or FormatVariant, the variants of a
+// version all match it.
+func IfMatch(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if header == "" {
+		return 0, apperrors.NewPreconditionRequired("If-Match header is required")
+	}
+
+	if header == "*" {
+		return Any, nil
+	}
+
+	tag, _, _ := strings.Cut(strings.Trim(header, `"`), "-")
+
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) {
+		return 0, apperrors.NewPreconditionFailed("If-Match does not match the current version")
+	}
+
+	return version, nil
+}
+
+// Mismatch is the error of a write whose If-Match version is not the
+// current one.
+func Mismatch() error {
+	return apperrors.NewPreconditionFailed("the resource was modified, fetch it again and retry")
+}
@@ -0,0 +1,69 @@
+package etag
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opaulochaves/myserver/apperrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotModified(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	assert.False(t, NotModified(w, r, 3))
+	assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+
+	r.Header.Set("If-None-Match", `"2", W/"3"`)
+	w = httptest.NewRecorder()
+
+	assert.True(t, NotModified(w, r, 3))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	r.Header.Set("If-None-Match", `"2"`)
+
+	assert.False(t, NotModified(httptest.NewRecorder(), r, 3))
+
+	// the variants of a version do not match each other
+	r.Header.Set("If-None-Match", `"3"`)
+	w = httptest.NewRecorder()
+
+	assert.False(t, NotModifiedVariant(w, r, 3, "html"))
+	assert.Equal(t, `"3-html"`, w.Header().Get("ETag"))
+
+	r.Header.Set("If-None-Match", `W/"3-html"`)
+
+	assert.True(t, NotModifiedVariant(httptest.NewRecorder(), r, 3, "html"))
+	assert.False(t, NotModified(httptest.NewRecorder(), r, 3))
+}
+
+func TestIfMatch(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/", nil)
+
+	_, err := IfMatch(r)
+	assert.Equal(t, http.StatusPreconditionRequired, apperrors.Status(err))
+
+	r.Header.Set("If-Match", `"7"`)
+	version, err := IfMatch(r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), version)
+
+	r.Header.Set("If-Match", `"7-html"`)
+	version, err = IfMatch(r)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), version)
+
+	r.Header.Set("If-Match", "*")
+	version, err = IfMatch(r)
+	require.NoError(t, err)
+	assert.Equal(t, Any, version)
+
+	for _, header := range []string{"7", `W/"7"`, `"seven"`, `"0"`} {
+		r.Header.Set("If-Match", header)
+		_, err = IfMatch(r)
+		assert.Equal(t, http.StatusPreconditionFailed, apperrors.Status(err), header)
+	}
+}
@@ -0,0 +1,88 @@
+// Package markdown renders CommonMark documents to HTML safe to embed in a
+// page.
+//
+// The raw HTML of the documents is kept, the output is then sanitised
+// against an allowlist of elements and attributes: scripts, styles, event
+// handlers and unsafe URLs are removed whatever produced them.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// Heading is a heading of a document, its ID is the id attribute of the
+// rendered heading so a table of contents can link to it.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+	ID    string `json:"id"`
+}
+
+// Document is a rendered document.
+type Document struct {
+	HTML     string    `json:"html"`
+	Headings []Heading `json:"headings"`
+}
+
+var (
+	md = goldmark.New(
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+
+	policy = newPolicy()
+)
+
+// newPolicy returns the allowlist of the rendered HTML: the elements of
+// user generated content, the ids of the headings and the languages of the
+// code blocks.
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\w-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	return p
+}
+
+// Render renders the CommonMark source to sanitised HTML, with its headings
+// in order.
+func Render(source string) (Document, error) {
+	src := []byte(source)
+	doc := md.Parser().Parse(text.NewReader(src))
+
+	headings := []Heading{}
+
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+
+		h := Heading{Level: heading.Level, Text: string(heading.Text(src))}
+		if id, ok := heading.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				h.ID = string(b)
+			}
+		}
+		headings = append(headings, h)
+
+		return ast.WalkSkipChildren, nil
+	})
+	if err != nil {
+		return Document{}, err
+	}
+
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, src, doc); err != nil {
+		return Document{}, err
+	}
+
+	return Document{HTML: policy.Sanitize(buf.String()), Headings: headings}, nil
+}
@@ -0,0 +1,36 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	doc, err := Render("# Title\n\nSome *text*.\n\n## A `code` section\n\n```go\nfmt.Println()\n```\n")
+	require.NoError(t, err)
+
+	assert.Equal(t, `<h1 id="title">Title</h1>
+<p>Some <em>text</em>.</p>
+<h2 id="a-code-section">A <code>code</code> section</h2>
+<pre><code class="language-go">fmt.Println()
+</code></pre>
+`, doc.HTML)
+
+	assert.Equal(t, []Heading{
+		{Level: 1, Text: "Title", ID: "title"},
+		{Level: 2, Text: "A code section", ID: "a-code-section"},
+	}, doc.Headings)
+}
+
+func TestRenderSanitised(t *testing.T) {
+	doc, err := Render("<script>alert(1)</script>\n\n<img src=x onerror=alert(1)>\n\n[link](javascript:alert(1)) <sup>1</sup>\n")
+	require.NoError(t, err)
+
+	assert.NotContains(t, doc.HTML, "script")
+	assert.NotContains(t, doc.HTML, "onerror")
+	assert.NotContains(t, doc.HTML, "javascript")
+	assert.Contains(t, doc.HTML, "<sup>1</sup>")
+	assert.Empty(t, doc.Headings)
+}
@@ -0,0 +1,79 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// CursorVar specifies the query parameter name for the cursor
+	CursorVar = "cursor"
+	// LimitVar specifies the query parameter name for the number of items
+	LimitVar = "limit"
+)
+
+// ErrInvalidCursor is returned when a cursor was not issued by NextCursor.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor represents a list of data items paginated with an opaque cursor
+// rather than page numbers, for lists that are too large to count or that
+// change while they are read. The cursor is the ID of the last item read.
+type Cursor struct {
+	After      int64       `json:"-"`
+	Limit      int         `json:"limit"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Items      interface{} `json:"items"`
+}
+
+// Render implements render.Renderer
+func (*Cursor) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// NewCursorFromRequest creates a Cursor object using the query parameters
+// found in the given HTTP request.
+func NewCursorFromRequest(req *http.Request) (*Cursor, error) {
+	limit := parseInt(req.URL.Query().Get(LimitVar), DefaultPageSize)
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	c := &Cursor{Limit: limit}
+
+	if value := req.URL.Query().Get(CursorVar); value != "" {
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+
+		if c.After, err = strconv.ParseInt(string(b), 10, 64); err != nil || c.After <= 0 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return c, nil
+}
+
+// Fetch returns the number of items to read: one more than Limit tells
+// whether there is a next page.
+func (c *Cursor) Fetch() int {
+	return c.Limit + 1
+}
+
+// Page returns how many of the count items read with Fetch belong to the
+// page and sets NextCursor when there are more. id returns the ID of the
+// i-th item.
+func (c *Cursor) Page(count int, id func(i int) int64) int {
+	if count <= c.Limit {
+		return count
+	}
+
+	c.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id(c.Limit-1), 10)))
+
+	return c.Limit
+}
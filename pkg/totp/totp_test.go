@@ -0,0 +1,50 @@
+package totp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test vectors from RFC 6238 appendix B, truncated to 6 digits.
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range cases {
+		assert.Equal(t, want, Code(secret, Step(time.Unix(unix, 0))), "time %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111109, 0)
+
+	step, ok := Validate(secret, "081804", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	_, ok = Validate(secret, "081804", now.Add(Period))
+	assert.True(t, ok, "previous period is accepted")
+
+	_, ok = Validate(secret, "081804", now.Add(3*Period))
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "000000", now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI([]byte("12345678901234567890"), "My Server", "user01@example.com")
+
+	assert.Equal(t, "otpauth://totp/My%20Server:user01@example.com?algorithm=SHA1&digits=6&issuer=My+Server&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", uri)
+}
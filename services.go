@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/opaulochaves/myserver/config"
+	"github.com/opaulochaves/myserver/internal/audit"
+	"github.com/opaulochaves/myserver/internal/auth"
+	"github.com/opaulochaves/myserver/internal/avatar"
+	"github.com/opaulochaves/myserver/internal/events"
+	"github.com/opaulochaves/myserver/internal/idempotency"
+	"github.com/opaulochaves/myserver/internal/jobs"
+	"github.com/opaulochaves/myserver/internal/mailer"
+	"github.com/opaulochaves/myserver/internal/note"
+	"github.com/opaulochaves/myserver/internal/outbox"
+	"github.com/opaulochaves/myserver/internal/privacy"
+	"github.com/opaulochaves/myserver/internal/scheduler"
+	"github.com/opaulochaves/myserver/internal/storage"
+	"github.com/opaulochaves/myserver/internal/user"
+	"github.com/opaulochaves/myserver/internal/util"
+	"github.com/opaulochaves/myserver/internal/webhook"
+)
+
+type services struct {
+	UserRepo        user.UserQueries
+	IdempotencyRepo idempotency.IdempotencyQueries
+	OutboxRepo      outbox.OutboxQueries
+	JobRepo         jobs.JobQueries
+	Jobs            *jobs.Pool
+	ScheduleRepo    scheduler.ScheduleQueries
+	Scheduler       *scheduler.Scheduler
+	Dispatcher      *outbox.Dispatcher
+	Events          *events.Broker
+	Audit           audit.Service
+	Auth            auth.Service
+	Avatar          avatar.Service
+	User            user.Service
+	Note            note.Service
+	Privacy         privacy.Service
+	Webhook         webhook.Service
+	Deliverer       *webhook.Deliverer
+}
+
+// initServices builds the services shared by the HTTP server and the CLI
+func initServices(cfg config.Config, ds *dataSources) (*services, error) {
+	verificationPolicy, err := auth.ParsePolicy(cfg.EmailVerificationPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config: %w", err)
+	}
+
+	mail, err := mailer.New(mailer.Config{
+		Driver:   cfg.MailDriver,
+		From:     cfg.MailFrom,
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		TLS:      cfg.SMTPTLS,
+		Dir:      cfg.MailDir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to initialize mailer: %w", err)
+	}
+
+	mailTemplates, err := mailer.NewTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse mail templates: %w", err)
+	}
+
+	pool := jobs.NewPool(ds.DB, jobs.Options{
+		Concurrency:  cfg.JobConcurrency,
+		PollInterval: seconds(cfg.JobPollInterval),
+		BaseBackoff:  10 * time.Second,
+		MaxBackoff:   time.Hour,
+		StaleAfter:   seconds(cfg.JobStaleAfter),
+	})
+
+	cipher, err := util.NewCipher(cfg.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config: %w", err)
+	}
+
+	passwordPolicy, err := user.NewPasswordPolicy(cfg.PasswordMinLength, cfg.BreachedPasswordsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load password policy: %w", err)
+	}
+
+	blobs, err := storage.New(storage.Config{
+		Driver:    cfg.StorageDriver,
+		Dir:       cfg.StorageDir,
+		Endpoint:  cfg.StorageEndpoint,
+		Region:    cfg.Region,
+		Bucket:    cfg.BucketName,
+		AccessKey: cfg.AccessKey,
+		SecretKey: cfg.SecretKey,
+		PathStyle: cfg.StoragePathStyle,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to initialize storage: %w", err)
+	}
+
+	// notes are indexed with the text search configuration, it must exist
+	if _, err := ds.DB.Exec(`SELECT $1::regconfig`, cfg.SearchLanguage); err != nil {
+		return nil, fmt.Errorf("Invalid config: search language %q: %w", cfg.SearchLanguage, err)
+	}
+
+	userRepo := user.NewUserQueries(ds.DB, nil)
+	authRepo := auth.NewAuthQueries(ds.DB, nil)
+	auditRepo := audit.NewAuditQueries(ds.DB, nil)
+
+	authService := auth.NewService(ds.DB, authRepo, userRepo, auth.NewTokenSigner(cfg.SessionSecret), cipher, auth.NewMailer(mail, mailTemplates), auth.Options{
+		Policy:                     verificationPolicy,
+		AppURL:                     cfg.AppURL,
+		VerificationTokenTTL:       seconds(cfg.VerificationTokenTTL),
+		VerificationResendInterval: seconds(cfg.VerificationResendInterval),
+		AccessTokenTTL:             seconds(cfg.AccessTokenTTL),
+		RefreshTokenTTL:            seconds(cfg.RefreshTokenTTL),
+		Issuer:                     cfg.TwoFactorIssuer,
+		ChallengeTTL:               seconds(cfg.TwoFactorChallengeTTL),
+		Lockout: auth.LockoutOptions{
+			Threshold:    cfg.LockoutThreshold,
+			Window:       seconds(cfg.LockoutWindow),
+			BaseDuration: seconds(cfg.LockoutDuration),
+			MaxDuration:  seconds(cfg.LockoutMaxDuration),
+			IPThreshold:  cfg.LockoutIPThreshold,
+		},
+	})
+	// emails are sent by the job queue, out of the requests
+	jobs.Register(pool, authService.SendVerification)
+	jobs.Register(pool, authService.SendAccountLocked)
+
+	webhookService := webhook.NewService(ds.DB, webhook.NewWebhookQueries(ds.DB, nil), cipher)
+
+	dispatcher := outbox.NewDispatcher(ds.DB, outbox.Options{
+		PollInterval: seconds(cfg.OutboxPollInterval),
+		MaxAttempts:  cfg.OutboxMaxAttempts,
+		BaseBackoff:  5 * time.Second,
+		MaxBackoff:   time.Hour,
+	})
+	dispatcher.Subscribe(outbox.AllEvents, webhookService.Enqueue)
+
+	privacyService := privacy.NewService(ds.DB, privacy.NewPrivacyQueries(ds.DB, nil), userRepo, auditRepo, privacy.Options{
+		Dir:       cfg.ExportDir,
+		ExportTTL: seconds(cfg.ExportTTL),
+	})
+	jobs.Register(pool, privacyService.Generate)
+
+	noteService := note.NewService(ds.DB, note.NewNoteQueries(ds.DB, nil), note.Options{
+		Language:          cfg.SearchLanguage,
+		RevisionLimit:     cfg.NoteRevisionLimit,
+		RenderCacheSize:   cfg.NoteRenderCacheSize,
+		Storage:           blobs,
+		AttachmentMaxSize: cfg.AttachmentMaxSize,
+		AttachmentQuota:   cfg.AttachmentQuota,
+		ImportMaxSize:     cfg.NoteImportMaxSize,
+		Throttle:          authService,
+	})
+
+	svc := &services{
+		UserRepo:        userRepo,
+		IdempotencyRepo: idempotency.NewIdempotencyQueries(ds.DB, nil),
+		OutboxRepo:      outbox.NewOutboxQueries(ds.DB, nil),
+		Dispatcher:      dispatcher,
+		Events:          events.NewBroker(ds.DB, events.Options{BufferSize: cfg.EventsBufferSize, Heartbeat: seconds(cfg.EventsHeartbeat)}),
+		Audit:           audit.NewService(auditRepo),
+		Auth:            authService,
+		Avatar:          avatar.NewService(ds.DB, avatar.NewAvatarQueries(ds.DB, nil), userRepo, avatar.Options{Storage: blobs, MaxSize: cfg.AvatarMaxSize}),
+		User:            user.NewService(ds.DB, userRepo, authService, passwordPolicy),
+		Note:            noteService,
+		JobRepo:         jobs.NewJobQueries(ds.DB, nil),
+		Jobs:            pool,
+		ScheduleRepo:    scheduler.NewScheduleQueries(ds.DB, nil),
+		Scheduler:       scheduler.NewScheduler(ds.DB, scheduler.Options{PollInterval: time.Second}),
+		Privacy:         privacyService,
+		Webhook:         webhookService,
+		Deliverer: webhook.NewDeliverer(ds.DB, cipher, webhook.Options{
+			PollInterval: time.Second,
+			Timeout:      seconds(cfg.WebhookTimeout),
+			MaxAttempts:  cfg.WebhookMaxAttempts,
+			BaseBackoff:  30 * time.Second,
+			MaxBackoff:   6 * time.Hour,
+			DisableAfter: cfg.WebhookDisableAfter,
+		}),
+	}
+
+	if err := registerMaintenance(svc.Scheduler, cfg, svc); err != nil {
+		return nil, fmt.Errorf("Unable to register the schedules: %w", err)
+	}
+
+	return svc, nil
+}
+
+func seconds(s int64) time.Duration {
+	return time.Duration(s) * time.Second
+}